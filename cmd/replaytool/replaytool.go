@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/jumpserver/koko/pkg/proxy"
+)
+
+/*
+	录像文件工具:
+	replaytool decrypt -key AGE-SECRET-KEY-1... -in {sid}.replay.gz -out {sid}.decrypted.replay.gz
+	replaytool merge -manifest {sid}.manifest.json -dir parts/ -out {sid}.replay [-key ...]
+	replaytool verify -key ed25519.pub -sig {sid}.replay.gz.sig -in {sid}.replay.gz
+
+	decrypt 和 merge 的 -key 为 age 私钥、age-keygen 生成的私钥文件或者 RSA 私钥 PEM 文件路径;
+	verify 的 -key 为 Ed25519 公钥 PEM 文件, 或者 HMAC 签名时终端的 access key 文件, 加密的录像需要先解密。
+	verify 发现篡改时输出第一个被篡改的帧所在的区间(默认每 1024 帧一个检查点), 区间之后的帧无法校验
+*/
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "decrypt":
+		err = decrypt(os.Args[2:])
+	case "merge":
+		err = merge(os.Args[2:])
+	case "verify":
+		err = verify(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n  %s decrypt -key KEY -in FILE -out FILE\n"+
+		"  %s merge -manifest FILE -dir DIR -out FILE [-key KEY]\n"+
+		"  %s verify -key KEY -sig FILE -in FILE\n", os.Args[0], os.Args[0], os.Args[0])
+	os.Exit(2)
+}
+
+func decrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	key := fs.String("key", "", "age secret key, age identity file or rsa private key file")
+	in := fs.String("in", "", "encrypted replay file")
+	out := fs.String("out", "", "decrypted replay file")
+	_ = fs.Parse(args)
+	if *key == "" || *in == "" || *out == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	identity, err := loadIdentity(*key)
+	if err != nil {
+		return err
+	}
+	return proxy.DecryptReplayFile(*in, *out, identity)
+}
+
+func merge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	key := fs.String("key", "", "age secret key, age identity file or rsa private key file")
+	manifestPath := fs.String("manifest", "", "replay manifest file")
+	dir := fs.String("dir", ".", "directory of downloaded replay parts")
+	out := fs.String("out", "", "merged replay file")
+	_ = fs.Parse(args)
+	if *manifestPath == "" || *out == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	data, err := ioutil.ReadFile(*manifestPath)
+	if err != nil {
+		return err
+	}
+	var manifest proxy.ReplayManifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return err
+	}
+	if !manifest.Complete {
+		fmt.Fprintf(os.Stderr, "warning: replay manifest not complete, %d/%d parts\n",
+			len(manifest.Parts), manifest.Total)
+	}
+	var identity proxy.ReplayIdentity
+	if *key != "" {
+		if identity, err = loadIdentity(*key); err != nil {
+			return err
+		}
+	}
+	return proxy.MergeReplayParts(manifest, *dir, *out, identity)
+}
+
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	key := fs.String("key", "", "ed25519 public key file or access key file")
+	sigPath := fs.String("sig", "", "replay signature file")
+	in := fs.String("in", "", "replay file")
+	_ = fs.Parse(args)
+	if *key == "" || *sigPath == "" || *in == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	verifier, err := proxy.LoadAuditVerifier(*key)
+	if err != nil {
+		return err
+	}
+	result, err := proxy.VerifyReplay(*in, *sigPath, verifier)
+	if err != nil {
+		return err
+	}
+	if !result.Valid {
+		return fmt.Errorf("verify failed: %s", result.Reason)
+	}
+	fmt.Printf("ok, %d frames verified\n", result.FrameCount)
+	return nil
+}
+
+// loadIdentity age-keygen 生成的私钥文件中查找 AGE-SECRET-KEY- 开头的行
+func loadIdentity(key string) (proxy.ReplayIdentity, error) {
+	if f, err := os.Open(key); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); strings.HasPrefix(line, "AGE-SECRET-KEY-") {
+				return proxy.ParseReplayIdentity(line)
+			}
+		}
+	}
+	return proxy.ParseReplayIdentity(key)
+}
@@ -0,0 +1,164 @@
+package auditlog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+)
+
+var testCommand = &model.Command{
+	SessionID:  "2b1c4d6e-1f9a-4e0c-8a3b-7d5e6f7a8b9c",
+	User:       "Administrator(admin)",
+	Server:     "web01",
+	SystemUser: "root",
+	Input:      `echo "a=b" | grep ]`,
+	Output:     "a=b\r\n",
+	Timestamp:  1622505600,
+	RiskLevel:  model.DangerLevel,
+}
+
+func TestFormatRFC5424(t *testing.T) {
+	f := formatter{format: FormatRFC5424, facility: 16, hostname: "koko host", procID: "1"}
+	msg := string(f.Format(CommandEvent(testCommand)))
+	expectPrefix := "<132>1 2021-06-01T00:00:00.000000Z kokohost koko 1 command [jumpserver@32473 "
+	if !strings.HasPrefix(msg, expectPrefix) {
+		t.Fatalf("invalid rfc5424 header: %s", msg)
+	}
+	if !strings.Contains(msg, `input="echo \"a=b\" | grep \]"`) {
+		t.Fatalf("invalid rfc5424 structured data: %s", msg)
+	}
+	if !strings.HasSuffix(msg, "] Dangerous command executed") {
+		t.Fatalf("invalid rfc5424 msg: %s", msg)
+	}
+	f.sdID = "audit@12345.1"
+	if msg = string(f.Format(CommandEvent(testCommand))); !strings.Contains(msg, " command [audit@12345.1 ") {
+		t.Fatalf("invalid rfc5424 sd-id: %s", msg)
+	}
+}
+
+func TestFormatCEF(t *testing.T) {
+	f := formatter{format: FormatCEF, facility: 16, hostname: "koko", procID: "1"}
+	msg := string(f.Format(CommandEvent(testCommand)))
+	if !strings.Contains(msg, " command - CEF:0|JumpServer|KoKo|unknown|command|Dangerous command executed|7|") {
+		t.Fatalf("invalid cef header: %s", msg)
+	}
+	for _, expect := range []string{`cs1=echo "a\=b" | grep ]`, "cs1Label=input", `cs2=a\=b\r\n`,
+		"suser=Administrator(admin)", "rt=1622505600000"} {
+		if !strings.Contains(msg, expect) {
+			t.Fatalf("cef extension %s not found: %s", expect, msg)
+		}
+	}
+}
+
+func TestCommandEventAudit(t *testing.T) {
+	cmd := *testCommand
+	cmd.RiskLevel = model.WarningLevel
+	cmd.RuleID = "local:audit-rm"
+	e := CommandEvent(&cmd)
+	if e.Name != "Audited command executed" || e.Severity != SeverityNotice {
+		t.Fatalf("unexpected event %s %d", e.Name, e.Severity)
+	}
+	f := formatter{format: FormatCEF, facility: 16, hostname: "koko", procID: "1"}
+	msg := string(f.Format(e))
+	if !strings.Contains(msg, "cs5=local:audit-rm") || !strings.Contains(msg, "cs5Label=rule_id") {
+		t.Fatalf("rule id not found: %s", msg)
+	}
+}
+
+func TestWriterUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	w, err := NewWriter(Config{Addr: conn.LocalAddr().String(), Protocol: ProtocolUDP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err = w.Write(LoginEvent("admin", "10.0.0.1", "password", "Failed")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<132>1 ") || !strings.Contains(msg, ` result="Failed"`) {
+		t.Fatalf("invalid udp message: %s", msg)
+	}
+}
+
+func TestWriterTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			lenStr, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			length, _ := strconv.Atoi(strings.TrimSpace(lenStr))
+			msg := make([]byte, length)
+			if _, err = io.ReadFull(r, msg); err != nil {
+				return
+			}
+			received <- string(msg)
+		}
+	}()
+	w, err := NewWriter(Config{Addr: ln.Addr().String(), Protocol: ProtocolTCP, Format: FormatCEF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	ftpLog := &model.FTPLog{User: "admin", Hostname: "web01", Operate: model.OperateUpload,
+		Path: "/tmp/a.txt", IsSuccess: true}
+	sess := &model.Session{ID: "2b1c4d6e-1f9a-4e0c-8a3b-7d5e6f7a8b9c", User: "admin", Protocol: "ssh"}
+	if err = w.Write(FTPLogEvent(ftpLog), SessionEvent(SessionStart, sess, "")); err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{"|ftp|File Upload|", "|session|Session start|"} {
+		select {
+		case msg := <-received:
+			if !strings.Contains(msg, expect) {
+				t.Fatalf("expect %s, got %s", expect, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("tcp message not received")
+		}
+	}
+}
+
+func TestNewWriterInvalid(t *testing.T) {
+	invalidConfs := []Config{
+		{},
+		{Addr: "127.0.0.1:514", Protocol: "http"},
+		{Addr: "127.0.0.1:514", Format: "json"},
+		{Addr: "127.0.0.1:514", Facility: "local9"},
+		{Addr: "127.0.0.1:514", SDID: "jumpserver"},
+		{Addr: "127.0.0.1:514", SDID: "jump server@12345"},
+	}
+	for i := range invalidConfs {
+		if _, err := NewWriter(invalidConfs[i]); err == nil {
+			t.Fatalf("expect err for %+v", invalidConfs[i])
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package auditlog
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+)
+
+// syslog severity
+const (
+	SeverityWarning = 4
+	SeverityNotice  = 5
+	SeverityInfo    = 6
+)
+
+const (
+	EventCommand = "command"
+	EventFTP     = "ftp"
+	EventSession = "session"
+	EventLogin   = "login"
+)
+
+const (
+	SessionStart  = "start"
+	SessionFailed = "failed"
+	SessionFinish = "finish"
+)
+
+// field name 为 RFC 5424 structured data 的参数名, cefKey 为 CEF 扩展字段名
+type field struct {
+	name   string
+	cefKey string
+	value  string
+}
+
+// Event 一条审计事件, Type 作为 syslog 的 MSGID 和 CEF 的 Signature ID
+type Event struct {
+	Type     string
+	Name     string
+	Severity int
+	Time     time.Time
+	fields   []field
+}
+
+func (e *Event) add(name, cefKey, value string) {
+	e.fields = append(e.fields, field{name: name, cefKey: cefKey, value: value})
+}
+
+func CommandEvent(cmd *model.Command) Event {
+	e := Event{
+		Type:     EventCommand,
+		Name:     "Command executed",
+		Severity: SeverityInfo,
+		Time:     time.Unix(cmd.Timestamp, 0),
+	}
+	switch cmd.RiskLevel {
+	case model.DangerLevel:
+		e.Name = "Dangerous command executed"
+		e.Severity = SeverityWarning
+	case model.WarningLevel:
+		e.Name = "Audited command executed"
+		e.Severity = SeverityNotice
+	}
+	e.add("session", "externalId", cmd.SessionID)
+	e.add("org_id", "cs6", cmd.OrgID)
+	e.add("user", "suser", cmd.User)
+	e.add("asset", "dhost", cmd.Server)
+	e.add("system_user", "duser", cmd.SystemUser)
+	e.add("input", "cs1", cmd.Input)
+	e.add("output", "cs2", cmd.Output)
+	e.add("risk_level", "cn1", strconv.FormatInt(cmd.RiskLevel, 10))
+	if cmd.RuleID != "" {
+		e.add("rule_id", "cs5", cmd.RuleID)
+	}
+	return e
+}
+
+func FTPLogEvent(ftpLog *model.FTPLog) Event {
+	e := Event{
+		Type:     EventFTP,
+		Name:     "File " + ftpLog.Operate,
+		Severity: SeverityInfo,
+		Time:     ftpLog.DataStart.Time,
+	}
+	if !ftpLog.IsSuccess {
+		e.Severity = SeverityWarning
+	}
+	e.add("org_id", "cs6", ftpLog.OrgID)
+	e.add("user", "suser", ftpLog.User)
+	e.add("remote_addr", "src", ftpLog.RemoteAddr)
+	e.add("asset", "dhost", ftpLog.Hostname)
+	e.add("system_user", "duser", ftpLog.SystemUser)
+	e.add("operate", "act", ftpLog.Operate)
+	e.add("filename", "fname", ftpLog.Path)
+	e.add("is_success", "outcome", strconv.FormatBool(ftpLog.IsSuccess))
+	return e
+}
+
+// SessionEvent action 为 SessionStart, SessionFailed 或 SessionFinish
+func SessionEvent(action string, sess *model.Session, reason string) Event {
+	e := Event{
+		Type:     EventSession,
+		Name:     "Session " + action,
+		Severity: SeverityNotice,
+		Time:     time.Now(),
+	}
+	if action == SessionFailed {
+		e.Severity = SeverityWarning
+	}
+	e.add("session", "externalId", sess.ID)
+	e.add("action", "act", action)
+	e.add("org_id", "cs6", sess.OrgID)
+	e.add("user", "suser", sess.User)
+	e.add("remote_addr", "src", sess.RemoteAddr)
+	e.add("login_from", "cs3", sess.LoginFrom)
+	e.add("asset", "dhost", sess.Asset)
+	e.add("system_user", "duser", sess.SystemUser)
+	e.add("protocol", "app", sess.Protocol)
+	if reason != "" {
+		e.add("reason", "reason", reason)
+	}
+	return e
+}
+
+// LoginEvent 用户登录 koko 的认证结果, result 例如 Accepted, Failed, Partial accepted
+func LoginEvent(username, remoteAddr, method, result string) Event {
+	e := Event{
+		Type:     EventLogin,
+		Name:     "User login " + strings.ToLower(result),
+		Severity: SeverityNotice,
+		Time:     time.Now(),
+	}
+	if strings.EqualFold(result, "failed") {
+		e.Severity = SeverityWarning
+	}
+	e.add("user", "suser", username)
+	e.add("remote_addr", "src", remoteAddr)
+	e.add("method", "cs4", method)
+	e.add("result", "outcome", result)
+	return e
+}
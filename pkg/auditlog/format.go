@@ -0,0 +1,166 @@
+package auditlog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatRFC5424 = "rfc5424"
+	FormatCEF     = "cef"
+
+	appName = "koko"
+
+	// 32473 为 IANA 保留给文档示例使用的企业号 (RFC 5612), 只作为默认值, 应配置为已注册的企业号
+	defaultStructuredDataID = "jumpserver@32473"
+
+	cefVendor  = "JumpServer"
+	cefProduct = "KoKo"
+)
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"auth":     4,
+	"authpriv": 10,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// ParseFacility 默认为 local0
+func ParseFacility(name string) (int, error) {
+	if name == "" {
+		return facilities["local0"], nil
+	}
+	facility, ok := facilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("syslog facility %s not support", name)
+	}
+	return facility, nil
+}
+
+// ParseStructuredDataID 格式为 name@企业号, 默认为 jumpserver@32473
+func ParseStructuredDataID(id string) (string, error) {
+	if id == "" {
+		return defaultStructuredDataID, nil
+	}
+	name, pen := id, ""
+	if i := strings.LastIndexByte(id, '@'); i >= 0 {
+		name, pen = id[:i], id[i+1:]
+	}
+	// 企业号可以包含以 . 分隔的子编号
+	for _, num := range strings.Split(pen, ".") {
+		if _, err := strconv.ParseUint(num, 10, 32); err != nil || name == "" {
+			return "", fmt.Errorf("syslog structured data id %s should be name@enterprise_number", id)
+		}
+	}
+	// RFC 5424 SD-NAME: 最长 32 个可打印字符, 不包含 '=', ' ', ']', '"'
+	if len(id) > 32 || strings.ContainsAny(id, "= ]\"") || strings.IndexFunc(id, func(r rune) bool {
+		return r <= ' ' || r > '~'
+	}) >= 0 {
+		return "", fmt.Errorf("syslog structured data id %s invalid", id)
+	}
+	return id, nil
+}
+
+// productVersion koko 的版本号, 用于 CEF 头部
+var productVersion = "unknown"
+
+type formatter struct {
+	format   string
+	facility int
+	hostname string
+	procID   string
+	sdID     string
+}
+
+/*
+	RFC 5424:
+	<134>1 2021-06-01T08:00:00.000000Z koko-host koko 1234 command [jumpserver@32473 session="..." input="ls"] Command executed
+
+	CEF 使用 RFC 5424 的头部, MSG 部分为 CEF:
+	<134>1 2021-06-01T08:00:00.000000Z koko-host koko 1234 command - CEF:0|JumpServer|KoKo|2.10.0|command|Command executed|3|externalId=... cs1=ls cs1Label=input
+*/
+
+func (f *formatter) Format(e Event) []byte {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %s %s ", f.facility*8+e.Severity,
+		e.Time.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		headerValue(f.hostname), appName, headerValue(f.procID), e.Type)
+	switch f.format {
+	case FormatCEF:
+		buf.WriteString("- ")
+		f.writeCEF(&buf, e)
+	default:
+		f.writeStructuredData(&buf, e)
+		buf.WriteByte(' ')
+		buf.WriteString(e.Name)
+	}
+	return []byte(buf.String())
+}
+
+func headerValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func (f *formatter) writeStructuredData(buf *strings.Builder, e Event) {
+	buf.WriteByte('[')
+	if f.sdID != "" {
+		buf.WriteString(f.sdID)
+	} else {
+		buf.WriteString(defaultStructuredDataID)
+	}
+	for _, item := range e.fields {
+		fmt.Fprintf(buf, ` %s="%s"`, item.name, sdValueEscaper.Replace(item.value))
+	}
+	buf.WriteByte(']')
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+// cefSeverity 将 syslog severity 转换为 CEF 的 0-10
+func cefSeverity(severity int) int {
+	switch {
+	case severity <= SeverityWarning:
+		return 7
+	case severity == SeverityNotice:
+		return 5
+	default:
+		return 3
+	}
+}
+
+func (f *formatter) writeCEF(buf *strings.Builder, e Event) {
+	fmt.Fprintf(buf, "CEF:0|%s|%s|%s|%s|%s|%d|", cefVendor, cefProduct,
+		cefHeaderEscaper.Replace(productVersion), e.Type, cefHeaderEscaper.Replace(e.Name),
+		cefSeverity(e.Severity))
+	fmt.Fprintf(buf, "rt=%s", strconv.FormatInt(e.Time.UnixNano()/int64(time.Millisecond), 10))
+	for _, item := range e.fields {
+		fmt.Fprintf(buf, " %s=%s", item.cefKey, cefExtensionEscaper.Replace(item.value))
+		// 自定义字段需要同时提供字段的标签
+		if strings.HasPrefix(item.cefKey, "cs") || strings.HasPrefix(item.cefKey, "cn") {
+			fmt.Fprintf(buf, " %sLabel=%s", item.cefKey, item.name)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package auditlog
+
+import (
+	"github.com/jumpserver/koko/pkg/config"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	除了命令存储, FTP 日志、会话开始结束、用户登录等事件也可以额外发送到 syslog,
+	通过配置文件 SYSLOG_ADDR 开启, 事件异步发送, 不影响原有的上报流程。
+*/
+
+var defaultTap *tap
+
+type tap struct {
+	writer *Writer
+	events chan Event
+}
+
+func Initial(version string) {
+	productVersion = version
+	conf := config.GetConf()
+	if conf.SyslogAddr == "" {
+		return
+	}
+	w, err := GetWriter(Config{
+		Addr:               conf.SyslogAddr,
+		Protocol:           conf.SyslogProtocol,
+		Format:             conf.SyslogFormat,
+		Facility:           conf.SyslogFacility,
+		SDID:               conf.SyslogSDID,
+		InsecureSkipVerify: conf.SyslogInsecureSkipVerify,
+	})
+	if err != nil {
+		logger.Errorf("Syslog tap init err: %s", err)
+		return
+	}
+	defaultTap = &tap{
+		writer: w,
+		events: make(chan Event, 1024),
+	}
+	go defaultTap.run()
+	logger.Infof("Syslog tap send events to %s", w)
+}
+
+func (t *tap) run() {
+	for e := range t.events {
+		if err := t.writer.Write(e); err != nil {
+			logger.Errorf("Syslog tap send %s event err: %s", e.Type, err)
+		}
+	}
+}
+
+// Emit 未开启时直接忽略; 队列已满时丢弃事件, 避免阻塞会话
+func Emit(e Event) {
+	if defaultTap == nil {
+		return
+	}
+	select {
+	case defaultTap.events <- e:
+	default:
+		logger.Errorf("Syslog tap queue is full, drop %s event", e.Type)
+	}
+}
@@ -0,0 +1,175 @@
+package auditlog
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProtocolUDP = "udp"
+	ProtocolTCP = "tcp"
+	ProtocolTLS = "tls"
+
+	dialTimeout  = 10 * time.Second
+	writeTimeout = 10 * time.Second
+)
+
+type Config struct {
+	Addr     string
+	Protocol string
+	Format   string
+	Facility string
+	// SDID RFC 5424 结构化数据的 SD-ID
+	SDID string
+
+	InsecureSkipVerify bool
+}
+
+func (c Config) key() string {
+	return strings.Join([]string{c.Addr, c.Protocol, c.Format, c.Facility, c.SDID,
+		strconv.FormatBool(c.InsecureSkipVerify)}, "|")
+}
+
+/*
+	UDP 每个数据包一条日志;
+	TCP 和 TLS 使用 RFC 6587/RFC 5425 的 octet counting 分帧: "LEN SP MSG"
+*/
+
+type Writer struct {
+	conf      Config
+	formatter formatter
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func NewWriter(conf Config) (*Writer, error) {
+	if conf.Addr == "" {
+		return nil, fmt.Errorf("syslog addr required")
+	}
+	conf.Protocol = strings.ToLower(conf.Protocol)
+	switch conf.Protocol {
+	case "":
+		conf.Protocol = ProtocolUDP
+	case ProtocolUDP, ProtocolTCP, ProtocolTLS:
+	default:
+		return nil, fmt.Errorf("syslog protocol %s not support", conf.Protocol)
+	}
+	conf.Format = strings.ToLower(conf.Format)
+	switch conf.Format {
+	case "":
+		conf.Format = FormatRFC5424
+	case FormatRFC5424, FormatCEF:
+	default:
+		return nil, fmt.Errorf("syslog format %s not support", conf.Format)
+	}
+	facility, err := ParseFacility(conf.Facility)
+	if err != nil {
+		return nil, err
+	}
+	sdID, err := ParseStructuredDataID(conf.SDID)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return &Writer{
+		conf: conf,
+		formatter: formatter{
+			format:   conf.Format,
+			facility: facility,
+			hostname: hostname,
+			procID:   strconv.Itoa(os.Getpid()),
+			sdID:     sdID,
+		},
+	}, nil
+}
+
+func (w *Writer) dial() (net.Conn, error) {
+	switch w.conf.Protocol {
+	case ProtocolTLS:
+		dialer := &net.Dialer{Timeout: dialTimeout}
+		return tls.DialWithDialer(dialer, "tcp", w.conf.Addr,
+			&tls.Config{InsecureSkipVerify: w.conf.InsecureSkipVerify})
+	default:
+		return net.DialTimeout(w.conf.Protocol, w.conf.Addr, dialTimeout)
+	}
+}
+
+// Write 连接断开时重新连接并重试一次
+func (w *Writer) Write(events ...Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := range events {
+		msg := w.frame(w.formatter.Format(events[i]))
+		if err := w.write(msg); err != nil {
+			w.closeConn()
+			if err = w.write(msg); err != nil {
+				w.closeConn()
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Writer) frame(msg []byte) []byte {
+	if w.conf.Protocol == ProtocolUDP {
+		return msg
+	}
+	return append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+}
+
+func (w *Writer) write(msg []byte) (err error) {
+	if w.conn == nil {
+		if w.conn, err = w.dial(); err != nil {
+			return err
+		}
+	}
+	_ = w.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err = w.conn.Write(msg)
+	return err
+}
+
+func (w *Writer) closeConn() {
+	if w.conn != nil {
+		_ = w.conn.Close()
+		w.conn = nil
+	}
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closeConn()
+	return nil
+}
+
+func (w *Writer) String() string {
+	return fmt.Sprintf("%s://%s(%s)", w.conf.Protocol, w.conf.Addr, w.conf.Format)
+}
+
+// 相同配置的 Writer 共用连接
+var (
+	writers    = make(map[string]*Writer)
+	writerLock sync.Mutex
+)
+
+func GetWriter(conf Config) (*Writer, error) {
+	writerLock.Lock()
+	defer writerLock.Unlock()
+	if w, ok := writers[conf.key()]; ok {
+		return w, nil
+	}
+	w, err := NewWriter(conf)
+	if err != nil {
+		return nil, err
+	}
+	writers[conf.key()] = w
+	return w, nil
+}
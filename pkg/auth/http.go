@@ -1,11 +1,14 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jumpserver/koko/pkg/config"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/service"
 	"github.com/jumpserver/koko/pkg/logger"
@@ -34,6 +37,56 @@ func HTTPMiddleSessionAuth(jmsService *service.JMService) gin.HandlerFunc {
 	}
 }
 
+const (
+	replayTokenCookieName = "koko_replay_token"
+	replayTokenHeader     = "X-Replay-Token"
+)
+
+// HTTPMiddleReplayAuth 本地录像播放的认证, Core 不可用时使用配置文件中的 LOCAL_REPLAY_TOKEN,
+// token 通过请求头 X-Replay-Token 提供(不使用请求参数, 避免 token 记录到访问日志和浏览器历史),
+// 认证后写入 cookie; 没有 token 时使用 Core 的会话认证, 只允许管理员和审计员访问。
+func HTTPMiddleReplayAuth(jmsService *service.JMService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if token := config.GetConf().LocalReplayToken; token != "" {
+			headerToken := ctx.GetHeader(replayTokenHeader)
+			reqToken := headerToken
+			if reqToken == "" {
+				reqToken, _ = ctx.Cookie(replayTokenCookieName)
+			}
+			if reqToken != "" && subtle.ConstantTimeCompare([]byte(reqToken), []byte(token)) == 1 {
+				if headerToken != "" {
+					ctx.SetSameSite(http.SameSiteStrictMode)
+					ctx.SetCookie(replayTokenCookieName, headerToken, 0, "/koko/", "", isSecureRequest(ctx.Request), true)
+				}
+				return
+			}
+		}
+		var cookies = make(map[string]string)
+		for _, cookie := range ctx.Request.Cookies() {
+			cookies[cookie.Name] = cookie.Value
+		}
+		user, err := jmsService.CheckUserCookie(cookies)
+		if err != nil {
+			logger.Errorf("Local replay check user cookie failed: %s", err)
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		switch user.Role {
+		case model.RoleAdmin, model.RoleAuditor:
+		default:
+			logger.Errorf("User %s has no permission to play local replay", user)
+			ctx.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		ctx.Set(ContextKeyUser, user)
+	}
+}
+
+// isSecureRequest 请求使用 TLS, 或者经过 TLS 反向代理
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
 func HTTPMiddleDebugAuth() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		switch ctx.ClientIP() {
@@ -7,6 +7,7 @@ import (
 	"github.com/gliderlabs/ssh"
 	gossh "golang.org/x/crypto/ssh"
 
+	"github.com/jumpserver/koko/pkg/auditlog"
 	"github.com/jumpserver/koko/pkg/common"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/service"
 	"github.com/jumpserver/koko/pkg/logger"
@@ -62,6 +63,7 @@ func SSHPasswordAndPublicKeyAuth(jmsService *service.JMService) SSHAuthFunc {
 		}
 		logger.Infof("SSH conn[%s] %s %s for %s from %s", ctx.SessionID(),
 			action, authMethod, username, remoteAddr)
+		auditlog.Emit(auditlog.LoginEvent(username, remoteAddr, authMethod, action))
 		return
 	}
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/gliderlabs/ssh"
 	gossh "golang.org/x/crypto/ssh"
 
+	"github.com/jumpserver/koko/pkg/auditlog"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/service"
 	"github.com/jumpserver/koko/pkg/logger"
@@ -164,14 +165,17 @@ func (u *UserAuthClient) CheckMFAAuth(ctx ssh.Context, challenger gossh.Keyboard
 		ok = true
 		logger.Infof("SSH conn[%s] %s MFA for %s from %s", ctx.SessionID(),
 			actionAccepted, username, remoteAddr)
+		auditlog.Emit(auditlog.LoginEvent(username, remoteAddr, "mfa", actionAccepted))
 	case authConfirmRequired:
 		logger.Infof("SSH conn[%s] %s MFA for %s from %s as login confirm", ctx.SessionID(),
 			actionPartialAccepted, username, remoteAddr)
+		auditlog.Emit(auditlog.LoginEvent(username, remoteAddr, "mfa", actionPartialAccepted))
 		ctx.SetValue(ContextKeyAuthStatus, authConfirmRequired)
 		ok = u.CheckConfirmAuth(ctx, challenger)
 	default:
 		logger.Errorf("SSH conn[%s] %s MFA for %s from %s", ctx.SessionID(),
 			actionFailed, username, remoteAddr)
+		auditlog.Emit(auditlog.LoginEvent(username, remoteAddr, "mfa", actionFailed))
 	}
 	return
 }
@@ -44,6 +44,63 @@ type Config struct {
 	EnableLocalPortForward bool `mapstructure:"ENABLE_LOCAL_PORT_FORWARD"`
 	EnableVscodeSupport    bool `mapstructure:"ENABLE_VSCODE_SUPPORT"`
 
+	EnableMySQLProxy  bool   `mapstructure:"ENABLE_MYSQL_PROXY"`
+	MySQLProxyHost    string `mapstructure:"MYSQL_PROXY_HOST"`
+	MySQLProxyPort    string `mapstructure:"MYSQL_PROXY_PORT"`
+	MySQLProxyTLSCert string `mapstructure:"MYSQL_PROXY_TLS_CERT"`
+	MySQLProxyTLSKey  string `mapstructure:"MYSQL_PROXY_TLS_KEY"`
+
+	CommandFilterPolicyFile string `mapstructure:"COMMAND_FILTER_POLICY_FILE"`
+	CommandAuditBanner      string `mapstructure:"COMMAND_AUDIT_BANNER"`
+
+	CommandConfirmBackend       string `mapstructure:"COMMAND_CONFIRM_BACKEND"` // core, webhook, local
+	CommandConfirmWebhookURL    string `mapstructure:"COMMAND_CONFIRM_WEBHOOK_URL"`
+	CommandConfirmWebhookSecret string `mapstructure:"COMMAND_CONFIRM_WEBHOOK_SECRET"`
+	CommandConfirmCallbackURL   string `mapstructure:"COMMAND_CONFIRM_CALLBACK_URL"`
+	CommandConfirmTimeout       int    `mapstructure:"COMMAND_CONFIRM_TIMEOUT"`
+
+	CommandRateLimit   int    `mapstructure:"COMMAND_RATE_LIMIT"`
+	CommandRateWindow  int    `mapstructure:"COMMAND_RATE_WINDOW"`
+	PasteBurstLines    int    `mapstructure:"PASTE_BURST_LINES"`
+	PasteBurstSize     string `mapstructure:"PASTE_BURST_SIZE"`
+	CommandBurstAction string `mapstructure:"COMMAND_BURST_ACTION"` // warn, confirm, terminate
+
+	EnableLocalReplay bool   `mapstructure:"ENABLE_LOCAL_REPLAY"`
+	LocalReplayToken  string `mapstructure:"LOCAL_REPLAY_TOKEN"`
+
+	ReplayFormat          string `mapstructure:"REPLAY_FORMAT"` // json, asciicast
+	ReplaySegmentInterval int    `mapstructure:"REPLAY_SEGMENT_INTERVAL"`
+	ReplaySegmentMaxSize  string `mapstructure:"REPLAY_SEGMENT_MAX_SIZE"`
+
+	ReplayIndexInterval    int `mapstructure:"REPLAY_INDEX_INTERVAL"`
+	ReplayKeyframeInterval int `mapstructure:"REPLAY_KEYFRAME_INTERVAL"`
+
+	EnableAuditSignature bool   `mapstructure:"ENABLE_AUDIT_SIGNATURE"`
+	AuditSignKeyPath     string `mapstructure:"AUDIT_SIGN_KEY_PATH"`
+
+	ReplayEncryptPublicKey string `mapstructure:"REPLAY_ENCRYPT_PUBLIC_KEY"`
+
+	EnableCommandSpool  bool   `mapstructure:"ENABLE_COMMAND_SPOOL"`
+	CommandSpoolMaxSize string `mapstructure:"COMMAND_SPOOL_MAX_SIZE"`
+
+	CommandInputMaxSize  int      `mapstructure:"COMMAND_INPUT_MAX_SIZE"`
+	CommandOutputMaxSize int      `mapstructure:"COMMAND_OUTPUT_MAX_SIZE"`
+	FullOutputRules      []string `mapstructure:"FULL_OUTPUT_RULES"`
+	FullOutputCommands   []string `mapstructure:"FULL_OUTPUT_COMMANDS"`
+	FullOutputMaxSize    string   `mapstructure:"FULL_OUTPUT_MAX_SIZE"`
+
+	EnableOutputMasking    bool              `mapstructure:"ENABLE_OUTPUT_MASKING"`
+	OutputMaskingDetectors []string          `mapstructure:"OUTPUT_MASKING_DETECTORS"`
+	OutputMaskingPatterns  map[string]string `mapstructure:"OUTPUT_MASKING_PATTERNS"`
+	OutputMaskingShared    bool              `mapstructure:"OUTPUT_MASKING_SHARED_SESSION"`
+
+	SyslogAddr               string `mapstructure:"SYSLOG_ADDR"`
+	SyslogProtocol           string `mapstructure:"SYSLOG_PROTOCOL"` // udp, tcp, tls
+	SyslogFormat             string `mapstructure:"SYSLOG_FORMAT"`   // rfc5424, cef
+	SyslogFacility           string `mapstructure:"SYSLOG_FACILITY"`
+	SyslogSDID               string `mapstructure:"SYSLOG_SD_ID"`
+	SyslogInsecureSkipVerify bool   `mapstructure:"SYSLOG_IGNORE_VERIFY_CERTS"`
+
 	RootPath          string
 	DataFolderPath    string
 	LogDirPath        string
@@ -119,7 +176,40 @@ func getDefaultConfig() Config {
 		RedisPassword:       "",
 
 		EnableLocalPortForward: false,
+		EnableLocalReplay:      false,
 		EnableVscodeSupport:    false,
+
+		EnableMySQLProxy: false,
+		MySQLProxyPort:   "33061",
+
+		CommandFilterPolicyFile: "",
+		CommandAuditBanner:      "",
+
+		CommandConfirmBackend: "core",
+		CommandConfirmTimeout: 300,
+
+		CommandRateWindow:  60,
+		CommandBurstAction: "warn",
+
+		ReplayFormat: "json",
+
+		EnableAuditSignature: false,
+
+		EnableCommandSpool:  true,
+		CommandSpoolMaxSize: "1G",
+
+		ReplayIndexInterval:    10,
+		ReplayKeyframeInterval: 60,
+
+		CommandInputMaxSize:  128,
+		CommandOutputMaxSize: 1024,
+		FullOutputMaxSize:    "10M",
+
+		OutputMaskingDetectors: []string{"aws_access_key", "aws_secret_key", "jwt", "private_key", "credit_card"},
+
+		SyslogProtocol: "udp",
+		SyslogFormat:   "rfc5424",
+		SyslogFacility: "local0",
 	}
 
 }
@@ -7,6 +7,17 @@ type RoomMessage struct {
 	Body  []byte `json:"data"`
 
 	Meta MetaMessage `json:"meta"` // receive的信息必须携带Meta
+
+	// WatcherBody 不为 nil 时, 共享会话中的其他用户收到该数据(如脱敏后的输出), 会话用户收到 Body
+	WatcherBody []byte `json:"-"`
+}
+
+// dataFor 返回发送给会话用户或其他用户的数据
+func (m *RoomMessage) dataFor(owner bool) []byte {
+	if !owner && m.WatcherBody != nil {
+		return m.WatcherBody
+	}
+	return m.Body
 }
 
 type MetaMessage struct {
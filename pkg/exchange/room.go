@@ -83,7 +83,9 @@ func (r *Room) run() {
 				if msg, ok := value.(*RoomMessage); ok {
 					switch msg.Event {
 					case DataEvent:
-						_, _ = con.Write(msg.Body)
+						if body := msg.dataFor(con.owner); len(body) > 0 {
+							_, _ = con.Write(body)
+						}
 					}
 				}
 			})
@@ -195,6 +197,13 @@ func WrapperUserCon(stream Stream) *Conn {
 	}
 }
 
+// WrapperOwnerCon 会话用户的连接, 收到原始数据
+func WrapperOwnerCon(stream Stream) *Conn {
+	conn := WrapperUserCon(stream)
+	conn.owner = true
+	return conn
+}
+
 type Stream interface {
 	io.WriteCloser
 	HandleRoomEvent(event string, msg *RoomMessage)
@@ -204,12 +213,15 @@ type Conn struct {
 	Id string
 	Stream
 	created time.Time
+	owner   bool
 }
 
 func (c *Conn) handlerMessage(msg *RoomMessage) {
 	switch msg.Event {
 	case DataEvent:
-		_, _ = c.Write(msg.Body)
+		if body := msg.dataFor(c.owner); len(body) > 0 {
+			_, _ = c.Write(body)
+		}
 	case PingEvent:
 		_, _ = c.Write(nil)
 	default:
@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jumpserver/koko/pkg/common"
+	"github.com/jumpserver/koko/pkg/i18n"
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/service"
+	"github.com/jumpserver/koko/pkg/logger"
+	"github.com/jumpserver/koko/pkg/proxy"
+	"github.com/jumpserver/koko/pkg/srvconn"
+	"github.com/jumpserver/koko/pkg/utils"
+)
+
+/*
+	各类数据库应用的数据结构相同, 列表、搜索、显示和连接共用同一套逻辑, 类型之间的差异登记在 dbAppTypes 中。
+	新增数据库时添加 selectType 和一项 dbAppType, 菜单 d 的数据库类型选择、select_handler 和 Web 终端会自动支持;
+	需要菜单快捷键时设置 shortcut 和 menuHelp
+*/
+
+type dbAppType struct {
+	selectType selectType
+	// name 选择数据库类型和日志中显示的名称
+	name     string
+	protocol string
+	// aliases 使用同样方式连接的其他协议
+	aliases []string
+	appType string
+	// userPerms 查询用户有权限的数据库, 为空时按 appType 查询
+	userPerms func(s *service.JMService, userId string, param model.PaginationParam) (model.PaginationResponse, error)
+	// shortcut 主菜单中直接显示该类型数据库的快捷键, menuHelp 为其帮助信息(i18n msgid)
+	shortcut string
+	menuHelp string
+}
+
+var dbAppTypes = []dbAppType{
+	{selectType: TypeMySQL, name: "MySQL", protocol: srvconn.ProtocolMySQL, aliases: []string{srvconn.ProtocolMariadb},
+		appType: model.AppTypeMySQL, userPerms: (*service.JMService).GetUserPermsMySQLAndMariadb},
+	{selectType: TypePostgreSQL, name: "PostgreSQL", protocol: srvconn.ProtocolPostgreSQL, appType: model.AppTypePostgreSQL,
+		shortcut: "s", menuHelp: "display the PostgreSQL databases that you have permission"},
+	{selectType: TypeRedis, name: "Redis", protocol: srvconn.ProtocolRedis, appType: model.AppTypeRedis,
+		shortcut: "c", menuHelp: "display the Redis that you have permission"},
+	{selectType: TypeMongoDB, name: "MongoDB", protocol: srvconn.ProtocolMongoDB, appType: model.AppTypeMongoDB,
+		shortcut: "m", menuHelp: "display the MongoDB that you have permission"},
+	{selectType: TypeSQLServer, name: "SQL Server", protocol: srvconn.ProtocolSQLServer, appType: model.AppTypeSQLServer},
+	{selectType: TypeOracle, name: "Oracle", protocol: srvconn.ProtocolOracle, appType: model.AppTypeOracle},
+}
+
+func getDBAppType(s selectType) (dbAppType, bool) {
+	for i := range dbAppTypes {
+		if dbAppTypes[i].selectType == s {
+			return dbAppTypes[i], true
+		}
+	}
+	return dbAppType{}, false
+}
+
+func getDBAppTypeByShortcut(key string) (dbAppType, bool) {
+	for i := range dbAppTypes {
+		if dbAppTypes[i].shortcut != "" && dbAppTypes[i].shortcut == key {
+			return dbAppTypes[i], true
+		}
+	}
+	return dbAppType{}, false
+}
+
+// IsDatabaseProtocol 协议是否为 dbAppTypes 中的数据库应用
+func IsDatabaseProtocol(protocol string) bool {
+	for i := range dbAppTypes {
+		if dbAppTypes[i].protocol == protocol {
+			return true
+		}
+		for _, alias := range dbAppTypes[i].aliases {
+			if alias == protocol {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (u *UserSelectHandler) retrieveRemoteDatabase(dbType dbAppType, reqParam model.PaginationParam) []map[string]interface{} {
+	var (
+		res model.PaginationResponse
+		err error
+	)
+	if dbType.userPerms != nil {
+		res, err = dbType.userPerms(u.h.jmsService, u.user.ID, reqParam)
+	} else {
+		res, err = u.h.jmsService.GetUserPermsDatabases(u.user.ID, dbType.appType, reqParam)
+	}
+	if err != nil {
+		logger.Errorf("Ger user perm %s failed: %s", dbType.name, err)
+	}
+	return u.updateRemotePageData(reqParam, res)
+}
+
+func (u *UserSelectHandler) searchLocalDatabase(searches ...string) []map[string]interface{} {
+	/*
+	   	  {
+	                  "id": "2b8f37ad-1580-4275-962a-7ea0f53c40b3",
+	                  "name": "www",
+	                  "domain": null,
+	                  "category": "db",
+	                  "type": "mysql",
+	                  "attrs": {
+	                      "host": "www",
+	                      "port": 32342,
+	                      "database": null
+	                  },
+	                  "comment": "",
+	                  "org_id": "",
+	                  "category_display": "数据库",
+	                  "type_display": "MySQL",
+	                  "org_name": "DEFAULT"
+	              }
+	*/
+	fields := map[string]struct{}{
+		"name":     {},
+		"host":     {},
+		"database": {},
+		"comment":  {},
+	}
+	return u.searchLocalFromFields(fields, searches...)
+}
+
+func (u *UserSelectHandler) displayDatabaseResult(searchHeader string) {
+	currentDBS := u.currentResult
+	term := u.h.term
+	if len(currentDBS) == 0 {
+		noDatabases := i18n.T("No Databases")
+		utils.IgnoreErrWriteString(term, utils.WrapperString(noDatabases, utils.Red))
+		utils.IgnoreErrWriteString(term, utils.CharNewLine)
+		utils.IgnoreErrWriteString(term, utils.WrapperString(searchHeader, utils.Green))
+		utils.IgnoreErrWriteString(term, utils.CharNewLine)
+		return
+	}
+
+	currentPage := u.CurrentPage()
+	pageSize := u.PageSize()
+	totalPage := u.TotalPage()
+	totalCount := u.TotalCount()
+
+	idLabel := i18n.T("ID")
+	nameLabel := i18n.T("Name")
+	ipLabel := i18n.T("IP")
+	dbTypeLabel := i18n.T("DBType")
+	dbNameLabel := i18n.T("DB Name")
+	commentLabel := i18n.T("Comment")
+
+	Labels := []string{idLabel, nameLabel, ipLabel,
+		dbTypeLabel, dbNameLabel, commentLabel}
+	fields := []string{"ID", "Name", "IP", "DBType", "DBName", "Comment"}
+	data := make([]map[string]string, len(currentDBS))
+	for i, j := range currentDBS {
+		row := make(map[string]string)
+		row["ID"] = strconv.Itoa(i + 1)
+		fieldsMap := map[string]string{
+			"name":     "Name",
+			"host":     "IP",
+			"type":     "DBType",
+			"database": "DBName",
+			"comment":  "Comment"}
+		row = convertMapItemToRow(j, fieldsMap, row)
+		// 特殊处理 comment
+		row["Comment"] = joinMultiLineString(row["Comment"])
+		data[i] = row
+	}
+	w, _ := term.GetSize()
+
+	caption := fmt.Sprintf(i18n.T("Page: %d, Count: %d, Total Page: %d, Total Count: %d"),
+		currentPage, pageSize, totalPage, totalCount)
+
+	caption = utils.WrapperString(caption, utils.Green)
+	table := common.WrapperTable{
+		Fields: fields,
+		Labels: Labels,
+		FieldsSize: map[string][3]int{
+			"ID":      {0, 0, 5},
+			"Name":    {0, 8, 0},
+			"IP":      {0, 15, 40},
+			"DBType":  {0, 8, 0},
+			"DBName":  {0, 8, 0},
+			"Comment": {0, 0, 0},
+		},
+		Data:        data,
+		TotalSize:   w,
+		Caption:     caption,
+		TruncPolicy: common.TruncMiddle,
+	}
+	table.Initial()
+	loginTip := i18n.T("Enter ID number directly login the database, multiple search use // + field, such as: //16")
+	pageActionTip := i18n.T("Page up: b	Page down: n")
+	actionTip := fmt.Sprintf("%s %s", loginTip, pageActionTip)
+
+	_, _ = term.Write([]byte(utils.CharClear))
+	_, _ = term.Write([]byte(table.Display()))
+	utils.IgnoreErrWriteString(term, utils.WrapperString(actionTip, utils.Green))
+	utils.IgnoreErrWriteString(term, utils.CharNewLine)
+	utils.IgnoreErrWriteString(term, utils.WrapperString(searchHeader, utils.Green))
+	utils.IgnoreErrWriteString(term, utils.CharNewLine)
+}
+
+func (u *UserSelectHandler) proxyDatabase(dbType dbAppType, appId string) {
+	dbApp, err := u.h.jmsService.GetDatabaseApplicationById(appId)
+	if err != nil || dbApp.ID == "" {
+		logger.Errorf("Select %s %s not found", dbType.name, appId)
+		return
+	}
+	systemUsers, err := u.h.jmsService.GetUserApplicationSystemUsers(u.user.ID, dbApp.ID)
+	if err != nil {
+		return
+	}
+	highestSystemUsers := selectHighestPrioritySystemUsers(systemUsers)
+	selectedSystemUser, ok := u.h.chooseSystemUser(highestSystemUsers)
+	if !ok {
+		logger.Infof("User %s don't select systemUser", u.user.Name)
+		return
+	}
+	srv, err := proxy.NewServer(u.h.sess, u.h.jmsService,
+		proxy.ConnectProtocolType(dbType.protocol),
+		proxy.ConnectDBApp(&dbApp),
+		proxy.ConnectSystemUser(&selectedSystemUser),
+		proxy.ConnectUser(u.user),
+	)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	srv.Proxy()
+	logger.Infof("Request %s: %s %s proxy end", u.h.sess.Uuid, dbType.name, dbApp.Name)
+}
+
+// chooseDatabaseType 选择数据库类型, 返回 false 时返回主菜单
+func (h *InteractiveHandler) chooseDatabaseType() (dbType dbAppType, ok bool) {
+	idLabel := i18n.T("ID")
+	dbTypeLabel := i18n.T("DBType")
+
+	labels := []string{idLabel, dbTypeLabel}
+	fields := []string{"ID", "DBType"}
+
+	data := make([]map[string]string, len(dbAppTypes))
+	for i, j := range dbAppTypes {
+		row := make(map[string]string)
+		row["ID"] = strconv.Itoa(i + 1)
+		row["DBType"] = j.name
+		data[i] = row
+	}
+	w, _ := h.term.GetSize()
+	table := common.WrapperTable{
+		Fields: fields,
+		Labels: labels,
+		FieldsSize: map[string][3]int{
+			"ID":     {0, 0, 5},
+			"DBType": {0, 10, 0},
+		},
+		Data:        data,
+		TotalSize:   w,
+		TruncPolicy: common.TruncMiddle,
+	}
+	table.Initial()
+
+	h.term.SetPrompt("ID> ")
+	selectTip := i18n.T("Tips: Enter database type ID to display the databases")
+	backTip := i18n.T("Back: B/b")
+	for {
+		utils.IgnoreErrWriteString(h.term, table.Display())
+		utils.IgnoreErrWriteString(h.term, utils.WrapperString(selectTip, utils.Green))
+		utils.IgnoreErrWriteString(h.term, utils.CharNewLine)
+		utils.IgnoreErrWriteString(h.term, utils.WrapperString(backTip, utils.Green))
+		utils.IgnoreErrWriteString(h.term, utils.CharNewLine)
+		line, err := h.term.ReadLine()
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch strings.ToLower(line) {
+		case "q", "b", "quit", "exit", "back":
+			return
+		}
+		if num, err := strconv.Atoi(line); err == nil {
+			if num > 0 && num <= len(dbAppTypes) {
+				return dbAppTypes[num-1], true
+			}
+		}
+	}
+}
@@ -44,15 +44,25 @@ type Menu []MenuItem
 func Initial() {
 	defaultTitle = utils.WrapperTitle(i18n.T("Welcome to use JumpServer open source fortress system"))
 	menu = Menu{
-		{id: 1, instruct: i18n.T("part IP, Hostname, Comment"), helpText: i18n.T("to search login if unique")},
-		{id: 2, instruct: i18n.T("/ + IP, Hostname, Comment"), helpText: i18n.T("to search, such as: /192.168")},
-		{id: 3, instruct: "p", helpText: i18n.T("display the host you have permission")},
-		{id: 4, instruct: "g", helpText: i18n.T("display the node that you have permission")},
-		{id: 5, instruct: "d", helpText: i18n.T("display the databases that you have permission")},
-		{id: 6, instruct: "k", helpText: i18n.T("display the kubernetes that you have permission")},
-		{id: 7, instruct: "r", helpText: i18n.T("refresh your assets and nodes")},
-		{id: 8, instruct: "h", helpText: i18n.T("print help")},
-		{id: 9, instruct: "q", helpText: i18n.T("exit")},
+		{instruct: i18n.T("part IP, Hostname, Comment"), helpText: i18n.T("to search login if unique")},
+		{instruct: i18n.T("/ + IP, Hostname, Comment"), helpText: i18n.T("to search, such as: /192.168")},
+		{instruct: "p", helpText: i18n.T("display the host you have permission")},
+		{instruct: "g", helpText: i18n.T("display the node that you have permission")},
+		{instruct: "d", helpText: i18n.T("display the databases that you have permission")},
+	}
+	for _, dbType := range dbAppTypes {
+		if dbType.shortcut != "" {
+			menu = append(menu, MenuItem{instruct: dbType.shortcut, helpText: i18n.T(dbType.menuHelp)})
+		}
+	}
+	menu = append(menu, Menu{
+		{instruct: "k", helpText: i18n.T("display the kubernetes that you have permission")},
+		{instruct: "r", helpText: i18n.T("refresh your assets and nodes")},
+		{instruct: "h", helpText: i18n.T("print help")},
+		{instruct: "q", helpText: i18n.T("exit")},
+	}...)
+	for i := range menu {
+		menu[i].id = i + 1
 	}
 }
 
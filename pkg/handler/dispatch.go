@@ -44,8 +44,13 @@ func (h *InteractiveHandler) Dispatch() {
 				h.selectHandler.MovePrePage()
 				continue
 			case "d":
-				h.selectHandler.SetSelectType(TypeMySQL)
-				h.selectHandler.Search("")
+				if dbType, ok := h.chooseDatabaseType(); ok {
+					h.selectHandler.SetSelectType(dbType.selectType)
+					h.selectHandler.Search("")
+				} else {
+					h.displayBanner()
+					initialed = false
+				}
 				continue
 			case "n":
 				h.selectHandler.MoveNextPage()
@@ -68,6 +73,12 @@ func (h *InteractiveHandler) Dispatch() {
 				h.selectHandler.SetSelectType(TypeK8s)
 				h.selectHandler.Search("")
 				continue
+			default:
+				if dbType, ok := getDBAppTypeByShortcut(strings.ToLower(line)); ok {
+					h.selectHandler.SetSelectType(dbType.selectType)
+					h.selectHandler.Search("")
+					continue
+				}
 			}
 		default:
 			switch {
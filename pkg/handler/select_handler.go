@@ -26,6 +26,11 @@ const (
 	TypeNodeAsset
 	TypeK8s
 	TypeMySQL
+	TypePostgreSQL
+	TypeRedis
+	TypeMongoDB
+	TypeSQLServer
+	TypeOracle
 )
 
 type UserSelectHandler struct {
@@ -61,8 +66,10 @@ func (u *UserSelectHandler) SetSelectType(s selectType) {
 		u.h.term.SetPrompt("[Host]> ")
 	case TypeK8s:
 		u.h.term.SetPrompt("[K8S]> ")
-	case TypeMySQL:
-		u.h.term.SetPrompt("[DB]> ")
+	default:
+		if _, ok := getDBAppType(s); ok {
+			u.h.term.SetPrompt("[DB]> ")
+		}
 	}
 	u.currentType = s
 }
@@ -93,8 +100,10 @@ func (u *UserSelectHandler) AutoCompletion() {
 						fmt.Fprintf(u.h.term, "%s%s\n%s\n", "[Host]> ", line, utils.Pretty(sugs, termWidth))
 					case TypeK8s:
 						fmt.Fprintf(u.h.term, "%s%s\n%s\n", "[K8S]> ", line, utils.Pretty(sugs, termWidth))
-					case TypeMySQL:
-						fmt.Fprintf(u.h.term, "%s%s\n%s\n", "[DB]> ", line, utils.Pretty(sugs, termWidth))
+					default:
+						if _, ok := getDBAppType(u.currentType); ok {
+							fmt.Fprintf(u.h.term, "%s%s\n%s\n", "[DB]> ", line, utils.Pretty(sugs, termWidth))
+						}
 					}
 					return commonPrefix, len(commonPrefix), true
 				}
@@ -196,9 +205,11 @@ func (u *UserSelectHandler) HasNext() bool {
 
 func (u *UserSelectHandler) DisplayCurrentResult() {
 	searchHeader := fmt.Sprintf(i18n.T("Search: %s"), strings.Join(u.searchKeys, " "))
+	if _, ok := getDBAppType(u.currentType); ok {
+		u.displayDatabaseResult(searchHeader)
+		return
+	}
 	switch u.currentType {
-	case TypeMySQL:
-		u.displayMySQLResult(searchHeader)
 	case TypeK8s:
 		u.displayK8sResult(searchHeader)
 	case TypeNodeAsset:
@@ -212,6 +223,10 @@ func (u *UserSelectHandler) DisplayCurrentResult() {
 
 func (u *UserSelectHandler) Proxy(target map[string]interface{}) {
 	targetId := target["id"].(string)
+	if dbType, ok := getDBAppType(u.currentType); ok {
+		u.proxyDatabase(dbType, targetId)
+		return
+	}
 	switch u.currentType {
 	case TypeAsset, TypeNodeAsset:
 		asset, err := u.h.jmsService.GetAssetById(targetId)
@@ -233,13 +248,6 @@ func (u *UserSelectHandler) Proxy(target map[string]interface{}) {
 			return
 		}
 		u.proxyK8s(app)
-	case TypeMySQL:
-		app, err := u.h.jmsService.GetMySQLOrMariadbApplicationById(targetId)
-		if err != nil || app.ID == "" {
-			logger.Errorf("Select MySQL %s not found", targetId)
-			return
-		}
-		u.proxyMySQL(app)
 	default:
 		logger.Errorf("Select unknown type for target id %s", targetId)
 	}
@@ -297,9 +305,10 @@ func (u *UserSelectHandler) retrieveFromLocal(pageSize, offset int, searches ...
 }
 
 func (u *UserSelectHandler) retrieveLocal(searches ...string) []map[string]interface{} {
+	if _, ok := getDBAppType(u.currentType); ok {
+		return u.searchLocalDatabase(searches...)
+	}
 	switch u.currentType {
-	case TypeMySQL:
-		return u.searchLocalMySQL(searches...)
 	case TypeK8s:
 		return u.searchLocalK8s(searches...)
 	case TypeAsset:
@@ -328,9 +337,10 @@ func (u *UserSelectHandler) retrieveFromRemote(pageSize, offset int, searches ..
 		Offset:   offset,
 		Searches: searches,
 	}
+	if dbType, ok := getDBAppType(u.currentType); ok {
+		return u.retrieveRemoteDatabase(dbType, reqParam)
+	}
 	switch u.currentType {
-	case TypeMySQL:
-		return u.retrieveRemoteMySQLAndMariadb(reqParam)
 	case TypeK8s:
 		return u.retrieveRemoteK8s(reqParam)
 	case TypeNodeAsset:
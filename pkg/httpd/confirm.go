@@ -0,0 +1,31 @@
+package httpd
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jumpserver/koko/pkg/logger"
+	"github.com/jumpserver/koko/pkg/proxy"
+)
+
+// CommandConfirmCallbackHandler webhook 方式的命令复核结果回调
+func (s *Server) CommandConfirmCallbackHandler(ctx *gin.Context) {
+	id := ctx.Param("id")
+	callback, err := proxy.ReadCommandConfirmCallback(ctx.Request)
+	if err == nil {
+		err = proxy.ResolveCommandConfirm(id, callback)
+	}
+	switch {
+	case err == nil:
+		ctx.JSON(http.StatusOK, gin.H{"ok": true})
+	case errors.Is(err, proxy.ErrConfirmNotFound):
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, proxy.ErrInvalidConfirmSignature), errors.Is(err, proxy.ErrInvalidConfirmCallback):
+		logger.Errorf("Command confirm %s callback from %s: %s", id, ctx.ClientIP(), err)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,258 @@
+package httpd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jumpserver/koko/pkg/common"
+	"github.com/jumpserver/koko/pkg/config"
+	"github.com/jumpserver/koko/pkg/logger"
+	"github.com/jumpserver/koko/pkg/proxy"
+)
+
+/*
+	本地录像播放, 用于 Core 不可用或者录像还未上传时查看 data/replays 下的录像。
+	录像统一转换为 asciicast v2 格式返回, 时间由服务端按参数调整:
+	start  从第几秒开始播放, 之前的输出合并到 0 秒
+	speed  播放倍速, 默认为 1
+	pause  最长的停顿秒数, 超过时缩短为该值, 默认不限制
+*/
+
+const replayGzipExt = ".replay.gz"
+
+type LocalReplay struct {
+	ID           string    `json:"id"`
+	Date         string    `json:"date"`
+	Size         int64     `json:"size"`
+	DateModified time.Time `json:"date_modified"`
+	Encrypted    bool      `json:"encrypted"`
+}
+
+// listLocalReplays 已压缩的录像优先, 按修改时间倒序
+func listLocalReplays(replayDir string) ([]LocalReplay, error) {
+	replays := make(map[string]LocalReplay)
+	err := filepath.Walk(replayDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		sid, compressed := parseReplayFilename(info.Name())
+		if sid == "" {
+			return nil
+		}
+		if _, ok := replays[sid]; ok && !compressed {
+			return nil
+		}
+		replays[sid] = LocalReplay{
+			ID:           sid,
+			Date:         filepath.Base(filepath.Dir(path)),
+			Size:         info.Size(),
+			DateModified: info.ModTime(),
+			Encrypted:    compressed && proxy.IsEncryptedReplay(path),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]LocalReplay, 0, len(replays))
+	for _, item := range replays {
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DateModified.After(result[j].DateModified)
+	})
+	return result, nil
+}
+
+// parseReplayFilename 录像文件名为 {sid}.replay.gz, 未压缩的录像为 {sid}
+func parseReplayFilename(name string) (sid string, compressed bool) {
+	if strings.HasSuffix(name, replayGzipExt) {
+		name = strings.TrimSuffix(name, replayGzipExt)
+		compressed = true
+	}
+	if !common.ValidUUIDString(name) {
+		return "", false
+	}
+	return name, compressed
+}
+
+func findLocalReplay(replayDir, sid string) (string, error) {
+	var found string
+	err := filepath.Walk(replayDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if name, compressed := parseReplayFilename(info.Name()); name == sid {
+			if found == "" || compressed {
+				found = path
+			}
+		}
+		return nil
+	})
+	if err == nil && found == "" {
+		err = os.ErrNotExist
+	}
+	return found, err
+}
+
+type replayPlayOptions struct {
+	Start    float64
+	Speed    float64
+	MaxPause float64
+}
+
+func parseReplayPlayOptions(ctx *gin.Context) (opts replayPlayOptions, err error) {
+	opts.Speed = 1
+	params := []struct {
+		name  string
+		value *float64
+	}{
+		{"start", &opts.Start},
+		{"speed", &opts.Speed},
+		{"pause", &opts.MaxPause},
+	}
+	for _, param := range params {
+		value := ctx.Query(param.name)
+		if value == "" {
+			continue
+		}
+		if *param.value, err = strconv.ParseFloat(value, 64); err != nil || *param.value < 0 {
+			return opts, errors.New("invalid param " + param.name)
+		}
+	}
+	if opts.Speed == 0 {
+		return opts, errors.New("invalid param speed")
+	}
+	return opts, nil
+}
+
+// writePlayableReplay 转换成 asciicast v2, 并按参数调整每个事件的时间
+func writePlayableReplay(w io.Writer, decoder proxy.ReplayDecoder, opts replayPlayOptions) error {
+	header := decoder.Header()
+	if header.Width == 0 || header.Height == 0 {
+		header.Width, header.Height = 80, 24
+	}
+	h := map[string]interface{}{
+		"version": 2,
+		"width":   header.Width,
+		"height":  header.Height,
+	}
+	if !header.Timestamp.IsZero() {
+		h["timestamp"] = header.Timestamp.Unix()
+	}
+	if header.Term != "" {
+		h["env"] = map[string]string{"TERM": header.Term}
+	}
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	if err := encoder.Encode(h); err != nil {
+		return err
+	}
+	var (
+		// 开始时间之前的输出合并为一个事件
+		skipped  strings.Builder
+		lastTime = opts.Start
+		playTime float64
+	)
+	for {
+		event, err := decoder.Next()
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		if event.Time < opts.Start {
+			if event.Type == "o" {
+				skipped.WriteString(event.Data)
+			} else if err = encoder.Encode([]interface{}{0, event.Type, event.Data}); err != nil {
+				return err
+			}
+			continue
+		}
+		if skipped.Len() > 0 {
+			if err = encoder.Encode([]interface{}{0, "o", skipped.String()}); err != nil {
+				return err
+			}
+			skipped.Reset()
+		}
+		delay := event.Time - lastTime
+		if delay < 0 {
+			delay = 0
+		}
+		if opts.MaxPause > 0 && delay > opts.MaxPause {
+			delay = opts.MaxPause
+		}
+		lastTime = event.Time
+		playTime += delay / opts.Speed
+		if err = encoder.Encode([]interface{}{playTime, event.Type, event.Data}); err != nil {
+			return err
+		}
+	}
+	if skipped.Len() > 0 {
+		if err := encoder.Encode([]interface{}{0, "o", skipped.String()}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func (s *Server) LocalReplayListHandler(ctx *gin.Context) {
+	replays, err := listLocalReplays(config.GetConf().ReplayFolderPath)
+	if err != nil {
+		logger.Errorf("List local replays err: %s", err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, replays)
+}
+
+func (s *Server) LocalReplayPlayHandler(ctx *gin.Context) {
+	sid := ctx.Param("id")
+	if !common.ValidUUIDString(sid) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid replay id"})
+		return
+	}
+	opts, err := parseReplayPlayOptions(ctx)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	path, err := findLocalReplay(config.GetConf().ReplayFolderPath, sid)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "replay not found"})
+		return
+	}
+	if proxy.IsEncryptedReplay(path) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "replay is encrypted"})
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	decoder, err := proxy.NewReplayDecoder(f)
+	if err != nil {
+		logger.Errorf("Open local replay %s err: %s", path, err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid replay file"})
+		return
+	}
+	ctx.Header("Content-Type", "application/x-asciicast")
+	ctx.Status(http.StatusOK)
+	if err = writePlayableReplay(ctx.Writer, decoder, opts); err != nil {
+		logger.Errorf("Play local replay %s err: %s", path, err)
+	}
+}
@@ -0,0 +1,91 @@
+package httpd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/proxy"
+)
+
+const testReplay = `{"version": 2, "width": 80, "height": 24, "timestamp": 1504467315}
+[1.0, "o", "a"]
+[2.0, "o", "b"]
+[12.0, "o", "c"]
+[13.0, "r", "100x40"]
+`
+
+func TestWritePlayableReplay(t *testing.T) {
+	tests := []struct {
+		opts   replayPlayOptions
+		expect [][]interface{}
+	}{
+		{replayPlayOptions{Speed: 1}, [][]interface{}{
+			{1.0, "o", "a"}, {2.0, "o", "b"}, {12.0, "o", "c"}, {13.0, "r", "100x40"}}},
+		{replayPlayOptions{Speed: 2, MaxPause: 2}, [][]interface{}{
+			{0.5, "o", "a"}, {1.0, "o", "b"}, {2.0, "o", "c"}, {2.5, "r", "100x40"}}},
+		{replayPlayOptions{Start: 10, Speed: 1}, [][]interface{}{
+			{0.0, "o", "ab"}, {2.0, "o", "c"}, {3.0, "r", "100x40"}}},
+	}
+	for _, tt := range tests {
+		decoder, err := proxy.NewReplayDecoder(strings.NewReader(testReplay))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err = writePlayableReplay(&buf, decoder, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != len(tt.expect)+1 {
+			t.Fatalf("%+v: expect %d events, got %q", tt.opts, len(tt.expect), buf.String())
+		}
+		for i, expect := range tt.expect {
+			var event []interface{}
+			if err = json.Unmarshal([]byte(lines[i+1]), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event[0] != expect[0] || event[1] != expect[1] || event[2] != expect[2] {
+				t.Fatalf("%+v: expect %v, got %v", tt.opts, expect, event)
+			}
+		}
+	}
+}
+
+func TestListLocalReplays(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replays")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sid := "59a33a9e-1b51-4b5b-a1b9-3e5ce1e24ea6"
+	dateDir := filepath.Join(dir, "2021-08-01")
+	_ = os.MkdirAll(dateDir, 0700)
+	for _, name := range []string{sid, sid + replayGzipExt, "other.txt", sid + ".index"} {
+		if err = ioutil.WriteFile(filepath.Join(dateDir, name), []byte(testReplay), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-time.Hour)
+	_ = os.Chtimes(filepath.Join(dateDir, sid), old, old)
+
+	replays, err := listLocalReplays(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replays) != 1 || replays[0].ID != sid || replays[0].Date != "2021-08-01" {
+		t.Fatalf("invalid replays %+v", replays)
+	}
+	path, err := findLocalReplay(dir, sid)
+	if err != nil || filepath.Base(path) != sid+replayGzipExt {
+		t.Fatalf("expect compressed replay, got %s %v", path, err)
+	}
+	if _, err = findLocalReplay(dir, "a2c1b8f6-1d6e-4f0f-9a4b-0f5b1d2c3e4f"); !os.IsNotExist(err) {
+		t.Fatalf("expect not exist, got %v", err)
+	}
+}
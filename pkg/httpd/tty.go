@@ -9,6 +9,7 @@ import (
 	"github.com/gliderlabs/ssh"
 
 	"github.com/jumpserver/koko/pkg/exchange"
+	"github.com/jumpserver/koko/pkg/handler"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/common"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/service"
@@ -234,18 +235,19 @@ func (h *tty) ValidateShareParams(shareId, code string) (info ShareInfo, err err
 }
 
 func (h *tty) getTargetApp(protocol string) bool {
-	switch strings.ToLower(protocol) {
-	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb:
-		databaseAsset, err := h.jmsService.GetMySQLOrMariadbApplicationById(h.targetId)
+	protocol = strings.ToLower(protocol)
+	switch {
+	case handler.IsDatabaseProtocol(protocol):
+		databaseAsset, err := h.jmsService.GetDatabaseApplicationById(h.targetId)
 		if err != nil {
-			logger.Errorf("Get MySQL App failed; %s", err)
+			logger.Errorf("Get %s App failed; %s", protocol, err)
 			return false
 		}
 		if databaseAsset.ID != "" {
 			h.dbApp = &databaseAsset
 			return true
 		}
-	case srvconn.ProtocolK8s:
+	case protocol == srvconn.ProtocolK8s:
 		k8sCluster, err := h.jmsService.GetK8sApplicationById(h.targetId)
 		if err != nil {
 			logger.Errorf("Get K8s App failed; %s", err)
@@ -282,10 +284,10 @@ func (h *tty) proxy(wg *sync.WaitGroup) {
 		proxyOpts = append(proxyOpts, proxy.ConnectProtocolType(h.systemUser.Protocol))
 		proxyOpts = append(proxyOpts, proxy.ConnectSystemUser(h.systemUser))
 		proxyOpts = append(proxyOpts, proxy.ConnectUser(h.ws.user))
-		switch h.systemUser.Protocol {
-		case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb:
+		switch {
+		case handler.IsDatabaseProtocol(h.systemUser.Protocol):
 			proxyOpts = append(proxyOpts, proxy.ConnectDBApp(h.dbApp))
-		case srvconn.ProtocolK8s:
+		case h.systemUser.Protocol == srvconn.ProtocolK8s:
 			proxyOpts = append(proxyOpts, proxy.ConnectK8sApp(h.k8sApp))
 		default:
 			proxyOpts = append(proxyOpts, proxy.ConnectAsset(h.assetApp))
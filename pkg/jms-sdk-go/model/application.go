@@ -42,8 +42,13 @@ func (db DatabaseApplication) String() string {
 }
 
 const (
-	AppTypeMySQL = "mysql"
-	AppTypeK8s   = "k8s"
+	AppTypeMySQL      = "mysql"
+	AppTypeK8s        = "k8s"
+	AppTypePostgreSQL = "postgresql"
+	AppTypeRedis      = "redis"
+	AppTypeMongoDB    = "mongodb"
+	AppTypeSQLServer  = "sqlserver"
+	AppTypeOracle     = "oracle"
 )
 
 const AppType = "Application"
\ No newline at end of file
@@ -12,16 +12,33 @@ type Command struct {
 	SystemUser string `json:"system_user"`
 	Timestamp  int64  `json:"timestamp"`
 	RiskLevel  int64  `json:"risk_level"`
+	// OutputRef 完整输出在录像存储中的路径
+	OutputRef string `json:"output_ref,omitempty"`
+	// RuleID 匹配的命令过滤规则
+	RuleID string `json:"rule_id,omitempty"`
+	// Kubectl k8s 会话中解析的 kubectl 命令
+	Kubectl []KubectlMeta `json:"kubectl,omitempty"`
 
 	DateCreated time.Time `json:"@timestamp"`
 }
 
+type KubectlMeta struct {
+	Verb       string            `json:"verb"`
+	Subcommand string            `json:"subcommand,omitempty"`
+	Resources  []string          `json:"resources,omitempty"`
+	Names      []string          `json:"names,omitempty"`
+	Namespace  string            `json:"namespace,omitempty"`
+	Flags      map[string]string `json:"flags,omitempty"`
+}
+
 const (
 	HighRiskFlag = "1"
 	LessRiskFlag = "0"
+	WarnRiskFlag = "2"
 )
 
 const (
-	DangerLevel = 5
-	NormalLevel = 0
+	DangerLevel  = 5
+	WarningLevel = 3
+	NormalLevel  = 0
 )
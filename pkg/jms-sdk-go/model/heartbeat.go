@@ -6,4 +6,5 @@ type HeartbeatData struct {
 	CpuUsed          float64  `json:"cpu_load"`
 	MemoryUsed       float64  `json:"memory_used"`
 	DiskUsed         float64  `json:"disk_used"`
+	CommandSpoolSize int64    `json:"command_spool_size"`
 }
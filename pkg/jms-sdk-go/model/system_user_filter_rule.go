@@ -16,6 +16,8 @@ const (
 	ActionAllow   RuleAction = 1
 	ActionConfirm RuleAction = 2
 	ActionUnknown RuleAction = 3
+	// ActionAudit 只记录匹配的命令, 不拦截
+	ActionAudit RuleAction = 4
 
 	TypeRegex = "regex"
 	TypeCmd   = "command"
@@ -102,11 +104,17 @@ func (f FilterRules) Less(i, j int) bool {
 	}
 }
 
+// StricterThan 动作的严格程度, 多个命令匹配到不同规则时取最严格的动作
+func (a RuleAction) StricterThan(other RuleAction) bool {
+	return actionPriorityMap[a] < actionPriorityMap[other]
+}
+
 var (
 	actionPriorityMap = map[RuleAction]int{
 		ActionDeny:    0,
 		ActionConfirm: 1,
-		ActionAllow:   2,
-		ActionUnknown: 3,
+		ActionAudit:   2,
+		ActionAllow:   3,
+		ActionUnknown: 4,
 	}
 )
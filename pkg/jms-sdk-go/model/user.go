@@ -32,6 +32,12 @@ type User struct {
 	OTPLevel int    `json:"otp_level"`
 }
 
+const (
+	RoleAdmin   = "Admin"
+	RoleAuditor = "Auditor"
+	RoleUser    = "User"
+)
+
 func (u *User) String() string {
 	return fmt.Sprintf("%s(%s)", u.Name, u.Username)
 }
@@ -40,6 +46,7 @@ type TokenUser struct {
 	UserID         string `json:"user"`
 	UserName       string `json:"username"`
 	AssetID        string `json:"asset"`
+	ApplicationID  string `json:"application"`
 	Hostname       string `json:"hostname"`
 	SystemUserID   string `json:"system_user"`
 	SystemUserName string `json:"system_user_name"`
@@ -5,12 +5,8 @@ import (
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
 )
 
-func (s *JMService) GetMySQLApplicationById(appId string) (app model.DatabaseApplication, err error) {
-	err = s.getApplicationById(appId, &app)
-	return
-}
-
-func (s *JMService) GetMySQLOrMariadbApplicationById(appId string) (app model.DatabaseApplication, err error) {
+// GetDatabaseApplicationById 各类数据库应用的详情格式相同
+func (s *JMService) GetDatabaseApplicationById(appId string) (app model.DatabaseApplication, err error) {
 	err = s.getApplicationById(appId, &app)
 	return
 }
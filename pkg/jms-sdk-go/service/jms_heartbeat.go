@@ -7,12 +7,18 @@ import (
 )
 
 func (s *JMService) TerminalHeartBeat(sIds []string) (res []model.TerminalTask, err error) {
+	return s.TerminalHeartBeatWithSpool(sIds, 0)
+}
+
+// TerminalHeartBeatWithSpool 同时上报本地缓存的命令大小
+func (s *JMService) TerminalHeartBeatWithSpool(sIds []string, spoolSize int64) (res []model.TerminalTask, err error) {
 	data := model.HeartbeatData{
 		SessionOnlineIds: sIds,
 		CpuUsed:          common.CpuLoad1Usage(),
 		MemoryUsed:       common.MemoryUsagePercent(),
 		DiskUsed:         common.DiskUsagePercent(),
 		SessionOnline:    len(sIds),
+		CommandSpoolSize: spoolSize,
 	}
 	_, err = s.authClient.Post(TerminalHeartBeatURL, data, &res)
 	return
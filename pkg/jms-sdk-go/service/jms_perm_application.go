@@ -36,6 +36,12 @@ func (s *JMService) GetUserPermsMySQLAndMariadb(userId string, param model.Pagin
 	return s.getPaginationResult(reqUrl, param)
 }
 
+// GetUserPermsDatabases 用户有权限的指定类型的数据库应用
+func (s *JMService) GetUserPermsDatabases(userId, appType string, param model.PaginationParam) (resp model.PaginationResponse, err error) {
+	reqUrl := fmt.Sprintf(UserPermsApplicationsURL, userId, appType)
+	return s.getPaginationResult(reqUrl, param)
+}
+
 func (s *JMService) GetUserPermsK8s(userId string, param model.PaginationParam) (resp model.PaginationResponse, err error) {
 	reqUrl := fmt.Sprintf(UserPermsApplicationsURL, userId, model.AppTypeK8s)
 	return s.getPaginationResult(reqUrl, param)
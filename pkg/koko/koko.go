@@ -8,12 +8,15 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/jumpserver/koko/pkg/auditlog"
 	"github.com/jumpserver/koko/pkg/config"
 	"github.com/jumpserver/koko/pkg/exchange"
 	"github.com/jumpserver/koko/pkg/handler"
 	"github.com/jumpserver/koko/pkg/httpd"
 	"github.com/jumpserver/koko/pkg/i18n"
 	"github.com/jumpserver/koko/pkg/logger"
+	"github.com/jumpserver/koko/pkg/mysqld"
+	"github.com/jumpserver/koko/pkg/proxy"
 	"github.com/jumpserver/koko/pkg/sshd"
 
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
@@ -25,6 +28,8 @@ var Version = "unknown"
 type Koko struct {
 	webSrv *httpd.Server
 	sshSrv *sshd.Server
+	// mysqlSrv 开启 MySQL 协议代理时不为空
+	mysqlSrv *mysqld.Server
 }
 
 const (
@@ -39,9 +44,15 @@ func (k *Koko) Start() {
 	fmt.Printf(startWelcomeMsg, time.Now().Format(timeFormat), Version)
 	go k.webSrv.Start()
 	go k.sshSrv.Start()
+	if k.mysqlSrv != nil {
+		go k.mysqlSrv.Start()
+	}
 }
 
 func (k *Koko) Stop() {
+	if k.mysqlSrv != nil {
+		k.mysqlSrv.Stop()
+	}
 	k.sshSrv.Stop()
 	k.webSrv.Stop()
 	logger.Info("Quit The KoKo")
@@ -50,6 +61,9 @@ func (k *Koko) Stop() {
 func RunForever(confPath string) {
 	config.Setup(confPath)
 	bootstrap()
+	if err := proxy.CheckCommandConfirmConfig(config.GetConf()); err != nil {
+		logger.Fatal(err)
+	}
 	gracefulStop := make(chan os.Signal, 1)
 	signal.Notify(gracefulStop, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 	jmsService := MustJMService()
@@ -61,6 +75,9 @@ func RunForever(confPath string) {
 		webSrv: webSrv,
 		sshSrv: sshSrv,
 	}
+	if config.GetConf().EnableMySQLProxy {
+		app.mysqlSrv = mysqld.NewMySQLServer(srv)
+	}
 	app.Start()
 	runTasks(jmsService)
 	<-gracefulStop
@@ -72,12 +89,15 @@ func bootstrap() {
 	logger.Initial()
 	handler.Initial()
 	exchange.Initial()
+	auditlog.Initial(Version)
 }
 
 func runTasks(jmsService *service.JMService) {
 	if config.GetConf().UploadFailedReplay {
 		go uploadRemainReplay(jmsService)
 	}
+	proxy.InitialCommandSpool(jmsService)
+	proxy.InitialLocalFilterPolicy()
 	go keepHeartbeat(jmsService)
 }
 
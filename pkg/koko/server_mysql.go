@@ -0,0 +1,157 @@
+package koko
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/gliderlabs/ssh"
+
+	"github.com/jumpserver/koko/pkg/common"
+	"github.com/jumpserver/koko/pkg/config"
+	"github.com/jumpserver/koko/pkg/exchange"
+	"github.com/jumpserver/koko/pkg/logger"
+	"github.com/jumpserver/koko/pkg/mysqld"
+	"github.com/jumpserver/koko/pkg/proxy"
+	"github.com/jumpserver/koko/pkg/srvconn"
+)
+
+func (s *server) GetMySQLAddr() string {
+	cf := config.GlobalConfig
+	host := cf.MySQLProxyHost
+	if host == "" {
+		host = cf.BindHost
+	}
+	return net.JoinHostPort(host, cf.MySQLProxyPort)
+}
+
+// GetMySQLTLSConfig 没有配置证书时返回 nil
+func (s *server) GetMySQLTLSConfig() *tls.Config {
+	cf := config.GlobalConfig
+	if cf.MySQLProxyTLSCert == "" && cf.MySQLProxyTLSKey == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(cf.MySQLProxyTLSCert, cf.MySQLProxyTLSKey)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+}
+
+// MySQLSessionHandler MySQL 客户端的用户名为 JumpServer 用户名, 密码为连接 token, 到 Core 校验
+func (s *server) MySQLSessionHandler(conn *mysqld.Conn, resp *mysqld.HandshakeResponse) {
+	userConn := newMySQLUserConn(conn)
+	defer userConn.Close()
+	opts, err := s.getMySQLConnectOptions(resp.Username, resp.Password)
+	if err != nil {
+		logger.Errorf("MySQL conn from %s user %s auth failed: %s", userConn.RemoteAddr(), resp.Username, err)
+		_ = conn.WriteError(mysqld.NewError(mysqld.ErAccessDenied, mysqld.StateAccessDenied,
+			"Access denied for user '%s'", resp.Username))
+		return
+	}
+	srv, err := proxy.NewServer(userConn, s.jmsService, opts...)
+	if err != nil {
+		logger.Errorf("Create proxy server failed: %s", err)
+		_ = conn.WriteError(mysqld.NewError(mysqld.ErAccessDenied, mysqld.StateAccessDenied, "%s", err))
+		return
+	}
+	srv.ProxyMySQLWire(conn, resp)
+}
+
+func (s *server) getMySQLConnectOptions(username, token string) ([]proxy.ConnectionOption, error) {
+	if token == "" {
+		return nil, fmt.Errorf("token is empty")
+	}
+	tokenUser, err := s.jmsService.GetTokenAsset(token)
+	if err != nil || tokenUser.UserID == "" {
+		return nil, fmt.Errorf("token is invalid: %v", err)
+	}
+	if tokenUser.UserName != username {
+		return nil, fmt.Errorf("token belongs to user %s", tokenUser.UserName)
+	}
+	if tokenUser.ApplicationID == "" {
+		return nil, fmt.Errorf("token is not for a database application")
+	}
+	user, err := s.jmsService.GetUserById(tokenUser.UserID)
+	if err != nil || user == nil {
+		return nil, fmt.Errorf("token user %s is invalid: %v", tokenUser.UserID, err)
+	}
+	systemUser, err := s.jmsService.GetSystemUserById(tokenUser.SystemUserID)
+	if err != nil || systemUser.ID == "" {
+		return nil, fmt.Errorf("token system user %s is invalid: %v", tokenUser.SystemUserID, err)
+	}
+	switch systemUser.Protocol {
+	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb:
+	default:
+		return nil, fmt.Errorf("system user %s protocol %s is not mysql", systemUser.Name, systemUser.Protocol)
+	}
+	dbApp, err := s.jmsService.GetDatabaseApplicationById(tokenUser.ApplicationID)
+	if err != nil || dbApp.ID == "" {
+		return nil, fmt.Errorf("token application %s is invalid: %v", tokenUser.ApplicationID, err)
+	}
+	return []proxy.ConnectionOption{
+		proxy.ConnectProtocolType(systemUser.Protocol),
+		proxy.ConnectSystemUser(&systemUser),
+		proxy.ConnectUser(user),
+		proxy.ConnectDBApp(&dbApp),
+		proxy.ConnectMySQLWire(),
+	}, nil
+}
+
+var _ proxy.UserConnection = (*mysqlUserConn)(nil)
+
+// mysqlUserConn MySQL 协议代理的客户端, 没有终端, 写入的提示信息忽略
+type mysqlUserConn struct {
+	id     string
+	conn   *mysqld.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newMySQLUserConn(conn *mysqld.Conn) *mysqlUserConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &mysqlUserConn{id: common.UUID(), conn: conn, ctx: ctx, cancel: cancel}
+}
+
+func (c *mysqlUserConn) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (c *mysqlUserConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (c *mysqlUserConn) Close() error {
+	c.cancel()
+	return c.conn.Close()
+}
+
+func (c *mysqlUserConn) ID() string {
+	return c.id
+}
+
+func (c *mysqlUserConn) WinCh() <-chan ssh.Window {
+	return nil
+}
+
+// LoginFrom DT 数据库客户端
+func (c *mysqlUserConn) LoginFrom() string {
+	return "DT"
+}
+
+func (c *mysqlUserConn) RemoteAddr() string {
+	host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+	return host
+}
+
+func (c *mysqlUserConn) Pty() ssh.Pty {
+	return ssh.Pty{Term: "mysql", Window: ssh.Window{Width: 80, Height: 24}}
+}
+
+func (c *mysqlUserConn) Context() context.Context {
+	return c.ctx
+}
+
+func (c *mysqlUserConn) HandleRoomEvent(event string, msg *exchange.RoomMessage) {}
@@ -1,6 +1,8 @@
 package koko
 
 import (
+	"bytes"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -22,6 +24,11 @@ func uploadRemainReplay(jmsService *service.JMService) {
 		return
 	}
 	replayStorage := proxy.NewReplayStorage(jmsService, &conf)
+	recipient, err := proxy.LoadReplayRecipient()
+	if err != nil {
+		logger.Errorf("Load replay encrypt key failed, remain replay not upload: %s", err)
+		return
+	}
 	allRemainFiles := make(map[string]string)
 	_ = filepath.Walk(replayDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil || info.IsDir() {
@@ -64,6 +71,12 @@ func uploadRemainReplay(jmsService *service.JMService) {
 			}
 			_ = os.Remove(path)
 		}
+		if recipient != nil {
+			if err := proxy.EncryptReplayFile(absGzPath, recipient); err != nil {
+				logger.Errorf("Encrypt remain replay file %s failed: %s", absGzPath, err)
+				continue
+			}
+		}
 		Target, _ := filepath.Rel(replayDir, absGzPath)
 		if err2 := replayStorage.Upload(absGzPath, Target); err2 != nil {
 			logger.Errorf("Upload remain replay file %s failed: %s", absGzPath, err2)
@@ -76,6 +89,9 @@ func uploadRemainReplay(jmsService *service.JMService) {
 		_ = os.Remove(absGzPath)
 		logger.Infof("Upload remain replay file %s success", absGzPath)
 	}
+	if proxy.IsObjectStorage(replayStorage) {
+		proxy.UploadRemainReplayParts(replayStorage, recipient, replayDir)
+	}
 	logger.Info("Upload remain replay done")
 }
 
@@ -84,7 +100,7 @@ func keepHeartbeat(jmsService *service.JMService) {
 	for {
 		time.Sleep(30 * time.Second)
 		data := proxy.GetAliveSessions()
-		tasks, err := jmsService.TerminalHeartBeat(data)
+		tasks, err := jmsService.TerminalHeartBeatWithSpool(data, proxy.GetCommandSpoolSize())
 		if err != nil {
 			logger.Error(err)
 			continue
@@ -111,6 +127,14 @@ const (
 )
 
 func ValidateRemainReplayFile(path string) error {
+	format, err := proxy.DetectReplayFormat(path)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case proxy.ReplayFormatAsciicast:
+		return validateAsciicastReplayFile(path)
+	}
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, os.ModePerm)
 	if err != nil {
 		return err
@@ -135,3 +159,33 @@ func ValidateRemainReplayFile(path string) error {
 	}
 	return err
 }
+
+// validateAsciicastReplayFile asciicast 每行都是一个完整的事件, 截断异常退出时残留的不完整行即可
+func validateAsciicastReplayFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4096)
+	end := stat.Size()
+	for end > 0 {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		nr, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if index := bytes.LastIndexByte(buf[:nr], '\n'); index >= 0 {
+			return f.Truncate(start + int64(index) + 1)
+		}
+		end = start
+	}
+	return f.Truncate(0)
+}
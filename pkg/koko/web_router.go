@@ -69,6 +69,28 @@ func registerWebHandlers(jmsService *service.JMService, webSrv *httpd.Server) {
 			ctx.HTML(http.StatusOK, "index.html", nil)
 		})
 	}
+	if config.GetConf().EnableLocalReplay {
+		// 页面不包含录像数据, 未认证时由页面输入 token 后请求 API
+		replayGroup := kokoGroup.Group("/replay")
+		{
+			replayGroup.GET("/", func(ctx *gin.Context) {
+				ctx.HTML(http.StatusOK, "index.html", nil)
+			})
+			replayGroup.GET("/:id/", func(ctx *gin.Context) {
+				ctx.HTML(http.StatusOK, "index.html", nil)
+			})
+		}
+		replayAPIGroup := kokoGroup.Group("/api/replays")
+		replayAPIGroup.Use(auth.HTTPMiddleReplayAuth(jmsService))
+		{
+			replayAPIGroup.GET("/", webSrv.LocalReplayListHandler)
+			replayAPIGroup.GET("/:id/", webSrv.LocalReplayPlayHandler)
+		}
+	}
+	if config.GetConf().CommandConfirmBackend == "webhook" {
+		// 回调使用签名校验
+		kokoGroup.POST("/api/confirms/:id/", webSrv.CommandConfirmCallbackHandler)
+	}
 	elfindlerGroup := kokoGroup.Group("/elfinder")
 	elfindlerGroup.Use(auth.HTTPMiddleSessionAuth(jmsService))
 	{
@@ -0,0 +1,75 @@
+package mysqld
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+/*
+	代理作为服务端的认证: 客户端的密码为 Core 的连接 token, 代理需要明文才能到 Core 校验,
+	使用 caching_sha2_password 的完整认证读取密码:
+	1. 握手使用 caching_sha2_password, 客户端使用其他插件时发送 AuthSwitchRequest 切换;
+	   mysql_native_password 的摘要无法还原明文, 代理事先也不知道 token, 不能用来校验
+	2. 客户端发送摘要后回复 perform full auth
+	3. SSL 连接中客户端发送明文密码; 非 SSL 连接中客户端请求公钥
+	   (mysql --get-server-public-key, JDBC allowPublicKeyRetrieval=true), 发送公钥加密的 密码 XOR salt
+*/
+
+// ReadAuthPassword 使用 caching_sha2_password 的完整认证读取客户端的明文密码;
+// 非 SSL 连接使用 key 交换密码, key 为空时只支持 SSL 连接
+func (c *Conn) ReadAuthPassword(resp *HandshakeResponse, key *rsa.PrivateKey) (string, error) {
+	if resp.AuthPlugin != AuthCachingSha2Password {
+		if resp.Capabilities&ClientPluginAuth == 0 {
+			return "", fmt.Errorf("%w: %s required", ErrUnsupportedClient, AuthCachingSha2Password)
+		}
+		payload := []byte{iEOF}
+		payload = append(payload, AuthCachingSha2Password...)
+		payload = append(payload, 0)
+		payload = append(payload, resp.salt...)
+		payload = append(payload, 0)
+		if err := c.WritePacket(payload); err != nil {
+			return "", err
+		}
+		// 切换后的摘要同样不使用
+		if _, err := c.ReadPacket(); err != nil {
+			return "", err
+		}
+	}
+	if err := c.WritePacket([]byte{iAuthMoreData, cachingSha2PerformFullAuth}); err != nil {
+		return "", err
+	}
+	data, err := c.ReadPacket()
+	if err != nil {
+		return "", err
+	}
+	if c.secure {
+		return string(bytes.TrimSuffix(data, []byte{0})), nil
+	}
+	if key == nil || !bytes.Equal(data, []byte{cachingSha2RequestPublicKey}) {
+		return "", fmt.Errorf("%w: ssl or server public key retrieval required", ErrUnsupportedClient)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	payload := []byte{iAuthMoreData}
+	payload = append(payload, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	if err = c.WritePacket(payload); err != nil {
+		return "", err
+	}
+	if data, err = c.ReadPacket(); err != nil {
+		return "", err
+	}
+	plain, err := rsa.DecryptOAEP(sha1.New(), nil, key, data, nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: decrypt password failed", ErrUnsupportedClient)
+	}
+	for i := range plain {
+		plain[i] ^= resp.salt[i%len(resp.salt)]
+	}
+	return string(bytes.TrimSuffix(plain, []byte{0})), nil
+}
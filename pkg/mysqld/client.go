@@ -0,0 +1,318 @@
+package mysqld
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	cachingSha2RequestPublicKey byte = 0x02
+	cachingSha2FastAuthSuccess  byte = 0x03
+	cachingSha2PerformFullAuth  byte = 0x04
+)
+
+var ErrUnsupportedServer = errors.New("mysql server unsupported")
+
+// ClientConfig 代理登录后端使用的参数
+type ClientConfig struct {
+	Username string
+	Password string
+	Database string
+	// Capabilities 客户端与代理协商的能力
+	Capabilities uint32
+	Charset      uint8
+	// TLSConfig 不为空时使用 SSL 登录
+	TLSConfig *tls.Config
+}
+
+// ClientHandshake 作为客户端登录后端, 后端拒绝时返回 *Error
+func (c *Conn) ClientHandshake(cfg ClientConfig) error {
+	c.ResetSequence()
+	data, err := c.ReadPacket()
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 && data[0] == iERR {
+		return ParseError(data)
+	}
+	greeting, err := parseServerGreeting(data)
+	if err != nil {
+		return err
+	}
+	caps := cfg.Capabilities&greeting.capabilities | ClientProtocol41 | ClientSecureConnection
+	caps &^= ClientSSL | ClientCompress | ClientLocalFiles | ClientConnectAttrs |
+		ClientSessionTrack | ClientDeprecateEOF
+	if cfg.Database != "" && greeting.capabilities&ClientConnectWithDB != 0 {
+		caps |= ClientConnectWithDB
+	} else {
+		caps &^= ClientConnectWithDB
+	}
+	if greeting.capabilities&ClientPluginAuth != 0 {
+		caps |= ClientPluginAuth
+	}
+	if greeting.capabilities&ClientSecureConnection == 0 {
+		return fmt.Errorf("%w: secure connection required", ErrUnsupportedServer)
+	}
+	if cfg.TLSConfig != nil {
+		if greeting.capabilities&ClientSSL == 0 {
+			return fmt.Errorf("%w: ssl is not supported", ErrUnsupportedServer)
+		}
+		caps |= ClientSSL
+	}
+	charset := cfg.Charset
+	if charset == 0 {
+		charset = defaultCharset
+	}
+	plugin := greeting.authPlugin
+	if plugin == "" {
+		plugin = AuthNativePassword
+	}
+	authResp, err := scramblePassword(plugin, greeting.salt, cfg.Password)
+	if err != nil {
+		return err
+	}
+
+	payload := appendUint32(nil, caps)
+	payload = appendUint32(payload, maxPacketSize)
+	payload = append(payload, charset)
+	payload = append(payload, make([]byte, 23)...)
+	if cfg.TLSConfig != nil {
+		// SSLRequest 与登录信息的开头相同
+		if err = c.WritePacket(payload); err != nil {
+			return err
+		}
+		if err = c.startTLS(tls.Client(c.Conn, cfg.TLSConfig)); err != nil {
+			return err
+		}
+	}
+	payload = append(payload, cfg.Username...)
+	payload = append(payload, 0)
+	if caps&ClientPluginAuthLenEncClientData != 0 {
+		payload = appendLenEncInt(payload, uint64(len(authResp)))
+	} else {
+		payload = append(payload, byte(len(authResp)))
+	}
+	payload = append(payload, authResp...)
+	if caps&ClientConnectWithDB != 0 {
+		payload = append(payload, cfg.Database...)
+		payload = append(payload, 0)
+	}
+	if caps&ClientPluginAuth != 0 {
+		payload = append(payload, plugin...)
+		payload = append(payload, 0)
+	}
+	if err = c.WritePacket(payload); err != nil {
+		return err
+	}
+	return c.readAuthResult(plugin, greeting.salt, cfg.Password)
+}
+
+func (c *Conn) readAuthResult(plugin string, salt []byte, password string) error {
+	for {
+		data, err := c.ReadPacket()
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return ErrMalformPacket
+		}
+		switch data[0] {
+		case iOK:
+			return nil
+		case iERR:
+			return ParseError(data)
+		case iEOF:
+			// AuthSwitchRequest, 只有 0xFE 时为旧的密码格式
+			if len(data) == 1 {
+				return fmt.Errorf("%w: old password authentication", ErrUnsupportedServer)
+			}
+			var rest []byte
+			plugin, rest = readNulString(data[1:])
+			salt = bytes.TrimSuffix(rest, []byte{0})
+			authResp, err := scramblePassword(plugin, salt, password)
+			if err != nil {
+				return err
+			}
+			if err = c.WritePacket(authResp); err != nil {
+				return err
+			}
+		case iAuthMoreData:
+			if plugin != AuthCachingSha2Password || len(data) < 2 {
+				return fmt.Errorf("%w: unexpected auth data for %s", ErrUnsupportedServer, plugin)
+			}
+			switch data[1] {
+			case cachingSha2FastAuthSuccess:
+				// 之后是 OK 包
+			case cachingSha2PerformFullAuth:
+				if err = c.cachingSha2FullAuth(salt, password); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("%w: unexpected caching_sha2_password state %d",
+					ErrUnsupportedServer, data[1])
+			}
+		default:
+			return fmt.Errorf("%w: unexpected auth packet 0x%02x", ErrUnsupportedServer, data[0])
+		}
+	}
+}
+
+// cachingSha2FullAuth SSL 连接发送明文密码, 没有 SSL 时请求后端的公钥, 使用公钥加密密码
+func (c *Conn) cachingSha2FullAuth(salt []byte, password string) error {
+	if c.secure {
+		return c.WritePacket(append([]byte(password), 0))
+	}
+	if err := c.WritePacket([]byte{cachingSha2RequestPublicKey}); err != nil {
+		return err
+	}
+	data, err := c.ReadPacket()
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || data[0] != iAuthMoreData {
+		if len(data) > 0 && data[0] == iERR {
+			return ParseError(data)
+		}
+		return fmt.Errorf("%w: request public key failed", ErrUnsupportedServer)
+	}
+	block, _ := pem.Decode(data[1:])
+	if block == nil {
+		return fmt.Errorf("%w: invalid public key", ErrUnsupportedServer)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: public key is not rsa", ErrUnsupportedServer)
+	}
+	plain := append([]byte(password), 0)
+	for i := range plain {
+		plain[i] ^= salt[i%len(salt)]
+	}
+	encrypted, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, rsaPub, plain, nil)
+	if err != nil {
+		return err
+	}
+	return c.WritePacket(encrypted)
+}
+
+// CheckAccount 登录后端校验账号, 成功后断开
+func CheckAccount(addr string, cfg ClientConfig, timeout time.Duration) error {
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(timeout))
+	conn := NewConn(c)
+	if err = conn.ClientHandshake(cfg); err != nil {
+		return err
+	}
+	conn.ResetSequence()
+	return conn.WritePacket([]byte{ComQuit})
+}
+
+type serverGreeting struct {
+	version      string
+	capabilities uint32
+	salt         []byte
+	authPlugin   string
+}
+
+func parseServerGreeting(data []byte) (*serverGreeting, error) {
+	if len(data) == 0 || data[0] != 10 {
+		return nil, fmt.Errorf("%w: protocol version 10 required", ErrUnsupportedServer)
+	}
+	var greeting serverGreeting
+	rest := data[1:]
+	greeting.version, rest = readNulString(rest)
+	// 连接 ID 4 字节, salt 8 字节, 填充 1 字节, 能力低 2 字节
+	if len(rest) < 15 {
+		return nil, ErrMalformPacket
+	}
+	greeting.salt = append(greeting.salt, rest[4:12]...)
+	greeting.capabilities = uint32(binary.LittleEndian.Uint16(rest[13:15]))
+	rest = rest[15:]
+	// 字符集 1 字节, 状态 2 字节, 能力高 2 字节, salt 长度 1 字节, 保留 10 字节
+	if len(rest) < 16 {
+		return &greeting, nil
+	}
+	greeting.capabilities |= uint32(binary.LittleEndian.Uint16(rest[3:5])) << 16
+	saltLen := int(rest[5])
+	rest = rest[16:]
+	if greeting.capabilities&ClientSecureConnection != 0 {
+		n := saltLen - 8
+		if n < 13 {
+			n = 13
+		}
+		if len(rest) < n {
+			return nil, ErrMalformPacket
+		}
+		greeting.salt = append(greeting.salt, bytes.TrimSuffix(rest[:n], []byte{0})...)
+		rest = rest[n:]
+	}
+	if greeting.capabilities&ClientPluginAuth != 0 {
+		greeting.authPlugin, _ = readNulString(rest)
+	}
+	return &greeting, nil
+}
+
+// scramblePassword 按认证插件计算认证数据
+func scramblePassword(plugin string, salt []byte, password string) ([]byte, error) {
+	switch plugin {
+	case AuthNativePassword:
+		return scrambleNativePassword(salt, password), nil
+	case AuthCachingSha2Password:
+		return scrambleSha256Password(salt, password), nil
+	case AuthClearPassword:
+		return append([]byte(password), 0), nil
+	}
+	return nil, fmt.Errorf("%w: auth plugin %s", ErrUnsupportedServer, plugin)
+}
+
+// scrambleNativePassword SHA1(password) XOR SHA1(salt + SHA1(SHA1(password)))
+func scrambleNativePassword(salt []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+	h := sha1.New()
+	h.Write(salt)
+	h.Write(stage2[:])
+	scramble := h.Sum(nil)
+	for i := range scramble {
+		scramble[i] ^= stage1[i]
+	}
+	return scramble
+}
+
+// scrambleSha256Password SHA256(password) XOR SHA256(SHA256(SHA256(password)) + salt)
+func scrambleSha256Password(salt []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+	stage1 := sha256.Sum256([]byte(password))
+	stage2 := sha256.Sum256(stage1[:])
+	h := sha256.New()
+	h.Write(stage2[:])
+	h.Write(salt)
+	scramble := h.Sum(nil)
+	for i := range scramble {
+		scramble[i] ^= stage1[i]
+	}
+	return scramble
+}
@@ -0,0 +1,106 @@
+package mysqld
+
+// 包的类型
+const (
+	iOK           byte = 0x00
+	iAuthMoreData byte = 0x01
+	iLocalInFile  byte = 0xfb
+	iEOF          byte = 0xfe
+	iERR          byte = 0xff
+)
+
+// 客户端和服务端的能力
+const (
+	ClientLongPassword uint32 = 1 << iota
+	ClientFoundRows
+	ClientLongFlag
+	ClientConnectWithDB
+	ClientNoSchema
+	ClientCompress
+	ClientODBC
+	ClientLocalFiles
+	ClientIgnoreSpace
+	ClientProtocol41
+	ClientInteractive
+	ClientSSL
+	ClientIgnoreSIGPIPE
+	ClientTransactions
+	ClientReserved
+	ClientSecureConnection
+	ClientMultiStatements
+	ClientMultiResults
+	ClientPSMultiResults
+	ClientPluginAuth
+	ClientConnectAttrs
+	ClientPluginAuthLenEncClientData
+	ClientCanHandleExpiredPasswords
+	ClientSessionTrack
+	ClientDeprecateEOF
+)
+
+// 服务端状态
+const (
+	StatusInTrans           uint16 = 0x0001
+	StatusAutocommit        uint16 = 0x0002
+	StatusMoreResultsExists uint16 = 0x0008
+	StatusCursorExists      uint16 = 0x0040
+	StatusLastRowSent       uint16 = 0x0080
+)
+
+// 命令
+const (
+	ComSleep byte = iota
+	ComQuit
+	ComInitDB
+	ComQuery
+	ComFieldList
+	ComCreateDB
+	ComDropDB
+	ComRefresh
+	ComShutdown
+	ComStatistics
+	ComProcessInfo
+	ComConnect
+	ComProcessKill
+	ComDebug
+	ComPing
+	ComTime
+	ComDelayedInsert
+	ComChangeUser
+	ComBinlogDump
+	ComTableDump
+	ComConnectOut
+	ComRegisterSlave
+	ComStmtPrepare
+	ComStmtExecute
+	ComStmtSendLongData
+	ComStmtClose
+	ComStmtReset
+	ComSetOption
+	ComStmtFetch
+	ComDaemon
+	ComBinlogDumpGTID
+	ComResetConnection
+)
+
+// 错误码和 SQL state
+const (
+	ErAccessDenied         uint16 = 1045
+	ErUnknownComError      uint16 = 1047
+	ErUnknownError         uint16 = 1105
+	ErSpecificAccessDenied uint16 = 1227
+	ErHandshakeError       uint16 = 1043
+	CrConnectionError      uint16 = 2003
+
+	StateUnknown        = "HY000"
+	StateAccessDenied   = "28000"
+	StateSyntaxOrAccess = "42000"
+	StateConnection     = "08S01"
+)
+
+// 认证插件
+const (
+	AuthNativePassword      = "mysql_native_password"
+	AuthCachingSha2Password = "caching_sha2_password"
+	AuthClearPassword       = "mysql_clear_password"
+)
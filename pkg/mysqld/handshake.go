@@ -0,0 +1,172 @@
+package mysqld
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// JDBC 等客户端根据版本号选择功能, 使用与 5.7 兼容的版本号
+	ServerVersion = "5.7.99-koko"
+
+	// utf8mb4_general_ci
+	defaultCharset uint8 = 45
+
+	authSaltSize = 20
+
+	// SSLRequest 只有能力、最大包长度、字符集和保留字节
+	sslRequestSize = 32
+)
+
+// ServerCapabilities 代理支持的能力, 与后端协商时取客户端能力和后端能力的交集, 保证两边的包格式一致;
+// 不支持压缩和 LOAD DATA LOCAL INFILE, 也不使用 DEPRECATE_EOF 和 SESSION_TRACK;
+// SSL 只用于客户端与代理之间, 监听配置了证书时才支持
+const ServerCapabilities = ClientLongPassword | ClientFoundRows | ClientLongFlag |
+	ClientConnectWithDB | ClientIgnoreSpace | ClientProtocol41 | ClientInteractive |
+	ClientIgnoreSIGPIPE | ClientTransactions | ClientSecureConnection |
+	ClientMultiStatements | ClientMultiResults | ClientPSMultiResults |
+	ClientPluginAuth | ClientConnectAttrs | ClientPluginAuthLenEncClientData
+
+var ErrUnsupportedClient = errors.New("mysql client unsupported")
+
+// HandshakeResponse 客户端的登录信息
+type HandshakeResponse struct {
+	Capabilities uint32
+	Charset      uint8
+	Username     string
+	AuthResponse []byte
+	Database     string
+	AuthPlugin   string
+	Attrs        map[string]string
+	// Password ReadAuthPassword 读取的明文密码
+	Password string
+
+	salt []byte
+}
+
+// ServerHandshake 作为服务端与客户端握手, 返回客户端的登录信息, tlsConfig 不为空时客户端必须使用 SSL;
+// 调用者使用 ReadAuthPassword 读取密码, 校验之后使用 WriteOK 或者 WriteError 回复客户端
+func (c *Conn) ServerHandshake(connID uint32, tlsConfig *tls.Config) (*HandshakeResponse, error) {
+	salt := make([]byte, authSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	// 部分客户端把 salt 当作字符串处理, 不能包含 0
+	for i := range salt {
+		salt[i] = salt[i]&0x7f | 0x01
+	}
+	caps := ServerCapabilities
+	if tlsConfig != nil {
+		caps |= ClientSSL
+	}
+	c.ResetSequence()
+	payload := []byte{10}
+	payload = append(payload, ServerVersion...)
+	payload = append(payload, 0)
+	payload = appendUint32(payload, connID)
+	payload = append(payload, salt[:8]...)
+	payload = append(payload, 0)
+	payload = appendUint16(payload, uint16(caps&0xffff))
+	payload = append(payload, defaultCharset)
+	payload = appendUint16(payload, StatusAutocommit)
+	payload = appendUint16(payload, uint16(caps>>16))
+	payload = append(payload, authSaltSize+1)
+	payload = append(payload, make([]byte, 10)...)
+	payload = append(payload, salt[8:]...)
+	payload = append(payload, 0)
+	payload = append(payload, AuthCachingSha2Password...)
+	payload = append(payload, 0)
+	if err := c.WritePacket(payload); err != nil {
+		return nil, err
+	}
+	data, err := c.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	isSSLRequest := len(data) == sslRequestSize && binary.LittleEndian.Uint32(data)&ClientSSL != 0
+	switch {
+	case isSSLRequest && tlsConfig != nil:
+		if err = c.startTLS(tls.Server(c.Conn, tlsConfig)); err != nil {
+			return nil, err
+		}
+		if data, err = c.ReadPacket(); err != nil {
+			return nil, err
+		}
+	case tlsConfig != nil:
+		return nil, fmt.Errorf("%w: ssl is required", ErrUnsupportedClient)
+	}
+	resp, err := parseHandshakeResponse(data)
+	if err != nil {
+		return nil, err
+	}
+	resp.salt = salt
+	return resp, nil
+}
+
+func parseHandshakeResponse(data []byte) (*HandshakeResponse, error) {
+	if len(data) < 4 {
+		return nil, ErrMalformPacket
+	}
+	caps := binary.LittleEndian.Uint32(data)
+	if caps&ClientProtocol41 == 0 {
+		return nil, fmt.Errorf("%w: protocol 4.1 required", ErrUnsupportedClient)
+	}
+	if caps&ClientSSL != 0 && len(data) == sslRequestSize {
+		return nil, fmt.Errorf("%w: ssl is not supported", ErrUnsupportedClient)
+	}
+	if len(data) < 32 {
+		return nil, ErrMalformPacket
+	}
+	resp := HandshakeResponse{
+		Capabilities: caps & ServerCapabilities,
+		Charset:      data[8],
+	}
+	rest := data[32:]
+	resp.Username, rest = readNulString(rest)
+	switch {
+	case caps&ClientPluginAuthLenEncClientData != 0:
+		auth, remain, ok := readLenEncString(rest)
+		if !ok {
+			return nil, ErrMalformPacket
+		}
+		resp.AuthResponse, rest = auth, remain
+	case caps&ClientSecureConnection != 0:
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, ErrMalformPacket
+		}
+		resp.AuthResponse, rest = rest[1:1+int(rest[0])], rest[1+int(rest[0]):]
+	default:
+		var auth string
+		auth, rest = readNulString(rest)
+		resp.AuthResponse = []byte(auth)
+	}
+	if caps&ClientConnectWithDB != 0 && len(rest) > 0 {
+		resp.Database, rest = readNulString(rest)
+	}
+	if caps&ClientPluginAuth != 0 && len(rest) > 0 {
+		resp.AuthPlugin, rest = readNulString(rest)
+	}
+	if caps&ClientConnectAttrs != 0 && len(rest) > 0 {
+		attrs, _, ok := readLenEncString(rest)
+		if !ok {
+			return nil, ErrMalformPacket
+		}
+		resp.Attrs = make(map[string]string)
+		for len(attrs) > 0 {
+			key, remain, ok := readLenEncString(attrs)
+			if !ok {
+				return nil, ErrMalformPacket
+			}
+			value, remain, ok := readLenEncString(remain)
+			if !ok {
+				return nil, ErrMalformPacket
+			}
+			resp.Attrs[string(key)] = string(value)
+			attrs = remain
+		}
+	}
+	return &resp, nil
+}
@@ -0,0 +1,186 @@
+package mysqld
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plugin := range []string{AuthCachingSha2Password, AuthNativePassword} {
+		client, server := net.Pipe()
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- NewConn(client).ClientHandshake(ClientConfig{
+				Username:     "admin",
+				Password:     "token-secret",
+				Database:     "test",
+				Capabilities: ServerCapabilities | ClientDeprecateEOF | ClientSSL,
+			})
+		}()
+		conn := NewConn(server)
+		resp, err := conn.ServerHandshake(1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Username != "admin" || resp.Database != "test" || resp.AuthPlugin != AuthCachingSha2Password {
+			t.Errorf("unexpected handshake response %+v", resp)
+		}
+		if resp.Capabilities&(ClientDeprecateEOF|ClientSSL|ClientConnectAttrs) != 0 {
+			t.Errorf("unexpected capabilities %x", resp.Capabilities)
+		}
+		if len(resp.AuthResponse) != sha256.Size {
+			t.Errorf("unexpected auth response %x", resp.AuthResponse)
+		}
+		// 客户端使用其他插件时切换到 caching_sha2_password
+		resp.AuthPlugin = plugin
+		if resp.Password, err = conn.ReadAuthPassword(resp, key); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Password != "token-secret" {
+			t.Errorf("%s: unexpected password %q", plugin, resp.Password)
+		}
+		if err = conn.WriteOK(0, 0, StatusAutocommit); err != nil {
+			t.Fatal(err)
+		}
+		if err = <-errCh; err != nil {
+			t.Fatal(err)
+		}
+		_ = client.Close()
+		_ = server.Close()
+	}
+}
+
+func TestHandshake_TLS(t *testing.T) {
+	tlsConfig := newTestTLSConfig(t)
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- NewConn(client).ClientHandshake(ClientConfig{
+			Username:     "admin",
+			Password:     "token-secret",
+			Capabilities: ServerCapabilities,
+			TLSConfig:    &tls.Config{InsecureSkipVerify: true},
+		})
+	}()
+	conn := NewConn(server)
+	resp, err := conn.ServerHandshake(1, tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conn.secure || resp.Username != "admin" {
+		t.Fatalf("unexpected handshake response %+v", resp)
+	}
+	// SSL 连接中不需要交换公钥
+	if resp.Password, err = conn.ReadAuthPassword(resp, nil); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Password != "token-secret" {
+		t.Errorf("unexpected password %q", resp.Password)
+	}
+	if err = conn.WriteOK(0, 0, StatusAutocommit); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	// 配置了证书时拒绝不使用 SSL 的客户端
+	client, server = net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		_ = NewConn(client).ClientHandshake(ClientConfig{Username: "admin", Capabilities: ServerCapabilities})
+	}()
+	if _, err = NewConn(server).ServerHandshake(1, tlsConfig); !errors.Is(err, ErrUnsupportedClient) {
+		t.Errorf("expect ssl required, got %v", err)
+	}
+}
+
+func newTestTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "koko"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestCheckLoopbackAddr(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:33061", "[::1]:33061", "localhost:33061"} {
+		if err := checkLoopbackAddr(addr); err != nil {
+			t.Errorf("%s: %s", addr, err)
+		}
+	}
+	for _, addr := range []string{"0.0.0.0:33061", ":33061", "10.0.0.1:33061"} {
+		if err := checkLoopbackAddr(addr); err == nil {
+			t.Errorf("%s: expect error", addr)
+		}
+	}
+}
+
+func TestHandshake_Error(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- NewConn(client).ClientHandshake(ClientConfig{Username: "root", Capabilities: ServerCapabilities})
+	}()
+	conn := NewConn(server)
+	if _, err := conn.ServerHandshake(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.WriteError(NewError(ErAccessDenied, StateAccessDenied, "Access denied for user '%s'", "root"))
+	err := <-errCh
+	if e, ok := err.(*Error); !ok || e.Code != ErAccessDenied || e.Message != "Access denied for user 'root'" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestScrambleNativePassword(t *testing.T) {
+	salt := []byte("0123456789abcdefghij")
+	scramble := scrambleNativePassword(salt, "secret")
+	// 服务端的校验: SHA1(salt + stage2) XOR scramble = stage1, SHA1(stage1) = stage2
+	stage1 := sha1.Sum([]byte("secret"))
+	stage2 := sha1.Sum(stage1[:])
+	h := sha1.Sum(append(append([]byte{}, salt...), stage2[:]...))
+	for i := range h {
+		h[i] ^= scramble[i]
+	}
+	if !bytes.Equal(h[:], stage1[:]) {
+		t.Error("native password scramble mismatch")
+	}
+	if scrambleNativePassword(salt, "") != nil || scrambleSha256Password(salt, "") != nil {
+		t.Error("expect empty scramble for empty password")
+	}
+}
@@ -0,0 +1,277 @@
+package mysqld
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+/*
+	MySQL 协议的包: 3 字节长度(小端) + 1 字节序号 + 数据
+	每个命令从序号 0 开始, 请求和响应的序号依次递增;
+	数据不小于 16M-1 时拆分为多个包, 最后一个包的长度小于 16M-1(可能为 0)
+*/
+
+const maxPacketSize = 1<<24 - 1
+
+var (
+	ErrPacketSequence = errors.New("mysql packet sequence mismatch")
+	ErrMalformPacket  = errors.New("mysql malformed packet")
+)
+
+// Conn 按包读写的连接, 维护当前命令的序号
+type Conn struct {
+	net.Conn
+	reader   *bufio.Reader
+	sequence uint8
+	// secure 握手时已经升级为 SSL
+	secure bool
+}
+
+func NewConn(c net.Conn) *Conn {
+	return &Conn{Conn: c, reader: bufio.NewReaderSize(c, 16*1024)}
+}
+
+// startTLS SSLRequest 之后的读写使用 SSL
+func (c *Conn) startTLS(tlsConn *tls.Conn) error {
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.Conn = tlsConn
+	c.reader.Reset(tlsConn)
+	c.secure = true
+	return nil
+}
+
+// ResetSequence 开始新的命令
+func (c *Conn) ResetSequence() {
+	c.sequence = 0
+}
+
+// ReadPacket 读取一个完整的包, 拆分的包合并后返回
+func (c *Conn) ReadPacket() ([]byte, error) {
+	var payload []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+			return nil, err
+		}
+		length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+		if header[3] != c.sequence {
+			return nil, fmt.Errorf("%w: expect %d, got %d", ErrPacketSequence, c.sequence, header[3])
+		}
+		c.sequence++
+		data := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		if payload == nil && length < maxPacketSize {
+			return data, nil
+		}
+		payload = append(payload, data...)
+		if length < maxPacketSize {
+			return payload, nil
+		}
+	}
+}
+
+// WritePacket 写入一个包, 超过 16M-1 时拆分
+func (c *Conn) WritePacket(payload []byte) error {
+	for {
+		length := len(payload)
+		if length > maxPacketSize {
+			length = maxPacketSize
+		}
+		buf := make([]byte, 4+length)
+		buf[0] = byte(length)
+		buf[1] = byte(length >> 8)
+		buf[2] = byte(length >> 16)
+		buf[3] = c.sequence
+		copy(buf[4:], payload[:length])
+		if _, err := c.Conn.Write(buf); err != nil {
+			return err
+		}
+		c.sequence++
+		payload = payload[length:]
+		if length < maxPacketSize {
+			return nil
+		}
+	}
+}
+
+// WriteOK 回复 OK 包
+func (c *Conn) WriteOK(affectedRows, lastInsertID uint64, status uint16) error {
+	payload := []byte{iOK}
+	payload = appendLenEncInt(payload, affectedRows)
+	payload = appendLenEncInt(payload, lastInsertID)
+	payload = appendUint16(payload, status)
+	payload = appendUint16(payload, 0)
+	return c.WritePacket(payload)
+}
+
+// WriteError 回复 ERR 包
+func (c *Conn) WriteError(err *Error) error {
+	return c.WritePacket(err.packet())
+}
+
+// Error ERR 包的内容, 格式与 mysql 客户端显示的一致
+type Error struct {
+	Code    uint16
+	State   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ERROR %d (%s): %s", e.Code, e.State, e.Message)
+}
+
+func (e *Error) packet() []byte {
+	payload := []byte{iERR}
+	payload = appendUint16(payload, e.Code)
+	state := e.State
+	if len(state) != 5 {
+		state = StateUnknown
+	}
+	payload = append(payload, '#')
+	payload = append(payload, state...)
+	return append(payload, e.Message...)
+}
+
+func NewError(code uint16, state, format string, a ...interface{}) *Error {
+	return &Error{Code: code, State: state, Message: fmt.Sprintf(format, a...)}
+}
+
+// ParseError 解析 ERR 包, 没有 SQL state 的旧格式使用 HY000
+func ParseError(payload []byte) *Error {
+	if len(payload) < 3 || payload[0] != iERR {
+		return NewError(ErUnknownError, StateUnknown, "malformed error packet")
+	}
+	e := Error{Code: binary.LittleEndian.Uint16(payload[1:3]), State: StateUnknown}
+	msg := payload[3:]
+	if len(msg) >= 6 && msg[0] == '#' {
+		e.State = string(msg[1:6])
+		msg = msg[6:]
+	}
+	e.Message = string(msg)
+	return &e
+}
+
+// OKPacket OK 包的内容
+type OKPacket struct {
+	AffectedRows uint64
+	LastInsertID uint64
+	Status       uint16
+	Warnings     uint16
+}
+
+// ParseOK 解析 OK 包, 也用于解析 0xFE 开头的 EOF 包的状态
+func ParseOK(payload []byte) (*OKPacket, error) {
+	if len(payload) < 1 || (payload[0] != iOK && payload[0] != iEOF) {
+		return nil, ErrMalformPacket
+	}
+	var (
+		ok  OKPacket
+		pos = 1
+	)
+	for _, v := range []*uint64{&ok.AffectedRows, &ok.LastInsertID} {
+		num, n, valid := readLenEncInt(payload[pos:])
+		if !valid {
+			return nil, ErrMalformPacket
+		}
+		*v = num
+		pos += n
+	}
+	if len(payload) >= pos+4 {
+		ok.Status = binary.LittleEndian.Uint16(payload[pos:])
+		ok.Warnings = binary.LittleEndian.Uint16(payload[pos+2:])
+	}
+	return &ok, nil
+}
+
+// IsEOF EOF 包与 0xFE 开头的数据行按长度区分
+func IsEOF(payload []byte) bool {
+	return len(payload) > 0 && len(payload) < 9 && payload[0] == iEOF
+}
+
+// EOFStatus EOF 包中的服务端状态
+func EOFStatus(payload []byte) uint16 {
+	if len(payload) < 5 {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(payload[3:5])
+}
+
+func readLenEncInt(b []byte) (uint64, int, bool) {
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+	switch b[0] {
+	case 0xfb:
+		// NULL
+		return 0, 1, true
+	case 0xfc:
+		if len(b) < 3 {
+			return 0, 0, false
+		}
+		return uint64(binary.LittleEndian.Uint16(b[1:])), 3, true
+	case 0xfd:
+		if len(b) < 4 {
+			return 0, 0, false
+		}
+		return uint64(b[1]) | uint64(b[2])<<8 | uint64(b[3])<<16, 4, true
+	case 0xfe:
+		if len(b) < 9 {
+			return 0, 0, false
+		}
+		return binary.LittleEndian.Uint64(b[1:]), 9, true
+	case 0xff:
+		return 0, 0, false
+	}
+	return uint64(b[0]), 1, true
+}
+
+func appendLenEncInt(b []byte, v uint64) []byte {
+	switch {
+	case v < 0xfb:
+		return append(b, byte(v))
+	case v <= 0xffff:
+		return append(b, 0xfc, byte(v), byte(v>>8))
+	case v <= 0xffffff:
+		return append(b, 0xfd, byte(v), byte(v>>8), byte(v>>16))
+	}
+	b = append(b, 0xfe)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// readLenEncString 返回字符串和之后的数据
+func readLenEncString(b []byte) ([]byte, []byte, bool) {
+	length, n, ok := readLenEncInt(b)
+	if !ok || uint64(len(b)-n) < length {
+		return nil, nil, false
+	}
+	return b[n : n+int(length)], b[n+int(length):], true
+}
+
+// readNulString 读取以 0 结尾的字符串, 没有结尾时返回剩余的全部数据
+func readNulString(b []byte) (string, []byte) {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i]), b[i+1:]
+		}
+	}
+	return string(b), nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
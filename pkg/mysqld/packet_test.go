@@ -0,0 +1,66 @@
+package mysqld
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestConn_WritePacket(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	w, r := NewConn(client), NewConn(server)
+
+	// 16M-1 的包需要一个空包结束
+	for _, size := range []int{0, 10, maxPacketSize, maxPacketSize + 10} {
+		payload := bytes.Repeat([]byte{'a'}, size)
+		w.ResetSequence()
+		r.ResetSequence()
+		done := make(chan error, 1)
+		go func() {
+			done <- w.WritePacket(payload)
+		}()
+		got, err := r.ReadPacket()
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if err = <-done; err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("size %d: got %d bytes", size, len(got))
+		}
+		if r.sequence != w.sequence {
+			t.Fatalf("size %d: sequence %d != %d", size, r.sequence, w.sequence)
+		}
+	}
+}
+
+func TestLenEncInt(t *testing.T) {
+	for _, v := range []uint64{0, 250, 251, 0xffff, 0x10000, 0xffffff, 0x1000000, 1 << 40} {
+		b := appendLenEncInt(nil, v)
+		got, n, ok := readLenEncInt(b)
+		if !ok || got != v || n != len(b) {
+			t.Errorf("%d: got %d, %d, %v", v, got, n, ok)
+		}
+	}
+	if _, _, ok := readLenEncInt([]byte{0xfc, 1}); ok {
+		t.Error("expect short lenenc int invalid")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	payload := append([]byte{iERR}, appendUint16(nil, 1064)...)
+	payload = append(payload, "#42000You have an error"...)
+	err := ParseError(payload)
+	if err.Error() != "ERROR 1064 (42000): You have an error" {
+		t.Errorf("unexpected error %q", err)
+	}
+	// 没有 SQL state 的旧格式
+	payload = append([]byte{iERR}, appendUint16(nil, 1040)...)
+	payload = append(payload, "Too many connections"...)
+	if err = ParseError(payload); err.State != StateUnknown || err.Message != "Too many connections" {
+		t.Errorf("unexpected error %+v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package mysqld
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Result 命令的响应摘要, 用于记录命令输出
+type Result struct {
+	// ResultSet 响应中有结果集
+	ResultSet    bool
+	Rows         uint64
+	AffectedRows uint64
+	// StatementID COM_STMT_PREPARE 成功时的语句 ID
+	StatementID uint32
+	Err         *Error
+}
+
+// String 与 mysql 客户端的显示一致
+func (r *Result) String() string {
+	switch {
+	case r.Err != nil:
+		return r.Err.Error()
+	case r.ResultSet:
+		return fmt.Sprintf("%d %s in set", r.Rows, plural(r.Rows, "row"))
+	}
+	return fmt.Sprintf("Query OK, %d %s affected", r.AffectedRows, plural(r.AffectedRows, "row"))
+}
+
+func plural(n uint64, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
+
+// NoResponse 没有响应的命令
+func NoResponse(cmd byte) bool {
+	switch cmd {
+	case ComQuit, ComStmtClose, ComStmtSendLongData:
+		return true
+	}
+	return false
+}
+
+// RelayResponse 读取 src 对命令 cmd 的响应并转发到 dst, 直到响应结束
+func RelayResponse(dst, src *Conn, cmd byte) (*Result, error) {
+	r := responseRelay{dst: dst, src: src}
+	if NoResponse(cmd) {
+		return &r.result, nil
+	}
+	var err error
+	switch cmd {
+	case ComQuery, ComStmtExecute, ComProcessInfo:
+		err = r.resultSets()
+	case ComStmtPrepare:
+		err = r.prepare()
+	case ComFieldList, ComStmtFetch:
+		// 列定义或者游标的数据行
+		r.result.ResultSet = true
+		_, err = r.rowsUntilEOF()
+	case ComStatistics:
+		// 纯文本, 或者 ERR 包
+		var data []byte
+		if data, err = r.copyPacket(); err == nil && len(data) > 0 && data[0] == iERR {
+			r.result.Err = ParseError(data)
+		}
+	default:
+		err = r.okOrError()
+	}
+	return &r.result, err
+}
+
+type responseRelay struct {
+	dst, src *Conn
+	result   Result
+}
+
+func (r *responseRelay) copyPacket() ([]byte, error) {
+	data, err := r.src.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	return data, r.dst.WritePacket(data)
+}
+
+func (r *responseRelay) okOrError() error {
+	data, err := r.copyPacket()
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return ErrMalformPacket
+	}
+	switch data[0] {
+	case iERR:
+		r.result.Err = ParseError(data)
+	case iOK:
+		if ok, err := ParseOK(data); err == nil {
+			r.result.AffectedRows = ok.AffectedRows
+		}
+	}
+	return nil
+}
+
+// resultSets 文本协议和二进制协议的结果集, 多语句时有多个结果
+func (r *responseRelay) resultSets() error {
+	for {
+		data, err := r.copyPacket()
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return ErrMalformPacket
+		}
+		var status uint16
+		switch data[0] {
+		case iERR:
+			r.result.Err = ParseError(data)
+			return nil
+		case iOK:
+			ok, err := ParseOK(data)
+			if err != nil {
+				return err
+			}
+			r.result.AffectedRows += ok.AffectedRows
+			status = ok.Status
+		case iLocalInFile:
+			// 没有协商 LOCAL_FILES, 后端不应该请求客户端的文件
+			return fmt.Errorf("%w: unexpected LOCAL INFILE request", ErrMalformPacket)
+		default:
+			columns, _, ok := readLenEncInt(data)
+			if !ok {
+				return ErrMalformPacket
+			}
+			if status, err = r.definitions(columns); err != nil {
+				return err
+			}
+			r.result.ResultSet = true
+			// 打开游标的 COM_STMT_EXECUTE 只有列定义, 数据行由之后的 COM_STMT_FETCH 获取
+			if status&StatusCursorExists != 0 {
+				return nil
+			}
+			if status, err = r.rowsUntilEOF(); err != nil || r.result.Err != nil {
+				return err
+			}
+		}
+		if status&StatusMoreResultsExists == 0 {
+			return nil
+		}
+	}
+}
+
+// prepare COM_STMT_PREPARE 的响应: OK 包之后是参数和列的定义
+func (r *responseRelay) prepare() error {
+	data, err := r.copyPacket()
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return ErrMalformPacket
+	}
+	if data[0] == iERR {
+		r.result.Err = ParseError(data)
+		return nil
+	}
+	if data[0] != iOK || len(data) < 9 {
+		return ErrMalformPacket
+	}
+	r.result.StatementID = binary.LittleEndian.Uint32(data[1:5])
+	columns := binary.LittleEndian.Uint16(data[5:7])
+	params := binary.LittleEndian.Uint16(data[7:9])
+	if _, err = r.definitions(uint64(params)); err != nil {
+		return err
+	}
+	_, err = r.definitions(uint64(columns))
+	return err
+}
+
+// definitions n 个列定义和之后的 EOF 包, 返回 EOF 包中的状态
+func (r *responseRelay) definitions(n uint64) (uint16, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	for i := uint64(0); i < n; i++ {
+		if _, err := r.copyPacket(); err != nil {
+			return 0, err
+		}
+	}
+	data, err := r.copyPacket()
+	if err != nil {
+		return 0, err
+	}
+	if !IsEOF(data) {
+		return 0, fmt.Errorf("%w: expect EOF after definitions", ErrMalformPacket)
+	}
+	return EOFStatus(data), nil
+}
+
+func (r *responseRelay) rowsUntilEOF() (uint16, error) {
+	for {
+		data, err := r.copyPacket()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case IsEOF(data):
+			return EOFStatus(data), nil
+		case len(data) > 0 && data[0] == iERR:
+			r.result.Err = ParseError(data)
+			return 0, nil
+		}
+		r.result.Rows++
+	}
+}
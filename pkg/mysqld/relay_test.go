@@ -0,0 +1,143 @@
+package mysqld
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRelayResponse(t *testing.T) {
+	eof := []byte{iEOF, 0, 0, 0, 0}
+	moreEOF := []byte{iEOF, 0, 0, byte(StatusMoreResultsExists), 0}
+	column := append([]byte{3}, "def"...)
+	ok := func(affected uint64, status uint16) []byte {
+		b := appendLenEncInt([]byte{iOK}, affected)
+		b = append(b, 0)
+		return append(appendUint16(b, status), 0, 0)
+	}
+	tests := []struct {
+		name    string
+		cmd     byte
+		packets [][]byte
+		expect  string
+	}{
+		{"ok", ComQuery, [][]byte{ok(3, 0)}, "Query OK, 3 rows affected"},
+		{"result set", ComQuery, [][]byte{{2}, column, column, eof, {1, 'a', 1, 'b'}, eof}, "1 row in set"},
+		{"multi results", ComQuery, [][]byte{{1}, column, eof, {1, 'a'}, {1, 'b'}, moreEOF,
+			ok(1, StatusMoreResultsExists), ok(2, 0)}, "2 rows in set"},
+		{"error in rows", ComQuery, [][]byte{{1}, column, eof, {1, 'a'},
+			NewError(1317, "70100", "Query execution was interrupted").packet()},
+			"ERROR 1317 (70100): Query execution was interrupted"},
+		{"prepare", ComStmtPrepare, [][]byte{{iOK, 7, 0, 0, 0, 1, 0, 2, 0, 0, 0, 0},
+			column, column, eof, column, eof}, "Query OK, 0 rows affected"},
+		{"field list", ComFieldList, [][]byte{column, column, eof}, "2 rows in set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendSrv, backend := net.Pipe()
+			clientSrv, client := net.Pipe()
+			defer backend.Close()
+			defer client.Close()
+			go func() {
+				defer backendSrv.Close()
+				c := NewConn(backendSrv)
+				for _, p := range tt.packets {
+					_ = c.WritePacket(p)
+				}
+			}()
+			received := make(chan int, 1)
+			go func() {
+				c := NewConn(client)
+				n := 0
+				for {
+					if _, err := c.ReadPacket(); err != nil {
+						received <- n
+						return
+					}
+					n++
+				}
+			}()
+			result, err := RelayResponse(NewConn(clientSrv), NewConn(backend), tt.cmd)
+			_ = clientSrv.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.String() != tt.expect {
+				t.Errorf("expect %q, got %q", tt.expect, result)
+			}
+			if n := <-received; n != len(tt.packets) {
+				t.Errorf("expect %d packets relayed, got %d", len(tt.packets), n)
+			}
+			if tt.cmd == ComStmtPrepare && result.StatementID != 7 {
+				t.Errorf("unexpected statement id %d", result.StatementID)
+			}
+			// 响应之后没有多读
+			_, _ = io.Copy(ioutil.Discard, backend)
+		})
+	}
+}
+
+func TestRelayResponse_Cursor(t *testing.T) {
+	column := append([]byte{3}, "def"...)
+	cursorEOF := []byte{iEOF, 0, 0, byte(StatusCursorExists), 0}
+	lastRowEOF := []byte{iEOF, 0, 0, byte(StatusCursorExists | StatusLastRowSent), 0}
+	execute := [][]byte{{1}, column, cursorEOF}
+	fetch := [][]byte{{0, 0, 1, 'a'}, {0, 0, 1, 'b'}, lastRowEOF}
+
+	backendSrv, backend := net.Pipe()
+	clientSrv, client := net.Pipe()
+	defer backend.Close()
+	defer backendSrv.Close()
+	defer client.Close()
+	defer clientSrv.Close()
+	fetchCh := make(chan struct{})
+	go func() {
+		c := NewConn(backendSrv)
+		for _, p := range execute {
+			_ = c.WritePacket(p)
+		}
+		// 后端在收到 COM_STMT_FETCH 之后才发送数据行
+		<-fetchCh
+		c.ResetSequence()
+		for _, p := range fetch {
+			_ = c.WritePacket(p)
+		}
+	}()
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, client)
+	}()
+
+	src, dst := NewConn(backend), NewConn(clientSrv)
+	done := make(chan struct{})
+	var (
+		result *Result
+		err    error
+	)
+	go func() {
+		defer close(done)
+		result, err = RelayResponse(dst, src, ComStmtExecute)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("relay of cursor execute blocked waiting for rows")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.ResultSet || result.Rows != 0 {
+		t.Errorf("unexpected execute result %+v", result)
+	}
+
+	close(fetchCh)
+	src.ResetSequence()
+	dst.ResetSequence()
+	if result, err = RelayResponse(dst, src, ComStmtFetch); err != nil {
+		t.Fatal(err)
+	}
+	if result.String() != "2 rows in set" {
+		t.Errorf("unexpected fetch result %q", result)
+	}
+}
@@ -0,0 +1,108 @@
+package mysqld
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+// 客户端完成握手的超时时间
+const handshakeTimeout = 30 * time.Second
+
+// Handler 处理完成握手的客户端, 返回后关闭连接;
+// GetMySQLTLSConfig 为空时不支持 SSL, 只能监听本地回环地址
+type Handler interface {
+	GetMySQLAddr() string
+	GetMySQLTLSConfig() *tls.Config
+	MySQLSessionHandler(conn *Conn, resp *HandshakeResponse)
+}
+
+type Server struct {
+	Addr    string
+	handler Handler
+
+	tlsConfig *tls.Config
+	// rsaKey 没有 SSL 时交换客户端的密码
+	rsaKey *rsa.PrivateKey
+
+	ln     net.Listener
+	connID uint32
+}
+
+func NewMySQLServer(handler Handler) *Server {
+	return &Server{Addr: handler.GetMySQLAddr(), handler: handler, tlsConfig: handler.GetMySQLTLSConfig()}
+}
+
+func (s *Server) Start() {
+	logger.Infof("Start MySQL server at %s", s.Addr)
+	if s.tlsConfig == nil {
+		if err := checkLoopbackAddr(s.Addr); err != nil {
+			logger.Fatal(err)
+		}
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		s.rsaKey = key
+	}
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	s.ln = &proxyproto.Listener{Listener: ln}
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			logger.Infof("MySQL server stop accept: %s", err)
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) Stop() {
+	if s.ln != nil {
+		_ = s.ln.Close()
+	}
+}
+
+func (s *Server) handleConn(c net.Conn) {
+	defer c.Close()
+	conn := NewConn(c)
+	_ = c.SetDeadline(time.Now().Add(handshakeTimeout))
+	resp, err := conn.ServerHandshake(atomic.AddUint32(&s.connID, 1), s.tlsConfig)
+	if err == nil {
+		resp.Password, err = conn.ReadAuthPassword(resp, s.rsaKey)
+	}
+	if err != nil {
+		logger.Errorf("MySQL conn from %s handshake failed: %s", c.RemoteAddr(), err)
+		if errors.Is(err, ErrUnsupportedClient) {
+			_ = conn.WriteError(NewError(ErHandshakeError, StateConnection, "%s", err))
+		}
+		return
+	}
+	_ = c.SetDeadline(time.Time{})
+	s.handler.MySQLSessionHandler(conn, resp)
+}
+
+// checkLoopbackAddr 没有 SSL 时客户端的 token 只能在本机传输
+func checkLoopbackAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("mysql proxy listens on %s without tls, set MYSQL_PROXY_TLS_CERT and "+
+		"MYSQL_PROXY_TLS_KEY or a loopback MYSQL_PROXY_HOST", addr)
+}
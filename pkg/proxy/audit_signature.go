@@ -0,0 +1,500 @@
+package proxy
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jumpserver/koko/pkg/config"
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	录像和命令的防篡改签名:
+	每个会话维护一条 SHA-256 哈希链 h(0) = sha256(sid), h(n) = sha256(h(n-1) + frame(n)),
+	会话结束后使用终端的 access key (HMAC-SHA256) 或者配置的 Ed25519 私钥对最终摘要签名,
+	每 checkpointInterval 帧记录一次哈希链的摘要, 检查点的间隔和摘要同样签名。
+	校验时重新计算哈希链, 只能定位第一个被篡改的帧所在的区间(两个检查点之间), 之后的帧都无法校验。
+	签名文件和录像一起上传到存储中:
+
+	2021-06-01/{sid}.replay.gz.sig
+	2021-06-01/{sid}.command.sig
+*/
+
+const (
+	SignTypeReplay  = "replay"
+	SignTypeCommand = "command"
+
+	SignAlgorithmHMAC    = "hmac-sha256"
+	SignAlgorithmEd25519 = "ed25519"
+
+	signatureVersion = 1
+
+	keyIDSize = 8
+
+	// 哈希链检查点的间隔帧数, 签名文件的大小和会话时长无关
+	checkpointInterval = 1024
+)
+
+var (
+	ErrInvalidSignKey   = errors.New("invalid audit sign key")
+	ErrInvalidSignature = errors.New("invalid audit signature")
+)
+
+type AuditSigner interface {
+	Algorithm() string
+	KeyID() string
+	Sign(msg []byte) ([]byte, error)
+	Verify(msg, sig []byte) bool
+}
+
+// NewAuditSigner 优先使用配置的 Ed25519 私钥，否则使用终端的 access key
+func NewAuditSigner() (AuditSigner, error) {
+	conf := config.GetConf()
+	if conf.AuditSignKeyPath != "" {
+		return LoadEd25519Signer(conf.AuditSignKeyPath)
+	}
+	var key model.AccessKey
+	if err := key.LoadFromFile(conf.AccessKeyFilePath); err != nil {
+		return nil, err
+	}
+	return NewHMACSigner(key.ID, []byte(key.Secret)), nil
+}
+
+// getAuditSigner 签名文件只上传到对象存储, 未开启或不支持时返回 nil
+func getAuditSigner(sessionID string, replayStorage ReplayStorage) AuditSigner {
+	if !config.GetConf().EnableAuditSignature {
+		return nil
+	}
+	if !IsObjectStorage(replayStorage) {
+		logger.Infof("Session %s: storage %s not support audit signature", sessionID,
+			replayStorage.TypeName())
+		return nil
+	}
+	signer, err := NewAuditSigner()
+	if err != nil {
+		logger.Errorf("Session %s: load audit sign key err: %s", sessionID, err)
+		return nil
+	}
+	return signer
+}
+
+func NewHMACSigner(keyID string, secret []byte) AuditSigner {
+	return &hmacSigner{keyID: keyID, secret: secret}
+}
+
+type hmacSigner struct {
+	keyID  string
+	secret []byte
+}
+
+func (s *hmacSigner) Algorithm() string {
+	return SignAlgorithmHMAC
+}
+
+func (s *hmacSigner) KeyID() string {
+	return s.keyID
+}
+
+func (s *hmacSigner) Sign(msg []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(msg)
+	return mac.Sum(nil), nil
+}
+
+func (s *hmacSigner) Verify(msg, sig []byte) bool {
+	expect, _ := s.Sign(msg)
+	return hmac.Equal(expect, sig)
+}
+
+// LoadAuditVerifier 校验签名使用的 key: PKIX PEM 格式的 Ed25519 公钥、PKCS8 PEM 格式的 Ed25519 私钥,
+// 或者终端的 access key 文件 (id:secret)
+func LoadAuditVerifier(path string) (AuditSigner, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		var key model.AccessKey
+		if err = key.LoadFromStr(string(data)); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSignKey, err)
+		}
+		return NewHMACSigner(key.ID, []byte(key.Secret)), nil
+	}
+	if block.Type == "PRIVATE KEY" {
+		return LoadEd25519Signer(path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSignKey, err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not ed25519 key", ErrInvalidSignKey, path)
+	}
+	return NewEd25519Verifier(publicKey, nil), nil
+}
+
+// LoadEd25519Signer 加载 PKCS8 PEM 格式的 Ed25519 私钥
+func LoadEd25519Signer(path string) (AuditSigner, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: %s no pem data", ErrInvalidSignKey, path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSignKey, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not ed25519 key", ErrInvalidSignKey, path)
+	}
+	return NewEd25519Signer(privateKey), nil
+}
+
+func NewEd25519Signer(privateKey ed25519.PrivateKey) AuditSigner {
+	return NewEd25519Verifier(privateKey.Public().(ed25519.PublicKey), privateKey)
+}
+
+// NewEd25519Verifier 只有公钥时，只能用于校验签名
+func NewEd25519Verifier(publicKey ed25519.PublicKey, privateKey ed25519.PrivateKey) AuditSigner {
+	sum := sha256.Sum256(publicKey)
+	return &ed25519Signer{
+		keyID:      hex.EncodeToString(sum[:keyIDSize]),
+		publicKey:  publicKey,
+		privateKey: privateKey,
+	}
+}
+
+type ed25519Signer struct {
+	keyID      string
+	publicKey  ed25519.PublicKey
+	privateKey ed25519.PrivateKey
+}
+
+func (s *ed25519Signer) Algorithm() string {
+	return SignAlgorithmEd25519
+}
+
+func (s *ed25519Signer) KeyID() string {
+	return s.keyID
+}
+
+func (s *ed25519Signer) Sign(msg []byte) ([]byte, error) {
+	if s.privateKey == nil {
+		return nil, fmt.Errorf("%w: no private key", ErrInvalidSignKey)
+	}
+	return ed25519.Sign(s.privateKey, msg), nil
+}
+
+func (s *ed25519Signer) Verify(msg, sig []byte) bool {
+	return ed25519.Verify(s.publicKey, msg, sig)
+}
+
+type hashChain struct {
+	sum      []byte
+	count    int
+	interval int
+
+	checkpoints []AuditCheckpoint
+}
+
+func newHashChain(sessionID string) *hashChain {
+	sum := sha256.Sum256([]byte(sessionID))
+	return &hashChain{sum: sum[:], interval: checkpointInterval}
+}
+
+func (c *hashChain) Add(frame []byte) {
+	h := sha256.New()
+	h.Write(c.sum)
+	h.Write(frame)
+	c.sum = h.Sum(nil)
+	c.count++
+	if c.count%c.interval == 0 {
+		c.checkpoints = append(c.checkpoints, AuditCheckpoint{Frame: c.count, Digest: c.Digest()})
+	}
+}
+
+func (c *hashChain) Digest() string {
+	return hex.EncodeToString(c.sum)
+}
+
+func (c *hashChain) Sign(signer AuditSigner, signType, sessionID string) (*AuditSignature, error) {
+	sig := AuditSignature{
+		Version:     signatureVersion,
+		SessionID:   sessionID,
+		Type:        signType,
+		Algorithm:   signer.Algorithm(),
+		KeyID:       signer.KeyID(),
+		Count:       c.count,
+		Digest:      c.Digest(),
+		Interval:    c.interval,
+		Checkpoints: c.checkpoints,
+		DateSign:    time.Now().UTC(),
+	}
+	signature, err := signer.Sign(sig.message())
+	if err != nil {
+		return nil, err
+	}
+	sig.Signature = base64.StdEncoding.EncodeToString(signature)
+	return &sig, nil
+}
+
+// chainEncoder 将写入录像的每一帧加入哈希链
+type chainEncoder struct {
+	ReplayEncoder
+	chain *hashChain
+
+	// 旧版 json 格式不记录窗口变化, 校验时也无法还原
+	recordResize bool
+}
+
+func newChainEncoder(encoder ReplayEncoder, format string, chain *hashChain) *chainEncoder {
+	return &chainEncoder{
+		ReplayEncoder: encoder,
+		chain:         chain,
+		recordResize:  format == ReplayFormatAsciicast,
+	}
+}
+
+func (e *chainEncoder) WriteOutput(offset time.Duration, p []byte) error {
+	if len(p) > 0 {
+		e.chain.Add(replayFrame(asciicastOutputEvent, offset.Seconds(), validUTF8String(p)))
+	}
+	return e.ReplayEncoder.WriteOutput(offset, p)
+}
+
+func (e *chainEncoder) WriteResize(offset time.Duration, width, height int) error {
+	if e.recordResize {
+		e.chain.Add(replayFrame(asciicastResizeEvent, offset.Seconds(),
+			fmt.Sprintf("%dx%d", width, height)))
+	}
+	return e.ReplayEncoder.WriteResize(offset, width, height)
+}
+
+// validUTF8String 和 json 编码一致, 每个非法字节替换成 U+FFFD
+func validUTF8String(p []byte) string {
+	if utf8.Valid(p) {
+		return string(p)
+	}
+	return string([]rune(string(p)))
+}
+
+// replayFrame 录像帧的规范化内容, 和具体的录像格式无关
+func replayFrame(event string, offset float64, data string) []byte {
+	return []byte(fmt.Sprintf("%f\x00%s\x00%s", offset, event, data))
+}
+
+// commandFrame 命令的规范化内容, 只包含存储后不会变化的字段
+func commandFrame(cmd *model.Command) []byte {
+	frame := fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%d\x00%d",
+		cmd.SessionID, cmd.User, cmd.Input, cmd.Output, cmd.Timestamp, cmd.RiskLevel)
+	if cmd.OutputRef != "" {
+		frame += "\x00" + cmd.OutputRef
+	}
+	return []byte(frame)
+}
+
+// uploadAuditSignature 签名并上传签名文件, 签名文件上传后删除
+func uploadAuditSignature(chain *hashChain, signer AuditSigner, replayStorage ReplayStorage,
+	signType, sessionID, path, target string) {
+	sig, err := chain.Sign(signer, signType, sessionID)
+	if err != nil {
+		logger.Errorf("Session %s: sign %s err: %s", sessionID, signType, err)
+		return
+	}
+	if err = sig.WriteFile(path); err != nil {
+		logger.Errorf("Session %s: write %s signature file err: %s", sessionID, signType, err)
+		return
+	}
+	defer os.Remove(path)
+	if err = uploadWithRetry(replayStorage, path, target, 3); err != nil {
+		logger.Errorf("Session %s: upload %s signature err: %s", sessionID, signType, err)
+		return
+	}
+	logger.Infof("Session %s: %s signature uploaded, total %d frames", sessionID, signType, sig.Count)
+}
+
+type AuditSignature struct {
+	Version     int               `json:"version"`
+	SessionID   string            `json:"session_id"`
+	Type        string            `json:"type"`
+	Algorithm   string            `json:"algorithm"`
+	KeyID       string            `json:"key_id"`
+	Count       int               `json:"count"`
+	Digest      string            `json:"digest"`
+	Interval    int               `json:"interval"`
+	Checkpoints []AuditCheckpoint `json:"checkpoints"`
+	Signature   string            `json:"signature"`
+	DateSign    time.Time         `json:"date_sign"`
+}
+
+// AuditCheckpoint 第 Frame 帧之后的哈希链摘要
+type AuditCheckpoint struct {
+	Frame  int    `json:"frame"`
+	Digest string `json:"digest"`
+}
+
+func (s *AuditSignature) message() []byte {
+	return []byte(fmt.Sprintf("koko-audit-v%d\n%s\n%s\n%d\n%s\n%d\n%s",
+		s.Version, s.Type, s.SessionID, s.Count, s.Digest, s.Interval, s.checkpointsDigest()))
+}
+
+// checkpointsDigest 签名中检查点列表的摘要
+func (s *AuditSignature) checkpointsDigest() string {
+	h := sha256.New()
+	for i := range s.Checkpoints {
+		fmt.Fprintf(h, "%d:%s\n", s.Checkpoints[i].Frame, s.Checkpoints[i].Digest)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *AuditSignature) WriteFile(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func LoadAuditSignature(path string) (*AuditSignature, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sig AuditSignature
+	if err = json.Unmarshal(data, &sig); err != nil {
+		return nil, err
+	}
+	return &sig, nil
+}
+
+// VerifyResult 第一个被篡改的帧在 FirstAlteredFrame 和 LastAlteredFrame 之间(检查点的区间), 从 1 开始,
+// 0 表示未发现被篡改的帧; 区间之后的帧无法校验, 不代表未被篡改
+type VerifyResult struct {
+	Valid             bool
+	SignatureValid    bool
+	FirstAlteredFrame int
+	LastAlteredFrame  int
+	FrameCount        int
+	Reason            string
+}
+
+func (r *VerifyResult) altered(first, last int) {
+	r.FirstAlteredFrame, r.LastAlteredFrame = first, last
+	if first == last {
+		r.Reason = fmt.Sprintf("frame %d was altered", first)
+		return
+	}
+	r.Reason = fmt.Sprintf("first altered frame is within frames %d-%d, later frames are unverified", first, last)
+}
+
+func (s *AuditSignature) verify(verifier AuditSigner, frames [][]byte) VerifyResult {
+	var result VerifyResult
+	result.FrameCount = len(frames)
+	if s.Algorithm != verifier.Algorithm() {
+		result.Reason = fmt.Sprintf("algorithm %s not match %s", s.Algorithm, verifier.Algorithm())
+		return result
+	}
+	signature, err := base64.StdEncoding.DecodeString(s.Signature)
+	result.SignatureValid = err == nil && verifier.Verify(s.message(), signature)
+	if !result.SignatureValid {
+		result.Reason = ErrInvalidSignature.Error()
+		return result
+	}
+	chain := newHashChain(s.SessionID)
+	if s.Interval > 0 {
+		chain.interval = s.Interval
+	}
+	// 上一个校验通过的帧
+	verified := 0
+	for i := range frames {
+		chain.Add(frames[i])
+		n := len(chain.checkpoints)
+		if n == 0 || chain.checkpoints[n-1].Frame != i+1 {
+			continue
+		}
+		if n > len(s.Checkpoints) || chain.checkpoints[n-1] != s.Checkpoints[n-1] {
+			result.altered(verified+1, i+1)
+			return result
+		}
+		verified = i + 1
+	}
+	switch {
+	case chain.Digest() == s.Digest && len(frames) == s.Count:
+		result.Valid = true
+	case len(frames) < s.Count:
+		result.altered(verified+1, s.Count)
+		result.Reason = fmt.Sprintf("frame count %d not match %d, %s", len(frames), s.Count, result.Reason)
+	default:
+		result.altered(verified+1, len(frames))
+	}
+	return result
+}
+
+// VerifyReplay 校验下载的录像文件 (支持 gzip 压缩文件) 和签名文件, 加密的录像需要先使用 DecryptReplayFile 解密
+func VerifyReplay(replayPath, sigPath string, verifier AuditSigner) (VerifyResult, error) {
+	sig, err := LoadAuditSignature(sigPath)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	frames, err := readReplayFrames(replayPath)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	return sig.verify(verifier, frames), nil
+}
+
+// VerifyCommands 校验下载的命令记录, 命令需要按记录的顺序排列
+func VerifyCommands(commands []*model.Command, sigPath string, verifier AuditSigner) (VerifyResult, error) {
+	sig, err := LoadAuditSignature(sigPath)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	frames := make([][]byte, 0, len(commands))
+	for i := range commands {
+		frames = append(frames, commandFrame(commands[i]))
+	}
+	return sig.verify(verifier, frames), nil
+}
+
+func readReplayFrames(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	decoder, err := NewReplayDecoder(f)
+	if err != nil {
+		return nil, err
+	}
+	frames := make([][]byte, 0, 1024)
+	for {
+		event, err := decoder.Next()
+		if err != nil {
+			if err == io.EOF {
+				return frames, nil
+			}
+			return nil, err
+		}
+		frames = append(frames, replayFrame(event.Type, event.Time, event.Data))
+	}
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+)
+
+func writeSignedReplay(t *testing.T, dir, format string, signer AuditSigner) (string, string) {
+	sid := "2b1c4d6e-1f9a-4e0c-8a3b-7d5e6f7a8b9c"
+	replayPath := filepath.Join(dir, sid+"."+format)
+	sigPath := replayPath + ".sig"
+	f, err := os.Create(replayPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := newHashChain(sid)
+	chain.interval = 1
+	encoder := newChainEncoder(NewReplayEncoder(format, f), format, chain)
+	_ = encoder.WriteHeader(ReplayHeader{Width: 80, Height: 24, Timestamp: time.Now()})
+	_ = encoder.WriteOutput(time.Millisecond*100, []byte("ls\r\n"))
+	_ = encoder.WriteResize(time.Millisecond*200, 100, 40)
+	_ = encoder.WriteOutput(time.Millisecond*300, []byte("a.txt b.txt\r\n"))
+	_ = encoder.WriteOutput(time.Millisecond*400, []byte("\xff\xfeinvalid\r\n"))
+	_ = encoder.WriteEnd(time.Millisecond * 500)
+	_ = f.Close()
+	sig, err := chain.Sign(signer, SignTypeReplay, sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sig.WriteFile(sigPath); err != nil {
+		t.Fatal(err)
+	}
+	return replayPath, sigPath
+}
+
+func TestVerifyReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	signers := []AuditSigner{
+		NewHMACSigner("access-key-id", []byte("access-key-secret")),
+		NewEd25519Signer(privateKey),
+	}
+	for _, format := range []string{ReplayFormatJSON, ReplayFormatAsciicast} {
+		for _, signer := range signers {
+			replayPath, sigPath := writeSignedReplay(t, dir, format, signer)
+			result, err := VerifyReplay(replayPath, sigPath, signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Valid {
+				t.Fatalf("%s %s replay verify failed: %s", format, signer.Algorithm(), result.Reason)
+			}
+
+			data, _ := ioutil.ReadFile(replayPath)
+			data = bytes.Replace(data, []byte("b.txt"), []byte("c.txt"), 1)
+			_ = ioutil.WriteFile(replayPath, data, 0644)
+			result, err = VerifyReplay(replayPath, sigPath, signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectFrame := 2
+			if format == ReplayFormatAsciicast {
+				expectFrame = 3
+			}
+			if result.Valid || result.FirstAlteredFrame != expectFrame {
+				t.Fatalf("%s altered frame %d, expect %d", format, result.FirstAlteredFrame, expectFrame)
+			}
+		}
+	}
+}
+
+func TestVerifyCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sid := "2b1c4d6e-1f9a-4e0c-8a3b-7d5e6f7a8b9c"
+	signer := NewHMACSigner("access-key-id", []byte("access-key-secret"))
+	commands := []*model.Command{
+		{SessionID: sid, User: "admin", Input: "ls", Output: "a.txt", Timestamp: 1622505600},
+		{SessionID: sid, User: "admin", Input: "rm -rf /", Timestamp: 1622505601, RiskLevel: 5},
+	}
+	chain := newHashChain(sid)
+	for i := range commands {
+		chain.Add(commandFrame(commands[i]))
+	}
+	sig, err := chain.Sign(signer, SignTypeCommand, sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigPath := filepath.Join(dir, sid+".command.sig")
+	if err = sig.WriteFile(sigPath); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := VerifyCommands(commands, sigPath, signer); !result.Valid {
+		t.Fatalf("commands verify failed: %s", result.Reason)
+	}
+	if result, _ := VerifyCommands(commands[:1], sigPath, signer); result.Valid {
+		t.Fatal("deleted command not detected")
+	}
+	commands[1].RiskLevel = 0
+	if result, _ := VerifyCommands(commands, sigPath, signer); result.FirstAlteredFrame != 1 ||
+		result.LastAlteredFrame != 2 {
+		t.Fatalf("altered commands %d-%d, expect 1-2", result.FirstAlteredFrame, result.LastAlteredFrame)
+	}
+	otherSigner := NewHMACSigner("access-key-id", []byte("other-secret"))
+	if result, _ := VerifyCommands(commands, sigPath, otherSigner); result.SignatureValid {
+		t.Fatal("invalid signature not detected")
+	}
+}
+
+func TestVerifyCheckpoints(t *testing.T) {
+	sid := "2b1c4d6e-1f9a-4e0c-8a3b-7d5e6f7a8b9c"
+	signer := NewHMACSigner("access-key-id", []byte("access-key-secret"))
+	frames := make([][]byte, 3000)
+	chain := newHashChain(sid)
+	for i := range frames {
+		frames[i] = []byte(fmt.Sprintf("frame %d", i))
+		chain.Add(frames[i])
+	}
+	sig, err := chain.Sign(signer, SignTypeReplay, sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sig.Checkpoints) != 2 {
+		t.Fatalf("checkpoints %d, expect 2", len(sig.Checkpoints))
+	}
+	if result := sig.verify(signer, frames); !result.Valid {
+		t.Fatalf("verify failed: %s", result.Reason)
+	}
+	frames[1500] = []byte("altered")
+	if result := sig.verify(signer, frames); result.FirstAlteredFrame != 1025 || result.LastAlteredFrame != 2048 {
+		t.Fatalf("altered frames %d-%d, expect 1025-2048", result.FirstAlteredFrame, result.LastAlteredFrame)
+	}
+	frames[1500] = []byte("frame 1500")
+	if result := sig.verify(signer, frames[:2500]); result.FirstAlteredFrame != 2049 || result.LastAlteredFrame != 3000 {
+		t.Fatalf("deleted frames %d-%d, expect 2049-3000", result.FirstAlteredFrame, result.LastAlteredFrame)
+	}
+
+	// 检查点和间隔同样被签名
+	forged := *sig
+	forged.Checkpoints = append([]AuditCheckpoint(nil), sig.Checkpoints...)
+	forged.Checkpoints[1].Digest = sig.Checkpoints[0].Digest
+	if result := forged.verify(signer, frames); result.SignatureValid {
+		t.Fatal("altered checkpoints not detected")
+	}
+	forged = *sig
+	forged.Interval = 512
+	if result := forged.verify(signer, frames); result.SignatureValid {
+		t.Fatal("altered interval not detected")
+	}
+}
+
+func TestLoadAuditVerifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	der, _ := x509.MarshalPKIXPublicKey(publicKey)
+	publicKeyPath := filepath.Join(dir, "ed25519.pub")
+	_ = ioutil.WriteFile(publicKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	accessKeyPath := filepath.Join(dir, ".access_key")
+	_ = ioutil.WriteFile(accessKeyPath, []byte("access-key-id:access-key-secret\n"), 0600)
+
+	tests := []struct {
+		path   string
+		signer AuditSigner
+	}{
+		{publicKeyPath, NewEd25519Signer(privateKey)},
+		{accessKeyPath, NewHMACSigner("access-key-id", []byte("access-key-secret"))},
+	}
+	for _, tt := range tests {
+		verifier, err := LoadAuditVerifier(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replayPath, sigPath := writeSignedReplay(t, dir, ReplayFormatAsciicast, tt.signer)
+		if result, _ := VerifyReplay(replayPath, sigPath, verifier); !result.Valid {
+			t.Errorf("%s verify failed: %s", tt.path, result.Reason)
+		}
+	}
+}
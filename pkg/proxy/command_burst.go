@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/common"
+	"github.com/jumpserver/koko/pkg/config"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	命令频率和粘贴突发检测, 允许执行的命令大量执行时同样危险:
+	  频率: COMMAND_RATE_WINDOW 秒内执行超过 COMMAND_RATE_LIMIT 条命令
+	  粘贴: 一次粘贴(括号粘贴 ESC[200~ ... ESC[201~, 或者连续的多字节输入)超过 PASTE_BURST_LINES 行或 PASTE_BURST_SIZE 字节
+	检测到后记录一条高危命令, 并按 COMMAND_BURST_ACTION 处理:
+	  warn:      提示用户, 并向共享会话发送 COMMAND_BURST 事件
+	  confirm:   同 warn, 并且下一条命令需要复核, 需要 webhook 或 local 复核后端
+	  terminate: 同 warn, 然后断开会话
+*/
+
+const (
+	burstActionWarn      = "warn"
+	burstActionConfirm   = "confirm"
+	burstActionTerminate = "terminate"
+
+	defaultCommandRateWindow = 60 * time.Second
+
+	// 没有括号粘贴时, 间隔小于 pasteInputGap 的多字节输入视为同一次粘贴
+	pasteInputGap = 500 * time.Millisecond
+	// 终端按键最多产生几个字节, 超过时视为粘贴
+	pasteMinInputSize = 8
+	// 记录到命令输出中的粘贴内容
+	pasteMaxDetailSize = 4096
+)
+
+var (
+	bracketedPasteStart = []byte("\x1b[200~")
+	bracketedPasteEnd   = []byte("\x1b[201~")
+)
+
+type CommandBurstPolicy struct {
+	RateLimit  int
+	RateWindow time.Duration
+	PasteLines int
+	PasteSize  int
+	Action     string
+}
+
+func (p CommandBurstPolicy) Enabled() bool {
+	return p.RateLimit > 0 || p.PasteLines > 0 || p.PasteSize > 0
+}
+
+var (
+	commandBurstPolicy     CommandBurstPolicy
+	commandBurstPolicyOnce sync.Once
+)
+
+// GetCommandBurstPolicy 根据配置文件生成, 未知的处理方式使用 warn
+func GetCommandBurstPolicy() CommandBurstPolicy {
+	commandBurstPolicyOnce.Do(func() {
+		conf := config.GetConf()
+		policy := CommandBurstPolicy{
+			RateLimit:  conf.CommandRateLimit,
+			RateWindow: time.Duration(conf.CommandRateWindow) * time.Second,
+			PasteLines: conf.PasteBurstLines,
+			Action:     conf.CommandBurstAction,
+		}
+		if conf.PasteBurstSize != "" {
+			policy.PasteSize = common.ConvertSizeToBytes(conf.PasteBurstSize)
+		}
+		if policy.RateWindow <= 0 {
+			policy.RateWindow = defaultCommandRateWindow
+		}
+		switch policy.Action {
+		case burstActionWarn, burstActionConfirm, burstActionTerminate:
+		default:
+			if policy.Action != "" {
+				logger.Errorf("Unknown command burst action %s, use warn", policy.Action)
+			}
+			policy.Action = burstActionWarn
+		}
+		commandBurstPolicy = policy
+	})
+	return commandBurstPolicy
+}
+
+// commandBurst 检测到的突发, 记录为高危命令
+type commandBurst struct {
+	Command string
+	Detail  string
+}
+
+// burstDetector 会话中的命令频率和粘贴检测, 为 nil 时不检测
+type burstDetector struct {
+	policy CommandBurstPolicy
+
+	commands []time.Time
+
+	inBracket bool
+	pasting   bool
+	lastPaste time.Time
+	lines     int
+	size      int
+	detail    bytes.Buffer
+	// reported 本次粘贴已经记录
+	reported bool
+
+	// escalate 下一条命令需要复核
+	escalate bool
+}
+
+func newBurstDetector(policy CommandBurstPolicy) *burstDetector {
+	if !policy.Enabled() {
+		return nil
+	}
+	return &burstDetector{policy: policy}
+}
+
+// Command 记录执行的命令, 超过频率限制时返回突发, 并重新计数
+func (d *burstDetector) Command(now time.Time) *commandBurst {
+	if d == nil || d.policy.RateLimit <= 0 {
+		return nil
+	}
+	start := now.Add(-d.policy.RateWindow)
+	i := 0
+	for i < len(d.commands) && d.commands[i].Before(start) {
+		i++
+	}
+	d.commands = append(d.commands[i:], now)
+	if len(d.commands) <= d.policy.RateLimit {
+		return nil
+	}
+	count := len(d.commands)
+	d.commands = nil
+	return d.report(&commandBurst{
+		Command: fmt.Sprintf("command rate burst: %d commands in %s", count, d.policy.RateWindow),
+	})
+}
+
+// Input 处理用户的输入, 粘贴超过阈值时返回突发, 每次粘贴只返回一次
+func (d *burstDetector) Input(now time.Time, b []byte) *commandBurst {
+	if d == nil || (d.policy.PasteLines <= 0 && d.policy.PasteSize <= 0) {
+		return nil
+	}
+	if bytes.Contains(b, bracketedPasteStart) {
+		d.inBracket = true
+		d.resetPaste()
+	}
+	bracket := d.inBracket
+	if bytes.Contains(b, bracketedPasteEnd) {
+		d.inBracket = false
+	}
+	if !bracket && len(b) < pasteMinInputSize {
+		d.pasting = false
+		return nil
+	}
+	// 括号粘贴以开始标记为准, 不按间隔计算
+	if !d.pasting || (!bracket && now.Sub(d.lastPaste) > pasteInputGap) {
+		d.resetPaste()
+	}
+	d.pasting = true
+	d.lastPaste = now
+	data := bytes.Replace(bytes.Replace(b, bracketedPasteStart, nil, 1), bracketedPasteEnd, nil, 1)
+	d.lines += countInputLines(data)
+	d.size += len(data)
+	if n := pasteMaxDetailSize - d.detail.Len(); n > 0 {
+		if len(data) > n {
+			data = data[:n]
+		}
+		d.detail.Write(data)
+	}
+	if d.reported {
+		return nil
+	}
+	if (d.policy.PasteLines > 0 && d.lines >= d.policy.PasteLines) ||
+		(d.policy.PasteSize > 0 && d.size >= d.policy.PasteSize) {
+		d.reported = true
+		return d.report(&commandBurst{
+			Command: fmt.Sprintf("paste burst: %d lines, %d bytes", d.lines, d.size),
+			Detail:  d.detail.String(),
+		})
+	}
+	return nil
+}
+
+func (d *burstDetector) resetPaste() {
+	d.lines = 0
+	d.size = 0
+	d.detail.Reset()
+	d.reported = false
+}
+
+func (d *burstDetector) report(burst *commandBurst) *commandBurst {
+	if d.policy.Action == burstActionConfirm {
+		d.escalate = true
+	}
+	return burst
+}
+
+// TakeEscalation 下一条命令是否需要复核, 只生效一次
+func (d *burstDetector) TakeEscalation() bool {
+	if d == nil || !d.escalate {
+		return false
+	}
+	d.escalate = false
+	return true
+}
+
+func (d *burstDetector) Terminate() bool {
+	return d != nil && d.policy.Action == burstActionTerminate
+}
+
+// countInputLines 终端粘贴的换行一般为 \r, 也可能为 \n 或 \r\n
+func countInputLines(b []byte) int {
+	text := strings.Replace(string(b), "\r\n", "\n", -1)
+	return strings.Count(text, "\n") + strings.Count(text, "\r")
+}
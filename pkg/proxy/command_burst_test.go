@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBurstDetector_Command(t *testing.T) {
+	d := newBurstDetector(CommandBurstPolicy{RateLimit: 3, RateWindow: time.Minute, Action: burstActionConfirm})
+	now := time.Now()
+	d.Command(now)
+	// 窗口外的命令不计数
+	for i := 61; i < 64; i++ {
+		if burst := d.Command(now.Add(time.Duration(i) * time.Second)); burst != nil {
+			t.Fatalf("unexpected burst %+v", burst)
+		}
+	}
+	burst := d.Command(now.Add(64 * time.Second))
+	if burst == nil || burst.Command != "command rate burst: 4 commands in 1m0s" {
+		t.Fatalf("unexpected burst %+v", burst)
+	}
+	if !d.TakeEscalation() || d.TakeEscalation() {
+		t.Error("expect escalation once")
+	}
+	if burst = d.Command(now.Add(65 * time.Second)); burst != nil {
+		t.Errorf("expect count reset, got %+v", burst)
+	}
+
+	if newBurstDetector(CommandBurstPolicy{}) != nil {
+		t.Error("expect nil detector when disabled")
+	}
+	var nilDetector *burstDetector
+	if nilDetector.Command(now) != nil || nilDetector.Input(now, []byte("ls\r")) != nil || nilDetector.TakeEscalation() {
+		t.Error("expect nil detector ignored")
+	}
+}
+
+func TestBurstDetector_Input(t *testing.T) {
+	d := newBurstDetector(CommandBurstPolicy{PasteLines: 5, Action: burstActionWarn})
+	now := time.Now()
+	for _, key := range []string{"l", "s", "\x1b[A", "\r"} {
+		if burst := d.Input(now, []byte(key)); burst != nil {
+			t.Fatalf("unexpected burst for typing %q", key)
+		}
+	}
+
+	// 括号粘贴跨多次读取
+	chunks := []string{"\x1b[200~rm a\r", "rm b\rrm c\r", "rm d\rrm e\r\x1b[201~"}
+	var burst *commandBurst
+	for i, chunk := range chunks {
+		if b := d.Input(now.Add(time.Duration(i)*time.Second), []byte(chunk)); b != nil {
+			burst = b
+		}
+	}
+	if burst == nil || burst.Command != "paste burst: 5 lines, 25 bytes" {
+		t.Fatalf("unexpected burst %+v", burst)
+	}
+	if burst.Detail != "rm a\rrm b\rrm c\rrm d\rrm e\r" {
+		t.Errorf("unexpected detail %q", burst.Detail)
+	}
+	if d.TakeEscalation() {
+		t.Error("warn action should not escalate")
+	}
+
+	// 没有括号粘贴时按连续的多字节输入计算, 间隔过长重新计数
+	lines := strings.Repeat("echo 1\r", 3)
+	if d.Input(now, []byte(lines)) != nil || d.Input(now.Add(time.Second), []byte(lines)) != nil {
+		t.Fatal("expect paste reset after gap")
+	}
+	if burst = d.Input(now.Add(time.Second+100*time.Millisecond), []byte(lines)); burst == nil {
+		t.Fatal("expect paste burst")
+	}
+	if d.Input(now.Add(time.Second+200*time.Millisecond), []byte(lines)) != nil {
+		t.Error("expect paste reported once")
+	}
+}
+
+func TestCountInputLines(t *testing.T) {
+	for input, expect := range map[string]int{
+		"ls":         0,
+		"a\rb\r":     2,
+		"a\r\nb\r\n": 2,
+		"a\nb":       1,
+	} {
+		if got := countInputLines([]byte(input)); got != expect {
+			t.Errorf("%q: expect %d, got %d", input, expect, got)
+		}
+	}
+}
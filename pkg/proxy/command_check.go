@@ -26,6 +26,9 @@ type commandConfirmStatus struct {
 
 	action    model.RuleAction
 	Processor string
+
+	// ticket 等待中的复核
+	ticket CommandConfirmTicket
 }
 
 func (c *commandConfirmStatus) SetStatus(status string) {
@@ -58,6 +61,18 @@ func (c *commandConfirmStatus) GetProcessor() string {
 	return c.Processor
 }
 
+func (c *commandConfirmStatus) SetTicket(ticket CommandConfirmTicket) {
+	c.Lock()
+	defer c.Unlock()
+	c.ticket = ticket
+}
+
+func (c *commandConfirmStatus) GetTicket() CommandConfirmTicket {
+	c.Lock()
+	defer c.Unlock()
+	return c.ticket
+}
+
 func (c *commandConfirmStatus) SetRule(rule model.SystemUserFilterRule) {
 	c.Lock()
 	defer c.Unlock()
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/config"
+	"github.com/jumpserver/koko/pkg/i18n"
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/service"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	命令复核: 命令匹配 confirm 规则并且用户选择继续后, 提交到 COMMAND_CONFIRM_BACKEND 并等待复核结果
+	  core:    默认, 提交 Core 的命令复核工单, 审核人在 Core 处理
+	  webhook: POST 到 COMMAND_CONFIRM_WEBHOOK_URL, 等待回调或者轮询状态地址, 格式见 command_confirm_webhook.go
+	  local:   共享会话中有写权限的其他用户在终端输入 y 同意, n 拒绝
+	webhook 和 local 超过 COMMAND_CONFIRM_TIMEOUT 秒没有结果时拒绝执行。
+*/
+
+const (
+	confirmBackendCore    = "core"
+	confirmBackendWebhook = "webhook"
+	confirmBackendLocal   = "local"
+)
+
+var errCommandConfirmTimeout = errors.New("command confirm timeout")
+
+// CommandConfirmResult 复核结果, Status 为 approve, reject 或 await
+type CommandConfirmResult struct {
+	Status    string `json:"status"`
+	Processor string `json:"processor"`
+}
+
+// CommandConfirmer 命令复核的后端, 每个会话一个
+type CommandConfirmer interface {
+	Submit(ruleID, cmd string) (CommandConfirmTicket, error)
+}
+
+// CommandConfirmTicket 一次提交的命令复核
+type CommandConfirmTicket interface {
+	// Tips 等待复核时给用户的提示
+	Tips() []string
+	// Wait 等待复核结果, ctx 取消时撤销复核并返回 ctx 的错误
+	Wait(ctx context.Context) (CommandConfirmResult, error)
+}
+
+// commandConfirmSession 提交复核时附带的会话信息
+type commandConfirmSession struct {
+	ID         string
+	User       string
+	Asset      string
+	SystemUser string
+}
+
+// newCommandConfirmer 根据配置选择复核的后端
+func newCommandConfirmer(jmsService *service.JMService, session commandConfirmSession) CommandConfirmer {
+	conf := config.GetConf()
+	timeout := time.Duration(conf.CommandConfirmTimeout) * time.Second
+	switch getCommandConfirmBackend(conf) {
+	case confirmBackendWebhook:
+		return newWebhookCommandConfirmer(session, conf.CommandConfirmWebhookURL,
+			conf.CommandConfirmWebhookSecret, conf.CommandConfirmCallbackURL, timeout)
+	case confirmBackendLocal:
+		return &localCommandConfirmer{timeout: timeout}
+	}
+	return &coreCommandConfirmer{sessionID: session.ID, jmsService: jmsService}
+}
+
+// CheckCommandConfirmConfig 启动时检查复核配置:
+// webhook 必须设置地址和签名密钥, 否则任何人都可以回调同意复核;
+// 突发之后的复核没有 Core 的规则, Core 无法复核, 需要 webhook 或 local 复核后端
+func CheckCommandConfirmConfig(conf config.Config) error {
+	if err := checkConfirmWebhookConfig(conf); err != nil {
+		return err
+	}
+	if conf.CommandBurstAction == burstActionConfirm && getCommandConfirmBackend(conf) == confirmBackendCore {
+		return fmt.Errorf("COMMAND_BURST_ACTION %s requires command confirm backend %s or %s",
+			burstActionConfirm, confirmBackendWebhook, confirmBackendLocal)
+	}
+	return nil
+}
+
+func checkConfirmWebhookConfig(conf config.Config) error {
+	if conf.CommandConfirmBackend != confirmBackendWebhook {
+		return nil
+	}
+	switch {
+	case conf.CommandConfirmWebhookURL == "":
+		return errors.New("command confirm webhook requires COMMAND_CONFIRM_WEBHOOK_URL")
+	case conf.CommandConfirmWebhookSecret == "":
+		return errors.New("command confirm webhook requires COMMAND_CONFIRM_WEBHOOK_SECRET")
+	}
+	return nil
+}
+
+// getCommandConfirmBackend 实际使用的复核后端, 配置错误时使用 Core
+func getCommandConfirmBackend(conf config.Config) string {
+	switch conf.CommandConfirmBackend {
+	case confirmBackendWebhook:
+		if err := checkConfirmWebhookConfig(conf); err != nil {
+			logger.Errorf("%s, use core", err)
+			break
+		}
+		return confirmBackendWebhook
+	case confirmBackendLocal:
+		return confirmBackendLocal
+	case confirmBackendCore, "":
+	default:
+		logger.Errorf("Unknown command confirm backend %s, use core", conf.CommandConfirmBackend)
+	}
+	return confirmBackendCore
+}
+
+// coreCommandConfirmer 提交 Core 的命令复核工单
+type coreCommandConfirmer struct {
+	sessionID  string
+	jmsService *service.JMService
+}
+
+func (c *coreCommandConfirmer) Submit(ruleID, cmd string) (CommandConfirmTicket, error) {
+	if ruleID == "" {
+		return nil, errors.New("core command confirm requires a filter rule")
+	}
+	resp, err := c.jmsService.SubmitCommandConfirm(c.sessionID, ruleID, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return &coreConfirmTicket{coreCommandConfirmer: c, resp: resp}, nil
+}
+
+type coreConfirmTicket struct {
+	*coreCommandConfirmer
+	resp service.ConfirmResponse
+}
+
+func (t *coreConfirmTicket) Tips() []string {
+	return []string{
+		i18n.T("Need ticket confirm to execute command, already send email to the reviewers"),
+		fmt.Sprintf(i18n.T("Ticket Reviewers: %s"), strings.Join(t.resp.Reviewers, ", ")),
+		fmt.Sprintf(i18n.T("Could copy website URL to notify reviewers: %s"), t.resp.TicketDetailUrl),
+	}
+}
+
+func (t *coreConfirmTicket) Wait(ctx context.Context) (CommandConfirmResult, error) {
+	checkTimer := time.NewTicker(10 * time.Second)
+	defer checkTimer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := t.jmsService.CancelConfirmByRequestInfo(t.resp.CloseConfirm); err != nil {
+				logger.Errorf("Session %s: Cancel command confirm err: %s", t.sessionID, err)
+			}
+			return CommandConfirmResult{}, ctx.Err()
+		case <-checkTimer.C:
+		}
+		statusResp, err := t.jmsService.CheckConfirmStatusByRequestInfo(t.resp.CheckConfirmStatus)
+		if err != nil {
+			logger.Errorf("Session %s: check command confirm status err: %s", t.sessionID, err)
+			continue
+		}
+		switch statusResp.Status {
+		case approve, reject:
+			return CommandConfirmResult{Status: statusResp.Status, Processor: statusResp.Processor}, nil
+		case await:
+		default:
+			logger.Errorf("Receive unknown command confirm status %s", statusResp.Status)
+		}
+	}
+}
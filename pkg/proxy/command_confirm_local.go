@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/i18n"
+)
+
+// localCommandConfirmer 共享会话中的其他用户在终端复核
+type localCommandConfirmer struct {
+	timeout time.Duration
+}
+
+func (c *localCommandConfirmer) Submit(ruleID, cmd string) (CommandConfirmTicket, error) {
+	return &localConfirmTicket{
+		timeout: c.timeout,
+		result:  make(chan CommandConfirmResult, 1),
+	}, nil
+}
+
+// terminalConfirmTicket 可以通过共享会话的输入复核
+type terminalConfirmTicket interface {
+	// HandleInput 处理会话用户以外的其他用户的输入
+	HandleInput(user string, b []byte)
+}
+
+type localConfirmTicket struct {
+	timeout time.Duration
+	result  chan CommandConfirmResult
+}
+
+func (t *localConfirmTicket) Tips() []string {
+	return []string{
+		i18n.T("Need confirm to execute command, waiting for other users in the shared session"),
+		i18n.T("Other users with write permission could input y to approve, n to reject"),
+	}
+}
+
+func (t *localConfirmTicket) Wait(ctx context.Context) (CommandConfirmResult, error) {
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return CommandConfirmResult{}, ctx.Err()
+	case <-timer.C:
+		return CommandConfirmResult{}, errCommandConfirmTimeout
+	case result := <-t.result:
+		return result, nil
+	}
+}
+
+// HandleInput y 同意, n 拒绝, 其他输入忽略
+func (t *localConfirmTicket) HandleInput(user string, b []byte) {
+	var status string
+	switch strings.ToLower(strings.TrimSpace(string(b))) {
+	case "y":
+		status = approve
+	case "n":
+		status = reject
+	default:
+		return
+	}
+	select {
+	case t.result <- CommandConfirmResult{Status: status, Processor: user}:
+	default:
+	}
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/config"
+)
+
+type fakeConfirmWebhook struct {
+	sync.Mutex
+	events    []webhookConfirmRequest
+	statusURL string
+	status    string
+}
+
+func (f *fakeConfirmWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if r.Method == http.MethodGet {
+		_ = json.NewEncoder(w).Encode(CommandConfirmResult{Status: f.status, Processor: "bot"})
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if !verifyConfirmSignature("secret", body, r.Header.Get(CommandConfirmSignatureHeader)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var req webhookConfirmRequest
+	_ = json.Unmarshal(body, &req)
+	f.events = append(f.events, req)
+	if req.Event == confirmEventSubmit && f.statusURL != "" {
+		_ = json.NewEncoder(w).Encode(webhookConfirmResponse{StatusURL: f.statusURL})
+	}
+}
+
+func (f *fakeConfirmWebhook) Events() []webhookConfirmRequest {
+	f.Lock()
+	defer f.Unlock()
+	return append([]webhookConfirmRequest(nil), f.events...)
+}
+
+func TestWebhookCommandConfirmer_Callback(t *testing.T) {
+	hook := &fakeConfirmWebhook{}
+	srv := httptest.NewServer(hook)
+	defer srv.Close()
+	session := commandConfirmSession{ID: "sid", User: "admin(admin)", Asset: "web(10.0.0.1)", SystemUser: "root(root)"}
+	confirmer := newWebhookCommandConfirmer(session, srv.URL, "secret", "http://koko:5000/", time.Minute)
+
+	ticket, err := confirmer.Submit("rule-1", "rm -rf /data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := hook.Events()
+	if len(events) != 1 {
+		t.Fatalf("expect 1 event, got %d", len(events))
+	}
+	req := events[0]
+	if req.SessionID != "sid" || req.Command != "rm -rf /data" || req.RuleID != "rule-1" || req.Asset != session.Asset {
+		t.Errorf("unexpected request %+v", req)
+	}
+	if !strings.HasPrefix(req.CallbackURL, "http://koko:5000/koko/api/confirms/") {
+		t.Errorf("unexpected callback url %s", req.CallbackURL)
+	}
+
+	expiredAt := time.Now().Add(time.Minute).Unix()
+	callback := func(id, status string) CommandConfirmCallback {
+		return CommandConfirmCallback{ID: id, CommandConfirmResult: CommandConfirmResult{Status: status,
+			Processor: "ops"}, ExpiredAt: expiredAt}
+	}
+	if err = ResolveCommandConfirm("unknown", callback("unknown", approve)); err != ErrConfirmNotFound {
+		t.Errorf("expect unknown confirm not found, got %v", err)
+	}
+	invalids := []CommandConfirmCallback{
+		callback("other", approve),
+		{ID: req.ID, CommandConfirmResult: CommandConfirmResult{Status: approve}, ExpiredAt: time.Now().Unix() - 1},
+		{ID: req.ID, CommandConfirmResult: CommandConfirmResult{Status: approve}, ExpiredAt: time.Now().Add(time.Hour).Unix()},
+	}
+	for _, invalid := range invalids {
+		if err = ResolveCommandConfirm(req.ID, invalid); !errors.Is(err, ErrInvalidConfirmCallback) {
+			t.Errorf("expect invalid callback %+v, got %v", invalid, err)
+		}
+	}
+	if err = ResolveCommandConfirm(req.ID, callback(req.ID, await)); err != nil {
+		t.Fatal(err)
+	}
+	if err = ResolveCommandConfirm(req.ID, callback(req.ID, await)); !errors.Is(err, ErrInvalidConfirmCallback) {
+		t.Errorf("expect reused callback rejected, got %v", err)
+	}
+	go ResolveCommandConfirm(req.ID, callback(req.ID, reject))
+	result, err := ticket.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != reject || result.Processor != "ops" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if err = ResolveCommandConfirm(req.ID, callback(req.ID, approve)); err != ErrConfirmNotFound {
+		t.Errorf("expect finished confirm removed, got %v", err)
+	}
+}
+
+func TestWebhookCommandConfirmer_Cancel(t *testing.T) {
+	hook := &fakeConfirmWebhook{}
+	srv := httptest.NewServer(hook)
+	defer srv.Close()
+	confirmer := newWebhookCommandConfirmer(commandConfirmSession{ID: "sid"}, srv.URL, "secret", "", time.Minute)
+
+	ticket, err := confirmer.Submit("rule-1", "reboot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = ticket.Wait(ctx); err != context.Canceled {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+	events := hook.Events()
+	if len(events) != 2 || events[1].Event != confirmEventCancel || events[1].ID != events[0].ID {
+		t.Errorf("unexpected events %+v", events)
+	}
+	if events[0].CallbackURL != "" {
+		t.Errorf("expect no callback url, got %s", events[0].CallbackURL)
+	}
+
+	confirmer.timeout = 10 * time.Millisecond
+	if ticket, err = confirmer.Submit("rule-1", "reboot"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ticket.Wait(context.Background()); err != errCommandConfirmTimeout {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+
+	confirmer.secret = "wrong"
+	if _, err = confirmer.Submit("rule-1", "reboot"); err == nil {
+		t.Error("expect error for unauthorized webhook")
+	}
+}
+
+func TestWebhookCommandConfirmer_Poll(t *testing.T) {
+	hook := &fakeConfirmWebhook{status: await}
+	srv := httptest.NewServer(hook)
+	defer srv.Close()
+	hook.statusURL = srv.URL + "/status"
+	confirmer := newWebhookCommandConfirmer(commandConfirmSession{ID: "sid"}, srv.URL, "secret", "", time.Minute)
+	ticket, err := confirmer.Submit("rule-1", "reboot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.(*webhookConfirmTicket).statusURL != hook.statusURL {
+		t.Fatalf("expect status url %s", hook.statusURL)
+	}
+	hook.Lock()
+	hook.status = approve
+	hook.Unlock()
+	result, err := ticket.(*webhookConfirmTicket).poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != approve || result.Processor != "bot" {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestLocalConfirmTicket(t *testing.T) {
+	confirmer := &localCommandConfirmer{timeout: time.Minute}
+	ticket, err := confirmer.Submit("rule-1", "reboot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := ticket.(terminalConfirmTicket)
+	input.HandleInput("ops(ops)", []byte("x"))
+	input.HandleInput("ops(ops)", []byte("Y"))
+	input.HandleInput("dev(dev)", []byte("n"))
+	result, err := ticket.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != approve || result.Processor != "ops(ops)" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	confirmer.timeout = 10 * time.Millisecond
+	ticket, _ = confirmer.Submit("rule-1", "reboot")
+	if _, err = ticket.Wait(context.Background()); err != errCommandConfirmTimeout {
+		t.Errorf("expect timeout, got %v", err)
+	}
+}
+
+func TestCheckCommandConfirmConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		conf config.Config
+		ok   bool
+	}{
+		{"core", config.Config{CommandBurstAction: burstActionWarn}, true},
+		{"burst confirm with core", config.Config{CommandBurstAction: burstActionConfirm}, false},
+		{"burst confirm with local", config.Config{CommandConfirmBackend: confirmBackendLocal,
+			CommandBurstAction: burstActionConfirm}, true},
+		{"burst confirm with webhook", config.Config{CommandConfirmBackend: confirmBackendWebhook,
+			CommandConfirmWebhookURL: "http://hook", CommandConfirmWebhookSecret: "secret",
+			CommandBurstAction: burstActionConfirm}, true},
+		{"webhook without secret", config.Config{CommandConfirmBackend: confirmBackendWebhook,
+			CommandConfirmWebhookURL: "http://hook"}, false},
+	}
+	for _, tt := range tests {
+		if err := CheckCommandConfirmConfig(tt.conf); (err == nil) != tt.ok {
+			t.Errorf("%s: unexpected result %v", tt.name, err)
+		}
+	}
+}
+
+func TestVerifyConfirmSignature(t *testing.T) {
+	body := []byte(`{"status":"approve"}`)
+	signature := signConfirmBody("secret", body)
+	if !verifyConfirmSignature("secret", body, signature) {
+		t.Error("expect valid signature")
+	}
+	if verifyConfirmSignature("secret", []byte(`{"status":"reject"}`), signature) {
+		t.Error("expect invalid signature for modified body")
+	}
+	if verifyConfirmSignature("", body, signConfirmBody("", body)) {
+		t.Error("expect invalid signature without secret")
+	}
+}
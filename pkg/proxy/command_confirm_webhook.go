@@ -0,0 +1,345 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/common"
+	"github.com/jumpserver/koko/pkg/config"
+	"github.com/jumpserver/koko/pkg/i18n"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	webhook 复核请求, event 为 submit, cancel (用户取消或会话结束) 或 timeout:
+	  {"event": "submit", "id": "...", "session_id": "...", "user": "...", "asset": "...", "system_user": "...",
+	   "rule_id": "...", "command": "...", "callback_url": "...", "expired_at": 1600000000}
+	请求头 X-Koko-Signature 为 sha256=hex(hmac_sha256(secret, body)), 必须设置 COMMAND_CONFIRM_WEBHOOK_SECRET。
+	submit 的响应可以返回 {"status_url": "..."}, 每隔 confirmPollInterval GET 该地址获取结果, 格式:
+	  {"status": "approve", "processor": "admin"}, status 为 approve, reject 或 await
+	回调 POST callback_url (COMMAND_CONFIRM_CALLBACK_URL/koko/api/confirms/{id}/), 使用同样的签名:
+	  {"id": "...", "status": "approve", "processor": "admin", "expired_at": 1600000060}
+	id 必须与地址中的一致, expired_at 为回调的过期时间, 最多为当前时间之后 confirmCallbackMaxAge;
+	过期或者重复使用的回调被拒绝。
+*/
+
+const (
+	CommandConfirmSignatureHeader = "X-Koko-Signature"
+
+	confirmCallbackPath = "/koko/api/confirms/%s/"
+	confirmPollInterval = 5 * time.Second
+
+	confirmCallbackMaxAge = 5 * time.Minute
+
+	confirmEventSubmit  = "submit"
+	confirmEventCancel  = "cancel"
+	confirmEventTimeout = "timeout"
+)
+
+var (
+	ErrInvalidConfirmSignature = errors.New("invalid command confirm signature")
+	ErrInvalidConfirmCallback  = errors.New("invalid command confirm callback")
+	ErrConfirmNotFound         = errors.New("command confirm not found")
+)
+
+// CommandConfirmCallback webhook 回调的内容, 签名包含 ID 和过期时间
+type CommandConfirmCallback struct {
+	ID string `json:"id"`
+	CommandConfirmResult
+	ExpiredAt int64 `json:"expired_at"`
+}
+
+type webhookConfirmRequest struct {
+	Event       string `json:"event"`
+	ID          string `json:"id"`
+	SessionID   string `json:"session_id"`
+	User        string `json:"user"`
+	Asset       string `json:"asset"`
+	SystemUser  string `json:"system_user"`
+	RuleID      string `json:"rule_id"`
+	Command     string `json:"command"`
+	CallbackURL string `json:"callback_url,omitempty"`
+	ExpiredAt   int64  `json:"expired_at"`
+}
+
+type webhookConfirmResponse struct {
+	StatusURL string `json:"status_url"`
+}
+
+type webhookCommandConfirmer struct {
+	session     commandConfirmSession
+	url         string
+	secret      string
+	callbackURL string
+	timeout     time.Duration
+	client      *http.Client
+}
+
+func newWebhookCommandConfirmer(session commandConfirmSession, url, secret,
+	callbackURL string, timeout time.Duration) *webhookCommandConfirmer {
+	return &webhookCommandConfirmer{
+		session:     session,
+		url:         url,
+		secret:      secret,
+		callbackURL: strings.TrimSuffix(callbackURL, "/"),
+		timeout:     timeout,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (c *webhookCommandConfirmer) Submit(ruleID, cmd string) (CommandConfirmTicket, error) {
+	req := webhookConfirmRequest{
+		Event:      confirmEventSubmit,
+		ID:         common.UUID(),
+		SessionID:  c.session.ID,
+		User:       c.session.User,
+		Asset:      c.session.Asset,
+		SystemUser: c.session.SystemUser,
+		RuleID:     ruleID,
+		Command:    cmd,
+		ExpiredAt:  time.Now().Add(c.timeout).Unix(),
+	}
+	if c.callbackURL != "" {
+		req.CallbackURL = c.callbackURL + fmt.Sprintf(confirmCallbackPath, req.ID)
+	}
+	ticket := &webhookConfirmTicket{
+		webhookCommandConfirmer: c,
+		req:                     req,
+		result:                  make(chan CommandConfirmResult, 1),
+	}
+	// 先注册再提交, 避免回调先于响应到达
+	webhookConfirmTickets.add(ticket)
+	var resp webhookConfirmResponse
+	if err := c.post(req, &resp); err != nil {
+		webhookConfirmTickets.remove(req.ID)
+		return nil, err
+	}
+	ticket.statusURL = resp.StatusURL
+	return ticket, nil
+}
+
+func (c *webhookCommandConfirmer) post(req webhookConfirmRequest, res interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	return c.do(httpReq, body, res)
+}
+
+func (c *webhookCommandConfirmer) do(req *http.Request, body []byte, res interface{}) error {
+	req.Header.Set(CommandConfirmSignatureHeader, signConfirmBody(c.secret, body))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("command confirm webhook %s response status %s", req.URL, resp.Status)
+	}
+	if res == nil {
+		return nil
+	}
+	// 响应可以为空
+	if err = json.NewDecoder(resp.Body).Decode(res); err != nil && err != io.EOF {
+		return fmt.Errorf("command confirm webhook %s response invalid: %w", req.URL, err)
+	}
+	return nil
+}
+
+type webhookConfirmTicket struct {
+	*webhookCommandConfirmer
+	req       webhookConfirmRequest
+	statusURL string
+	result    chan CommandConfirmResult
+}
+
+func (t *webhookConfirmTicket) Tips() []string {
+	return []string{
+		i18n.T("Need ticket confirm to execute command, already send to the reviewers"),
+		fmt.Sprintf(i18n.T("Confirm ID: %s"), t.req.ID),
+	}
+}
+
+func (t *webhookConfirmTicket) Wait(ctx context.Context) (CommandConfirmResult, error) {
+	defer webhookConfirmTickets.remove(t.req.ID)
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+	var pollC <-chan time.Time
+	if t.statusURL != "" {
+		ticker := time.NewTicker(confirmPollInterval)
+		defer ticker.Stop()
+		pollC = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			t.notify(confirmEventCancel)
+			return CommandConfirmResult{}, ctx.Err()
+		case <-timer.C:
+			t.notify(confirmEventTimeout)
+			return CommandConfirmResult{}, errCommandConfirmTimeout
+		case result := <-t.result:
+			return result, nil
+		case <-pollC:
+			result, err := t.poll()
+			if err != nil {
+				logger.Errorf("Session %s: check command confirm status err: %s", t.session.ID, err)
+				continue
+			}
+			switch result.Status {
+			case approve, reject:
+				return result, nil
+			}
+		}
+	}
+}
+
+func (t *webhookConfirmTicket) poll() (result CommandConfirmResult, err error) {
+	req, err := http.NewRequest(http.MethodGet, t.statusURL, nil)
+	if err != nil {
+		return
+	}
+	err = t.do(req, nil, &result)
+	return
+}
+
+// notify 通知 webhook 复核已取消或超时
+func (t *webhookConfirmTicket) notify(event string) {
+	req := t.req
+	req.Event = event
+	if err := t.post(req, nil); err != nil {
+		logger.Errorf("Session %s: notify command confirm %s err: %s", t.session.ID, event, err)
+	}
+}
+
+// resolve 回调的结果, await 忽略
+func (t *webhookConfirmTicket) resolve(result CommandConfirmResult) {
+	switch result.Status {
+	case approve, reject:
+	default:
+		return
+	}
+	select {
+	case t.result <- result:
+	default:
+	}
+}
+
+type confirmTicketRegistry struct {
+	sync.Mutex
+	tickets map[string]*webhookConfirmTicket
+	// 已使用的回调和过期时间
+	used map[string]int64
+}
+
+func (r *confirmTicketRegistry) add(t *webhookConfirmTicket) {
+	r.Lock()
+	defer r.Unlock()
+	r.tickets[t.req.ID] = t
+}
+
+func (r *confirmTicketRegistry) remove(id string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.tickets, id)
+}
+
+func (r *confirmTicketRegistry) get(id string) *webhookConfirmTicket {
+	r.Lock()
+	defer r.Unlock()
+	return r.tickets[id]
+}
+
+// use 记录回调, 已经使用过时返回 false; 过期的记录同时清理
+func (r *confirmTicketRegistry) use(callback CommandConfirmCallback, now int64) bool {
+	r.Lock()
+	defer r.Unlock()
+	for key, expiredAt := range r.used {
+		if expiredAt < now {
+			delete(r.used, key)
+		}
+	}
+	key := fmt.Sprintf("%s/%s/%s/%d", callback.ID, callback.Status, callback.Processor, callback.ExpiredAt)
+	if _, ok := r.used[key]; ok {
+		return false
+	}
+	r.used[key] = callback.ExpiredAt
+	return true
+}
+
+// webhookConfirmTickets 等待回调的复核
+var webhookConfirmTickets = confirmTicketRegistry{
+	tickets: make(map[string]*webhookConfirmTicket),
+	used:    make(map[string]int64),
+}
+
+// ResolveCommandConfirm 处理 webhook 的回调, 校验回调的 ID、过期时间以及是否重复使用
+func ResolveCommandConfirm(id string, callback CommandConfirmCallback) error {
+	now := time.Now()
+	switch {
+	case callback.ID != id:
+		return fmt.Errorf("%w: id mismatch", ErrInvalidConfirmCallback)
+	case callback.ExpiredAt <= now.Unix():
+		return fmt.Errorf("%w: expired", ErrInvalidConfirmCallback)
+	case callback.ExpiredAt > now.Add(confirmCallbackMaxAge).Unix():
+		return fmt.Errorf("%w: expired_at too far in the future", ErrInvalidConfirmCallback)
+	}
+	ticket := webhookConfirmTickets.get(id)
+	if ticket == nil {
+		return ErrConfirmNotFound
+	}
+	if !webhookConfirmTickets.use(callback, now.Unix()) {
+		return fmt.Errorf("%w: reused", ErrInvalidConfirmCallback)
+	}
+	logger.Infof("Session %s: command confirm %s %s by %s", ticket.session.ID, id,
+		callback.Status, callback.Processor)
+	ticket.resolve(callback.CommandConfirmResult)
+	return nil
+}
+
+// ReadCommandConfirmCallback 校验回调的签名, 没有设置密钥时拒绝所有回调
+func ReadCommandConfirmCallback(r *http.Request) (callback CommandConfirmCallback, err error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		return
+	}
+	secret := config.GetConf().CommandConfirmWebhookSecret
+	if !verifyConfirmSignature(secret, body, r.Header.Get(CommandConfirmSignatureHeader)) {
+		err = ErrInvalidConfirmSignature
+		return
+	}
+	err = json.Unmarshal(body, &callback)
+	return
+}
+
+func signConfirmBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyConfirmSignature(secret string, body []byte, signature string) bool {
+	if secret == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signConfirmBody(secret, body)), []byte(signature))
+}
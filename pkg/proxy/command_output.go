@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jumpserver/koko/pkg/common"
+	"github.com/jumpserver/koko/pkg/config"
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	命令输出的记录策略: 命令列表中的输入和输出按最大长度截断,
+	匹配 FULL_OUTPUT_RULES (命令过滤规则 ID) 或 FULL_OUTPUT_COMMANDS (正则) 的命令记录完整输出,
+	完整输出单独保存到录像存储 {date}/{sid}.output.{n}.gz, 命令的 output_ref 为该对象的路径。
+*/
+
+const (
+	defaultCommandInputMaxSize  = 128
+	defaultCommandOutputMaxSize = 1024
+	defaultFullOutputMaxSize    = 10 * 1024 * 1024
+)
+
+type OutputCapturePolicy struct {
+	InputMaxSize  int
+	OutputMaxSize int
+	// FullMaxSize 完整输出的最大长度, 超过部分丢弃
+	FullMaxSize int
+
+	ruleIDs  map[string]bool
+	patterns []*regexp.Regexp
+}
+
+func NewOutputCapturePolicy(inputMaxSize, outputMaxSize, fullMaxSize int,
+	ruleIDs, commands []string) (*OutputCapturePolicy, error) {
+	if inputMaxSize <= 0 {
+		inputMaxSize = defaultCommandInputMaxSize
+	}
+	if outputMaxSize <= 0 {
+		outputMaxSize = defaultCommandOutputMaxSize
+	}
+	if fullMaxSize < outputMaxSize {
+		fullMaxSize = outputMaxSize
+	}
+	p := OutputCapturePolicy{
+		InputMaxSize:  inputMaxSize,
+		OutputMaxSize: outputMaxSize,
+		FullMaxSize:   fullMaxSize,
+		ruleIDs:       make(map[string]bool, len(ruleIDs)),
+	}
+	for _, id := range ruleIDs {
+		if id = strings.TrimSpace(id); id != "" {
+			p.ruleIDs[id] = true
+		}
+	}
+	for _, item := range commands {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(item)
+		if err != nil {
+			return nil, fmt.Errorf("full output command %s invalid: %w", item, err)
+		}
+		p.patterns = append(p.patterns, pattern)
+	}
+	return &p, nil
+}
+
+// NeedFullCapture ruleID 为命令匹配到的过滤规则, 未匹配时为空
+func (p *OutputCapturePolicy) NeedFullCapture(command, ruleID string) bool {
+	if ruleID != "" && p.ruleIDs[ruleID] {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(command) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *OutputCapturePolicy) TruncateInput(input string) string {
+	return truncateString(input, p.InputMaxSize)
+}
+
+func (p *OutputCapturePolicy) TruncateOutput(output string) string {
+	return truncateString(output, p.OutputMaxSize)
+}
+
+// truncateString 按字节截断, 不截断多字节的 UTF-8 字符
+func truncateString(s string, maxSize int) string {
+	if len(s) <= maxSize {
+		return s
+	}
+	for maxSize > 0 && !utf8.RuneStart(s[maxSize]) {
+		maxSize--
+	}
+	return s[:maxSize]
+}
+
+var (
+	outputCapturePolicy     *OutputCapturePolicy
+	outputCapturePolicyOnce sync.Once
+)
+
+// GetOutputCapturePolicy 根据配置文件生成, 配置错误时不记录完整输出
+func GetOutputCapturePolicy() *OutputCapturePolicy {
+	outputCapturePolicyOnce.Do(func() {
+		conf := config.GetConf()
+		fullMaxSize := defaultFullOutputMaxSize
+		if conf.FullOutputMaxSize != "" {
+			fullMaxSize = common.ConvertSizeToBytes(conf.FullOutputMaxSize)
+		}
+		policy, err := NewOutputCapturePolicy(conf.CommandInputMaxSize, conf.CommandOutputMaxSize,
+			fullMaxSize, conf.FullOutputRules, conf.FullOutputCommands)
+		if err != nil {
+			logger.Errorf("Output capture policy err: %s", err)
+			policy, _ = NewOutputCapturePolicy(conf.CommandInputMaxSize, conf.CommandOutputMaxSize,
+				fullMaxSize, nil, nil)
+		}
+		outputCapturePolicy = policy
+	})
+	return outputCapturePolicy
+}
+
+// commandOutputStore 保存会话中命令的完整输出, 只支持对象存储
+type commandOutputStore struct {
+	sessionID string
+	date      string
+	dir       string
+	storage   ReplayStorage
+
+	recipient    ReplayRecipient
+	recipientErr error
+
+	count int
+	wg    sync.WaitGroup
+}
+
+func newCommandOutputStore(sessionID string, storage ReplayStorage) *commandOutputStore {
+	date := time.Now().UTC().Format("2006-01-02")
+	o := commandOutputStore{
+		sessionID: sessionID,
+		date:      date,
+		dir:       filepath.Join(config.GetConf().RootPath, "data", "replays", date),
+		storage:   storage,
+	}
+	if o.recipient, o.recipientErr = LoadReplayRecipient(); o.recipientErr != nil {
+		logger.Errorf("Session %s: load replay encrypt key err: %s", sessionID, o.recipientErr)
+	}
+	return &o
+}
+
+// Save 写入本地文件并设置 cmd.OutputRef, 后台上传成功后删除本地文件
+func (o *commandOutputStore) Save(cmd *model.Command, output string) {
+	if !IsObjectStorage(o.storage) {
+		logger.Infof("Session %s: storage %s not support full command output", o.sessionID,
+			o.storage.TypeName())
+		return
+	}
+	if o.recipientErr != nil {
+		logger.Errorf("Session %s: replay encrypt key invalid, skip full command output", o.sessionID)
+		return
+	}
+	o.count++
+	name := fmt.Sprintf("%s.output.%d.gz", o.sessionID, o.count)
+	path := filepath.Join(o.dir, name)
+	if err := writeCommandOutput(path, output, o.recipient); err != nil {
+		logger.Errorf("Session %s: write command output %s err: %s", o.sessionID, path, err)
+		_ = os.Remove(path)
+		return
+	}
+	target := strings.Join([]string{o.date, name}, "/")
+	cmd.OutputRef = target
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		if err := uploadWithRetry(o.storage, path, target, 3); err != nil {
+			logger.Errorf("Session %s: upload command output %s err: %s", o.sessionID, target, err)
+			return
+		}
+		_ = os.Remove(path)
+	}()
+}
+
+func (o *commandOutputStore) Wait() {
+	o.wg.Wait()
+}
+
+// writeCommandOutput gzip 压缩, 开启录像加密时再加密
+func writeCommandOutput(path, output string, recipient ReplayRecipient) error {
+	if err := common.EnsureDirExist(filepath.Dir(path)); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var w io.Writer = f
+	var encWriter io.WriteCloser
+	if recipient != nil {
+		if encWriter, err = NewReplayEncryptWriter(f, recipient); err != nil {
+			return err
+		}
+		w = encWriter
+	}
+	gzWriter := gzip.NewWriter(w)
+	if _, err = io.WriteString(gzWriter, output); err != nil {
+		return err
+	}
+	if err = gzWriter.Close(); err != nil {
+		return err
+	}
+	if encWriter != nil {
+		if err = encWriter.Close(); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+)
+
+func TestOutputCapturePolicy(t *testing.T) {
+	policy, err := NewOutputCapturePolicy(0, 8, 0, []string{"rule-1"}, []string{`^cat\s+/etc/sudoers`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.InputMaxSize != defaultCommandInputMaxSize || policy.FullMaxSize != 8 {
+		t.Fatalf("invalid default size: %+v", policy)
+	}
+	tests := []struct {
+		command string
+		ruleID  string
+		expect  bool
+	}{
+		{"cat /etc/sudoers", "", true},
+		{"ls /etc/sudoers", "", false},
+		{"ls", "rule-1", true},
+		{"ls", "rule-2", false},
+	}
+	for _, tt := range tests {
+		if got := policy.NeedFullCapture(tt.command, tt.ruleID); got != tt.expect {
+			t.Fatalf("%s %s expect %v, got %v", tt.command, tt.ruleID, tt.expect, got)
+		}
+	}
+	if got := policy.TruncateOutput("0123456789"); got != "01234567" {
+		t.Fatalf("invalid truncated output: %s", got)
+	}
+	// 中文每个字符 3 个字节, 不截断半个字符
+	if got := policy.TruncateOutput("ab中文字符"); got != "ab中文" {
+		t.Fatalf("invalid truncated utf8 output: %q", got)
+	}
+	if _, err = NewOutputCapturePolicy(0, 0, 0, nil, []string{"("}); err == nil {
+		t.Fatal("expect invalid regexp err")
+	}
+}
+
+func readCommandOutput(t *testing.T, path string, identity ReplayIdentity) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var gzReader *gzip.Reader
+	if identity != nil {
+		r, err := NewReplayDecryptReader(f, identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gzReader, err = gzip.NewReader(r)
+	} else {
+		gzReader, err = gzip.NewReader(f)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gzReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCommandOutputStore(t *testing.T) {
+	localDir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(localDir)
+	remoteDir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remoteDir)
+
+	sid := "9f1ba4a2-6ec4-4c4b-9a8c-0d4c1dd2a8b5"
+	output := strings.Repeat("root ALL=(ALL:ALL) ALL\r\n", 1000)
+	recipient, identity := newTestX25519Key(t)
+	for _, item := range []struct {
+		recipient ReplayRecipient
+		identity  ReplayIdentity
+	}{{nil, nil}, {recipient, identity}} {
+		store := &commandOutputStore{
+			sessionID: sid,
+			date:      "2021-06-01",
+			dir:       localDir,
+			storage:   localReplayStorage{dir: remoteDir},
+			recipient: item.recipient,
+		}
+		cmd := &model.Command{SessionID: sid, Input: "cat /etc/sudoers", Output: output[:1024]}
+		store.Save(cmd, output)
+		store.Wait()
+		if cmd.OutputRef != "2021-06-01/"+sid+".output.1.gz" {
+			t.Fatalf("invalid output ref: %s", cmd.OutputRef)
+		}
+		if files, _ := ioutil.ReadDir(localDir); len(files) != 0 {
+			t.Fatal("local output file should be removed after upload")
+		}
+		remotePath := filepath.Join(remoteDir, filepath.Base(cmd.OutputRef))
+		if got := readCommandOutput(t, remotePath, item.identity); got != output {
+			t.Fatalf("full output mismatch, got %d bytes", len(got))
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/viper"
+
+	"github.com/jumpserver/koko/pkg/config"
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	本地命令过滤策略, 由 COMMAND_FILTER_POLICY_FILE 指定 YAML 或 JSON 文件, 与 Core 下发的规则合并后按优先级排序:
+	rules:
+	  - id: deny-shutdown
+	    priority: 1
+	    type: command            # command 或 regex, 默认 command
+	    content: |
+	      shutdown
+	      reboot
+	    action: deny             # deny, allow, confirm, audit(只标记不拦截)
+	    assets: ["10.1.*", "prod-*"]
+	    system_users: ["root"]
+	    protocols: ["ssh", "telnet"]
+	    orgs: ["Default"]
+	  - id: deny-delete-without-where
+	    type: sql                # 只用于数据库会话, 按语句结构匹配, 条件见 sql_filter.go
+	    statements: [delete, update, drop table]
+	    tables: ["prod.*"]
+	    missing_where: true
+	    max_rows: 1000
+	    action: deny
+	  - id: deny-delete-prod-pods
+	    type: kubectl            # 只用于 k8s 会话, 按 kubectl 命令结构匹配, 条件见 kubectl_filter.go
+	    verbs: [delete, exec]
+	    resources: [pod, deploy]
+	    names: ["*"]
+	    namespaces: ["prod-*"]
+	    labels: ["tier=db"]
+	    flags: ["--force"]
+	    action: deny
+	redact_rules:                # 命令参数中凭证的替换规则, 追加到内置规则之后, 字段见 command_redact.go
+	  - commands: [vault]
+	    flags: ["-token"]
+	redact_patterns:
+	  - 'X-Api-Key:\s*(\S+)'
+	assets 匹配资产的主机名或 IP, 数据库和 k8s 应用匹配应用名称或地址;
+	system_users 匹配系统用户的用户名或名称; orgs 匹配组织 ID 或名称;
+	assets、system_users、orgs 支持通配符, 为空时匹配所有。
+	confirm 规则只能提交到 webhook 或 local 复核后端, Core 中没有本地规则, COMMAND_CONFIRM_BACKEND 为 core 时
+	包含 confirm 规则的策略加载失败。
+	收到 SIGHUP 信号时重新加载, 对之后新建的会话生效, 加载失败时保留之前的策略。
+*/
+
+const localFilterRulePrefix = "local:"
+
+var localFilterActions = map[string]model.RuleAction{
+	"deny":       model.ActionDeny,
+	"allow":      model.ActionAllow,
+	"confirm":    model.ActionConfirm,
+	"audit":      model.ActionAudit,
+	"audit-only": model.ActionAudit,
+	"warn":       model.ActionAudit,
+}
+
+type LocalFilterRule struct {
+	ID          string   `mapstructure:"id"`
+	Priority    int      `mapstructure:"priority"`
+	Type        string   `mapstructure:"type"`
+	Content     string   `mapstructure:"content"`
+	Action      string   `mapstructure:"action"`
+	Assets      []string `mapstructure:"assets"`
+	SystemUsers []string `mapstructure:"system_users"`
+	Protocols   []string `mapstructure:"protocols"`
+	Orgs        []string `mapstructure:"orgs"`
+
+	Statements   []string `mapstructure:"statements"`
+	Tables       []string `mapstructure:"tables"`
+	MissingWhere bool     `mapstructure:"missing_where"`
+	MaxRows      int      `mapstructure:"max_rows"`
+
+	Verbs      []string `mapstructure:"verbs"`
+	Resources  []string `mapstructure:"resources"`
+	Names      []string `mapstructure:"names"`
+	Namespaces []string `mapstructure:"namespaces"`
+	Labels     []string `mapstructure:"labels"`
+	Flags      []string `mapstructure:"flags"`
+
+	rule model.SystemUserFilterRule
+}
+
+func (r *LocalFilterRule) compile() error {
+	action, ok := localFilterActions[strings.ToLower(r.Action)]
+	if !ok {
+		return fmt.Errorf("invalid action %q", r.Action)
+	}
+	ruleType := strings.ToLower(r.Type)
+	switch ruleType {
+	case "":
+		ruleType = model.TypeCmd
+	case model.TypeCmd, model.TypeRegex, filterRuleTypeSQL, filterRuleTypeKubectl:
+	default:
+		return fmt.Errorf("invalid type %q", r.Type)
+	}
+	r.rule = model.SystemUserFilterRule{
+		ID:       localFilterRulePrefix + r.ID,
+		Priority: r.Priority,
+		Type:     ruleType,
+		Content:  r.Content,
+		Action:   action,
+	}
+	if ruleType == filterRuleTypeSQL {
+		if len(r.Statements) == 0 && len(r.Tables) == 0 && !r.MissingWhere && r.MaxRows <= 0 {
+			return errors.New("sql rule has no condition")
+		}
+		return nil
+	}
+	if ruleType == filterRuleTypeKubectl {
+		if len(r.Verbs) == 0 && len(r.Resources) == 0 && len(r.Names) == 0 &&
+			len(r.Namespaces) == 0 && len(r.Labels) == 0 && len(r.Flags) == 0 {
+			return errors.New("kubectl rule has no condition")
+		}
+		return nil
+	}
+	if strings.TrimSpace(r.Content) == "" {
+		return errors.New("content is empty")
+	}
+	if r.rule.Pattern() == nil {
+		return fmt.Errorf("invalid content %q", r.Content)
+	}
+	return nil
+}
+
+// Match 规则是否适用于连接的目标
+func (r *LocalFilterRule) Match(target FilterPolicyTarget) bool {
+	if len(r.Protocols) > 0 && !containsFold(r.Protocols, target.Protocol) {
+		return false
+	}
+	return matchAnyGlob(r.Assets, target.Assets) &&
+		matchAnyGlob(r.SystemUsers, target.SystemUsers) &&
+		matchAnyGlob(r.Orgs, target.Orgs)
+}
+
+type LocalFilterPolicy struct {
+	Rules []LocalFilterRule `mapstructure:"rules"`
+
+	// 命令参数中凭证的替换规则, 见 command_redact.go
+	RedactRules    []ArgRedactRule `mapstructure:"redact_rules"`
+	RedactPatterns []string        `mapstructure:"redact_patterns"`
+
+	redactor *ArgRedactor
+}
+
+// FilterPolicyTarget 会话连接的目标, 用于匹配本地策略
+type FilterPolicyTarget struct {
+	Assets      []string
+	SystemUsers []string
+	Protocol    string
+	Orgs        []string
+}
+
+func newFilterPolicyTarget(opts *ConnectionOptions) FilterPolicyTarget {
+	target := FilterPolicyTarget{Protocol: opts.ProtocolType}
+	if opts.systemUser != nil {
+		target.SystemUsers = []string{opts.systemUser.Username, opts.systemUser.Name}
+	}
+	switch {
+	case opts.asset != nil:
+		target.Assets = []string{opts.asset.Hostname, opts.asset.IP}
+		target.Orgs = []string{opts.asset.OrgID, opts.asset.OrgName}
+	case opts.dbApp != nil:
+		target.Assets = []string{opts.dbApp.Name, opts.dbApp.Attrs.Host}
+		target.Orgs = []string{opts.dbApp.OrgID, opts.dbApp.OrgName}
+	case opts.k8sApp != nil:
+		target.Assets = []string{opts.k8sApp.Name, opts.k8sApp.Attrs.Cluster}
+		target.Orgs = []string{opts.k8sApp.OrgID, opts.k8sApp.OrgName}
+	}
+	return target
+}
+
+// LoadLocalFilterPolicy 根据文件后缀解析 YAML 或 JSON
+func LoadLocalFilterPolicy(policyPath string) (*LocalFilterPolicy, error) {
+	v := viper.New()
+	v.SetConfigFile(policyPath)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var policy LocalFilterPolicy
+	if err := v.Unmarshal(&policy); err != nil {
+		return nil, err
+	}
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.ID == "" {
+			rule.ID = fmt.Sprintf("rule-%d", i+1)
+		}
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.ID, err)
+		}
+	}
+	redactor, err := newCommandRedactor(policy.RedactRules, policy.RedactPatterns)
+	if err != nil {
+		return nil, err
+	}
+	policy.redactor = redactor
+	return &policy, nil
+}
+
+// checkConfirmBackend Core 只能复核 Core 下发的规则, 本地的 confirm 规则需要 webhook 或 local 复核后端
+func (p *LocalFilterPolicy) checkConfirmBackend(backend string) error {
+	if backend != confirmBackendCore {
+		return nil
+	}
+	for i := range p.Rules {
+		if p.Rules[i].rule.Action == model.ActionConfirm {
+			return fmt.Errorf("rule %s: action confirm requires command confirm backend %s or %s",
+				p.Rules[i].ID, confirmBackendWebhook, confirmBackendLocal)
+		}
+	}
+	return nil
+}
+
+// FilterRules 返回适用于目标的命令和正则规则
+func (p *LocalFilterPolicy) FilterRules(target FilterPolicyTarget) []model.SystemUserFilterRule {
+	var rules []model.SystemUserFilterRule
+	for i := range p.Rules {
+		switch p.Rules[i].rule.Type {
+		case filterRuleTypeSQL, filterRuleTypeKubectl:
+			continue
+		}
+		if p.Rules[i].Match(target) {
+			rules = append(rules, p.Rules[i].rule)
+		}
+	}
+	return rules
+}
+
+// SQLFilterRules 返回适用于目标的 sql 规则
+func (p *LocalFilterPolicy) SQLFilterRules(target FilterPolicyTarget) []SQLFilterRule {
+	var rules []SQLFilterRule
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.rule.Type == filterRuleTypeSQL && rule.Match(target) {
+			rules = append(rules, SQLFilterRule{
+				SystemUserFilterRule: rule.rule,
+				Statements:           rule.Statements,
+				Tables:               rule.Tables,
+				MissingWhere:         rule.MissingWhere,
+				MaxRows:              rule.MaxRows,
+			})
+		}
+	}
+	return rules
+}
+
+// KubectlFilterRules 返回适用于目标的 kubectl 规则
+func (p *LocalFilterPolicy) KubectlFilterRules(target FilterPolicyTarget) []KubectlFilterRule {
+	var rules []KubectlFilterRule
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.rule.Type == filterRuleTypeKubectl && rule.Match(target) {
+			rules = append(rules, KubectlFilterRule{
+				SystemUserFilterRule: rule.rule,
+				Verbs:                rule.Verbs,
+				Resources:            rule.Resources,
+				Names:                rule.Names,
+				Namespaces:           rule.Namespaces,
+				Labels:               rule.Labels,
+				Flags:                rule.Flags,
+			})
+		}
+	}
+	return rules
+}
+
+var (
+	localFilterPolicy     *LocalFilterPolicy
+	localFilterPolicyLock sync.RWMutex
+)
+
+// InitialLocalFilterPolicy 加载本地命令过滤策略, 收到 SIGHUP 时重新加载
+func InitialLocalFilterPolicy() {
+	conf := config.GetConf()
+	policyPath := conf.CommandFilterPolicyFile
+	if policyPath == "" {
+		return
+	}
+	if !filepath.IsAbs(policyPath) {
+		policyPath = filepath.Join(conf.RootPath, policyPath)
+	}
+	reloadLocalFilterPolicy(policyPath)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	go func() {
+		for range sigChan {
+			reloadLocalFilterPolicy(policyPath)
+		}
+	}()
+}
+
+func reloadLocalFilterPolicy(policyPath string) {
+	policy, err := LoadLocalFilterPolicy(policyPath)
+	if err == nil {
+		err = policy.checkConfirmBackend(getCommandConfirmBackend(config.GetConf()))
+	}
+	if err != nil {
+		logger.Errorf("Load command filter policy %s err: %s", policyPath, err)
+		return
+	}
+	localFilterPolicyLock.Lock()
+	localFilterPolicy = policy
+	localFilterPolicyLock.Unlock()
+	logger.Infof("Load command filter policy %s: %d rules", policyPath, len(policy.Rules))
+}
+
+// GetLocalFilterRules 本地策略中适用于目标的规则, 没有配置时返回空
+func GetLocalFilterRules(target FilterPolicyTarget) []model.SystemUserFilterRule {
+	localFilterPolicyLock.RLock()
+	policy := localFilterPolicy
+	localFilterPolicyLock.RUnlock()
+	if policy == nil {
+		return nil
+	}
+	return policy.FilterRules(target)
+}
+
+// GetLocalSQLFilterRules 本地策略中适用于目标的 sql 规则
+func GetLocalSQLFilterRules(target FilterPolicyTarget) []SQLFilterRule {
+	localFilterPolicyLock.RLock()
+	policy := localFilterPolicy
+	localFilterPolicyLock.RUnlock()
+	if policy == nil {
+		return nil
+	}
+	return policy.SQLFilterRules(target)
+}
+
+// GetLocalKubectlFilterRules 本地策略中适用于目标的 kubectl 规则
+func GetLocalKubectlFilterRules(target FilterPolicyTarget) []KubectlFilterRule {
+	localFilterPolicyLock.RLock()
+	policy := localFilterPolicy
+	localFilterPolicyLock.RUnlock()
+	if policy == nil {
+		return nil
+	}
+	return policy.KubectlFilterRules(target)
+}
+
+// matchAnyGlob patterns 为空时匹配所有
+func matchAnyGlob(patterns, values []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if value == "" {
+				continue
+			}
+			if ok, _ := path.Match(pattern, value); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsFold(items []string, value string) bool {
+	for _, item := range items {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+)
+
+const testFilterPolicy = `
+rules:
+  - id: deny-rm
+    priority: 1
+    content: rm -rf
+    action: deny
+    assets: ["10.1.*", "prod-*"]
+    system_users: ["root"]
+  - id: audit-passwd
+    priority: 10
+    type: regex
+    content: passwd\s+\w+
+    action: audit-only
+    protocols: ["ssh"]
+  - content: reboot
+    action: confirm
+    orgs: ["Default"]
+  - id: deny-delete
+    type: sql
+    statements: [delete, update]
+    missing_where: true
+    action: deny
+    protocols: ["mysql"]
+  - id: deny-delete-prod
+    type: kubectl
+    verbs: [delete]
+    namespaces: ["prod-*"]
+    flags: ["--force"]
+    action: deny
+    protocols: ["k8s"]
+redact_rules:
+  - commands: [vault]
+    flags: ["-token"]
+`
+
+func writeFilterPolicy(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	policyPath := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(policyPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return policyPath
+}
+
+func TestLoadLocalFilterPolicy(t *testing.T) {
+	policy, err := LoadLocalFilterPolicy(writeFilterPolicy(t, "policy.yml", testFilterPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Rules) != 5 {
+		t.Fatalf("expect 5 rules, got %d", len(policy.Rules))
+	}
+	expects := []struct {
+		id     string
+		action model.RuleAction
+	}{
+		{"local:deny-rm", model.ActionDeny},
+		{"local:audit-passwd", model.ActionAudit},
+		{"local:rule-3", model.ActionConfirm},
+		{"local:deny-delete", model.ActionDeny},
+		{"local:deny-delete-prod", model.ActionDeny},
+	}
+	for i, expect := range expects {
+		rule := policy.Rules[i].rule
+		if rule.ID != expect.id || rule.Action != expect.action {
+			t.Errorf("rule %d: expect %s %d, got %s %d", i, expect.id, expect.action, rule.ID, rule.Action)
+		}
+	}
+
+	if got := policy.redactor.Redact("vault login -token=x"); got != "vault login -token=******" {
+		t.Errorf("unexpected redacted command %q", got)
+	}
+
+	invalids := []string{
+		"rules:\n  - content: ls\n    action: block\n",
+		"rules:\n  - content: ''\n    action: deny\n",
+		"rules:\n  - type: regex\n    content: '(ls'\n    action: deny\n",
+		"rules:\n  - type: sql\n    action: deny\n",
+		"rules:\n  - type: kubectl\n    action: deny\n",
+		"redact_patterns: ['(x']\n",
+	}
+	for _, content := range invalids {
+		if _, err = LoadLocalFilterPolicy(writeFilterPolicy(t, "policy.yml", content)); err == nil {
+			t.Errorf("expect error for %q", content)
+		}
+	}
+	if err = policy.checkConfirmBackend(confirmBackendCore); err == nil {
+		t.Error("expect confirm rule error for core backend")
+	}
+	if err = policy.checkConfirmBackend(confirmBackendLocal); err != nil {
+		t.Errorf("unexpected error for local backend: %s", err)
+	}
+}
+
+func TestLocalFilterPolicy_FilterRules(t *testing.T) {
+	policy, err := LoadLocalFilterPolicy(writeFilterPolicy(t, "policy.yml", testFilterPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target FilterPolicyTarget
+		expect []string
+	}{
+		{FilterPolicyTarget{Assets: []string{"web", "10.1.0.5"}, SystemUsers: []string{"root"},
+			Protocol: "ssh", Orgs: []string{"Default"}},
+			[]string{"local:deny-rm", "local:audit-passwd", "local:rule-3"}},
+		{FilterPolicyTarget{Assets: []string{"prod-db", "192.168.1.2"}, SystemUsers: []string{"web"},
+			Protocol: "telnet", Orgs: []string{"Other"}}, nil},
+		{FilterPolicyTarget{Assets: []string{"prod-db", ""}, SystemUsers: []string{"root"},
+			Protocol: "SSH"}, []string{"local:deny-rm", "local:audit-passwd"}},
+	}
+	for i, tt := range tests {
+		var got []string
+		for _, rule := range policy.FilterRules(tt.target) {
+			got = append(got, rule.ID)
+		}
+		if len(got) != len(tt.expect) {
+			t.Errorf("%d: expect %v, got %v", i, tt.expect, got)
+			continue
+		}
+		for j := range got {
+			if got[j] != tt.expect[j] {
+				t.Errorf("%d: expect %v, got %v", i, tt.expect, got)
+				break
+			}
+		}
+	}
+}
+
+func TestLocalFilterPolicy_SQLFilterRules(t *testing.T) {
+	policy, err := LoadLocalFilterPolicy(writeFilterPolicy(t, "policy.yml", testFilterPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := policy.SQLFilterRules(FilterPolicyTarget{Assets: []string{"db"}, Protocol: "mysql"})
+	if len(rules) != 1 || rules[0].ID != "local:deny-delete" || !rules[0].MissingWhere ||
+		len(rules[0].Statements) != 2 {
+		t.Fatalf("unexpected sql rules %+v", rules)
+	}
+	if rules = policy.SQLFilterRules(FilterPolicyTarget{Protocol: "ssh"}); len(rules) != 0 {
+		t.Fatalf("expect no sql rules for ssh, got %+v", rules)
+	}
+}
+
+func TestLocalFilterPolicy_KubectlFilterRules(t *testing.T) {
+	policy, err := LoadLocalFilterPolicy(writeFilterPolicy(t, "policy.yml", testFilterPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := policy.KubectlFilterRules(FilterPolicyTarget{Assets: []string{"cluster"}, Protocol: "k8s"})
+	if len(rules) != 1 || rules[0].ID != "local:deny-delete-prod" || len(rules[0].Verbs) != 1 ||
+		len(rules[0].Flags) != 1 {
+		t.Fatalf("unexpected kubectl rules %+v", rules)
+	}
+	if rules = policy.KubectlFilterRules(FilterPolicyTarget{Protocol: "mysql"}); len(rules) != 0 {
+		t.Fatalf("expect no kubectl rules for mysql, got %+v", rules)
+	}
+}
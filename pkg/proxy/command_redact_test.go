@@ -0,0 +1,48 @@
+package proxy
+
+import "testing"
+
+func TestArgRedactor_Redact(t *testing.T) {
+	redactor, err := newCommandRedactor([]ArgRedactRule{
+		{Commands: []string{"vault"}, Flags: []string{"-token"}},
+	}, []string{`X-Api-Key:\s*([^\s']+)`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{"mysql -uroot -pS3cret -h db", "mysql -uroot -p****** -h db"},
+		{"mysql -u root -p test", "mysql -u root -p test"},
+		{"mysqldump --password='a b' db", "mysqldump --password=****** db"},
+		{"curl -u admin:pass https://x", "curl -u admin:****** https://x"},
+		{"curl --user=admin:pass -H 'Authorization: Bearer abc.def' x",
+			"curl --user=admin:****** -H 'Authorization: Bearer ******' x"},
+		{"sshpass -p 'p@ss' ssh root@host", "sshpass -p ****** ssh root@host"},
+		{"htpasswd -bc /etc/htpasswd admin secret", "htpasswd -bc /etc/htpasswd admin ******"},
+		{"htpasswd -c /etc/htpasswd admin", "htpasswd -c /etc/htpasswd admin"},
+		{"docker login -u me -p pass registry", "docker login -u me -p ****** registry"},
+		{"docker run -p 80:80 nginx", "docker run -p 80:80 nginx"},
+		{"kubectl --token=abc get po", "kubectl --token=****** get po"},
+		{"kubectl create secret generic s --from-literal=password=x",
+			"kubectl create secret generic s --from-literal=password=******"},
+		{"export AWS_SECRET_ACCESS_KEY=abc PATH=/bin", "export AWS_SECRET_ACCESS_KEY=****** PATH=/bin"},
+		{"PGPASSWORD=x psql -h db && sudo -u app env API_KEY=y ./run",
+			"PGPASSWORD=****** psql -h db && sudo -u app env API_KEY=****** ./run"},
+		{"psql postgresql://app:pw@db/app", "psql postgresql://app:******@db/app"},
+		{"echo $(mysql -pX -e 'select 1')", "echo $(mysql -p****** -e 'select 1')"},
+		{"vault login -token=abc; curl -H 'X-Api-Key: k' x", "vault login -token=******; curl -H 'X-Api-Key: ******' x"},
+		{"openssl enc -pass pass:x -keyform PEM", "openssl enc -pass ****** -keyform PEM"},
+		{"ls -la", "ls -la"},
+	}
+	for _, tt := range tests {
+		if got := redactor.Redact(tt.input); got != tt.expect {
+			t.Errorf("%q:\nexpect %q\ngot    %q", tt.input, tt.expect, got)
+		}
+	}
+
+	if _, err = NewArgRedactor([]ArgRedactRule{{Commands: []string{"x"}}}, nil); err == nil {
+		t.Error("expect error for rule without flags")
+	}
+}
@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/common"
+	"github.com/jumpserver/koko/pkg/config"
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/service"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	命令本地缓存: 命令存储不可用时, 命令按行(JSON)追加写入 data/spool/commands 下的分段文件,
+	后台任务按分段顺序重新写入命令存储, 写入成功后删除分段。
+	缓存中还有命令时, 新的命令也追加到缓存中, 命令按记录的顺序写入存储, 并保留原来的时间戳。
+	{unix_nano}.spool      分段文件
+	{unix_nano}.spool.pos  分段已经写入存储的偏移量, 重启后从该位置继续
+*/
+
+const (
+	spoolSegmentExt      = ".spool"
+	spoolPosExt          = ".pos"
+	spoolSegmentMaxSize  = 4 * 1024 * 1024
+	spoolDrainBatchSize  = 100
+	spoolDrainMinBackoff = 5 * time.Second
+	spoolDrainMaxBackoff = 5 * time.Minute
+)
+
+var ErrCommandSpoolFull = errors.New("command spool is full")
+
+type CommandSpool struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	cur     *os.File
+	curSize int64
+
+	// size 所有分段文件的总大小
+	size   int64
+	notify chan struct{}
+}
+
+func NewCommandSpool(dir string, maxSize int64) (*CommandSpool, error) {
+	if err := common.EnsureDirExist(dir); err != nil {
+		return nil, err
+	}
+	s := &CommandSpool{
+		dir:     dir,
+		maxSize: maxSize,
+		notify:  make(chan struct{}, 1),
+	}
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range segments {
+		if info, err := os.Stat(path); err == nil {
+			s.size += info.Size()
+		}
+	}
+	return s, nil
+}
+
+// Size 本地缓存的分段文件总大小
+func (s *CommandSpool) Size() int64 {
+	return atomic.LoadInt64(&s.size)
+}
+
+// Append 追加命令并同步到磁盘, 超过最大缓存大小时返回 ErrCommandSpoolFull
+func (s *CommandSpool) Append(commands []*model.Command) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for i := range commands {
+		if err := encoder.Encode(commands[i]); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxSize > 0 && s.Size()+int64(buf.Len()) > s.maxSize {
+		return ErrCommandSpoolFull
+	}
+	if s.cur == nil {
+		name := strconv.FormatInt(time.Now().UnixNano(), 10) + spoolSegmentExt
+		f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		s.cur = f
+		s.curSize = 0
+	}
+	n, err := s.cur.Write(buf.Bytes())
+	s.curSize += int64(n)
+	atomic.AddInt64(&s.size, int64(n))
+	if err == nil {
+		err = s.cur.Sync()
+	}
+	if err != nil || s.curSize >= spoolSegmentMaxSize {
+		s.rollLocked()
+	}
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+func (s *CommandSpool) rollLocked() {
+	if s.cur != nil {
+		_ = s.cur.Close()
+		s.cur = nil
+	}
+}
+
+// segments 按创建时间排序的分段文件
+func (s *CommandSpool) segments() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]string, 0, len(files))
+	for i := range files {
+		if !files[i].IsDir() && strings.HasSuffix(files[i].Name(), spoolSegmentExt) {
+			segments = append(segments, filepath.Join(s.dir, files[i].Name()))
+		}
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// nextSegment 返回最早的分段, 如果是正在写入的分段则先结束该分段
+func (s *CommandSpool) nextSegment() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	segments, err := s.segments()
+	if err != nil || len(segments) == 0 {
+		return "", err
+	}
+	if s.cur != nil && s.cur.Name() == segments[0] {
+		s.rollLocked()
+	}
+	return segments[0], nil
+}
+
+// Drain 将所有已缓存的命令写入存储, 遇到错误立即返回
+func (s *CommandSpool) Drain(storage CommandStorage) (int, error) {
+	total := 0
+	for {
+		path, err := s.nextSegment()
+		if err != nil || path == "" {
+			return total, err
+		}
+		n, err := s.drainSegment(path, storage)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+func (s *CommandSpool) drainSegment(path string, storage CommandStorage) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	posPath := path + spoolPosExt
+	var offset int64
+	if data, err := ioutil.ReadFile(posPath); err == nil {
+		offset, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(f)
+	batch := make([]*model.Command, 0, spoolDrainBatchSize)
+	batchSize := int64(0)
+	total := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := storage.BulkSave(batch); err != nil {
+			return err
+		}
+		offset += batchSize
+		total += len(batch)
+		batch = batch[:0]
+		batchSize = 0
+		return ioutil.WriteFile(posPath, []byte(strconv.FormatInt(offset, 10)), 0600)
+	}
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			batchSize += int64(len(line))
+			var cmd model.Command
+			if err = json.Unmarshal(line, &cmd); err != nil {
+				logger.Errorf("Command spool %s skip invalid line: %s", path, err)
+			} else {
+				batch = append(batch, &cmd)
+			}
+			if len(batch) >= spoolDrainBatchSize {
+				if err = flush(); err != nil {
+					return total, err
+				}
+			}
+		}
+		if readErr != nil {
+			// 不完整的最后一行是异常退出时写入失败的数据, 直接丢弃
+			if readErr != io.EOF {
+				return total, readErr
+			}
+			break
+		}
+	}
+	if err = flush(); err != nil {
+		return total, err
+	}
+	_ = os.Remove(posPath)
+	if err = os.Remove(path); err != nil {
+		return total, err
+	}
+	atomic.AddInt64(&s.size, -info.Size())
+	return total, nil
+}
+
+// run 有缓存的命令时持续写入存储, 失败后按指数退避重试
+func (s *CommandSpool) run(getStorage func() (CommandStorage, error)) {
+	backoff := spoolDrainMinBackoff
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	for {
+		for s.Size() > 0 {
+			n, err := s.drainOnce(getStorage)
+			if err == nil {
+				backoff = spoolDrainMinBackoff
+				if n > 0 {
+					logger.Infof("Command spool drained %d commands, remain %d bytes", n, s.Size())
+				}
+				break
+			}
+			logger.Errorf("Command spool drain err: %s, retry after %s", err, backoff)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > spoolDrainMaxBackoff {
+				backoff = spoolDrainMaxBackoff
+			}
+		}
+		select {
+		case <-s.notify:
+		case <-tick.C:
+		}
+	}
+}
+
+func (s *CommandSpool) drainOnce(getStorage func() (CommandStorage, error)) (int, error) {
+	storage, err := getStorage()
+	if err != nil {
+		return 0, err
+	}
+	return s.Drain(storage)
+}
+
+var commandSpool *CommandSpool
+
+// InitialCommandSpool 创建命令缓存并启动后台写入任务
+func InitialCommandSpool(jmsService *service.JMService) {
+	conf := config.GetConf()
+	if !conf.EnableCommandSpool {
+		return
+	}
+	var maxSize int64
+	if conf.CommandSpoolMaxSize != "" {
+		maxSize = int64(common.ConvertSizeToBytes(conf.CommandSpoolMaxSize))
+	}
+	dir := filepath.Join(conf.DataFolderPath, "spool", "commands")
+	spool, err := NewCommandSpool(dir, maxSize)
+	if err != nil {
+		logger.Errorf("Create command spool %s err: %s", dir, err)
+		return
+	}
+	commandSpool = spool
+	logger.Infof("Command spool %s, remain %d bytes", dir, spool.Size())
+	go spool.run(func() (CommandStorage, error) {
+		terminalConf, err := jmsService.GetTerminalConfig()
+		if err != nil {
+			return nil, fmt.Errorf("get terminal config: %w", err)
+		}
+		return NewCommandStorage(jmsService, &terminalConf), nil
+	})
+}
+
+// GetCommandSpoolSize 心跳上报本地缓存的命令大小
+func GetCommandSpoolSize() int64 {
+	if commandSpool == nil {
+		return 0
+	}
+	return commandSpool.Size()
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+)
+
+type fakeCommandStorage struct {
+	commands []*model.Command
+	err      error
+}
+
+func (f *fakeCommandStorage) BulkSave(commands []*model.Command) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.commands = append(f.commands, commands...)
+	return nil
+}
+
+func (f *fakeCommandStorage) TypeName() string {
+	return "fake"
+}
+
+func testSpoolCommands(n int) []*model.Command {
+	commands := make([]*model.Command, 0, n)
+	for i := 0; i < n; i++ {
+		commands = append(commands, &model.Command{
+			SessionID: "sid", Input: "echo " + strconv.Itoa(i), Timestamp: int64(i)})
+	}
+	return commands
+}
+
+func TestCommandSpool_AppendDrain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool, err := NewCommandSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = spool.Append(testSpoolCommands(250)); err != nil {
+		t.Fatal(err)
+	}
+	if spool.Size() == 0 {
+		t.Fatal("spool size should not be 0")
+	}
+
+	failed := &fakeCommandStorage{err: errors.New("storage unavailable")}
+	if _, err = spool.Drain(failed); err == nil {
+		t.Fatal("expect drain err")
+	}
+
+	storage := &fakeCommandStorage{}
+	n, err := spool.Drain(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 250 || len(storage.commands) != 250 {
+		t.Fatalf("expect 250 commands, got %d", len(storage.commands))
+	}
+	for i := range storage.commands {
+		if storage.commands[i].Timestamp != int64(i) {
+			t.Fatalf("command %d out of order", i)
+		}
+	}
+	if spool.Size() != 0 {
+		t.Fatalf("expect empty spool, got %d bytes", spool.Size())
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("expect spool dir empty, got %d files", len(files))
+	}
+}
+
+func TestCommandSpool_Full(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool, err := NewCommandSpool(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = spool.Append(testSpoolCommands(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err = spool.Append(testSpoolCommands(100)); err != ErrCommandSpoolFull {
+		t.Fatalf("expect ErrCommandSpoolFull, got %v", err)
+	}
+}
+
+func TestCommandSpool_Resume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool, err := NewCommandSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = spool.Append(testSpoolCommands(150)); err != nil {
+		t.Fatal(err)
+	}
+	spool.rollLocked()
+	segments, err := spool.segments()
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("expect 1 segment, got %d %v", len(segments), err)
+	}
+	// 模拟写入第一批之后进程退出, 且最后一行没有写完整
+	data, _ := ioutil.ReadFile(segments[0])
+	offset := 0
+	for i := 0; i < spoolDrainBatchSize; i++ {
+		for data[offset] != '\n' {
+			offset++
+		}
+		offset++
+	}
+	posPath := segments[0] + spoolPosExt
+	if err = ioutil.WriteFile(posPath, []byte(strconv.Itoa(offset)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0600)
+	_, _ = f.WriteString(`{"session":"sid","input":"broken`)
+	_ = f.Close()
+
+	spool, err = NewCommandSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := &fakeCommandStorage{}
+	if _, err = spool.Drain(storage); err != nil {
+		t.Fatal(err)
+	}
+	if len(storage.commands) != 50 {
+		t.Fatalf("expect 50 commands, got %d", len(storage.commands))
+	}
+	if storage.commands[0].Timestamp != int64(spoolDrainBatchSize) {
+		t.Fatalf("expect resume from %d, got %d", spoolDrainBatchSize, storage.commands[0].Timestamp)
+	}
+	if _, err = os.Stat(filepath.Join(dir, filepath.Base(posPath))); !os.IsNotExist(err) {
+		t.Fatal("pos file should be removed")
+	}
+}
@@ -3,6 +3,7 @@ package proxy
 import (
 	"bytes"
 	"fmt"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -11,6 +12,7 @@ import (
 	"github.com/jumpserver/koko/pkg/i18n"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
 	"github.com/jumpserver/koko/pkg/logger"
+	"github.com/jumpserver/koko/pkg/srvconn"
 	"github.com/jumpserver/koko/pkg/utils"
 )
 
@@ -21,8 +23,53 @@ const (
 
 var _ ParseEngine = (*DBParser)(nil)
 
+// dbPrompt 数据库客户端的主提示符和多行语句的续行提示符
+type dbPrompt struct {
+	primary      *regexp.Regexp
+	continuation *regexp.Regexp
+}
+
+func (d dbPrompt) IsPrimary(line string) bool {
+	return d.primary != nil && d.primary.MatchString(line)
+}
+
+func (d dbPrompt) IsContinuation(line string) bool {
+	return d.continuation != nil && d.continuation.MatchString(line)
+}
+
+var (
+	// mysql> 或 MariaDB [db]>, 续行为 -> 或者未结束的引号、注释 '> "> `> /*>
+	mysqlPrompt = dbPrompt{
+		primary:      regexp.MustCompile(`^(mysql|MariaDB \[[^\]]*\])>\s*$`),
+		continuation: regexp.MustCompile("^\\s*(->|'>|\">|`>|/\\*>)\\s*$"),
+	}
+	// psql 默认提示符 %/%R%x%#: 数据库名, 状态(= 开始, ^ 单行模式, ! 断开连接; - 续行, 未结束的引号、括号、注释 ' " $ ( *),
+	// 事务状态(* ! ?), 超级用户为 #, 其他为 >; 如 postgres=> postgres=# postgres-# postgres=*#
+	psqlPrompt = dbPrompt{
+		primary:      regexp.MustCompile(`^\S*[=^!][*!?]?[#>]\s*$`),
+		continuation: regexp.MustCompile(`^\S*[-'"$(*][*!?]?[#>]\s*$`),
+	}
+	// mongosh 提示符为数据库名, 副本集和 Atlas 等在前面加上部署信息, 如 test> 或 rs0 [direct: primary] test>,
+	// 续行为 ...
+	mongoshPrompt = dbPrompt{
+		primary:      regexp.MustCompile(`^([\w.-]+ )*(\[[^\]]*\] )?[^\s\[\]>]+>\s*$`),
+		continuation: regexp.MustCompile(`^\.\.\.\s*$`),
+	}
+	// sqlcmd 提示符为批处理中的行号, 如 1> 2>
+	sqlcmdPrompt = dbPrompt{
+		primary:      regexp.MustCompile(`^1>\s*$`),
+		continuation: regexp.MustCompile(`^\d+>\s*$`),
+	}
+	// sqlplus 主提示符为 SQL>, 续行提示符为行号, 如 "  2  "
+	sqlplusPrompt = dbPrompt{
+		primary:      regexp.MustCompile(`^SQL>\s*$`),
+		continuation: regexp.MustCompile(`^\s*\d+\s*$`),
+	}
+)
+
 type DBParser struct {
-	id string
+	id       string
+	protocol string
 
 	userOutputChan chan []byte
 	srvOutputChan  chan []byte
@@ -39,9 +86,16 @@ type DBParser struct {
 	cmdCreateDate   time.Time
 	cmdInputParser  *CmdParser
 	cmdOutputParser *CmdParser
+	// sqlBuffer 缓存多行输入, 语句结束后作为一条命令
+	sqlBuffer  *sqlStatementBuffer
+	inputLines []string
+	prompt     dbPrompt
 
-	cmdFilterRules []model.SystemUserFilterRule
-	closed         chan struct{}
+	cmdFilterRules []SQLFilterRule
+	// matchedRule 当前命令匹配的过滤规则, 记录到命令中
+	matchedRule model.SystemUserFilterRule
+	auditBanner string
+	closed      chan struct{}
 
 	currentUser CurrentActiveUser
 }
@@ -52,6 +106,24 @@ func (p *DBParser) initial() {
 
 	p.cmdInputParser = NewCmdParser(p.id, DBInputParserName)
 	p.cmdOutputParser = NewCmdParser(p.id, DBOutputParserName)
+	p.cmdOutputParser.SetMaxSize(GetOutputCapturePolicy().OutputMaxSize)
+	switch p.protocol {
+	case srvconn.ProtocolPostgreSQL:
+		p.sqlBuffer = newPostgreSQLStatementBuffer()
+		p.prompt = psqlPrompt
+	case srvconn.ProtocolMongoDB:
+		p.sqlBuffer = newMongoDBStatementBuffer()
+		p.prompt = mongoshPrompt
+	case srvconn.ProtocolSQLServer:
+		p.sqlBuffer = newSQLServerStatementBuffer()
+		p.prompt = sqlcmdPrompt
+	case srvconn.ProtocolOracle:
+		p.sqlBuffer = newOracleStatementBuffer()
+		p.prompt = sqlplusPrompt
+	default:
+		p.sqlBuffer = newSQLStatementBuffer()
+		p.prompt = mysqlPrompt
+	}
 
 	p.closed = make(chan struct{})
 	p.cmdRecordChan = make(chan *ExecutedCommand, 1024)
@@ -113,46 +185,115 @@ func (p *DBParser) ParseStream(userInChan chan *exchange.RoomMessage, srvInChan
 // parseInputState 切换用户输入状态, 并结算命令和结果
 func (p *DBParser) parseInputState(b []byte) []byte {
 	p.inputPreState = p.inputState
+	if bytes.IndexByte(b, utils.CharCtrlC) >= 0 {
+		// Ctrl+C 清空 mysql 客户端中未结束的语句
+		p.sqlBuffer.Reset()
+		p.inputLines = nil
+	}
 	if bytes.LastIndex(b, charEnter) == 0 {
 		// 连续输入enter key, 结算上一条可能存在的命令结果
 		p.sendCommandRecord()
 		p.inputState = false
-		// 用户输入了Enter，开始结算命令
-		p.parseCmdInput()
-		if cmd, ok := p.IsCommandForbidden(); !ok {
-			fbdMsg := utils.WrapperWarn(fmt.Sprintf(i18n.T("Command `%s` is forbidden"), cmd))
-			_, _ = p.cmdOutputParser.WriteData([]byte(fbdMsg))
-			p.srvOutputChan <- []byte("\r\n" + fbdMsg)
-			p.cmdRecordChan <- &ExecutedCommand{
-				Command:     p.command,
-				Output:      fbdMsg,
-				CreatedDate: p.cmdCreateDate,
-				RiskLevel:   model.HighRiskFlag,
-				User:        p.currentUser,
+		// 用户输入了Enter，语句结束时开始结算命令
+		stmts := p.parseCmdInput()
+		if len(stmts) == 0 {
+			return b
+		}
+		rule, cmd, ok := p.IsMatchCommandRule(stmts)
+		p.matchedRule = rule
+		if !ok {
+			return b
+		}
+		switch rule.Action {
+		case model.ActionDeny:
+			cleanup := p.sqlBuffer.ClearInput(strings.Contains(p.command, "\n"))
+			p.forbiddenCommand(cmd)
+			return cleanup
+		case model.ActionAudit:
+			logger.Infof("DB Session %s: command `%s` matched audit rule %s", p.id, RedactCommand(cmd), rule.ID)
+			if p.auditBanner != "" {
+				p.srvOutputChan <- []byte("\r\n" + utils.WrapperWarn(p.auditBanner))
 			}
-			p.command = ""
-			p.output = ""
-			return []byte{utils.CharCleanLine, '\r'}
 		}
 	} else {
 		p.inputState = true
 		// 用户又开始输入，并上次不处于输入状态，开始结算上次命令的结果
 		if !p.inputPreState {
+			p.syncPrompt()
 			p.sendCommandRecord()
 		}
 	}
 	return b
 }
 
-// parseCmdInput 解析命令的输入
-func (p *DBParser) parseCmdInput() {
-	commands := p.cmdInputParser.Parse()
-	if len(commands) <= 0 {
-		p.command = ""
-	} else {
-		p.command = commands[len(commands)-1]
+func (p *DBParser) forbiddenCommand(cmd string) {
+	fbdMsg := utils.WrapperWarn(fmt.Sprintf(i18n.T("Command `%s` is forbidden"), cmd))
+	_, _ = p.cmdOutputParser.WriteData([]byte(fbdMsg))
+	p.srvOutputChan <- []byte("\r\n" + fbdMsg)
+	p.cmdRecordChan <- &ExecutedCommand{
+		Command:     p.command,
+		Output:      fbdMsg,
+		CreatedDate: p.cmdCreateDate,
+		RiskLevel:   model.HighRiskFlag,
+		RuleID:      p.matchedRule.ID,
+		User:        p.currentUser,
+	}
+	p.command = ""
+	p.output = ""
+	p.matchedRule = model.SystemUserFilterRule{}
+	p.sqlBuffer.Reset()
+	p.inputLines = nil
+}
+
+// parseCmdInput 解析命令的输入, 语句结束时返回语句的分析结果
+func (p *DBParser) parseCmdInput() []*SQLStatement {
+	var line string
+	if commands := p.cmdInputParser.Parse(); len(commands) > 0 {
+		line = commands[len(commands)-1]
+	}
+	// 丢弃上一行之后的输出, 如多行输入时的续行提示符
+	p.cmdOutputParser.Parse()
+	if line == "" && !p.sqlBuffer.Pending() {
+		return nil
+	}
+	p.inputLines = append(p.inputLines, line)
+	texts := p.sqlBuffer.Append(line)
+	if len(texts) == 0 {
+		if !p.sqlBuffer.Pending() {
+			// \c 清空了语句
+			p.inputLines = nil
+		}
+		return nil
+	}
+	p.command = strings.Join(p.inputLines, "\n")
+	p.inputLines = nil
+	if p.sqlBuffer.Pending() {
+		p.inputLines = []string{p.sqlBuffer.pending}
 	}
 	p.cmdCreateDate = time.Now()
+	stmts := make([]*SQLStatement, 0, len(texts))
+	for _, text := range texts {
+		stmts = append(stmts, p.sqlBuffer.Analyze(text))
+	}
+	return stmts
+}
+
+// syncPrompt 用户开始输入时, 根据输出的最后一行识别客户端的提示符, 去除命令和输出中的提示符;
+// 主提示符说明客户端中没有未结束的语句
+func (p *DBParser) syncPrompt() {
+	line := p.cmdOutputParser.LastLine()
+	switch {
+	case p.prompt.IsPrimary(line):
+		if p.sqlBuffer.Pending() {
+			p.sqlBuffer.Reset()
+			p.inputLines = nil
+		}
+	case p.prompt.IsContinuation(line):
+	default:
+		return
+	}
+	p.cmdInputParser.SetPs1(line)
+	p.cmdOutputParser.SetPs1(line)
 }
 
 // parseCmdOutput 解析命令输出
@@ -191,20 +332,9 @@ func (p *DBParser) ParseServerOutput(b []byte) []byte {
 	return b
 }
 
-// IsCommandForbidden 判断命令是不是在过滤规则中
-func (p *DBParser) IsCommandForbidden() (string, bool) {
-	for _, rule := range p.cmdFilterRules {
-		allowed, cmd := rule.Match(p.command)
-		switch allowed {
-		case model.ActionAllow:
-			return "", true
-		case model.ActionDeny:
-			return cmd, false
-		default:
-
-		}
-	}
-	return "", true
+// IsMatchCommandRule 判断语句是不是在过滤规则中
+func (p *DBParser) IsMatchCommandRule(stmts []*SQLStatement) (model.SystemUserFilterRule, string, bool) {
+	return matchSQLFilterRules(p.cmdFilterRules, stmts)
 }
 
 // Close 关闭parser
@@ -220,18 +350,27 @@ func (p *DBParser) Close() {
 	logger.Infof("DB Session %s: Parser close", p.id)
 }
 
+// Resize 数据库命令不依赖屏幕内容
+func (p *DBParser) Resize(int, int) {}
+
 func (p *DBParser) sendCommandRecord() {
 	if p.command != "" {
 		p.parseCmdOutput()
+		riskLevel := model.LessRiskFlag
+		if p.matchedRule.Action == model.ActionAudit {
+			riskLevel = model.WarnRiskFlag
+		}
 		p.cmdRecordChan <- &ExecutedCommand{
 			Command:     p.command,
 			Output:      p.output,
 			CreatedDate: p.cmdCreateDate,
-			RiskLevel:   model.LessRiskFlag,
+			RiskLevel:   riskLevel,
+			RuleID:      p.matchedRule.ID,
 			User:        p.currentUser,
 		}
 		p.command = ""
 		p.output = ""
+		p.matchedRule = model.SystemUserFilterRule{}
 	}
 }
 
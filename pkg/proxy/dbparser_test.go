@@ -0,0 +1,50 @@
+package proxy
+
+import "testing"
+
+func TestDBPrompt(t *testing.T) {
+	tests := []struct {
+		prompt dbPrompt
+		line   string
+		expect string
+	}{
+		{mysqlPrompt, "mysql> ", "primary"},
+		{mysqlPrompt, "MariaDB [test]> ", "primary"},
+		{mysqlPrompt, "    -> ", "continuation"},
+		{mysqlPrompt, "    '> ", "continuation"},
+		{mysqlPrompt, "| mysql> |", ""},
+		{psqlPrompt, "postgres=> ", "primary"},
+		{psqlPrompt, "postgres=# ", "primary"},
+		{psqlPrompt, "postgres=*# ", "primary"},
+		{psqlPrompt, "postgres-# ", "continuation"},
+		{psqlPrompt, "postgres(# ", "continuation"},
+		{psqlPrompt, "postgres$> ", "continuation"},
+		{psqlPrompt, "(1 row)", ""},
+		{psqlPrompt, " id | name", ""},
+		{mongoshPrompt, "test> ", "primary"},
+		{mongoshPrompt, "rs0 [direct: primary] admin> ", "primary"},
+		{mongoshPrompt, "Atlas atlas-x1-shard-0 [primary] test> ", "primary"},
+		{mongoshPrompt, "... ", "continuation"},
+		{mongoshPrompt, "{ acknowledged: true, deletedCount: 1 }", ""},
+		{mongoshPrompt, "switched to db prod", ""},
+		{sqlcmdPrompt, "1> ", "primary"},
+		{sqlcmdPrompt, "12> ", "continuation"},
+		{sqlcmdPrompt, "(1 rows affected)", ""},
+		{sqlplusPrompt, "SQL> ", "primary"},
+		{sqlplusPrompt, "  2  ", "continuation"},
+		{sqlplusPrompt, "Table dropped.", ""},
+	}
+	for _, tt := range tests {
+		// 与 syncPrompt 一致, 先判断主提示符
+		got := ""
+		switch {
+		case tt.prompt.IsPrimary(tt.line):
+			got = "primary"
+		case tt.prompt.IsContinuation(tt.line):
+			got = "continuation"
+		}
+		if got != tt.expect {
+			t.Errorf("%q: expect %q, got %q", tt.line, tt.expect, got)
+		}
+	}
+}
@@ -14,4 +14,6 @@ type ParseEngine interface {
 	CommandRecordChan() chan *ExecutedCommand
 
 	RegisterEventCallback(event string, f func())
+
+	Resize(width, height int)
 }
@@ -0,0 +1,345 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	k8s 会话的命令过滤, 命令和正则规则匹配命令文本, kubectl 规则按 kubectl 命令的结构匹配:
+	  verbs: 如 delete、exec、rollout restart
+	  resources: 资源类型, 支持简称, 如 po、deploy; 命令中的 all 匹配所有类型
+	  names: 资源名称通配符, 命令没有指定名称(--all、-l)时匹配
+	  namespaces: namespace 通配符, -A 匹配所有, 操作 namespace 资源时名称也作为 namespace
+	  labels: key 或 key=value(value 支持通配符), 匹配 -l 参数, 以及从集群查询的 pod 或工作负载模版的标签;
+	          无法确定标签(查询失败、没有名称和 -l)时, 非允许的规则视为匹配
+	  flags: 如 --force、--grace-period=0、-A, 需要全部出现
+	条件之间为且的关系, 至少需要一个条件。
+*/
+
+const filterRuleTypeKubectl = "kubectl"
+
+type KubectlFilterRule struct {
+	model.SystemUserFilterRule
+
+	Verbs      []string
+	Resources  []string
+	Names      []string
+	Namespaces []string
+	Labels     []string
+	Flags      []string
+}
+
+// MatchKubectl 返回匹配的动作和匹配的内容, cmd 为 nil 时只匹配命令和正则规则
+func (r *KubectlFilterRule) MatchKubectl(candidate kubectlCandidate, labels kubeLabelResolver) (model.RuleAction, string) {
+	if r.Type != filterRuleTypeKubectl {
+		return r.Match(candidate.text)
+	}
+	cmd := candidate.kubectl
+	if cmd == nil {
+		return model.ActionUnknown, ""
+	}
+	if len(r.Verbs) > 0 && !r.matchVerbs(cmd) ||
+		len(r.Resources) > 0 && !r.matchResources(cmd) ||
+		len(r.Names) > 0 && len(cmd.Names) > 0 && !matchAnyGlob(r.Names, cmd.Names) ||
+		len(r.Namespaces) > 0 && !r.matchNamespaces(cmd) ||
+		len(r.Flags) > 0 && !r.matchFlags(cmd) ||
+		len(r.Labels) > 0 && !r.matchLabels(cmd, labels) {
+		return model.ActionUnknown, ""
+	}
+	return r.Action, candidate.text
+}
+
+func (r *KubectlFilterRule) matchVerbs(cmd *KubectlCommand) bool {
+	for _, verb := range r.Verbs {
+		verb = strings.ToLower(strings.Join(strings.Fields(verb), " "))
+		if verb == cmd.Verb || cmd.Subcommand != "" && verb == cmd.Verb+" "+cmd.Subcommand {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *KubectlFilterRule) matchResources(cmd *KubectlCommand) bool {
+	for _, resource := range r.Resources {
+		resource = normalizeKubeResource(resource)
+		for _, item := range cmd.Resources {
+			if item == resource || item == "all" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *KubectlFilterRule) matchNamespaces(cmd *KubectlCommand) bool {
+	if cmd.AllNamespaces {
+		return true
+	}
+	namespaces := []string{cmd.Namespace}
+	for _, resource := range cmd.Resources {
+		if resource == "namespace" {
+			if len(cmd.Names) == 0 {
+				return true
+			}
+			namespaces = append(namespaces, cmd.Names...)
+		}
+	}
+	return matchAnyGlob(r.Namespaces, namespaces)
+}
+
+func (r *KubectlFilterRule) matchFlags(cmd *KubectlCommand) bool {
+	for _, flag := range r.Flags {
+		name, expect := flag, ""
+		if i := strings.IndexByte(flag, '='); i >= 0 {
+			name, expect = flag[:i], flag[i+1:]
+		}
+		value, ok := cmd.Flags[normalizeKubectlFlag(name)]
+		if !ok {
+			return false
+		}
+		if expect != "" {
+			if matched, _ := path.Match(expect, value); !matched {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchLabels 标签无法确定时, 非允许的规则视为匹配
+func (r *KubectlFilterRule) matchLabels(cmd *KubectlCommand, resolver kubeLabelResolver) bool {
+	unknown := r.Action != model.ActionAllow
+	if selector, ok := cmd.Flags["selector"]; ok {
+		return matchLabelRules(r.Labels, parseLabelSelector(selector))
+	}
+	if len(cmd.Names) == 0 {
+		return unknown
+	}
+	if resolver == nil {
+		return unknown
+	}
+	resource := "pod"
+	if len(cmd.Resources) > 0 {
+		resource = cmd.Resources[0]
+	}
+	for _, name := range cmd.Names {
+		labels, err := resolver.Labels(cmd.Namespace, resource, name)
+		if err != nil {
+			logger.Errorf("Get k8s %s %s/%s labels err: %s", resource, cmd.Namespace, name, err)
+			if unknown {
+				return true
+			}
+			continue
+		}
+		if matchLabelRules(r.Labels, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLabelRules rules 为 key 或 key=value, 任意一个匹配即可
+func matchLabelRules(rules []string, labels map[string]string) bool {
+	for _, rule := range rules {
+		key, expect := rule, ""
+		if i := strings.IndexByte(rule, '='); i >= 0 {
+			key, expect = rule[:i], rule[i+1:]
+		}
+		value, ok := labels[key]
+		if !ok {
+			continue
+		}
+		if expect == "" {
+			return true
+		}
+		if matched, _ := path.Match(expect, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLabelSelector 解析 a=b,c==d,e 形式的选择器, 不等和集合条件只保留 key
+func parseLabelSelector(selector string) map[string]string {
+	labels := make(map[string]string)
+	var items []string
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	items = append(items, selector[start:])
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "", strings.HasPrefix(item, "!"):
+		case strings.Contains(item, "!="):
+		case strings.Contains(item, "=="):
+			i := strings.Index(item, "==")
+			labels[strings.TrimSpace(item[:i])] = strings.TrimSpace(item[i+2:])
+		case strings.Contains(item, "="):
+			i := strings.IndexByte(item, '=')
+			labels[strings.TrimSpace(item[:i])] = strings.TrimSpace(item[i+1:])
+		default:
+			// key in (a,b)、key notin (a,b)
+			if fields := strings.Fields(item); len(fields) > 0 {
+				labels[fields[0]] = ""
+			}
+		}
+	}
+	return labels
+}
+
+// newK8sFilterRules 合并命令过滤规则和 kubectl 规则, 按优先级排序
+func newK8sFilterRules(rules []model.SystemUserFilterRule, kubectlRules []KubectlFilterRule) []KubectlFilterRule {
+	k8sRules := make([]KubectlFilterRule, 0, len(rules)+len(kubectlRules))
+	for i := range rules {
+		k8sRules = append(k8sRules, KubectlFilterRule{SystemUserFilterRule: rules[i]})
+	}
+	k8sRules = append(k8sRules, kubectlRules...)
+	sort.SliceStable(k8sRules, func(i, j int) bool {
+		if k8sRules[i].Priority == k8sRules[j].Priority {
+			return k8sRules[i].Action.StricterThan(k8sRules[j].Action)
+		}
+		return k8sRules[i].Priority < k8sRules[j].Priority
+	})
+	return k8sRules
+}
+
+// matchKubectlFilterRules 原始命令和每个简单命令分别按优先级匹配规则, 取最严格的动作
+func matchKubectlFilterRules(rules []KubectlFilterRule, candidates []kubectlCandidate,
+	labels kubeLabelResolver) (model.SystemUserFilterRule, string, bool) {
+	var (
+		matchedRule model.SystemUserFilterRule
+		matchedCmd  string
+		matched     bool
+	)
+	for _, candidate := range candidates {
+		index, cmd, ok := firstMatchedRule(len(rules), func(i int) (model.RuleAction, string) {
+			return rules[i].MatchKubectl(candidate, labels)
+		})
+		if !ok {
+			continue
+		}
+		if rule := rules[index].SystemUserFilterRule; !matched || rule.Action.StricterThan(matchedRule.Action) {
+			matchedRule, matchedCmd, matched = rule, cmd, true
+		}
+	}
+	return matchedRule, matchedCmd, matched
+}
+
+type kubeLabelResolver interface {
+	Labels(namespace, resource, name string) (map[string]string, error)
+}
+
+// 工作负载使用 pod 模版的标签
+var kubeLabelAPIs = map[string]string{
+	"pod":         "/api/v1/namespaces/%s/pods/%s",
+	"deployment":  "/apis/apps/v1/namespaces/%s/deployments/%s",
+	"statefulset": "/apis/apps/v1/namespaces/%s/statefulsets/%s",
+	"daemonset":   "/apis/apps/v1/namespaces/%s/daemonsets/%s",
+	"replicaset":  "/apis/apps/v1/namespaces/%s/replicasets/%s",
+	"job":         "/apis/batch/v1/namespaces/%s/jobs/%s",
+}
+
+// k8sLabelFetcher 使用会话的 token 从集群查询资源的标签, 结果在会话中缓存
+type k8sLabelFetcher struct {
+	server string
+	token  string
+	client *http.Client
+
+	lock  sync.Mutex
+	cache map[string]map[string]string
+}
+
+func newK8sLabelFetcher(server, token string) *k8sLabelFetcher {
+	return &k8sLabelFetcher{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+		cache: make(map[string]map[string]string),
+	}
+}
+
+func (f *k8sLabelFetcher) Labels(namespace, resource, name string) (map[string]string, error) {
+	api, ok := kubeLabelAPIs[resource]
+	if !ok {
+		return nil, fmt.Errorf("unsupported resource %s", resource)
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	key := strings.Join([]string{resource, namespace, name}, "/")
+	f.lock.Lock()
+	labels, ok := f.cache[key]
+	f.lock.Unlock()
+	if ok {
+		return labels, nil
+	}
+	reqURL := f.server + fmt.Sprintf(api, url.PathEscape(namespace), url.PathEscape(name))
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+f.token)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	var obj struct {
+		Metadata struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+		Spec struct {
+			Template struct {
+				Metadata struct {
+					Labels map[string]string `json:"labels"`
+				} `json:"metadata"`
+			} `json:"template"`
+		} `json:"spec"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return nil, err
+	}
+	labels = obj.Metadata.Labels
+	if resource != "pod" {
+		labels = obj.Spec.Template.Metadata.Labels
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	f.lock.Lock()
+	f.cache[key] = labels
+	f.lock.Unlock()
+	return labels, nil
+}
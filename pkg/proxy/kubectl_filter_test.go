@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+)
+
+type fakeLabelResolver map[string]map[string]string
+
+func (f fakeLabelResolver) Labels(namespace, resource, name string) (map[string]string, error) {
+	labels, ok := f[resource+"/"+namespace+"/"+name]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return labels, nil
+}
+
+func TestMatchKubectlFilterRules(t *testing.T) {
+	rules := newK8sFilterRules([]model.SystemUserFilterRule{
+		{ID: "deny-rm", Priority: 1, Type: model.TypeCmd, Content: "rm -rf", Action: model.ActionDeny},
+		{ID: "allow-all", Priority: 100, Type: model.TypeRegex, Content: ".*", Action: model.ActionAllow},
+	}, []KubectlFilterRule{
+		{SystemUserFilterRule: model.SystemUserFilterRule{ID: "deny-delete-prod", Priority: 10,
+			Type: filterRuleTypeKubectl, Action: model.ActionDeny},
+			Verbs: []string{"delete"}, Namespaces: []string{"prod*"}},
+		{SystemUserFilterRule: model.SystemUserFilterRule{ID: "deny-exec-db", Priority: 10,
+			Type: filterRuleTypeKubectl, Action: model.ActionDeny},
+			Verbs: []string{"exec"}, Labels: []string{"tier=db*"}},
+		{SystemUserFilterRule: model.SystemUserFilterRule{ID: "audit-restart", Priority: 20,
+			Type: filterRuleTypeKubectl, Action: model.ActionAudit},
+			Verbs: []string{"rollout restart"}, Resources: []string{"deploy"}},
+		{SystemUserFilterRule: model.SystemUserFilterRule{ID: "deny-force", Priority: 20,
+			Type: filterRuleTypeKubectl, Action: model.ActionDeny},
+			Flags: []string{"--force", "--grace-period=0"}},
+		{SystemUserFilterRule: model.SystemUserFilterRule{ID: "deny-secret", Priority: 20,
+			Type: filterRuleTypeKubectl, Action: model.ActionDeny},
+			Resources: []string{"secrets"}, Names: []string{"db-*"}},
+	})
+	labels := fakeLabelResolver{
+		"pod/default/mysql-0": {"tier": "db-primary"},
+		"pod/default/web-0":   {"tier": "web"},
+	}
+	tests := []struct {
+		line   string
+		ruleID string
+	}{
+		{"kubectl delete po web-0 -n prod-1", "deny-delete-prod"},
+		{"kubectl delete po web-0", "allow-all"},
+		{"kubectl delete ns prod", "deny-delete-prod"},
+		{"kubectl delete po --all -A", "deny-delete-prod"},
+		{"kubectl exec -it mysql-0 -- bash", "deny-exec-db"},
+		{"kubectl exec -it web-0 -- bash", "allow-all"},
+		{"kubectl exec -it unknown-0 -- bash", "deny-exec-db"},
+		{"kubectl exec -it web-0 -- rm -rf /", "deny-rm"},
+		{"kubectl get po | grep x; kubectl rollout restart deploy/web", "audit-restart"},
+		{"kubectl delete po web-0 --force --grace-period=0", "deny-force"},
+		{"kubectl delete po web-0 --force", "allow-all"},
+		{"kubectl get secret db-pass -o yaml", "deny-secret"},
+		{"kubectl get secrets", "deny-secret"},
+		{"kubectl get all", "deny-secret"},
+		{"kubectl get secret web-tls", "allow-all"},
+	}
+	for _, tt := range tests {
+		rule, _, ok := matchKubectlFilterRules(rules, kubectlCandidates(tt.line, "default"), labels)
+		if !ok || rule.ID != tt.ruleID {
+			t.Errorf("%q: expect %q, got %q", tt.line, tt.ruleID, rule.ID)
+		}
+	}
+}
+
+func TestParseLabelSelector(t *testing.T) {
+	labels := parseLabelSelector("app=web, tier==db,env!=prod,!canary,zone in (a,b)")
+	expect := map[string]string{"app": "web", "tier": "db", "zone": ""}
+	for key, value := range expect {
+		if got, ok := labels[key]; !ok || got != value {
+			t.Errorf("expect %s=%q, got %q", key, value, got)
+		}
+	}
+	if _, ok := labels["env"]; ok {
+		t.Error("env should be ignored")
+	}
+}
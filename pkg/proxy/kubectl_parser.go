@@ -0,0 +1,464 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	k8s 会话中 kubectl 命令的解析, 从 shell 拆分出的简单命令中获取:
+	verb(子命令, 如 rollout restart)、资源类型、资源名称、namespace 和参数
+	1. 资源类型统一为单数全称, 如 po、pods 为 pod, deployments.apps 为 deployment
+	2. 参数统一为长参数名(不含 --), 如 -n 为 namespace, -A 为 all-namespaces, 没有值的参数值为 true
+	3. 会话使用 kubectl-aliases, 命令中的别名(如 k、kgpo、kex)按别名文件展开
+	4. 没有指定 namespace 时使用会话当前的 namespace, 可以通过 kubectl config set-context 修改
+*/
+
+const kubectlAliasesFile = "/opt/kubectl-aliases/.kubectl_aliases"
+
+var kubectlBinaries = map[string]bool{"kubectl": true, "rawkubectl": true}
+
+// 资源类型的简称和复数
+var kubeResourceAliases = map[string]string{
+	"po":                        "pod",
+	"pods":                      "pod",
+	"svc":                       "service",
+	"services":                  "service",
+	"deploy":                    "deployment",
+	"deployments":               "deployment",
+	"ds":                        "daemonset",
+	"daemonsets":                "daemonset",
+	"sts":                       "statefulset",
+	"statefulsets":              "statefulset",
+	"rs":                        "replicaset",
+	"replicasets":               "replicaset",
+	"rc":                        "replicationcontroller",
+	"replicationcontrollers":    "replicationcontroller",
+	"cm":                        "configmap",
+	"configmaps":                "configmap",
+	"secrets":                   "secret",
+	"ns":                        "namespace",
+	"namespaces":                "namespace",
+	"no":                        "node",
+	"nodes":                     "node",
+	"pv":                        "persistentvolume",
+	"persistentvolumes":         "persistentvolume",
+	"pvc":                       "persistentvolumeclaim",
+	"persistentvolumeclaims":    "persistentvolumeclaim",
+	"sa":                        "serviceaccount",
+	"serviceaccounts":           "serviceaccount",
+	"ing":                       "ingress",
+	"ingresses":                 "ingress",
+	"jobs":                      "job",
+	"cj":                        "cronjob",
+	"cronjobs":                  "cronjob",
+	"ep":                        "endpoints",
+	"ev":                        "event",
+	"events":                    "event",
+	"hpa":                       "horizontalpodautoscaler",
+	"horizontalpodautoscalers":  "horizontalpodautoscaler",
+	"netpol":                    "networkpolicy",
+	"networkpolicies":           "networkpolicy",
+	"crd":                       "customresourcedefinition",
+	"crds":                      "customresourcedefinition",
+	"customresourcedefinitions": "customresourcedefinition",
+	"roles":                     "role",
+	"rolebindings":              "rolebinding",
+	"clusterroles":              "clusterrole",
+	"clusterrolebindings":       "clusterrolebinding",
+	"sc":                        "storageclass",
+	"storageclasses":            "storageclass",
+	"quota":                     "resourcequota",
+	"resourcequotas":            "resourcequota",
+	"limits":                    "limitrange",
+	"limitranges":               "limitrange",
+	"pdb":                       "poddisruptionbudget",
+	"poddisruptionbudgets":      "poddisruptionbudget",
+	"pc":                        "priorityclass",
+	"priorityclasses":           "priorityclass",
+}
+
+// 短参数对应的长参数
+var kubectlShortFlags = map[string]string{
+	"n": "namespace", "A": "all-namespaces", "l": "selector", "f": "filename", "o": "output",
+	"c": "container", "i": "stdin", "t": "tty", "R": "recursive", "w": "watch", "L": "label-columns",
+	"k": "kustomize", "s": "server", "e": "env", "r": "replicas", "v": "v",
+}
+
+// 需要值的参数, 其他参数没有使用 = 时视为没有值
+var kubectlValueFlags = map[string]bool{
+	"namespace": true, "context": true, "cluster": true, "user": true, "server": true, "token": true,
+	"kubeconfig": true, "as": true, "as-group": true, "as-uid": true, "request-timeout": true,
+	"cache-dir": true, "certificate-authority": true, "client-certificate": true, "client-key": true,
+	"tls-server-name": true, "password": true, "username": true, "profile": true, "v": true,
+	"selector": true, "filename": true, "output": true, "container": true, "field-selector": true,
+	"label-columns": true, "sort-by": true, "template": true, "image": true, "replicas": true,
+	"patch": true, "type": true, "grace-period": true, "timeout": true, "since": true, "since-time": true,
+	"tail": true, "limit-bytes": true, "from-literal": true, "from-file": true, "from-env-file": true,
+	"port": true, "target-port": true, "name": true, "overrides": true, "env": true, "labels": true,
+	"serviceaccount": true, "restart": true, "image-pull-policy": true, "kustomize": true,
+	"field-manager": true, "current-replicas": true, "resource-version": true, "to-revision": true,
+	"revision": true, "min": true, "max": true, "cpu-percent": true, "target": true, "copy-to": true,
+	"chunk-size": true, "for": true, "verb": true, "resource": true, "role": true, "clusterrole": true,
+	"group": true, "schedule": true, "pod-running-timeout": true, "max-log-requests": true,
+	"docker-server": true, "docker-username": true, "docker-password": true, "docker-email": true,
+	"cert": true, "key": true, "address": true, "subresource": true, "raw": true,
+}
+
+// 有子命令的 verb
+var kubectlSubcommandVerbs = map[string]bool{
+	"rollout": true, "config": true, "auth": true, "set": true, "certificate": true,
+	"cluster-info": true, "plugin": true, "alpha": true, "top": true, "create": true,
+}
+
+// 操作 pod 的 verb, 第一个参数为 pod 名称或 TYPE/NAME
+var kubectlPodVerbs = map[string]bool{
+	"exec": true, "attach": true, "port-forward": true, "logs": true, "debug": true, "run": true,
+}
+
+// 操作 node 的 verb
+var kubectlNodeVerbs = map[string]bool{"drain": true, "cordon": true, "uncordon": true}
+
+// 参数值中的凭证, 记录时隐藏
+var kubectlSecretFlags = map[string]bool{
+	"token": true, "password": true, "client-key": true, "docker-password": true,
+}
+
+type KubectlCommand struct {
+	Verb       string
+	Subcommand string
+	Resources  []string
+	Names      []string
+	Namespace  string
+	// AllNamespaces 使用了 -A 参数
+	AllNamespaces bool
+	Flags         map[string]string
+	// Args exec、debug 等命令中 -- 之后的参数
+	Args []string
+}
+
+// kubectlCandidate 用于匹配规则的命令, kubectl 为 nil 时不是 kubectl 命令
+type kubectlCandidate struct {
+	text    string
+	kubectl *KubectlCommand
+}
+
+// kubectlCandidates 返回原始命令和拆分后的每个简单命令
+func kubectlCandidates(line, namespace string) []kubectlCandidate {
+	candidates := []kubectlCandidate{{text: line}}
+	for _, item := range ParseShellCommands(line) {
+		cmd, _ := ParseKubectlCommand(item.Args, namespace)
+		candidates = append(candidates, kubectlCandidate{text: item.String(), kubectl: cmd})
+	}
+	return candidates
+}
+
+// ParseKubectlCommands 返回命令行中的 kubectl 命令
+func ParseKubectlCommands(line, namespace string) []*KubectlCommand {
+	var commands []*KubectlCommand
+	for _, item := range ParseShellCommands(line) {
+		if cmd, ok := ParseKubectlCommand(item.Args, namespace); ok {
+			commands = append(commands, cmd)
+		}
+	}
+	return commands
+}
+
+// ParseKubectlCommand 解析 kubectl 命令的参数, namespace 为没有指定时使用的 namespace
+func ParseKubectlCommand(args []string, namespace string) (*KubectlCommand, bool) {
+	args = expandKubectlAlias(args)
+	if len(args) == 0 || !kubectlBinaries[shellBaseName(args[0])] {
+		return nil, false
+	}
+	cmd := KubectlCommand{Flags: make(map[string]string)}
+	var positional []string
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			cmd.Args = args[i+1:]
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+		verb := ""
+		if len(positional) > 0 {
+			verb = positional[0]
+		}
+		for _, flag := range splitKubectlFlag(arg, verb) {
+			if flag.value == "" && flag.needValue {
+				if i+1 < len(args) {
+					i++
+					flag.value = args[i]
+				}
+			}
+			if flag.value == "" {
+				flag.value = "true"
+			}
+			cmd.Flags[flag.name] = flag.value
+		}
+	}
+	cmd.parsePositional(positional)
+	cmd.Namespace = cmd.Flags["namespace"]
+	if cmd.Namespace == "" {
+		cmd.Namespace = namespace
+	}
+	cmd.AllNamespaces = isKubectlTrue(cmd.Flags["all-namespaces"])
+	return &cmd, true
+}
+
+type kubectlFlag struct {
+	name      string
+	value     string
+	needValue bool
+}
+
+// splitKubectlFlag 解析 --name=value、-n value、-nvalue、-it 形式的参数
+func splitKubectlFlag(arg, verb string) []kubectlFlag {
+	if strings.HasPrefix(arg, "--") {
+		name, value := arg[2:], ""
+		hasValue := false
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name, value, hasValue = name[:i], name[i+1:], true
+		}
+		return []kubectlFlag{{name: name, value: value, needValue: !hasValue && kubectlValueFlags[name]}}
+	}
+	var flags []kubectlFlag
+	short := arg[1:]
+	for i := 0; i < len(short); i++ {
+		name := normalizeKubectlFlag(short[i : i+1])
+		// -p 在 patch 中为 --patch, 在 logs 中为 --previous
+		if short[i] == 'p' {
+			name = "previous"
+			if verb == "patch" {
+				name = "patch"
+			}
+		}
+		if !kubectlValueFlags[name] {
+			flags = append(flags, kubectlFlag{name: name})
+			continue
+		}
+		value := strings.TrimPrefix(short[i+1:], "=")
+		return append(flags, kubectlFlag{name: name, value: value, needValue: value == ""})
+	}
+	return flags
+}
+
+func (c *KubectlCommand) parsePositional(positional []string) {
+	if len(positional) == 0 {
+		return
+	}
+	c.Verb = strings.ToLower(positional[0])
+	rest := positional[1:]
+	if kubectlSubcommandVerbs[c.Verb] && len(rest) > 0 {
+		c.Subcommand = strings.ToLower(rest[0])
+		rest = rest[1:]
+		switch c.Verb {
+		case "top":
+			c.Resources = []string{normalizeKubeResource(c.Subcommand)}
+			c.Names = rest
+			return
+		case "create":
+			// kubectl create deployment NAME, kubectl create secret generic NAME
+			c.Resources = []string{normalizeKubeResource(c.Subcommand)}
+			if len(rest) > 1 && (c.Subcommand == "secret" || c.Subcommand == "service" || c.Subcommand == "svc") {
+				rest = rest[1:]
+			}
+			if len(rest) > 0 {
+				c.Names = rest[:1]
+			}
+			return
+		case "rollout", "set":
+		default:
+			return
+		}
+	}
+	switch {
+	case kubectlPodVerbs[c.Verb]:
+		if len(rest) == 0 {
+			return
+		}
+		if resource, name, ok := splitKubeResourceName(rest[0]); ok {
+			c.Resources = []string{resource}
+			c.Names = []string{name}
+			return
+		}
+		c.Resources = []string{"pod"}
+		c.Names = rest[:1]
+	case c.Verb == "cp":
+		// kubectl cp namespace/pod:path path
+		for _, arg := range rest {
+			i := strings.IndexByte(arg, ':')
+			if i <= 0 {
+				continue
+			}
+			c.Resources = []string{"pod"}
+			pod := arg[:i]
+			if j := strings.IndexByte(pod, '/'); j >= 0 {
+				c.Flags["namespace"], pod = pod[:j], pod[j+1:]
+			}
+			c.Names = append(c.Names, pod)
+		}
+	case kubectlNodeVerbs[c.Verb]:
+		c.Resources = []string{"node"}
+		c.Names = rest
+	default:
+		c.parseResources(rest)
+	}
+}
+
+// parseResources 解析 TYPE NAME...、TYPE1,TYPE2 和 TYPE/NAME... 形式的资源
+func (c *KubectlCommand) parseResources(args []string) {
+	for i, arg := range args {
+		// label、annotate、set image 等命令的 key=value 参数
+		if strings.ContainsAny(arg, "=:") || strings.HasSuffix(arg, "-") {
+			continue
+		}
+		if resource, name, ok := splitKubeResourceName(arg); ok {
+			c.Resources = appendUniqueFold(c.Resources, resource)
+			c.Names = append(c.Names, name)
+			continue
+		}
+		if i == 0 {
+			for _, resource := range strings.Split(arg, ",") {
+				c.Resources = appendUniqueFold(c.Resources, normalizeKubeResource(resource))
+			}
+			continue
+		}
+		c.Names = append(c.Names, arg)
+	}
+}
+
+// ContextNamespace 修改当前上下文 namespace 的命令, 返回新的 namespace
+func (c *KubectlCommand) ContextNamespace() (string, bool) {
+	if c.Verb != "config" || c.Subcommand != "set-context" {
+		return "", false
+	}
+	namespace, ok := c.Flags["namespace"]
+	return namespace, ok && namespace != ""
+}
+
+// Meta 记录到命令中的结构化信息, 隐藏参数中的凭证
+func (c *KubectlCommand) Meta() model.KubectlMeta {
+	flags := make(map[string]string, len(c.Flags))
+	for name, value := range c.Flags {
+		switch {
+		case kubectlSecretFlags[name]:
+			value = commandRedactReplacement
+		case name == "from-literal":
+			// key=value 只隐藏 value
+			if i := strings.IndexByte(value, '='); i >= 0 {
+				value = value[:i+1] + commandRedactReplacement
+			}
+		}
+		flags[name] = value
+	}
+	return model.KubectlMeta{
+		Verb:       c.Verb,
+		Subcommand: c.Subcommand,
+		Resources:  c.Resources,
+		Names:      c.Names,
+		Namespace:  c.Namespace,
+		Flags:      flags,
+	}
+}
+
+func splitKubeResourceName(arg string) (string, string, bool) {
+	i := strings.IndexByte(arg, '/')
+	if i <= 0 || i == len(arg)-1 {
+		return "", "", false
+	}
+	return normalizeKubeResource(arg[:i]), arg[i+1:], true
+}
+
+// normalizeKubeResource 返回资源类型的单数全称, 去掉 API 组
+func normalizeKubeResource(resource string) string {
+	resource = strings.ToLower(resource)
+	if i := strings.IndexByte(resource, '.'); i > 0 {
+		resource = resource[:i]
+	}
+	if name, ok := kubeResourceAliases[resource]; ok {
+		return name
+	}
+	return resource
+}
+
+// normalizeKubectlFlag 返回不含 - 的长参数名
+func normalizeKubectlFlag(flag string) string {
+	if strings.HasPrefix(flag, "--") {
+		return flag[2:]
+	}
+	flag = strings.TrimPrefix(flag, "-")
+	if name, ok := kubectlShortFlags[flag]; ok {
+		return name
+	}
+	return flag
+}
+
+func isKubectlTrue(value string) bool {
+	return value != "" && value != "false"
+}
+
+var (
+	kubectlAliases     map[string]string
+	kubectlAliasesOnce sync.Once
+)
+
+// expandKubectlAlias 展开命令开头的 kubectl-aliases 别名
+func expandKubectlAlias(args []string) []string {
+	kubectlAliasesOnce.Do(func() {
+		kubectlAliases = map[string]string{"k": "kubectl"}
+		f, err := os.Open(kubectlAliasesFile)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		for name, value := range loadKubectlAliases(f) {
+			kubectlAliases[name] = value
+		}
+		logger.Infof("Load %d kubectl aliases", len(kubectlAliases))
+	})
+	if len(args) == 0 {
+		return args
+	}
+	value, ok := kubectlAliases[args[0]]
+	if !ok {
+		return args
+	}
+	commands := ParseShellCommands(value)
+	if len(commands) == 0 {
+		return args
+	}
+	expanded := append([]string{}, commands[0].Args...)
+	return append(expanded, args[1:]...)
+}
+
+// loadKubectlAliases 解析 alias name='value' 形式的别名
+func loadKubectlAliases(r io.Reader) map[string]string {
+	aliases := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "alias ") {
+			continue
+		}
+		line = strings.TrimSpace(line[len("alias "):])
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			continue
+		}
+		name, value := line[:i], line[i+1:]
+		if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		aliases[name] = value
+	}
+	return aliases
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseKubectlCommand(t *testing.T) {
+	tests := []struct {
+		line   string
+		expect KubectlCommand
+	}{
+		{"kubectl get pods -n kube-system",
+			KubectlCommand{Verb: "get", Resources: []string{"pod"}, Namespace: "kube-system",
+				Flags: map[string]string{"namespace": "kube-system"}}},
+		{"kubectl -n prod delete deploy/web svc/web --force --grace-period=0",
+			KubectlCommand{Verb: "delete", Resources: []string{"deployment", "service"}, Names: []string{"web", "web"},
+				Namespace: "prod", Flags: map[string]string{"namespace": "prod", "force": "true", "grace-period": "0"}}},
+		{"kubectl delete po,svc -l app=web -A",
+			KubectlCommand{Verb: "delete", Resources: []string{"pod", "service"}, Namespace: "default", AllNamespaces: true,
+				Flags: map[string]string{"selector": "app=web", "all-namespaces": "true"}}},
+		{"kubectl exec -it web-0 -c app -- sh -c 'rm -rf /'",
+			KubectlCommand{Verb: "exec", Resources: []string{"pod"}, Names: []string{"web-0"}, Namespace: "default",
+				Flags: map[string]string{"stdin": "true", "tty": "true", "container": "app"}, Args: []string{"sh", "-c", "rm -rf /"}}},
+		{"kubectl logs -p deploy/web -nprod",
+			KubectlCommand{Verb: "logs", Resources: []string{"deployment"}, Names: []string{"web"}, Namespace: "prod",
+				Flags: map[string]string{"previous": "true", "namespace": "prod"}}},
+		{"kubectl patch deployments.apps web -p '{}'",
+			KubectlCommand{Verb: "patch", Resources: []string{"deployment"}, Names: []string{"web"}, Namespace: "default",
+				Flags: map[string]string{"patch": "{}"}}},
+		{"kubectl rollout restart sts/db",
+			KubectlCommand{Verb: "rollout", Subcommand: "restart", Resources: []string{"statefulset"}, Names: []string{"db"},
+				Namespace: "default", Flags: map[string]string{}}},
+		{"kubectl create secret generic db-pass --from-literal=password=x",
+			KubectlCommand{Verb: "create", Subcommand: "secret", Resources: []string{"secret"}, Names: []string{"db-pass"},
+				Namespace: "default", Flags: map[string]string{"from-literal": "password=x"}}},
+		{"kubectl label pods web-0 tier=db env-",
+			KubectlCommand{Verb: "label", Resources: []string{"pod"}, Names: []string{"web-0"}, Namespace: "default",
+				Flags: map[string]string{}}},
+		{"kubectl cp prod/web-0:/etc/passwd /tmp/passwd",
+			KubectlCommand{Verb: "cp", Resources: []string{"pod"}, Names: []string{"web-0"}, Namespace: "prod",
+				Flags: map[string]string{"namespace": "prod"}}},
+		{"kubectl drain node-1 --ignore-daemonsets",
+			KubectlCommand{Verb: "drain", Resources: []string{"node"}, Names: []string{"node-1"}, Namespace: "default",
+				Flags: map[string]string{"ignore-daemonsets": "true"}}},
+		{"k delete ns staging",
+			KubectlCommand{Verb: "delete", Resources: []string{"namespace"}, Names: []string{"staging"}, Namespace: "default",
+				Flags: map[string]string{}}},
+	}
+	for _, tt := range tests {
+		commands := ParseShellCommands(tt.line)
+		if len(commands) != 1 {
+			t.Fatalf("%q: expect 1 command, got %d", tt.line, len(commands))
+		}
+		got, ok := ParseKubectlCommand(commands[0].Args, "default")
+		if !ok {
+			t.Errorf("%q: not a kubectl command", tt.line)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.expect) {
+			t.Errorf("%q:\nexpect %+v\ngot    %+v", tt.line, tt.expect, *got)
+		}
+	}
+
+	if _, ok := ParseKubectlCommand([]string{"ls", "-l"}, "default"); ok {
+		t.Error("ls should not be a kubectl command")
+	}
+}
+
+func TestParseKubectlCommands(t *testing.T) {
+	commands := ParseKubectlCommands("ls && kubectl get po | grep web; kubectl config set-context --current --namespace=prod", "dev")
+	if len(commands) != 2 {
+		t.Fatalf("expect 2 kubectl commands, got %d", len(commands))
+	}
+	if commands[0].Verb != "get" || commands[0].Namespace != "dev" {
+		t.Errorf("unexpected first command %+v", commands[0])
+	}
+	if namespace, ok := commands[1].ContextNamespace(); !ok || namespace != "prod" {
+		t.Errorf("expect context namespace prod, got %q", namespace)
+	}
+	if _, ok := commands[0].ContextNamespace(); ok {
+		t.Error("get should not change context namespace")
+	}
+
+	meta := ParseKubectlCommands("kubectl get po --token=secret", "default")[0].Meta()
+	if meta.Flags["token"] != "******" {
+		t.Errorf("expect token hidden, got %q", meta.Flags["token"])
+	}
+	meta = ParseKubectlCommands("kubectl create secret generic s --from-literal=password=x", "default")[0].Meta()
+	if meta.Flags["from-literal"] != "password=******" {
+		t.Errorf("expect literal value hidden, got %q", meta.Flags["from-literal"])
+	}
+}
+
+func TestLoadKubectlAliases(t *testing.T) {
+	aliases := loadKubectlAliases(strings.NewReader(`# comment
+alias k='kubectl'
+alias kgpo='kubectl get pods'
+alias kdel="kubectl delete"
+alias broken
+`))
+	expect := map[string]string{"k": "kubectl", "kgpo": "kubectl get pods", "kdel": "kubectl delete"}
+	if !reflect.DeepEqual(aliases, expect) {
+		t.Errorf("expect %v, got %v", expect, aliases)
+	}
+}
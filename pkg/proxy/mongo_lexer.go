@@ -0,0 +1,558 @@
+package proxy
+
+import (
+	"strings"
+)
+
+/*
+	MongoDB(mongosh) 的语句切分和分析:
+	1. mongosh 按 JavaScript 语法判断输入是否结束: 括号、块注释、模板字符串未结束, 或者行尾为 . , = + 等运算符时继续输入,
+	   否则换行即执行; 顶层的 ; 分隔同一行的多个语句。空缓存的行首 use、show、exit 等为 shell 命令, 作为单独的语句返回
+	2. analyzeMongo 将 db.collection.method() 调用映射为 SQL 的语句类型, 方便使用相同的规则,
+	   如 deleteMany 为 DELETE, drop 为 DROP COLLECTION, dropDatabase 为 DROP DATABASE, 未知的方法为方法名的大写;
+	   集合名作为表名, getSiblingDB("d").c 为 d.c; 修改和删除的查询条件为空或 {} 时没有 WHERE 条件
+	一条语句中有多个 db 调用时, 以第一个不是查询的调用为准; 通过变量间接调用的集合无法识别, 只能使用命令或正则规则
+*/
+
+// mongosh 的 shell 命令, 只在空缓存的行首生效
+var mongoShellCommands = map[string]bool{
+	"use": true, "show": true, "exit": true, "quit": true, "it": true, "help": true, "cls": true, "edit": true,
+}
+
+// mongosh 的全局函数, 与 mysql 客户端的长命令保持一致
+var mongoGlobalFunctions = map[string]string{
+	"load": "SOURCE", "exit": "EXIT", "quit": "QUIT", "connect": "CONNECT", "Mongo": "CONNECT",
+}
+
+// 集合的方法对应的语句类型和对象类型
+var mongoCollectionMethods = map[string]string{
+	"find": "SELECT", "findOne": "SELECT", "aggregate": "SELECT", "count": "SELECT",
+	"countDocuments": "SELECT", "estimatedDocumentCount": "SELECT", "distinct": "SELECT", "watch": "SELECT",
+	"insert": "INSERT", "insertOne": "INSERT", "insertMany": "INSERT",
+	"update": "UPDATE", "updateOne": "UPDATE", "updateMany": "UPDATE", "replaceOne": "UPDATE",
+	"findOneAndUpdate": "UPDATE", "findOneAndReplace": "UPDATE", "findAndModify": "UPDATE",
+	"remove": "DELETE", "deleteOne": "DELETE", "deleteMany": "DELETE", "findOneAndDelete": "DELETE",
+	"drop": "DROP COLLECTION", "renameCollection": "RENAME COLLECTION",
+	"createIndex": "CREATE INDEX", "createIndexes": "CREATE INDEX", "ensureIndex": "CREATE INDEX",
+	"dropIndex": "DROP INDEX", "dropIndexes": "DROP INDEX",
+}
+
+// 数据库的方法对应的语句类型和对象类型
+var mongoDatabaseMethods = map[string]string{
+	"dropDatabase": "DROP DATABASE", "createCollection": "CREATE COLLECTION", "createView": "CREATE VIEW",
+	"createUser": "CREATE USER", "dropUser": "DROP USER", "dropAllUsers": "DROP USER",
+	"updateUser": "ALTER USER", "changeUserPassword": "ALTER USER",
+	"createRole": "CREATE ROLE", "dropRole": "DROP ROLE", "dropAllRoles": "DROP ROLE", "updateRole": "ALTER ROLE",
+	"grantRolesToUser": "GRANT", "grantRolesToRole": "GRANT", "grantPrivilegesToRole": "GRANT",
+	"revokeRolesFromUser": "REVOKE", "revokeRolesFromRole": "REVOKE", "revokePrivilegesFromRole": "REVOKE",
+	"shutdownServer": "SHUTDOWN",
+}
+
+// mongosh 的行尾为这些运算符时继续输入
+const mongoContinuationChars = ".,=+-*&|?:!<>%^~"
+
+// 这些字符之后的 / 为正则表达式
+const mongoRegexPrecedingChars = "(,=:[!&|?{};+-*%<>~^"
+
+func newMongoDBStatementBuffer() *sqlStatementBuffer {
+	return &sqlStatementBuffer{dialect: sqlDialectMongoDB}
+}
+
+func (b *sqlStatementBuffer) splitMongo(text string) ([]string, string) {
+	var (
+		stmts []string
+		cur   strings.Builder
+		depth int
+		// last、prev 最后两个不是空白和注释的字符
+		last, prev byte
+	)
+	flush := func() {
+		// 只有注释时客户端不执行
+		if stmt := strings.TrimSpace(cur.String()); stmt != "" && last != 0 {
+			stmts = append(stmts, stmt)
+		}
+		cur.Reset()
+		depth, last, prev = 0, 0, 0
+	}
+	code := func(c byte) {
+		prev, last = last, c
+	}
+	for i := 0; i < len(text); {
+		if (i == 0 || text[i-1] == '\n') && strings.TrimSpace(cur.String()) == "" {
+			end := sqlLineEnd(text, i)
+			if isMongoShellCommand(text[i:end]) {
+				stmts = append(stmts, strings.TrimSpace(text[i:end]))
+				cur.Reset()
+				i = end
+				continue
+			}
+		}
+		c := text[i]
+		switch {
+		case c == '\'' || c == '"':
+			end, complete := skipMongoQuoted(text, i)
+			if !complete {
+				return stmts, cur.String() + text[i:]
+			}
+			cur.WriteString(text[i:end])
+			code(c)
+			i = end
+		case c == '`':
+			end, closed := skipMongoTemplate(text, i)
+			if !closed {
+				return stmts, cur.String() + text[i:]
+			}
+			cur.WriteString(text[i:end])
+			code(c)
+			i = end
+		case strings.HasPrefix(text[i:], "//"):
+			end := sqlLineEnd(text, i)
+			cur.WriteString(text[i:end])
+			i = end
+		case strings.HasPrefix(text[i:], "/*"):
+			n := strings.Index(text[i+2:], "*/")
+			if n < 0 {
+				return stmts, cur.String() + text[i:]
+			}
+			cur.WriteString(text[i : i+n+4])
+			i += n + 4
+		case c == '/' && (last == 0 || strings.IndexByte(mongoRegexPrecedingChars, last) >= 0):
+			end := skipMongoRegex(text, i)
+			cur.WriteString(text[i:end])
+			code(c)
+			i = end
+		case c == ';' && depth == 0:
+			flush()
+			i++
+		case c == '\n' && depth == 0 && !mongoContinues(last, prev):
+			flush()
+			i++
+		default:
+			switch c {
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				if depth > 0 {
+					depth--
+				}
+			}
+			if !isSQLSpace(c) {
+				code(c)
+			}
+			cur.WriteByte(c)
+			i++
+		}
+	}
+	if depth > 0 || mongoContinues(last, prev) {
+		return stmts, cur.String()
+	}
+	flush()
+	return stmts, ""
+}
+
+// isMongoShellCommand 行首为 shell 命令, 且不是同名的函数调用或变量, 如 exit()
+func isMongoShellCommand(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !mongoShellCommands[strings.TrimSuffix(fields[0], ";")] {
+		return false
+	}
+	return len(fields) == 1 || strings.IndexByte("(=.", fields[1][0]) < 0
+}
+
+// mongoContinues 行尾为运算符时语句未结束, 后缀的 ++、-- 除外
+func mongoContinues(last, prev byte) bool {
+	if last == 0 || strings.IndexByte(mongoContinuationChars, last) < 0 {
+		return false
+	}
+	return !((last == '+' || last == '-') && prev == last)
+}
+
+// skipMongoQuoted 返回字符串结束之后的位置, 字符串不能跨行, 行尾的反斜杠续行时未结束
+func skipMongoQuoted(s string, i int) (int, bool) {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			if j+1 == len(s) {
+				return len(s), false
+			}
+			j++
+		case quote:
+			return j + 1, true
+		case '\n':
+			// 未结束的字符串为语法错误, 客户端直接执行
+			return j, true
+		}
+	}
+	return len(s), true
+}
+
+// skipMongoTemplate 模板字符串可以跨行
+func skipMongoTemplate(s string, i int) (int, bool) {
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '`':
+			return j + 1, true
+		}
+	}
+	return len(s), false
+}
+
+// skipMongoRegex 正则表达式在同一行中结束, 没有结束时按除号处理
+func skipMongoRegex(s string, i int) int {
+	inClass := false
+	for j := i + 1; j < len(s) && s[j] != '\n'; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '/':
+			if inClass {
+				continue
+			}
+			for j++; j < len(s) && isSQLWordByte(s[j]); j++ {
+			}
+			return j
+		}
+	}
+	return i + 1
+}
+
+type mongoTokenKind int
+
+const (
+	mongoTokenIdent mongoTokenKind = iota
+	mongoTokenString
+	mongoTokenSymbol
+	mongoTokenOther
+)
+
+type mongoToken struct {
+	kind mongoTokenKind
+	text string
+}
+
+// tokenizeMongo 跳过注释, 字符串为转义后的内容, 数字、正则和带 ${} 的模板字符串不区分
+func tokenizeMongo(s string) []mongoToken {
+	var tokens []mongoToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case isSQLSpace(c):
+			i++
+		case strings.HasPrefix(s[i:], "//"):
+			i = sqlLineEnd(s, i)
+		case strings.HasPrefix(s[i:], "/*"):
+			if n := strings.Index(s[i+2:], "*/"); n >= 0 {
+				i += n + 4
+			} else {
+				i = len(s)
+			}
+		case c == '\'' || c == '"':
+			end, _ := skipMongoQuoted(s, i)
+			tokens = append(tokens, mongoToken{kind: mongoTokenString, text: unquoteMongo(s[i:end])})
+			i = end
+		case c == '`':
+			end, _ := skipMongoTemplate(s, i)
+			kind := mongoTokenString
+			if strings.Contains(s[i:end], "${") {
+				kind = mongoTokenOther
+			}
+			tokens = append(tokens, mongoToken{kind: kind, text: unquoteMongo(s[i:end])})
+			i = end
+		case c == '/' && (len(tokens) == 0 || tokens[len(tokens)-1].kind == mongoTokenSymbol &&
+			strings.Contains(mongoRegexPrecedingChars, tokens[len(tokens)-1].text)):
+			end := skipMongoRegex(s, i)
+			tokens = append(tokens, mongoToken{kind: mongoTokenOther, text: s[i:end]})
+			i = end
+		case isSQLWordByte(c):
+			end := i + 1
+			for end < len(s) && isSQLWordByte(s[end]) {
+				end++
+			}
+			kind := mongoTokenIdent
+			if '0' <= c && c <= '9' {
+				kind = mongoTokenOther
+			}
+			tokens = append(tokens, mongoToken{kind: kind, text: s[i:end]})
+			i = end
+		default:
+			tokens = append(tokens, mongoToken{kind: mongoTokenSymbol, text: s[i : i+1]})
+			i++
+		}
+	}
+	return tokens
+}
+
+// unquoteMongo 去掉引号, 处理常用的转义字符
+func unquoteMongo(s string) string {
+	if len(s) < 2 {
+		return ""
+	}
+	quote := s[0]
+	s = s[1:]
+	if s[len(s)-1] == quote {
+		s = s[:len(s)-1]
+	}
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '\n':
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// mongoCall db 开始的调用链, 如 db.getSiblingDB("d").users.deleteMany({})
+type mongoCall struct {
+	database   string
+	collection string
+	method     string
+	args       [][]mongoToken
+}
+
+func analyzeMongo(text string) *SQLStatement {
+	stmt := SQLStatement{Text: text, Rows: -1}
+	if isMongoShellCommand(text) {
+		stmt.Type = strings.ToUpper(strings.TrimSuffix(strings.Fields(text)[0], ";"))
+		return &stmt
+	}
+	tokens := tokenizeMongo(text)
+	var (
+		main     *mongoCall
+		mainType string
+	)
+	for i, tok := range tokens {
+		if tok.kind != mongoTokenIdent || i > 0 && tokens[i-1].text == "." {
+			continue
+		}
+		if tok.text != "db" {
+			if t, ok := mongoGlobalFunctions[tok.text]; ok && stmt.Type == "" &&
+				i+1 < len(tokens) && tokens[i+1].text == "(" {
+				stmt.Type = t
+			}
+			continue
+		}
+		call := parseMongoCall(tokens, i+1)
+		if call.method == "" {
+			continue
+		}
+		typ, _, tables := call.analyze()
+		stmt.Tables = appendUniqueFold(stmt.Tables, tables...)
+		if main == nil || mainType == "SELECT" && typ != "SELECT" {
+			main, mainType = call, typ
+		}
+	}
+	if main == nil {
+		return &stmt
+	}
+	stmt.Type, stmt.Object, _ = main.analyze()
+	first := main.arg(0)
+	switch stmt.Type {
+	case "SELECT", "UPDATE", "DELETE":
+		// 第一个参数为查询条件
+		stmt.HasWhere = len(first) > 0 && !(len(first) == 2 && first[0].text == "{" && first[1].text == "}")
+	}
+	switch {
+	case strings.HasSuffix(main.method, "One") || strings.HasPrefix(main.method, "findOneAnd"):
+		stmt.Rows = 1
+	case stmt.Type == "INSERT":
+		stmt.Rows = mongoArrayLength(first)
+	}
+	return &stmt
+}
+
+// parseMongoCall 从 db 之后解析调用链, 到第一个不是切换数据库或集合的方法调用为止
+func parseMongoCall(tokens []mongoToken, i int) *mongoCall {
+	var (
+		call  mongoCall
+		names []string
+	)
+	for i < len(tokens) {
+		var name string
+		switch {
+		case tokens[i].text == "." && i+1 < len(tokens) && tokens[i+1].kind == mongoTokenIdent:
+			name = tokens[i+1].text
+			i += 2
+		case tokens[i].text == "[" && i+2 < len(tokens) && tokens[i+1].kind == mongoTokenString &&
+			tokens[i+2].text == "]":
+			name = tokens[i+1].text
+			i += 3
+		default:
+			call.collection = strings.Join(names, ".")
+			return &call
+		}
+		if i >= len(tokens) || tokens[i].text != "(" {
+			names = append(names, name)
+			continue
+		}
+		args, next := mongoCallArgs(tokens, i)
+		i = next
+		lit := mongoStringArg(args, 0)
+		switch {
+		case name == "getMongo" && len(names) == 0:
+			continue
+		case (name == "getSiblingDB" || name == "getSisterDB" || name == "getDB") && len(names) == 0 && lit != "":
+			call.database = lit
+			continue
+		case name == "getCollection" && len(names) == 0 && lit != "":
+			names = append(names, lit)
+			continue
+		}
+		call.collection = strings.Join(names, ".")
+		call.method = name
+		call.args = args
+		return &call
+	}
+	call.collection = strings.Join(names, ".")
+	return &call
+}
+
+// analyze 返回调用的语句类型、对象类型和涉及的集合
+func (c *mongoCall) analyze() (string, string, []string) {
+	var (
+		kind       string
+		ok         bool
+		collection = c.collection
+		tables     []string
+	)
+	if collection != "" {
+		kind, ok = mongoCollectionMethods[c.method]
+	} else {
+		kind, ok = mongoDatabaseMethods[c.method]
+	}
+	if !ok {
+		kind = strings.ToUpper(c.method)
+	}
+	switch c.method {
+	case "createCollection", "createView":
+		if collection == "" {
+			collection = mongoStringArg(c.args, 0)
+		}
+	}
+	if collection != "" {
+		tables = append(tables, c.qualify(collection))
+	}
+	if target := mongoStringArg(c.args, 0); c.method == "renameCollection" && target != "" {
+		tables = append(tables, c.qualify(target))
+	}
+	parts := strings.SplitN(kind, " ", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1], tables
+	}
+	return parts[0], "", tables
+}
+
+func (c *mongoCall) qualify(collection string) string {
+	if c.database != "" {
+		return c.database + "." + collection
+	}
+	return collection
+}
+
+func (c *mongoCall) arg(i int) []mongoToken {
+	if i < len(c.args) {
+		return c.args[i]
+	}
+	return nil
+}
+
+// mongoCallArgs 读取括号中的参数, 返回按顶层的逗号分隔的参数和括号结束之后的位置
+func mongoCallArgs(tokens []mongoToken, i int) ([][]mongoToken, int) {
+	var (
+		args  [][]mongoToken
+		cur   []mongoToken
+		depth int
+	)
+	for i++; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.kind == mongoTokenSymbol {
+			switch tok.text {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				if depth == 0 {
+					if len(cur) > 0 || len(args) > 0 {
+						args = append(args, cur)
+					}
+					return args, i + 1
+				}
+				depth--
+			case ",":
+				if depth == 0 {
+					args = append(args, cur)
+					cur = nil
+					continue
+				}
+			}
+		}
+		cur = append(cur, tok)
+	}
+	if len(cur) > 0 {
+		args = append(args, cur)
+	}
+	return args, i
+}
+
+// mongoStringArg 参数为字符串常量时返回字符串
+func mongoStringArg(args [][]mongoToken, i int) string {
+	if i < len(args) && len(args[i]) == 1 && args[i][0].kind == mongoTokenString {
+		return args[i][0].text
+	}
+	return ""
+}
+
+// mongoArrayLength 数组常量的元素个数, 不是数组时插入一个文档, 无法判断时返回 -1
+func mongoArrayLength(arg []mongoToken) int {
+	if len(arg) == 0 {
+		return -1
+	}
+	if arg[0].text == "{" {
+		return 1
+	}
+	if arg[0].text != "[" || arg[len(arg)-1].text != "]" {
+		return -1
+	}
+	items := 0
+	depth := 0
+	for _, tok := range arg[1 : len(arg)-1] {
+		if items == 0 {
+			items = 1
+		}
+		if tok.kind != mongoTokenSymbol {
+			continue
+		}
+		switch tok.text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		case ",":
+			if depth == 0 {
+				items++
+			}
+		}
+	}
+	return items
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMongoDBStatementBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		expect [][]string
+	}{
+		{"single line", []string{"db.users.find()"}, [][]string{{"db.users.find()"}}},
+		{"multi line", []string{"db.users.deleteMany({", "  age: { $lt: 18 }", "})"},
+			[][]string{nil, nil, {"db.users.deleteMany({\n  age: { $lt: 18 }\n})"}}},
+		{"semicolon", []string{"db.a.find(); db.b.drop() // x;"}, [][]string{{"db.a.find()", "db.b.drop() // x;"}}},
+		{"trailing operator", []string{"db.users.", "drop()", "x++", "y = 1 +"},
+			[][]string{nil, {"db.users.\ndrop()"}, {"x++"}, nil}},
+		{"quoted", []string{`db.a.find({n: "a;b(\"", m: '}'})`}, [][]string{{`db.a.find({n: "a;b(\"", m: '}'})`}}},
+		{"template and comment", []string{"db.a.find({n: `a", "b`}) /*", "*/"},
+			[][]string{nil, nil, {"db.a.find({n: `a\nb`}) /*\n*/"}}},
+		{"regex", []string{"db.a.find({n: /[(/]/i})"}, [][]string{{"db.a.find({n: /[(/]/i})"}}},
+		{"comment only", []string{"// db.a.drop()"}, [][]string{nil}},
+		{"shell command", []string{"use prod", "show collections", "exit()"},
+			[][]string{{"use prod"}, {"show collections"}, {"exit()"}}},
+	}
+	for _, tt := range tests {
+		buf := newMongoDBStatementBuffer()
+		for i, line := range tt.lines {
+			var expect []string
+			if i < len(tt.expect) {
+				expect = tt.expect[i]
+			}
+			if got := buf.Append(line); !reflect.DeepEqual(got, expect) {
+				t.Errorf("%s: line %d expect %q, got %q", tt.name, i, expect, got)
+			}
+		}
+	}
+}
+
+func TestAnalyzeMongo(t *testing.T) {
+	tests := []struct {
+		text   string
+		expect SQLStatement
+	}{
+		{"use prod", SQLStatement{Type: "USE", Rows: -1}},
+		{"db.users.find({age: 1})", SQLStatement{Type: "SELECT", Tables: []string{"users"}, HasWhere: true, Rows: -1}},
+		{"db.users.deleteMany({})", SQLStatement{Type: "DELETE", Tables: []string{"users"}, Rows: -1}},
+		{"db.users.deleteOne({_id: 1})", SQLStatement{Type: "DELETE", Tables: []string{"users"}, HasWhere: true, Rows: 1}},
+		{"db.users.updateMany( { }, {$set: {a: 1}})", SQLStatement{Type: "UPDATE", Tables: []string{"users"}, Rows: -1}},
+		{"db.users.drop()", SQLStatement{Type: "DROP", Object: "COLLECTION", Tables: []string{"users"}, Rows: -1}},
+		{`db["app.logs"].drop()`, SQLStatement{Type: "DROP", Object: "COLLECTION", Tables: []string{"app.logs"}, Rows: -1}},
+		{`db.getSiblingDB("prod").getCollection("users").remove()`,
+			SQLStatement{Type: "DELETE", Tables: []string{"prod.users"}, Rows: -1}},
+		{"db.dropDatabase()", SQLStatement{Type: "DROP", Object: "DATABASE", Rows: -1}},
+		{`db.createCollection("logs")`, SQLStatement{Type: "CREATE", Object: "COLLECTION", Tables: []string{"logs"}, Rows: -1}},
+		{`db.users.renameCollection("old")`,
+			SQLStatement{Type: "RENAME", Object: "COLLECTION", Tables: []string{"users", "old"}, Rows: -1}},
+		{"db.users.insertMany([{a: 1}, {a: [2, 3]}])", SQLStatement{Type: "INSERT", Tables: []string{"users"}, Rows: 2}},
+		{"db.runCommand({ping: 1})", SQLStatement{Type: "RUNCOMMAND", Rows: -1}},
+		{"db.a.find().forEach(d => db.b.deleteOne(d))",
+			SQLStatement{Type: "DELETE", Tables: []string{"a", "b"}, HasWhere: true, Rows: 1}},
+		{`print("db.users.drop()")`, SQLStatement{Rows: -1}},
+		{`load("/tmp/a.js")`, SQLStatement{Type: "SOURCE", Rows: -1}},
+	}
+	for _, tt := range tests {
+		got := newMongoDBStatementBuffer().Analyze(tt.text)
+		tt.expect.Text = tt.text
+		if !reflect.DeepEqual(*got, tt.expect) {
+			t.Errorf("%q: expect %+v, got %+v", tt.text, tt.expect, *got)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/config"
+	"github.com/jumpserver/koko/pkg/i18n"
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+	"github.com/jumpserver/koko/pkg/logger"
+	"github.com/jumpserver/koko/pkg/mysqld"
+)
+
+/*
+	MySQL 协议代理: 客户端(DBeaver、JDBC、mysql 等)使用 JumpServer 用户名和连接 token (作为密码)登录 koko 的 MySQL 端口,
+	koko 使用系统用户的认证信息登录后端数据库(或者网关的本地隧道), 然后按命令转发:
+	  COM_QUERY、COM_STMT_PREPARE、COM_INIT_DB 中的语句按数据库的命令过滤规则匹配, 拒绝时回复 ERR 包, 不发送到后端
+	  COM_QUERY 和 COM_STMT_EXECUTE 执行的语句记录到命令记录, 输出为结果的摘要, 如 "3 rows in set"
+	  COM_CHANGE_USER 和 binlog 复制相关的命令不支持
+	没有终端, 不支持命令复核(confirm 规则不生效)和需要复核的登录, 也不生成录像
+*/
+
+var errMySQLWireLoginConfirm = errors.New("login confirm is required")
+
+// ConnectMySQLWire 使用 MySQL 协议代理, 不需要安装 mysql 客户端
+func ConnectMySQLWire() ConnectionOption {
+	return func(opts *ConnectionOptions) {
+		opts.mysqlWire = true
+	}
+}
+
+// ProxyMySQLWire 代理已经完成握手的客户端, 登录后端成功后回复客户端 OK, 失败时回复 ERR
+func (s *Server) ProxyMySQLWire(client *mysqld.Conn, resp *mysqld.HandshakeResponse) {
+	if s.systemUserAuthInfo.Username == "" {
+		logger.Errorf("Conn[%s]: system user %s has no username", s.UserConn.ID(), s.connOpts.systemUser.Name)
+		_ = client.WriteError(mysqld.NewError(mysqld.ErAccessDenied, mysqld.StateAccessDenied,
+			"%s", i18n.T("Get auth username failed")))
+		return
+	}
+	if err := s.checkMySQLWireLoginConfirm(); err != nil {
+		logger.Errorf("Conn[%s]: check login confirm failed: %s", s.UserConn.ID(), err)
+		_ = client.WriteError(mysqld.NewError(mysqld.ErAccessDenied, mysqld.StateAccessDenied, "%s", err))
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sw := SwitchSession{
+		ID:            s.ID,
+		MaxIdleTime:   s.terminalConf.MaxIdleTime,
+		keepAliveTime: 60,
+		ctx:           ctx,
+		cancel:        cancel,
+		p:             s,
+	}
+	if err := s.CreateSessionCallback(); err != nil {
+		logger.Errorf("Conn[%s] submit session %s to core server err: %s", s.UserConn.ID(), s.ID, err)
+		_ = client.WriteError(mysqld.NewError(mysqld.ErUnknownError, mysqld.StateUnknown,
+			"%s", i18n.T("Connect with api server failed")))
+		return
+	}
+	AddCommonSwitch(&sw)
+	defer RemoveCommonSwitch(&sw)
+	defer func() {
+		if err := s.DisConnectedCallback(); err != nil {
+			logger.Errorf("Conn[%s] update session %s err: %+v", s.UserConn.ID(), s.ID, err)
+		}
+	}()
+	host := s.connOpts.dbApp.Attrs.Host
+	port := s.connOpts.dbApp.Attrs.Port
+	if s.domainGateways != nil && len(s.domainGateways.Gateways) != 0 {
+		dGateway, err := s.createAvailableGateWay(s.domainGateways)
+		if err == nil {
+			err = dGateway.Start()
+		}
+		if err != nil {
+			msg := fmt.Sprintf(i18n.T("Start domain gateway failed %s"), err)
+			logger.Error(msg)
+			_ = client.WriteError(mysqld.NewError(mysqld.CrConnectionError, mysqld.StateUnknown, "%s", msg))
+			return
+		}
+		defer dGateway.Stop()
+		host = "127.0.0.1"
+		port = dGateway.GetListenAddr().Port
+	}
+	backend, err := s.dialMySQLWireBackend(net.JoinHostPort(host, strconv.Itoa(port)), resp)
+	if err != nil {
+		logger.Errorf("Conn[%s] %s error: %s", s.UserConn.ID(), s.connOpts.ConnectMsg(), err)
+		if err2 := s.ConnectedFailedCallback(err); err2 != nil {
+			logger.Errorf("Conn[%s] update session err: %s", s.UserConn.ID(), err2)
+		}
+		var backendErr *mysqld.Error
+		if !errors.As(err, &backendErr) {
+			backendErr = mysqld.NewError(mysqld.CrConnectionError, mysqld.StateUnknown,
+				"%s", ConvertErrorToReadableMsg(err))
+		}
+		_ = client.WriteError(backendErr)
+		return
+	}
+	defer backend.Close()
+
+	logger.Infof("Conn[%s] create session %s success", s.UserConn.ID(), s.ID)
+	if err2 := s.ConnectedSuccessCallback(); err2 != nil {
+		logger.Errorf("Conn[%s] update session %s err: %s", s.UserConn.ID(), s.ID, err2)
+	}
+	if err = client.WriteOK(0, 0, mysqld.StatusAutocommit); err != nil {
+		logger.Errorf("Conn[%s] write mysql ok packet err: %s", s.UserConn.ID(), err)
+		return
+	}
+	session := mysqlWireSession{
+		sw:       &sw,
+		client:   client,
+		backend:  backend,
+		rules:    newDBFilterRules(s.filterRules, s.sqlFilterRules),
+		recorder: s.GetCommandRecorder(),
+		user: CurrentActiveUser{
+			UserId:     s.connOpts.user.ID,
+			User:       s.connOpts.user.String(),
+			RemoteAddr: s.UserConn.RemoteAddr(),
+		},
+		stmts: make(map[uint32]string),
+	}
+	defer session.recorder.End()
+	session.run()
+}
+
+func (s *Server) dialMySQLWireBackend(addr string, resp *mysqld.HandshakeResponse) (*mysqld.Conn, error) {
+	timeout := time.Duration(config.GetConf().SSHTimeout) * time.Second
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	backend := mysqld.NewConn(conn)
+	// 客户端没有指定数据库时使用应用中配置的数据库
+	database := resp.Database
+	if database == "" {
+		database = s.connOpts.dbApp.Attrs.Database
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	err = backend.ClientHandshake(mysqld.ClientConfig{
+		Username:     s.systemUserAuthInfo.Username,
+		Password:     s.systemUserAuthInfo.Password,
+		Database:     database,
+		Capabilities: resp.Capabilities,
+		Charset:      resp.Charset,
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return backend, nil
+}
+
+// checkMySQLWireLoginConfirm 协议代理没有终端, 不能等待登录复核
+func (s *Server) checkMySQLWireLoginConfirm() error {
+	srv := s.newLoginConfirmService()
+	ok, err := srv.CheckIsNeedLoginConfirm()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrAPIFailed, err)
+	}
+	if ok {
+		return errMySQLWireLoginConfirm
+	}
+	return nil
+}
+
+type mysqlWireSession struct {
+	sw       *SwitchSession
+	client   *mysqld.Conn
+	backend  *mysqld.Conn
+	rules    []SQLFilterRule
+	recorder *CommandRecorder
+	user     CurrentActiveUser
+
+	// stmts 预处理语句 ID 对应的语句, 执行时记录
+	stmts map[uint32]string
+	// lastActive 最后一次执行命令的时间(unix 秒)
+	lastActive int64
+}
+
+func (m *mysqlWireSession) run() {
+	atomic.StoreInt64(&m.lastActive, time.Now().Unix())
+	done := make(chan struct{})
+	defer close(done)
+	go m.watch(done)
+	for {
+		m.client.ResetSequence()
+		packet, err := m.client.ReadPacket()
+		if err != nil {
+			logger.Infof("Session[%s] mysql client read end: %s", m.sw.ID, err)
+			return
+		}
+		atomic.StoreInt64(&m.lastActive, time.Now().Unix())
+		if len(packet) == 0 {
+			continue
+		}
+		if err = m.handleCommand(packet); err != nil {
+			logger.Errorf("Session[%s] mysql command 0x%02x err: %s", m.sw.ID, packet[0], err)
+			return
+		}
+		if packet[0] == mysqld.ComQuit {
+			return
+		}
+	}
+}
+
+// watch 空闲超时、权限过期或者管理员终止时断开连接
+func (m *mysqlWireSession) watch(done chan struct{}) {
+	maxIdleTime := time.Duration(m.sw.MaxIdleTime) * time.Minute
+	tick := time.NewTicker(30 * time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-tick.C:
+			lastActive := time.Unix(atomic.LoadInt64(&m.lastActive), 0)
+			switch {
+			case now.After(lastActive.Add(maxIdleTime)):
+				logger.Infof("Session[%s] idle more than %d minutes, disconnect", m.sw.ID, m.sw.MaxIdleTime)
+			case m.sw.p.CheckPermissionExpired(now):
+				logger.Infof("Session[%s] permission has expired, disconnect", m.sw.ID)
+			default:
+				continue
+			}
+		case <-m.sw.ctx.Done():
+			logger.Infof("Session[%s]: %s", m.sw.ID, i18n.T("Terminated by administrator"))
+		}
+		_ = m.client.Close()
+		_ = m.backend.Close()
+		return
+	}
+}
+
+func (m *mysqlWireSession) handleCommand(packet []byte) error {
+	var (
+		cmd   = packet[0]
+		query string
+	)
+	switch cmd {
+	case mysqld.ComQuery, mysqld.ComStmtPrepare:
+		query = string(packet[1:])
+	case mysqld.ComInitDB:
+		query = fmt.Sprintf("USE `%s`", packet[1:])
+	case mysqld.ComStmtExecute:
+		if len(packet) >= 5 {
+			query = m.stmts[uint32(packet[1])|uint32(packet[2])<<8|uint32(packet[3])<<16|uint32(packet[4])<<24]
+		}
+	case mysqld.ComStmtClose:
+		if len(packet) >= 5 {
+			delete(m.stmts, uint32(packet[1])|uint32(packet[2])<<8|uint32(packet[3])<<16|uint32(packet[4])<<24)
+		}
+	case mysqld.ComChangeUser, mysqld.ComBinlogDump, mysqld.ComBinlogDumpGTID, mysqld.ComRegisterSlave:
+		return m.client.WriteError(mysqld.NewError(mysqld.ErUnknownComError, mysqld.StateConnection,
+			"command 0x%02x is not supported by koko", cmd))
+	}
+	createdDate := time.Now()
+	var rule model.SystemUserFilterRule
+	if query != "" && cmd != mysqld.ComStmtExecute {
+		var (
+			matchedCmd string
+			ok         bool
+		)
+		rule, matchedCmd, ok = matchSQLFilterRules(m.rules, analyzeWireSQL(query))
+		switch {
+		case ok && rule.Action == model.ActionDeny:
+			msg := fmt.Sprintf(i18n.T("Command `%s` is forbidden"), matchedCmd)
+			m.record(&ExecutedCommand{
+				Command:     query,
+				Output:      msg,
+				CreatedDate: createdDate,
+				RiskLevel:   model.HighRiskFlag,
+				RuleID:      rule.ID,
+			})
+			return m.client.WriteError(mysqld.NewError(mysqld.ErSpecificAccessDenied,
+				mysqld.StateSyntaxOrAccess, "%s", msg))
+		case ok && rule.Action == model.ActionAudit:
+			logger.Infof("DB Session %s: command `%s` matched audit rule %s",
+				m.sw.ID, RedactCommand(query), rule.ID)
+		default:
+			rule = model.SystemUserFilterRule{}
+		}
+	}
+	m.backend.ResetSequence()
+	if err := m.backend.WritePacket(packet); err != nil {
+		return err
+	}
+	result, err := mysqld.RelayResponse(m.client, m.backend, cmd)
+	if err != nil {
+		return err
+	}
+	switch cmd {
+	case mysqld.ComStmtPrepare:
+		// 预处理时匹配规则, 执行时记录
+		if result.Err == nil {
+			m.stmts[result.StatementID] = query
+		}
+		return nil
+	case mysqld.ComQuery, mysqld.ComStmtExecute, mysqld.ComInitDB:
+		if query == "" {
+			return nil
+		}
+		riskLevel := model.LessRiskFlag
+		if rule.Action == model.ActionAudit {
+			riskLevel = model.WarnRiskFlag
+		}
+		m.record(&ExecutedCommand{
+			Command:     query,
+			Output:      result.String(),
+			CreatedDate: createdDate,
+			RiskLevel:   riskLevel,
+			RuleID:      rule.ID,
+		})
+	}
+	return nil
+}
+
+func (m *mysqlWireSession) record(item *ExecutedCommand) {
+	item.User = m.user
+	item.Command = RedactCommand(item.Command)
+	cmd, fullOutput := m.sw.generateCommandResult(item)
+	if fullOutput != "" {
+		m.recorder.RecordWithOutput(cmd, fullOutput)
+		return
+	}
+	m.recorder.Record(cmd)
+}
+
+// analyzeWireSQL 切分客户端发送的语句, 不需要处理 mysql 客户端的命令, 最后一个语句可以没有分号
+func analyzeWireSQL(query string) []*SQLStatement {
+	buf := newSQLStatementBuffer()
+	texts := buf.Append(query)
+	if rest := strings.TrimSpace(buf.pending); rest != "" {
+		texts = append(texts, rest)
+	}
+	stmts := make([]*SQLStatement, 0, len(texts))
+	for _, text := range texts {
+		stmts = append(stmts, analyzeSQL(text))
+	}
+	return stmts
+}
@@ -0,0 +1,18 @@
+package proxy
+
+import (
+	"testing"
+)
+
+func TestAnalyzeWireSQL(t *testing.T) {
+	stmts := analyzeWireSQL("select * from t1 where id = ';'; delete from t2")
+	if len(stmts) != 2 {
+		t.Fatalf("expect 2 statements, got %d", len(stmts))
+	}
+	if stmts[0].Type != "SELECT" || stmts[1].Type != "DELETE" || stmts[1].HasWhere {
+		t.Errorf("unexpected statements %+v %+v", stmts[0], stmts[1])
+	}
+	if stmts = analyzeWireSQL("  "); len(stmts) != 0 {
+		t.Errorf("expect no statement, got %d", len(stmts))
+	}
+}
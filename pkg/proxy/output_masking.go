@@ -0,0 +1,374 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/config"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	输出脱敏: 录像、命令输出中的敏感数据替换为 ****, 可选对共享会话中的其他用户脱敏, 会话用户自己的终端不受影响。
+	由 OUTPUT_MASKING_DETECTORS 选择内置的检测器:
+	  aws_access_key: AWS Access Key ID, 如 AKIA...
+	  aws_secret_key: aws_secret_access_key = ... 中的 Secret Access Key
+	  jwt: JSON Web Token
+	  private_key: PEM 格式的私钥, 只替换 BEGIN 和 END 之间的内容
+	  credit_card: 13-19 位的银行卡号, 需要通过 Luhn 校验
+	OUTPUT_MASKING_PATTERNS 为自定义的检测器, 名称: 正则, 正则有分组时只替换第一个分组。
+	录像按行处理, 没有换行的数据最多延迟 outputMaskFlushDelay 写入。
+*/
+
+const (
+	outputMaskReplacement = "****"
+
+	// 没有换行时最多缓存的数据, 超过后直接处理
+	outputMaskMaxPending = 32 * 1024
+	outputMaskFlushDelay = 100 * time.Millisecond
+
+	maskDetectorPrivateKey = "private_key"
+)
+
+var (
+	pemBeginPattern   = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)
+	pemEndPattern     = regexp.MustCompile(`-----END [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)
+	pemContentPattern = regexp.MustCompile(`[A-Za-z0-9+/=]{4,}`)
+)
+
+var builtinMaskDetectors = map[string]*MaskDetector{
+	"aws_access_key": {
+		Name:    "aws_access_key",
+		pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA|AIPA)[0-9A-Z]{16}\b`),
+	},
+	"aws_secret_key": {
+		Name:    "aws_secret_key",
+		pattern: regexp.MustCompile(`(?i)aws_?secret_?(?:access_?)?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`),
+	},
+	"jwt": {
+		Name:    "jwt",
+		pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{10,}`),
+	},
+	"credit_card": {
+		Name:     "credit_card",
+		pattern:  regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		validate: luhnValid,
+	},
+}
+
+// MaskDetector 检测输出中的敏感数据
+type MaskDetector struct {
+	Name string
+
+	pattern  *regexp.Regexp
+	validate func(value string) bool
+}
+
+func NewMaskDetector(name, pattern string) (*MaskDetector, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("masking pattern %s invalid: %w", name, err)
+	}
+	return &MaskDetector{Name: name, pattern: re}, nil
+}
+
+// mask 替换匹配的内容, 返回替换的次数
+func (d *MaskDetector) mask(b []byte) ([]byte, int) {
+	matches := d.pattern.FindAllSubmatchIndex(b, -1)
+	if len(matches) == 0 {
+		return b, 0
+	}
+	var (
+		buf   bytes.Buffer
+		last  int
+		count int
+	)
+	for _, loc := range matches {
+		start, end := loc[0], loc[1]
+		// 有分组时只替换第一个分组
+		if len(loc) >= 4 && loc[2] >= 0 {
+			start, end = loc[2], loc[3]
+		}
+		if start < last || start == end {
+			continue
+		}
+		if d.validate != nil && !d.validate(string(b[start:end])) {
+			continue
+		}
+		buf.Write(b[last:start])
+		buf.WriteString(outputMaskReplacement)
+		last = end
+		count++
+	}
+	if count == 0 {
+		return b, 0
+	}
+	buf.Write(b[last:])
+	return buf.Bytes(), count
+}
+
+// luhnValid 忽略空格和 -, 校验 13-19 位卡号
+func luhnValid(value string) bool {
+	var digits []byte
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c-'0')
+		case c == ' ' || c == '-':
+		default:
+			return false
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range digits {
+		d := int(digits[len(digits)-1-i])
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+type OutputMasker struct {
+	detectors  []*MaskDetector
+	privateKey bool
+}
+
+// NewOutputMasker names 为内置检测器的名称, patterns 为自定义检测器
+func NewOutputMasker(names []string, patterns map[string]string) (*OutputMasker, error) {
+	var m OutputMasker
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == maskDetectorPrivateKey {
+			m.privateKey = true
+			continue
+		}
+		detector, ok := builtinMaskDetectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown masking detector %s", name)
+		}
+		m.detectors = append(m.detectors, detector)
+	}
+	customNames := make([]string, 0, len(patterns))
+	for name := range patterns {
+		customNames = append(customNames, name)
+	}
+	sort.Strings(customNames)
+	for _, name := range customNames {
+		detector, err := NewMaskDetector(name, patterns[name])
+		if err != nil {
+			return nil, err
+		}
+		m.detectors = append(m.detectors, detector)
+	}
+	return &m, nil
+}
+
+// MaskString 处理完整的文本, 如命令的输出
+func (m *OutputMasker) MaskString(s string) string {
+	return string(m.newStream().mask([]byte(s)))
+}
+
+func (m *OutputMasker) maskText(b []byte, counts map[string]int) []byte {
+	for _, detector := range m.detectors {
+		var n int
+		if b, n = detector.mask(b); n > 0 {
+			counts[detector.Name] += n
+		}
+	}
+	return b
+}
+
+func (m *OutputMasker) newStream() *maskStream {
+	return &maskStream{masker: m, counts: make(map[string]int)}
+}
+
+// maskStream 按行处理输出流, 私钥可以跨行
+type maskStream struct {
+	masker  *OutputMasker
+	pending []byte
+	inKey   bool
+
+	// counts 各检测器的替换次数
+	counts map[string]int
+}
+
+// Write 返回可以写入的脱敏数据, 最后一个换行之后的数据缓存到下次写入或 Flush
+func (s *maskStream) Write(p []byte) []byte {
+	s.pending = append(s.pending, p...)
+	i := bytes.LastIndexByte(s.pending, '\n')
+	if i < 0 {
+		if len(s.pending) < outputMaskMaxPending {
+			return []byte{}
+		}
+		i = len(s.pending) - 1
+	}
+	out := s.mask(s.pending[:i+1])
+	s.pending = append(s.pending[:0:0], s.pending[i+1:]...)
+	return out
+}
+
+func (s *maskStream) Pending() bool {
+	return len(s.pending) > 0
+}
+
+func (s *maskStream) Flush() []byte {
+	out := s.mask(s.pending)
+	s.pending = nil
+	return out
+}
+
+func (s *maskStream) mask(data []byte) []byte {
+	var buf bytes.Buffer
+	for len(data) > 0 {
+		if s.inKey {
+			loc := pemEndPattern.FindIndex(data)
+			if loc == nil {
+				buf.Write(pemContentPattern.ReplaceAll(data, []byte(outputMaskReplacement)))
+				break
+			}
+			buf.Write(pemContentPattern.ReplaceAll(data[:loc[0]], []byte(outputMaskReplacement)))
+			buf.Write(data[loc[0]:loc[1]])
+			data = data[loc[1]:]
+			s.inKey = false
+			continue
+		}
+		var loc []int
+		if s.masker.privateKey {
+			loc = pemBeginPattern.FindIndex(data)
+		}
+		if loc == nil {
+			buf.Write(s.masker.maskText(data, s.counts))
+			break
+		}
+		buf.Write(s.masker.maskText(data[:loc[0]], s.counts))
+		buf.Write(data[loc[0]:loc[1]])
+		data = data[loc[1]:]
+		s.counts[maskDetectorPrivateKey]++
+		s.inKey = true
+	}
+	return buf.Bytes()
+}
+
+// Summary 会话中各检测器的替换次数, 用于日志
+func (s *maskStream) Summary() string {
+	names := make([]string, 0, len(s.counts))
+	for name := range s.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	items := make([]string, 0, len(names))
+	for _, name := range names {
+		items = append(items, fmt.Sprintf("%s=%d", name, s.counts[name]))
+	}
+	return strings.Join(items, ", ")
+}
+
+var (
+	outputMasker     *OutputMasker
+	outputMaskerOnce sync.Once
+)
+
+// GetOutputMasker 根据配置文件生成, 没有开启或配置错误时返回 nil
+func GetOutputMasker() *OutputMasker {
+	outputMaskerOnce.Do(func() {
+		conf := config.GetConf()
+		if !conf.EnableOutputMasking {
+			return
+		}
+		masker, err := NewOutputMasker(conf.OutputMaskingDetectors, conf.OutputMaskingPatterns)
+		if err != nil {
+			logger.Errorf("Output masking config err: %s", err)
+			return
+		}
+		outputMasker = masker
+	})
+	return outputMasker
+}
+
+// sessionOutputMasker 会话输出流的脱敏, 为 nil 时不脱敏
+type sessionOutputMasker struct {
+	sessionID string
+	stream    *maskStream
+	// shared 对共享会话中的其他用户脱敏
+	shared bool
+
+	timer   *time.Timer
+	waiting bool
+}
+
+func newSessionOutputMasker(sessionID string) *sessionOutputMasker {
+	masker := GetOutputMasker()
+	if masker == nil {
+		return nil
+	}
+	timer := time.NewTimer(outputMaskFlushDelay)
+	timer.Stop()
+	return &sessionOutputMasker{
+		sessionID: sessionID,
+		stream:    masker.newStream(),
+		shared:    config.GetConf().OutputMaskingShared,
+		timer:     timer,
+	}
+}
+
+// Write 返回脱敏后的数据, 缓存的数据在 FlushC 触发后通过 Flush 获取
+func (m *sessionOutputMasker) Write(p []byte) []byte {
+	if m == nil {
+		return p
+	}
+	out := m.stream.Write(p)
+	if m.stream.Pending() && !m.waiting {
+		m.timer.Reset(outputMaskFlushDelay)
+		m.waiting = true
+	}
+	return out
+}
+
+func (m *sessionOutputMasker) FlushC() <-chan time.Time {
+	if m == nil {
+		return nil
+	}
+	return m.timer.C
+}
+
+func (m *sessionOutputMasker) Flush() []byte {
+	if m == nil {
+		return nil
+	}
+	m.waiting = false
+	return m.stream.Flush()
+}
+
+// WatcherBody 共享会话中其他用户收到的数据, 不脱敏时返回 nil
+func (m *sessionOutputMasker) WatcherBody(masked []byte) []byte {
+	if m == nil || !m.shared {
+		return nil
+	}
+	return masked
+}
+
+func (m *sessionOutputMasker) Close() {
+	if m == nil {
+		return
+	}
+	m.timer.Stop()
+	if summary := m.stream.Summary(); summary != "" {
+		logger.Infof("Session %s: masked sensitive output %s", m.sessionID, summary)
+	}
+}
@@ -14,32 +14,13 @@ import (
 	"github.com/jumpserver/koko/pkg/exchange"
 	"github.com/jumpserver/koko/pkg/i18n"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
-	"github.com/jumpserver/koko/pkg/jms-sdk-go/service"
 	"github.com/jumpserver/koko/pkg/logger"
+	"github.com/jumpserver/koko/pkg/srvconn"
 	"github.com/jumpserver/koko/pkg/utils"
 )
 
 var (
 	charEnter = []byte("\r")
-
-	enterMarks = [][]byte{
-		[]byte("\x1b[?1049h"),
-		[]byte("\x1b[?1048h"),
-		[]byte("\x1b[?1047h"),
-		[]byte("\x1b[?47h"),
-	}
-
-	exitMarks = [][]byte{
-		[]byte("\x1b[?1049l"),
-		[]byte("\x1b[?1048l"),
-		[]byte("\x1b[?1047l"),
-		[]byte("\x1b[?47l"),
-	}
-)
-
-const (
-	CommandInputParserName  = "Command Input parser"
-	CommandOutputParserName = "Command Output parser"
 )
 
 var _ ParseEngine = (*Parser)(nil)
@@ -47,7 +28,6 @@ var _ ParseEngine = (*Parser)(nil)
 type Parser struct {
 	id           string
 	protocolType string
-	jmsService   *service.JMService
 
 	userOutputChan chan []byte
 	srvOutputChan  chan []byte
@@ -61,16 +41,38 @@ type Parser struct {
 	once       *sync.Once
 	lock       *sync.RWMutex
 
-	command         string
-	output          string
-	cmdCreateDate   time.Time
-	cmdInputParser  *CmdParser
-	cmdOutputParser *CmdParser
+	command       string
+	output        string
+	cmdCreateDate time.Time
+	// cmdTracker 根据终端屏幕获取命令和输出
+	cmdTracker *vtCommandTracker
+
+	// 需要记录完整输出的命令
+	capture     *OutputCapturePolicy
+	fullCapture bool
 
 	cmdFilterRules []model.SystemUserFilterRule
-	closed         chan struct{}
+	// matchedRule 当前命令匹配的过滤规则, 记录到命令中
+	matchedRule model.SystemUserFilterRule
+	// auditBanner 命令匹配审计规则时给用户的提示, 为空时不提示
+	auditBanner string
+	closed      chan struct{}
+
+	// k8s 会话按 kubectl 命令匹配规则
+	k8sFilterRules []KubectlFilterRule
+	// k8sNamespace 会话当前的 namespace
+	k8sNamespace string
+	podLabels    kubeLabelResolver
+	// kubectlCommands 当前命令中的 kubectl 命令, 记录到命令中
+	kubectlCommands []*KubectlCommand
+
+	// burst 命令频率和粘贴突发检测
+	burst *burstDetector
 
 	confirmStatus commandConfirmStatus
+	confirmer     CommandConfirmer
+	// userID 会话用户, 共享会话中的其他用户可以复核命令
+	userID string
 
 	zmodemParser        *ZmodemParser
 	permAction          *model.Permission
@@ -79,6 +81,8 @@ type Parser struct {
 	abortedFileTransfer bool
 	currentActiveUser   CurrentActiveUser
 
+	// 回调在 ParseStream 之后注册, 使用 eventsLock 保护
+	eventsLock    sync.RWMutex
 	eventsFuncMap map[string]func()
 }
 
@@ -86,15 +90,22 @@ func (p *Parser) initial() {
 	p.once = new(sync.Once)
 	p.lock = new(sync.RWMutex)
 
-	p.cmdInputParser = NewCmdParser(p.id, CommandInputParserName)
-	p.cmdOutputParser = NewCmdParser(p.id, CommandOutputParserName)
+	if p.cmdTracker == nil {
+		p.cmdTracker = newVTCommandTracker(0, 0)
+	}
+	p.cmdTracker.SetMaxSize(p.outputMaxSize())
 	p.closed = make(chan struct{})
 	p.cmdRecordChan = make(chan *ExecutedCommand, 1024)
 	p.eventsFuncMap = make(map[string]func())
-	p.zmodemParser.fireStatusEvent = func(event string) {
-		if callback, ok := p.eventsFuncMap[event]; ok {
-			callback()
-		}
+	p.zmodemParser.fireStatusEvent = p.fireEvent
+}
+
+func (p *Parser) fireEvent(event string) {
+	p.eventsLock.RLock()
+	callback, ok := p.eventsFuncMap[event]
+	p.eventsLock.RUnlock()
+	if ok {
+		callback()
 	}
 }
 
@@ -202,6 +213,11 @@ func (p *Parser) parseInputState(b []byte) []byte {
 	}
 
 	if p.confirmStatus.InRunning() {
+		if ticket, ok := p.confirmStatus.GetTicket().(terminalConfirmTicket); ok &&
+			p.currentActiveUser.UserId != "" && p.currentActiveUser.UserId != p.userID {
+			ticket.HandleInput(p.currentActiveUser.User, b)
+			return nil
+		}
 		if p.confirmStatus.IsNeedCancel(b) {
 			logger.Infof("Session %s: user cancel confirm status", p.id)
 			p.srvOutputChan <- []byte("\r\n")
@@ -259,8 +275,22 @@ func (p *Parser) parseInputState(b []byte) []byte {
 		p.inputState = false
 		// 用户输入了Enter，开始结算命令
 		p.parseCmdInput()
-		if rule, cmd, ok := p.IsMatchCommandRule(p.command); ok {
+		if p.command != "" {
+			if burst := p.burst.Command(time.Now()); burst != nil {
+				p.handleCommandBurst(burst)
+			}
+		}
+		rule, cmd, ok := p.IsMatchCommandRule(p.command)
+		if p.command != "" && p.burst.TakeEscalation() && (!ok || model.ActionConfirm.StricterThan(rule.Action)) {
+			// 突发之后的命令需要复核
+			rule, cmd, ok = model.SystemUserFilterRule{Action: model.ActionConfirm}, p.command, true
+		}
+		p.updateOutputCapture(rule.ID)
+		p.matchedRule = rule
+		if ok {
 			switch rule.Action {
+			case model.ActionAudit:
+				p.auditCommand(rule, cmd)
 			case model.ActionDeny:
 				p.forbiddenCommand(cmd)
 				return nil
@@ -280,9 +310,7 @@ func (p *Parser) parseInputState(b []byte) []byte {
 		// 用户又开始输入，并上次不处于输入状态，开始结算上次命令的结果
 		if !p.inputPreState {
 			p.sendCommandRecord()
-			if ps1 := p.cmdOutputParser.GetPs1(); ps1 != "" {
-				p.cmdInputParser.SetPs1(ps1)
-			}
+			p.cmdTracker.MarkPrompt()
 		}
 	}
 	return b
@@ -306,26 +334,73 @@ func (p *Parser) forbiddenCommand(cmd string) {
 		Output:      fbdMsg,
 		CreatedDate: p.cmdCreateDate,
 		RiskLevel:   model.HighRiskFlag,
+		RuleID:      p.matchedRule.ID,
+		Kubectl:     p.kubectlMetas(),
 		User:        p.currentActiveUser}
 	p.command = ""
 	p.output = ""
+	p.matchedRule = model.SystemUserFilterRule{}
+	p.kubectlCommands = nil
 	p.userOutputChan <- breakInputPacket(p.protocolType)
 }
 
+// auditCommand 命令匹配审计规则, 只做标记和提示, 不拦截
+func (p *Parser) auditCommand(rule model.SystemUserFilterRule, cmd string) {
+	logger.Infof("Session %s: command `%s` matched audit rule %s", p.id, RedactCommand(cmd), rule.ID)
+	if p.auditBanner != "" {
+		p.srvOutputChan <- []byte("\r\n" + utils.WrapperWarn(p.auditBanner))
+	}
+}
+
+// handleCommandBurst 记录为高危命令并提示用户
+func (p *Parser) handleCommandBurst(burst *commandBurst) {
+	logger.Infof("Session %s: %s", p.id, burst.Command)
+	p.cmdRecordChan <- &ExecutedCommand{
+		Command:     burst.Command,
+		Output:      burst.Detail,
+		CreatedDate: time.Now(),
+		RiskLevel:   model.HighRiskFlag,
+		User:        p.currentActiveUser,
+	}
+	msg := i18n.T("Too many commands or pasted lines, the session has been flagged")
+	p.srvOutputChan <- []byte("\r\n" + utils.WrapperWarn(msg) + "\r\n")
+	p.fireEvent(commandBurstEvent)
+	if p.burst.Terminate() {
+		p.fireEvent(commandBurstTerminateEvent)
+	}
+}
+
 // parseCmdInput 解析命令的输入
 func (p *Parser) parseCmdInput() {
-	commands := p.cmdInputParser.Parse()
-	if len(commands) <= 0 {
-		p.command = ""
-	} else {
-		p.command = commands[len(commands)-1]
-	}
+	p.command = p.cmdTracker.Command()
 	p.cmdCreateDate = time.Now()
+	if p.protocolType == srvconn.ProtocolK8s {
+		p.kubectlCommands = ParseKubectlCommands(p.command, p.k8sNamespace)
+	}
 }
 
 // parseCmdOutput 解析命令输出
 func (p *Parser) parseCmdOutput() {
-	p.output = strings.Join(p.cmdOutputParser.Parse(), "\r\n")
+	p.output = p.cmdTracker.Output()
+}
+
+// updateOutputCapture 命令需要记录完整输出时放大输出的缓存
+func (p *Parser) updateOutputCapture(ruleID string) {
+	p.fullCapture = p.capture != nil && p.command != "" &&
+		p.capture.NeedFullCapture(p.command, ruleID)
+	maxSize := p.outputMaxSize()
+	if p.fullCapture {
+		maxSize = p.capture.FullMaxSize
+	}
+	p.cmdTracker.SetMaxSize(maxSize)
+}
+
+// outputMaxSize 命令记录中输出的最大长度
+func (p *Parser) outputMaxSize() int {
+	if p.capture != nil {
+		return p.capture.OutputMaxSize
+	}
+	return cmdParserBufMaxSize
 }
 
 // ParseUserInput 解析用户的输入
@@ -333,6 +408,11 @@ func (p *Parser) ParseUserInput(b []byte) []byte {
 	p.once.Do(func() {
 		p.inputInitial = true
 	})
+	if !p.zmodemParser.IsStartSession() {
+		if burst := p.burst.Input(time.Now(), b); burst != nil {
+			p.handleCommandBurst(burst)
+		}
+	}
 	nb := p.parseInputState(b)
 	return nb
 }
@@ -343,18 +423,6 @@ func (p *Parser) parseZmodemState(b []byte) {
 	p.zmodemParser.Parse(b)
 }
 
-// parseVimState 解析vim的状态，处于vim状态中，里面输入的命令不再记录
-func (p *Parser) parseVimState(b []byte) {
-	if !p.inVimState && IsEditEnterMode(b) {
-		p.inVimState = true
-		logger.Debug("In vim state: true")
-	}
-	if p.inVimState && IsEditExitMode(b) {
-		p.inVimState = false
-		logger.Debug("In vim state: false")
-	}
-}
-
 // splitCmdStream 将服务器输出流分离到命令buffer和命令输出buffer
 func (p *Parser) splitCmdStream(b []byte) []byte {
 	if p.zmodemParser.IsStartSession() {
@@ -373,24 +441,24 @@ func (p *Parser) splitCmdStream(b []byte) []byte {
 			return nil
 		}
 		return b
-	} else {
-		p.parseVimState(b)
-		if p.inVimState || !p.inputInitial {
-			return b
-		}
+	} else if !p.inVimState && p.inputInitial {
 		p.parseZmodemState(b)
 	}
 	if p.zmodemParser.IsStartSession() {
 		logger.Infof("Zmodem start session %s", p.zmodemParser.Status())
 		return b
 	}
-	if p.inputState {
-		_, _ = p.cmdInputParser.WriteData(b)
-	}
-	_, _ = p.cmdOutputParser.WriteData(b)
+	_, _ = p.cmdTracker.Write(b)
+	// vim、top 等全屏程序使用备用屏幕, 其中的输入不作为命令记录
+	p.inVimState = p.cmdTracker.AltScreen()
 	return b
 }
 
+// Resize 窗口大小变化时同步调整屏幕
+func (p *Parser) Resize(width, height int) {
+	p.cmdTracker.Resize(width, height)
+}
+
 // ParseServerOutput 解析服务器输出
 func (p *Parser) ParseServerOutput(b []byte) []byte {
 	p.lock.Lock()
@@ -407,54 +475,61 @@ func (p *Parser) SetCMDFilterRules(rules []model.SystemUserFilterRule) {
 
 // IsMatchCommandRule 判断命令是不是在过滤规则中
 func (p *Parser) IsMatchCommandRule(command string) (model.SystemUserFilterRule, string, bool) {
-	for _, rule := range p.cmdFilterRules {
-		allowed, cmd := rule.Match(command)
-		switch allowed {
-		case model.ActionAllow:
-			return rule, cmd, true
-		case model.ActionConfirm, model.ActionDeny:
-			return rule, cmd, true
-		default:
+	if p.protocolType == srvconn.ProtocolK8s {
+		return matchKubectlFilterRules(p.k8sFilterRules, kubectlCandidates(command, p.k8sNamespace), p.podLabels)
+	}
+	return matchShellCommandRules(p.cmdFilterRules, command)
+}
+
+// kubectlMetas 当前命令中 kubectl 命令的结构化信息
+func (p *Parser) kubectlMetas() []model.KubectlMeta {
+	if len(p.kubectlCommands) == 0 {
+		return nil
+	}
+	metas := make([]model.KubectlMeta, 0, len(p.kubectlCommands))
+	for _, cmd := range p.kubectlCommands {
+		metas = append(metas, cmd.Meta())
+	}
+	return metas
+}
+
+// updateK8sNamespace 命令执行后, 跟踪 kubectl config set-context 修改的 namespace
+func (p *Parser) updateK8sNamespace() {
+	for _, cmd := range p.kubectlCommands {
+		if namespace, ok := cmd.ContextNamespace(); ok {
+			logger.Infof("Session %s: k8s namespace changed to %s", p.id, namespace)
+			p.k8sNamespace = namespace
 		}
 	}
-	return model.SystemUserFilterRule{}, "", false
 }
 
 func (p *Parser) waitCommandConfirm() {
 	cmd := p.confirmStatus.Cmd
-	resp, err := p.jmsService.SubmitCommandConfirm(p.id, p.confirmStatus.Rule.ID, p.confirmStatus.Cmd)
+	ticket, err := p.confirmer.Submit(p.confirmStatus.Rule.ID, RedactCommand(cmd))
 	if err != nil {
-		logger.Errorf("Session %s: submit command confirm api err: %s", p.id, err)
+		logger.Errorf("Session %s: submit command confirm err: %s", p.id, err)
 		p.confirmStatus.SetAction(model.ActionDeny)
 		return
 	}
-	checkReq := resp.CheckConfirmStatus
-	cancelReq := resp.CloseConfirm
-	detailURL := resp.TicketDetailUrl
-	reviewers := resp.Reviewers
+	p.confirmStatus.SetTicket(ticket)
+	defer p.confirmStatus.SetTicket(nil)
 	msg := i18n.T("Please waiting for the reviewers to confirm command `%s`, cancel by CTRL+C.")
 	waitMsg := fmt.Sprintf(msg, cmd)
-	checkTimer := time.NewTicker(10 * time.Second)
-	defer checkTimer.Stop()
 	ctx, cancelFunc := context.WithCancel(p.confirmStatus.ctx)
 	defer cancelFunc()
 	go func() {
 		delay := 0
-		titleMsg := i18n.T("Need ticket confirm to execute command, already send email to the reviewers")
-		reviewersMsg := fmt.Sprintf(i18n.T("Ticket Reviewers: %s"), strings.Join(reviewers, ", "))
-		detailURLMsg := fmt.Sprintf(i18n.T("Could copy website URL to notify reviewers: %s"), detailURL)
 		var tipString strings.Builder
 		tipString.WriteString(utils.CharNewLine)
-		tipString.WriteString(titleMsg)
-		tipString.WriteString(utils.CharNewLine)
-		tipString.WriteString(reviewersMsg)
-		tipString.WriteString(utils.CharNewLine)
-		tipString.WriteString(detailURLMsg)
-		tipString.WriteString(utils.CharNewLine)
+		for _, tip := range ticket.Tips() {
+			tipString.WriteString(tip)
+			tipString.WriteString(utils.CharNewLine)
+		}
 		p.srvOutputChan <- []byte(utils.WrapperString(tipString.String(), utils.Green))
 		for {
 			select {
 			case <-p.closed:
+				cancelFunc()
 				return
 			case <-ctx.Done():
 				return
@@ -467,42 +542,28 @@ func (p *Parser) waitCommandConfirm() {
 			}
 		}
 	}()
-	for {
+	result, err := ticket.Wait(ctx)
+	if err != nil {
 		select {
 		case <-p.closed:
-			if err = p.jmsService.CancelConfirmByRequestInfo(cancelReq); err != nil {
-				logger.Errorf("Session %s: Cancel command confirm err: %s", p.id, err)
-			}
 			logger.Infof("Session %s: Closed", p.id)
 			return
-		case <-ctx.Done():
-			// 取消
-			if err = p.jmsService.CancelConfirmByRequestInfo(cancelReq); err != nil {
-				logger.Errorf("Session %s: Cancel command confirm err: %s", p.id, err)
-			}
-			logger.Infof("Session %s: Cancel confirm command", p.id)
-			return
-		case <-checkTimer.C:
-		}
-		statusResp, err := p.jmsService.CheckConfirmStatusByRequestInfo(checkReq)
-		if err != nil {
-			logger.Errorf("Session %s: check command confirm status err: %s", p.id, err)
-			continue
+		default:
 		}
-		switch statusResp.Status {
-		case approve:
-			p.confirmStatus.SetAction(model.ActionAllow)
-			p.confirmStatus.SetProcessor(statusResp.Processor)
-			return
-		case reject:
-			p.confirmStatus.SetProcessor(statusResp.Processor)
-			p.confirmStatus.SetAction(model.ActionDeny)
+		if err == context.Canceled {
+			logger.Infof("Session %s: Cancel confirm command", p.id)
 			return
-		case await:
-			continue
-		default:
-			logger.Errorf("Receive unknown command confirm status %s", statusResp.Status)
 		}
+		logger.Errorf("Session %s: wait command confirm err: %s", p.id, err)
+		p.confirmStatus.SetAction(model.ActionDeny)
+		return
+	}
+	p.confirmStatus.SetProcessor(result.Processor)
+	switch result.Status {
+	case approve:
+		p.confirmStatus.SetAction(model.ActionAllow)
+	case reject:
+		p.confirmStatus.SetAction(model.ActionDeny)
 	}
 }
 
@@ -525,23 +586,32 @@ func (p *Parser) Close() {
 		close(p.closed)
 
 	}
-	_ = p.cmdOutputParser.Close()
-	_ = p.cmdInputParser.Close()
 	logger.Infof("Session %s: Parser close", p.id)
 }
 
 func (p *Parser) sendCommandRecord() {
 	if p.command != "" {
 		p.parseCmdOutput()
+		riskLevel := model.LessRiskFlag
+		if p.matchedRule.Action == model.ActionAudit {
+			riskLevel = model.WarnRiskFlag
+		}
 		p.cmdRecordChan <- &ExecutedCommand{
 			Command:     p.command,
 			Output:      p.output,
 			CreatedDate: p.cmdCreateDate,
-			RiskLevel:   model.LessRiskFlag,
+			RiskLevel:   riskLevel,
+			RuleID:      p.matchedRule.ID,
+			Kubectl:     p.kubectlMetas(),
 			User:        p.currentActiveUser,
+			FullOutput:  p.fullCapture,
 		}
+		p.updateK8sNamespace()
 		p.command = ""
 		p.output = ""
+		p.fullCapture = false
+		p.matchedRule = model.SystemUserFilterRule{}
+		p.kubectlCommands = nil
 	}
 }
 
@@ -559,6 +629,8 @@ func (p *Parser) UpdateActiveUser(msg *exchange.RoomMessage) {
 }
 
 func (p *Parser) RegisterEventCallback(event string, f func()) {
+	p.eventsLock.Lock()
+	defer p.eventsLock.Unlock()
 	p.eventsFuncMap[event] = f
 }
 
@@ -567,7 +639,13 @@ type ExecutedCommand struct {
 	Output      string
 	CreatedDate time.Time
 	RiskLevel   string
-	User        CurrentActiveUser
+	// RuleID 匹配的命令过滤规则
+	RuleID string
+	// Kubectl k8s 会话中解析的 kubectl 命令
+	Kubectl []model.KubectlMeta
+	User    CurrentActiveUser
+	// FullOutput 需要保存完整输出
+	FullOutput bool
 }
 
 type CurrentActiveUser struct {
@@ -576,23 +654,6 @@ type CurrentActiveUser struct {
 	RemoteAddr string
 }
 
-func IsEditEnterMode(p []byte) bool {
-	return matchMark(p, enterMarks)
-}
-
-func IsEditExitMode(p []byte) bool {
-	return matchMark(p, exitMarks)
-}
-
-func matchMark(p []byte, marks [][]byte) bool {
-	for _, item := range marks {
-		if bytes.Contains(p, item) {
-			return true
-		}
-	}
-	return false
-}
-
 func breakInputPacket(protocolType string) []byte {
 	switch protocolType {
 	case model.ProtocolTelnet:
@@ -606,4 +667,7 @@ func breakInputPacket(protocolType string) []byte {
 const (
 	zmodemStartEvent = "ZMODEM_START"
 	zmodemEndEvent   = "ZMODEM_END"
+
+	commandBurstEvent          = "COMMAND_BURST"
+	commandBurstTerminateEvent = "COMMAND_BURST_TERMINATE"
 )
@@ -10,6 +10,8 @@ import (
 	"github.com/jumpserver/koko/pkg/logger"
 )
 
+const cmdParserBufMaxSize = 1024
+
 func NewCmdParser(sid, name string) *CmdParser {
 	parser := CmdParser{id: sid, name: name}
 	return &parser
@@ -23,17 +25,34 @@ type CmdParser struct {
 	lock sync.Mutex
 
 	ps1 string
+
+	// maxSize 缓存的最大长度, 为 0 时使用默认值
+	maxSize int
 }
 
 func (cp *CmdParser) WriteData(p []byte) (int, error) {
 	cp.lock.Lock()
 	defer cp.lock.Unlock()
-	if cp.buf.Len() >= 1024 {
+	if cp.buf.Len() >= cp.bufMaxSize() {
 		return 0, nil
 	}
 	return cp.buf.Write(p)
 }
 
+func (cp *CmdParser) bufMaxSize() int {
+	if cp.maxSize > 0 {
+		return cp.maxSize
+	}
+	return cmdParserBufMaxSize
+}
+
+// SetMaxSize 需要记录完整输出时调整缓存的最大长度
+func (cp *CmdParser) SetMaxSize(size int) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	cp.maxSize = size
+}
+
 func (cp *CmdParser) Close() error {
 	logger.Infof("session ID: %s, ParseEngine name: %s Close", cp.id, cp.name)
 	return nil
@@ -71,6 +90,17 @@ func (cp *CmdParser) GetPs1() string {
 	return cp.ps1
 }
 
+// LastLine 缓存中的最后一行, 不清空缓存
+func (cp *CmdParser) LastLine() string {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	lines := cp.parse(cp.buf.Bytes())
+	if len(lines) == 0 {
+		return ""
+	}
+	return lines[len(lines)-1]
+}
+
 func (cp *CmdParser) SetPs1(s string) {
 	cp.lock.Lock()
 	defer cp.lock.Unlock()
@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"strings"
+)
+
+/*
+	psql 的语句切分, 与 mysql 客户端的区别:
+	1. 结束符固定为 ;, \g 系列元命令(\g \gx \gset \gexec \gdesc)同样结束语句, \r 清空当前语句
+	2. 其他反斜杠开头的元命令(\c \d \! \i 等)使用到行尾的内容作为参数, 作为单独的语句返回, 当前语句不受影响
+	3. 字符串中的反斜杠只在 E'...' 中转义, 支持 $$...$$ 和 $tag$...$tag$ 美元符号引用, -- 之后不需要空白字符, # 不是注释
+	4. 单独一行的 quit、exit、help 不需要结束符(psql 11 起)
+*/
+
+// psql 单独一行时不需要结束符的命令
+var psqlClientCommands = map[string]bool{
+	"quit": true, "exit": true, "help": true,
+}
+
+// psql 结束语句的元命令
+var psqlSendCommands = map[string]bool{
+	"g": true, "gx": true, "gset": true, "gexec": true, "gdesc": true,
+}
+
+// psql 元命令对应的语句类型, 与 mysql 客户端的长命令保持一致, 方便使用相同的规则
+var psqlMetaCommands = map[string]string{
+	"!": "SYSTEM", "i": "SOURCE", "include": "SOURCE", "ir": "SOURCE", "include_relative": "SOURCE",
+	"c": "CONNECT", "connect": "CONNECT", "q": "QUIT", "o": "TEE", "out": "TEE", "w": "WRITE", "write": "WRITE",
+	"e": "EDIT", "edit": "EDIT", "ef": "EDIT", "ev": "EDIT", "h": "HELP", "help": "HELP", "?": "HELP",
+	"copy": "COPY", "password": "PASSWORD", "setenv": "SETENV", "cd": "CD", "l": "LIST", "list": "LIST",
+	"lo_import": "LO_IMPORT", "lo_export": "LO_EXPORT", "lo_unlink": "LO_UNLINK",
+}
+
+func newPostgreSQLStatementBuffer() *sqlStatementBuffer {
+	return &sqlStatementBuffer{dialect: sqlDialectPostgreSQL, delimiter: ";", clearCommand: 'r'}
+}
+
+func (b *sqlStatementBuffer) splitPSQL(text string) ([]string, string) {
+	var (
+		stmts []string
+		cur   strings.Builder
+	)
+	flush := func() {
+		if stmt := strings.TrimSpace(cur.String()); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		cur.Reset()
+	}
+	for i := 0; i < len(text); {
+		if (i == 0 || text[i-1] == '\n') && strings.TrimSpace(cur.String()) == "" {
+			end := sqlLineEnd(text, i)
+			line := strings.TrimSpace(text[i:end])
+			if psqlClientCommands[strings.ToLower(strings.TrimSuffix(line, ";"))] {
+				stmts = append(stmts, line)
+				cur.Reset()
+				i = end
+				continue
+			}
+		}
+		c := text[i]
+		switch {
+		case c == '\'' || c == '"':
+			end, closed := skipPSQLQuoted(text, i)
+			if !closed {
+				return stmts, cur.String() + text[i:]
+			}
+			cur.WriteString(text[i:end])
+			i = end
+		case c == '$' && psqlDollarTag(text, i) != "":
+			tag := psqlDollarTag(text, i)
+			n := strings.Index(text[i+len(tag):], tag)
+			if n < 0 {
+				return stmts, cur.String() + text[i:]
+			}
+			end := i + len(tag) + n + len(tag)
+			cur.WriteString(text[i:end])
+			i = end
+		case strings.HasPrefix(text[i:], "--"):
+			end := sqlLineEnd(text, i)
+			cur.WriteString(text[i:end])
+			i = end
+		case strings.HasPrefix(text[i:], "/*"):
+			n := strings.Index(text[i+2:], "*/")
+			if n < 0 {
+				return stmts, cur.String() + text[i:]
+			}
+			cur.WriteString(text[i : i+n+4])
+			i += n + 4
+		case c == ';':
+			flush()
+			i++
+		case c == '\\' && i+1 < len(text):
+			name := psqlMetaName(text, i+1)
+			switch {
+			case name == "\\":
+				// \\ 分隔同一行的多个元命令
+				i += 2
+			case psqlSendCommands[name]:
+				flush()
+				i = sqlLineEnd(text, i)
+			case name == "r" || name == "reset":
+				cur.Reset()
+				i += 1 + len(name)
+			default:
+				end := sqlLineEnd(text, i)
+				stmts = append(stmts, strings.TrimSpace(text[i:end]))
+				i = end
+			}
+		default:
+			cur.WriteByte(c)
+			i++
+		}
+	}
+	return stmts, cur.String()
+}
+
+// psqlMetaName 反斜杠之后的元命令名称, 字母开头时到非单词字符为止, 否则为一个字符
+func psqlMetaName(s string, i int) string {
+	end := i
+	for end < len(s) && (isSQLWordByte(s[end]) || s[end] == '+') && s[end] != '$' {
+		end++
+	}
+	if end == i {
+		return s[i : i+1]
+	}
+	// \dt+ 等命令的 + 不属于名称
+	return strings.TrimRight(s[i:end], "+")
+}
+
+// psqlDollarTag 返回 $tag$ 引用的标签, 不是美元符号引用时返回空
+func psqlDollarTag(s string, i int) string {
+	if i > 0 && isSQLWordByte(s[i-1]) {
+		return ""
+	}
+	for j := i + 1; j < len(s); j++ {
+		switch c := s[j]; {
+		case c == '$':
+			return s[i : j+1]
+		case c == '_' || c >= 0x80 || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z'),
+			'0' <= c && c <= '9' && j > i+1:
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// skipPSQLQuoted 返回引号结束之后的位置, 只有 E'...' 支持反斜杠转义
+func skipPSQLQuoted(s string, i int) (int, bool) {
+	quote := s[i]
+	escape := quote == '\'' && i > 0 && (s[i-1] == 'E' || s[i-1] == 'e') &&
+		(i == 1 || !isSQLWordByte(s[i-2]))
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			if escape {
+				j++
+			}
+		case quote:
+			if j+1 < len(s) && s[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1, true
+		}
+	}
+	return len(s), false
+}
+
+// analyzePSQL 元命令按 psqlMetaCommands 分类, \d 开头的查看对象的命令为 DESCRIBE
+func analyzePSQL(text string) *SQLStatement {
+	if len(text) < 2 || text[0] != '\\' {
+		return analyzeSQL(text)
+	}
+	stmt := SQLStatement{Text: text, Rows: -1}
+	name := psqlMetaName(text, 1)
+	stmt.Type = psqlMetaCommands[name]
+	if stmt.Type == "" && strings.HasPrefix(name, "d") {
+		stmt.Type = "DESCRIBE"
+	}
+	return &stmt
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPostgreSQLStatementBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		expect [][]string
+	}{
+		{"multi line", []string{"delete from t", "where id = 1;"}, [][]string{nil, {"delete from t\nwhere id = 1"}}},
+		{"quoted", []string{`select 'a\'; select E'b\';', "c;" -- d;`, "# e;"},
+			[][]string{{`select 'a\'`}, {"select E'b\\';', \"c;\" -- d;\n# e"}}},
+		{"dollar quoted", []string{"create function f() returns int as $body$ select 1; $body$", "language sql;"},
+			[][]string{nil, {"create function f() returns int as $body$ select 1; $body$\nlanguage sql"}}},
+		{"meta command", []string{"\\dt+ users", "select 1 \\! rm -rf /", "\\g"},
+			[][]string{{"\\dt+ users"}, {"\\! rm -rf /"}, {"select 1"}}},
+		{"reset", []string{"delete from t", "\\r", "select 1 \\gx"}, [][]string{nil, nil, {"select 1"}}},
+		{"connect", []string{"\\c prod", "quit"}, [][]string{{"\\c prod"}, {"quit"}}},
+		{"delimiter is not a command", []string{"delimiter //", ";"}, [][]string{nil, {"delimiter //"}}},
+	}
+	for _, tt := range tests {
+		buf := newPostgreSQLStatementBuffer()
+		for i, line := range tt.lines {
+			var expect []string
+			if i < len(tt.expect) {
+				expect = tt.expect[i]
+			}
+			if got := buf.Append(line); !reflect.DeepEqual(got, expect) {
+				t.Errorf("%s: line %d expect %q, got %q", tt.name, i, expect, got)
+			}
+		}
+	}
+}
+
+func TestAnalyzePSQL(t *testing.T) {
+	tests := []struct {
+		text   string
+		expect SQLStatement
+	}{
+		{"\\! rm -rf /", SQLStatement{Type: "SYSTEM", Rows: -1}},
+		{"\\c prod", SQLStatement{Type: "CONNECT", Rows: -1}},
+		{"\\dt+ users", SQLStatement{Type: "DESCRIBE", Rows: -1}},
+		{"\\i /tmp/a.sql", SQLStatement{Type: "SOURCE", Rows: -1}},
+		{"delete from t", SQLStatement{Type: "DELETE", Tables: []string{"t"}, Rows: -1}},
+	}
+	for _, tt := range tests {
+		got := newPostgreSQLStatementBuffer().Analyze(tt.text)
+		tt.expect.Text = tt.text
+		if !reflect.DeepEqual(*got, tt.expect) {
+			t.Errorf("%q: expect %+v, got %+v", tt.text, tt.expect, *got)
+		}
+	}
+}
@@ -1,12 +1,10 @@
 package proxy
 
 import (
-	"encoding/json"
-	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	storage "github.com/jumpserver/koko/pkg/proxy/recorderstorage"
@@ -25,6 +23,17 @@ type CommandRecorder struct {
 	queue  chan *model.Command
 	closed chan struct{}
 
+	// 命令存储不可用时写入本地缓存
+	spool *CommandSpool
+
+	outputs *commandOutputStore
+
+	// 防篡改签名, signer 为 nil 表示未开启
+	signer        AuditSigner
+	chain         *hashChain
+	replayStorage ReplayStorage
+	date          string
+
 	jmsService *service.JMService
 }
 
@@ -32,6 +41,14 @@ func (c *CommandRecorder) Record(command *model.Command) {
 	c.queue <- command
 }
 
+// RecordWithOutput 先保存完整输出, 命令中记录完整输出的路径
+func (c *CommandRecorder) RecordWithOutput(command *model.Command, fullOutput string) {
+	if c.outputs != nil {
+		c.outputs.Save(command, fullOutput)
+	}
+	c.Record(command)
+}
+
 func (c *CommandRecorder) End() {
 	select {
 	case <-c.closed:
@@ -39,6 +56,10 @@ func (c *CommandRecorder) End() {
 	default:
 	}
 	close(c.closed)
+	// 等待命令完整输出上传完成, 避免会话结束后丢失
+	if c.outputs != nil {
+		c.outputs.Wait()
+	}
 }
 
 func (c *CommandRecorder) record() {
@@ -53,12 +74,16 @@ func (c *CommandRecorder) record() {
 		select {
 		case <-c.closed:
 			if len(cmdList) == 0 {
+				c.uploadSignature()
 				return
 			}
 		case p, ok := <-c.queue:
 			if !ok {
 				return
 			}
+			if c.signer != nil {
+				c.chain.Add(commandFrame(p))
+			}
 			if p.RiskLevel == model.DangerLevel {
 				notificationList = append(notificationList, p)
 			}
@@ -78,6 +103,14 @@ func (c *CommandRecorder) record() {
 				logger.Errorf("Session %s: command notify err: %s", c.sessionID, err)
 			}
 		}
+		// 本地缓存中还有命令时, 新的命令也写入缓存, 保证命令按记录的顺序写入存储
+		if c.spool != nil && c.spool.Size() > 0 {
+			if err := c.spool.Append(cmdList); err == nil {
+				cmdList = cmdList[:0]
+				maxRetry = 0
+				continue
+			}
+		}
 		err := c.storage.BulkSave(cmdList)
 		if err == nil {
 			cmdList = cmdList[:0]
@@ -87,6 +120,15 @@ func (c *CommandRecorder) record() {
 		if err != nil {
 			logger.Errorf("Session %s: command bulk save err: %s", c.sessionID, err)
 		}
+		if c.spool != nil {
+			if err = c.spool.Append(cmdList); err == nil {
+				logger.Infof("Session %s: %d commands saved to spool", c.sessionID, len(cmdList))
+				cmdList = cmdList[:0]
+				maxRetry = 0
+				continue
+			}
+			logger.Errorf("Session %s: command spool err: %s", c.sessionID, err)
+		}
 
 		if maxRetry > 5 {
 			cmdList = cmdList[1:]
@@ -95,6 +137,21 @@ func (c *CommandRecorder) record() {
 	}
 }
 
+func (c *CommandRecorder) uploadSignature() {
+	if c.signer == nil {
+		return
+	}
+	rootPath := config.GetConf().RootPath
+	sigFileName := c.sessionID + ".command.sig"
+	path := filepath.Join(rootPath, "data", "replays", c.date, sigFileName)
+	if err := common.EnsureDirExist(filepath.Dir(path)); err != nil {
+		logger.Errorf("Session %s: create dir %s err: %s", c.sessionID, filepath.Dir(path), err)
+		return
+	}
+	target := strings.Join([]string{c.date, sigFileName}, "/")
+	uploadAuditSignature(c.chain, c.signer, c.replayStorage, SignTypeCommand, c.sessionID, path, target)
+}
+
 type ReplyRecorder struct {
 	SessionID string
 
@@ -104,9 +161,21 @@ type ReplyRecorder struct {
 	file          *os.File
 	timeStartNano int64
 
+	format   string
+	header   ReplayHeader
+	encoder  ReplayEncoder
+	segments *replaySegmentWriter
+	index    *replayIndexWriter
+
+	signer AuditSigner
+	chain  *hashChain
+
+	// 录像加密, 加密公钥配置错误时录像保留在本地不上传
+	recipient    ReplayRecipient
+	recipientErr error
+
 	storage ReplayStorage
 
-	once       sync.Once
 	jmsService *service.JMService
 }
 
@@ -118,20 +187,27 @@ func (r *ReplyRecorder) Record(b []byte) {
 	if r.isNullStorage() {
 		return
 	}
-	if len(b) > 0 {
-
-		r.once.Do(func() {
-			_, _ = r.file.Write([]byte("{"))
-		})
-		delta := float64(time.Now().UnixNano()-r.timeStartNano) / 1000 / 1000 / 1000
-		data, _ := json.Marshal(string(b))
-		_, err := r.file.WriteString(fmt.Sprintf(`"%f":%s,`, delta, data))
-		if err != nil {
+	if len(b) > 0 && r.encoder != nil {
+		if err := r.encoder.WriteOutput(r.offset(), b); err != nil {
 			logger.Errorf("Session %s write replay to file failed: %s", r.SessionID, err)
 		}
 	}
 }
 
+// RecordResize 记录窗口大小变化
+func (r *ReplyRecorder) RecordResize(width, height int) {
+	if r.isNullStorage() || r.encoder == nil {
+		return
+	}
+	if err := r.encoder.WriteResize(r.offset(), width, height); err != nil {
+		logger.Errorf("Session %s write replay resize event failed: %s", r.SessionID, err)
+	}
+}
+
+func (r *ReplyRecorder) offset() time.Duration {
+	return time.Duration(time.Now().UnixNano() - r.timeStartNano)
+}
+
 func (r *ReplyRecorder) prepare() {
 	sessionID := r.SessionID
 	rootPath := config.GetConf().RootPath
@@ -160,14 +236,97 @@ func (r *ReplyRecorder) prepare() {
 	if err != nil {
 		logger.Errorf("Create file %s error: %s\n", r.absFilePath, err)
 	}
+	logger.Infof("Session %s: Replay format: %s", r.SessionID, r.format)
+	if r.recipient, r.recipientErr = LoadReplayRecipient(); r.recipientErr != nil {
+		logger.Errorf("Session %s: load replay encrypt key err: %s", r.SessionID, r.recipientErr)
+	}
+	var writer io.Writer = r.file
+	if r.segments = r.getSegmentWriter(); r.segments != nil {
+		writer = r.segments
+	}
+	if r.index = r.getIndexWriter(); r.index != nil {
+		counter := &countingWriter{w: writer}
+		r.encoder = &indexEncoder{
+			ReplayEncoder: NewReplayEncoder(r.format, counter),
+			index:         r.index,
+			counter:       counter,
+		}
+	} else {
+		r.encoder = NewReplayEncoder(r.format, writer)
+	}
+	if r.signer = getAuditSigner(r.SessionID, r.storage); r.signer != nil {
+		r.chain = newHashChain(r.SessionID)
+		r.encoder = newChainEncoder(r.encoder, r.format, r.chain)
+	}
+	r.header.Timestamp = time.Unix(0, r.timeStartNano)
+	if err = r.encoder.WriteHeader(r.header); err != nil {
+		logger.Errorf("Session %s write replay header failed: %s", r.SessionID, err)
+	}
+}
+
+func (r *ReplyRecorder) getSegmentWriter() *replaySegmentWriter {
+	conf := config.GetConf()
+	var maxSize int64
+	if conf.ReplaySegmentMaxSize != "" {
+		maxSize = int64(common.ConvertSizeToBytes(conf.ReplaySegmentMaxSize))
+	}
+	interval := time.Duration(conf.ReplaySegmentInterval) * time.Second
+	if maxSize <= 0 && interval <= 0 {
+		return nil
+	}
+	if !IsObjectStorage(r.storage) {
+		logger.Infof("Session %s: storage %s not support segment replay", r.SessionID,
+			r.storage.TypeName())
+		return nil
+	}
+	if r.recipientErr != nil {
+		return nil
+	}
+	logger.Infof("Session %s: Replay segment max size %d, interval %s", r.SessionID,
+		maxSize, interval)
+	return newReplaySegmentWriter(r, maxSize, interval)
+}
+
+// getIndexWriter 录像索引只上传到对象存储
+func (r *ReplyRecorder) getIndexWriter() *replayIndexWriter {
+	conf := config.GetConf()
+	if conf.ReplayIndexInterval <= 0 || r.file == nil || r.recipientErr != nil {
+		return nil
+	}
+	if !IsObjectStorage(r.storage) {
+		logger.Infof("Session %s: storage %s not support replay index", r.SessionID,
+			r.storage.TypeName())
+		return nil
+	}
+	interval := time.Duration(conf.ReplayIndexInterval) * time.Second
+	keyframeInterval := time.Duration(conf.ReplayKeyframeInterval) * time.Second
+	index, err := newReplayIndexWriter(r.absFilePath+replayIndexExt, r.format, r.header,
+		interval, keyframeInterval)
+	if err != nil {
+		logger.Errorf("Session %s: create replay index err: %s", r.SessionID, err)
+		return nil
+	}
+	return index
+}
+
+// RecordCommand 在录像索引中标记命令的开始时间
+func (r *ReplyRecorder) RecordCommand(input string, createdDate time.Time) {
+	if r.index == nil {
+		return
+	}
+	r.index.Command(time.Duration(createdDate.UnixNano()-r.timeStartNano), input)
 }
 
 func (r *ReplyRecorder) End() {
 	if r.isNullStorage() {
 		return
 	}
-	delta := float64(time.Now().UnixNano()-r.timeStartNano) / 1000 / 1000 / 1000
-	_, _ = r.file.WriteString(fmt.Sprintf(`"%f":"","%f":""}`, delta, 0.0))
+	if r.encoder != nil {
+		_ = r.encoder.WriteEnd(r.offset())
+	}
+	if r.index != nil {
+		_ = r.index.Close()
+	}
 	_ = r.file.Close()
 	go r.uploadReplay()
 }
@@ -179,13 +338,19 @@ func (r *ReplyRecorder) isNullStorage() bool {
 func (r *ReplyRecorder) uploadReplay() {
 	logger.Infof("Session %s: Replay recorder is uploading", r.SessionID)
 	defer logger.Infof("Session %s: Replay recorder has uploaded", r.SessionID)
+	if r.segments != nil {
+		// 等待分段上传完成，避免和完整录像上传时切换的 storage 冲突
+		r.segments.Close()
+	}
 	if !common.FileExists(r.absFilePath) {
 		logger.Debug("Replay file not found, passed: ", r.absFilePath)
+		_ = os.Remove(r.absFilePath + replayIndexExt)
 		return
 	}
 	if stat, err := os.Stat(r.absFilePath); err == nil && stat.Size() == 0 {
 		logger.Debug("Replay file is empty, removed: ", r.absFilePath)
 		_ = os.Remove(r.absFilePath)
+		_ = os.Remove(r.absFilePath + replayIndexExt)
 		return
 	}
 	if !common.FileExists(r.AbsGzFilePath) {
@@ -193,10 +358,55 @@ func (r *ReplyRecorder) uploadReplay() {
 		_ = common.GzipCompressFile(r.absFilePath, r.AbsGzFilePath)
 		_ = os.Remove(r.absFilePath)
 	}
+	if r.recipientErr != nil {
+		logger.Errorf("Session %s: replay encrypt key invalid, keep replay file: %s",
+			r.SessionID, r.AbsGzFilePath)
+		return
+	}
+	if r.recipient != nil {
+		if err := EncryptReplayFile(r.AbsGzFilePath, r.recipient); err != nil {
+			logger.Errorf("Session %s: encrypt replay file err: %s", r.SessionID, err)
+			return
+		}
+	}
+	if r.signer != nil {
+		// 先上传签名文件, 录像上传失败时会切换到 server 存储
+		uploadAuditSignature(r.chain, r.signer, r.storage, SignTypeReplay, r.SessionID,
+			r.AbsGzFilePath+".sig", r.Target+".sig")
+	}
+	r.uploadIndex()
 	r.UploadGzipFile(3)
 
 }
 
+// uploadIndex 压缩并上传录像索引, 开启录像加密时同样加密
+func (r *ReplyRecorder) uploadIndex() {
+	if r.index == nil {
+		return
+	}
+	indexPath := r.absFilePath + replayIndexExt
+	gzPath := indexPath + ".gz"
+	defer os.Remove(gzPath)
+	err := common.GzipCompressFile(indexPath, gzPath)
+	_ = os.Remove(indexPath)
+	if err != nil {
+		logger.Errorf("Session %s: compress replay index err: %s", r.SessionID, err)
+		return
+	}
+	if r.recipient != nil {
+		if err = EncryptReplayFile(gzPath, r.recipient); err != nil {
+			logger.Errorf("Session %s: encrypt replay index err: %s", r.SessionID, err)
+			return
+		}
+	}
+	target := strings.TrimSuffix(r.Target, ".replay.gz") + ".index.gz"
+	if err = uploadWithRetry(r.storage, gzPath, target, 3); err != nil {
+		logger.Errorf("Session %s: upload replay index err: %s", r.SessionID, err)
+		return
+	}
+	logger.Infof("Session %s: replay index uploaded", r.SessionID)
+}
+
 func (r *ReplyRecorder) UploadGzipFile(maxRetry int) {
 	if r.storage.TypeName() == "null" {
 		_ = r.storage.Upload(r.AbsGzFilePath, r.Target)
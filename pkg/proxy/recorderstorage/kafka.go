@@ -0,0 +1,140 @@
+package recorderstorage
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+const (
+	KafkaSASLPlain        = "PLAIN"
+	KafkaSASLScramSHA256  = "SCRAM-SHA-256"
+	KafkaSASLScramSHA512  = "SCRAM-SHA-512"
+	defaultKafkaTopic     = "jumpserver_command"
+	kafkaWriteTimeout     = 10 * time.Second
+	kafkaWriteMaxAttempts = 3
+)
+
+type KafkaConfig struct {
+	Hosts []string
+	Topic string
+
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+
+	UseTLS             bool
+	InsecureSkipVerify bool
+}
+
+func (c KafkaConfig) key() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%t|%t", strings.Join(c.Hosts, ","), c.Topic,
+		c.SASLMechanism, c.SASLUsername, c.UseTLS, c.InsecureSkipVerify)
+}
+
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// 所有会话共用同一个 Writer, 避免每个会话都建立到 broker 的连接
+var (
+	kafkaWriters    = make(map[string]kafkaWriter)
+	kafkaWriterLock sync.Mutex
+)
+
+func NewKafkaCommandStorage(conf KafkaConfig) (*KafkaCommandStorage, error) {
+	if conf.Topic == "" {
+		conf.Topic = defaultKafkaTopic
+	}
+	kafkaWriterLock.Lock()
+	defer kafkaWriterLock.Unlock()
+	key := conf.key()
+	if writer, ok := kafkaWriters[key]; ok {
+		return &KafkaCommandStorage{Topic: conf.Topic, writer: writer}, nil
+	}
+	dialer := &kafka.Dialer{
+		Timeout:   kafkaWriteTimeout,
+		DualStack: true,
+	}
+	if conf.UseTLS {
+		dialer.TLS = &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify}
+	}
+	if conf.SASLMechanism != "" {
+		mechanism, err := newKafkaSASLMechanism(conf)
+		if err != nil {
+			return nil, err
+		}
+		dialer.SASLMechanism = mechanism
+	}
+	writer := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      conf.Hosts,
+		Topic:        conf.Topic,
+		Dialer:       dialer,
+		Balancer:     &kafka.Hash{},
+		MaxAttempts:  kafkaWriteMaxAttempts,
+		BatchTimeout: 100 * time.Millisecond,
+		WriteTimeout: kafkaWriteTimeout,
+		RequiredAcks: -1,
+	})
+	kafkaWriters[key] = writer
+	return &KafkaCommandStorage{Topic: conf.Topic, writer: writer}, nil
+}
+
+func newKafkaSASLMechanism(conf KafkaConfig) (sasl.Mechanism, error) {
+	switch strings.ToUpper(conf.SASLMechanism) {
+	case KafkaSASLPlain:
+		return plain.Mechanism{Username: conf.SASLUsername, Password: conf.SASLPassword}, nil
+	case KafkaSASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, conf.SASLUsername, conf.SASLPassword)
+	case KafkaSASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, conf.SASLUsername, conf.SASLPassword)
+	default:
+		return nil, fmt.Errorf("kafka sasl mechanism %s not support", conf.SASLMechanism)
+	}
+}
+
+type KafkaCommandStorage struct {
+	Topic string
+
+	writer kafkaWriter
+}
+
+// BulkSave 每条命令作为一条 JSON 消息, 以会话 ID 作为 key 保证同一会话的命令在同一分区有序
+func (k *KafkaCommandStorage) BulkSave(commands []*model.Command) (err error) {
+	msgs := make([]kafka.Message, 0, len(commands))
+	for _, item := range commands {
+		data, err := json.Marshal(item)
+		if err != nil {
+			logger.Errorf("Kafka marshal data to json err: %s", err)
+			return err
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:   []byte(item.SessionID),
+			Value: data,
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaWriteTimeout*kafkaWriteMaxAttempts)
+	defer cancel()
+	if err = k.writer.WriteMessages(ctx, msgs...); err != nil {
+		logger.Errorf("Kafka write %d commands to topic %s err: %s", len(msgs), k.Topic, err)
+		return err
+	}
+	logger.Infof("Kafka write %d commands to topic %s success", len(msgs), k.Topic)
+	return nil
+}
+
+func (k *KafkaCommandStorage) TypeName() string {
+	return "kafka"
+}
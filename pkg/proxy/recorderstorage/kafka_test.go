@@ -0,0 +1,74 @@
+package recorderstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+)
+
+type fakeKafkaWriter struct {
+	msgs []kafka.Message
+	err  error
+}
+
+func (f *fakeKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.msgs = append(f.msgs, msgs...)
+	return nil
+}
+
+func TestKafkaCommandStorage_BulkSave(t *testing.T) {
+	writer := &fakeKafkaWriter{}
+	storage := &KafkaCommandStorage{Topic: defaultKafkaTopic, writer: writer}
+	commands := []*model.Command{
+		{SessionID: "sid-1", Input: "ls", Output: "a.txt", User: "admin"},
+		{SessionID: "sid-2", Input: "pwd", Output: "/root", User: "admin"},
+	}
+	if err := storage.BulkSave(commands); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.msgs) != len(commands) {
+		t.Fatalf("expect %d messages, got %d", len(commands), len(writer.msgs))
+	}
+	for i := range writer.msgs {
+		if string(writer.msgs[i].Key) != commands[i].SessionID {
+			t.Fatalf("message key %s not session id %s", writer.msgs[i].Key, commands[i].SessionID)
+		}
+		var cmd model.Command
+		if err := json.Unmarshal(writer.msgs[i].Value, &cmd); err != nil {
+			t.Fatal(err)
+		}
+		if cmd.Input != commands[i].Input {
+			t.Fatalf("message value %s not match", writer.msgs[i].Value)
+		}
+	}
+
+	writer.err = errors.New("broker not available")
+	if err := storage.BulkSave(commands); err == nil {
+		t.Fatal("expect bulk save err")
+	}
+}
+
+func TestNewKafkaCommandStorage(t *testing.T) {
+	conf := KafkaConfig{Hosts: []string{"127.0.0.1:9092"}, SASLMechanism: KafkaSASLScramSHA512,
+		SASLUsername: "koko", SASLPassword: "koko"}
+	storage1, err := NewKafkaCommandStorage(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage2, _ := NewKafkaCommandStorage(conf)
+	if storage1.writer != storage2.writer || storage1.Topic != defaultKafkaTopic {
+		t.Fatal("kafka writer not shared")
+	}
+	conf.SASLMechanism = "GSSAPI"
+	if _, err = NewKafkaCommandStorage(conf); err == nil {
+		t.Fatal("expect unsupported sasl mechanism err")
+	}
+}
@@ -0,0 +1,27 @@
+package recorderstorage
+
+import (
+	"github.com/jumpserver/koko/pkg/auditlog"
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+type SyslogCommandStorage struct {
+	Writer *auditlog.Writer
+}
+
+func (s SyslogCommandStorage) BulkSave(commands []*model.Command) (err error) {
+	events := make([]auditlog.Event, 0, len(commands))
+	for i := range commands {
+		events = append(events, auditlog.CommandEvent(commands[i]))
+	}
+	if err = s.Writer.Write(events...); err != nil {
+		logger.Errorf("Syslog %s send %d commands err: %s", s.Writer, len(commands), err)
+		return err
+	}
+	return nil
+}
+
+func (s SyslogCommandStorage) TypeName() string {
+	return "syslog"
+}
@@ -0,0 +1,304 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/exchange"
+	"github.com/jumpserver/koko/pkg/i18n"
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+	"github.com/jumpserver/koko/pkg/logger"
+	"github.com/jumpserver/koko/pkg/srvconn"
+)
+
+/*
+	Redis 会话的命令由 srvconn.RedisConn 解析为参数, 通过 CommandInterceptor 交给 RedisParser 过滤和记录,
+	数据流只做转发。命令类型的规则每行按 redis-cli 的规则拆分参数, 与命令的前几个参数逐个比较:
+	命令名称和容器命令的子命令(如 CONFIG SET)不区分大小写, 其他参数需要完全一致,
+	如 FLUSHALL 匹配所有 FLUSHALL 命令, KEYS * 只匹配 KEYS *; 正则规则匹配合并为一行的命令。
+	与数据库会话一致, 暂不支持命令复核。
+*/
+
+var (
+	_ ParseEngine                = (*RedisParser)(nil)
+	_ srvconn.CommandInterceptor = (*RedisParser)(nil)
+)
+
+// redisContainerCommands 第二个参数为子命令的命令
+var redisContainerCommands = map[string]bool{
+	"ACL":      true,
+	"CLIENT":   true,
+	"CLUSTER":  true,
+	"COMMAND":  true,
+	"CONFIG":   true,
+	"DEBUG":    true,
+	"FUNCTION": true,
+	"LATENCY":  true,
+	"MEMORY":   true,
+	"MODULE":   true,
+	"OBJECT":   true,
+	"PUBSUB":   true,
+	"SCRIPT":   true,
+	"SLOWLOG":  true,
+	"XGROUP":   true,
+	"XINFO":    true,
+}
+
+type RedisParser struct {
+	id string
+
+	userOutputChan chan []byte
+	srvOutputChan  chan []byte
+	cmdRecordChan  chan *ExecutedCommand
+
+	cmdFilterRules []model.SystemUserFilterRule
+	auditBanner    string
+	closed         chan struct{}
+
+	lock         *sync.Mutex
+	recordClosed bool
+	// 执行中的命令匹配的审计规则和开始时间
+	matchedRule   model.SystemUserFilterRule
+	cmdCreateDate time.Time
+	currentUser   CurrentActiveUser
+}
+
+func (p *RedisParser) initial() {
+	p.lock = new(sync.Mutex)
+	p.closed = make(chan struct{})
+	p.cmdRecordChan = make(chan *ExecutedCommand, 1024)
+}
+
+// ParseStream 终端在 RedisConn 中处理, 数据流直接转发
+func (p *RedisParser) ParseStream(userInChan chan *exchange.RoomMessage, srvInChan <-chan []byte) (userOut, srvOut <-chan []byte) {
+	p.userOutputChan = make(chan []byte, 1)
+	p.srvOutputChan = make(chan []byte, 1)
+	logger.Infof("Redis Session %s: Parser start", p.id)
+	go func() {
+		defer func() {
+			p.lock.Lock()
+			p.recordClosed = true
+			close(p.cmdRecordChan)
+			p.lock.Unlock()
+			close(p.userOutputChan)
+			close(p.srvOutputChan)
+			logger.Infof("Redis Session %s: Parser routine done", p.id)
+		}()
+		for {
+			select {
+			case <-p.closed:
+				return
+			case msg, ok := <-userInChan:
+				if !ok {
+					return
+				}
+				var b []byte
+				switch msg.Event {
+				case exchange.DataEvent:
+					b = msg.Body
+				}
+				p.UpdateMeta(msg)
+				select {
+				case <-p.closed:
+					return
+				case p.userOutputChan <- b:
+				}
+			case b, ok := <-srvInChan:
+				if !ok {
+					return
+				}
+				select {
+				case <-p.closed:
+					return
+				case p.srvOutputChan <- b:
+				}
+			}
+		}
+	}()
+	return p.userOutputChan, p.srvOutputChan
+}
+
+// CheckCommand 拒绝的命令直接记录, 审计的命令执行后记录为警告
+func (p *RedisParser) CheckCommand(args []string) (string, error) {
+	line := srvconn.FormatRedisArgs(redactRedisArgs(args))
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.cmdCreateDate = time.Now()
+	p.matchedRule = model.SystemUserFilterRule{}
+	rule, cmd, ok := matchRedisFilterRules(p.cmdFilterRules, args, line)
+	if !ok {
+		return "", nil
+	}
+	switch rule.Action {
+	case model.ActionDeny:
+		msg := fmt.Sprintf(i18n.T("Command `%s` is forbidden"), cmd)
+		p.sendCommandRecord(&ExecutedCommand{
+			Command:     line,
+			Output:      msg,
+			CreatedDate: p.cmdCreateDate,
+			RiskLevel:   model.HighRiskFlag,
+			RuleID:      rule.ID,
+		})
+		return "", errors.New(msg)
+	case model.ActionAudit:
+		logger.Infof("Redis Session %s: command `%s` matched audit rule %s", p.id, line, rule.ID)
+		p.matchedRule = rule
+		return p.auditBanner, nil
+	}
+	return "", nil
+}
+
+// CommandDone 记录命令和显示的回复
+func (p *RedisParser) CommandDone(args []string, output string) {
+	line := srvconn.FormatRedisArgs(redactRedisArgs(args))
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	riskLevel := model.LessRiskFlag
+	if p.matchedRule.Action == model.ActionAudit {
+		riskLevel = model.WarnRiskFlag
+	}
+	// 与终端输出一致使用 \r\n, 结尾的换行在生成命令结果时去掉
+	output = strings.ReplaceAll(output, "\n", "\r\n") + "\r\n"
+	p.sendCommandRecord(&ExecutedCommand{
+		Command:     line,
+		Output:      output,
+		CreatedDate: p.cmdCreateDate,
+		RiskLevel:   riskLevel,
+		RuleID:      p.matchedRule.ID,
+	})
+	p.matchedRule = model.SystemUserFilterRule{}
+}
+
+// sendCommandRecord 需要持有锁, 会话结束后不再记录
+func (p *RedisParser) sendCommandRecord(item *ExecutedCommand) {
+	if p.recordClosed {
+		return
+	}
+	item.User = p.currentUser
+	p.cmdRecordChan <- item
+}
+
+func (p *RedisParser) Close() {
+	select {
+	case <-p.closed:
+		return
+	default:
+		close(p.closed)
+	}
+	logger.Infof("Redis Session %s: Parser close", p.id)
+}
+
+func (p *RedisParser) Resize(int, int) {}
+
+func (p *RedisParser) NeedRecord() bool {
+	return true
+}
+
+func (p *RedisParser) CommandRecordChan() chan *ExecutedCommand {
+	return p.cmdRecordChan
+}
+
+func (p *RedisParser) UpdateMeta(msg *exchange.RoomMessage) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.currentUser.UserId = msg.Meta.UserId
+	p.currentUser.User = msg.Meta.User
+}
+
+func (p *RedisParser) RegisterEventCallback(event string, f func()) {}
+
+// matchRedisFilterRules 按优先级匹配规则, 返回匹配的规则和匹配的命令
+func matchRedisFilterRules(rules []model.SystemUserFilterRule, args []string, line string) (model.SystemUserFilterRule, string, bool) {
+	index, cmd, ok := firstMatchedRule(len(rules), func(i int) (model.RuleAction, string) {
+		rule := &rules[i]
+		if rule.Action == model.ActionConfirm {
+			return model.ActionUnknown, ""
+		}
+		if rule.Type != model.TypeCmd {
+			return rule.Match(line)
+		}
+		if n := matchRedisCommandRule(rule.Content, args); n > 0 {
+			return rule.Action, srvconn.FormatRedisArgs(redactRedisArgs(args)[:n])
+		}
+		return model.ActionUnknown, ""
+	})
+	if !ok {
+		return model.SystemUserFilterRule{}, "", false
+	}
+	return rules[index], cmd, true
+}
+
+// matchRedisCommandRule 规则的每行为一个命令, 返回匹配的参数个数, 不匹配时为 0
+func matchRedisCommandRule(content string, args []string) int {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.ReplaceAll(content, "\r", "\n")
+	for _, item := range strings.Split(content, "\n") {
+		tokens, err := srvconn.SplitRedisArgs(item)
+		if err != nil || len(tokens) == 0 || len(tokens) > len(args) {
+			continue
+		}
+		matched := true
+		for i, token := range tokens {
+			caseInsensitive := i == 0 || i == 1 && redisContainerCommands[strings.ToUpper(args[0])]
+			if caseInsensitive && !strings.EqualFold(token, args[i]) || !caseInsensitive && token != args[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return len(tokens)
+		}
+	}
+	return 0
+}
+
+// redactRedisArgs 替换 AUTH、HELLO AUTH、MIGRATE AUTH、CONFIG SET、ACL SETUSER 中的密码
+func redactRedisArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+	if len(args) == 0 {
+		return redacted
+	}
+	switch name := strings.ToUpper(args[0]); {
+	case name == "AUTH" && len(args) > 1:
+		redacted[len(args)-1] = commandRedactReplacement
+	case name == "HELLO" || name == "MIGRATE":
+		for i := 1; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "AUTH":
+				if name == "HELLO" {
+					// HELLO protover AUTH username password
+					i++
+				}
+				if i+1 < len(args) {
+					redacted[i+1] = commandRedactReplacement
+				}
+				return redacted
+			case "AUTH2":
+				// MIGRATE ... AUTH2 username password
+				if i+2 < len(args) {
+					redacted[i+2] = commandRedactReplacement
+				}
+				return redacted
+			}
+		}
+	case name == "CONFIG" && len(args) > 2 && strings.EqualFold(args[1], "SET"):
+		for i := 2; i+1 < len(args); i += 2 {
+			switch strings.ToLower(args[i]) {
+			case "requirepass", "masterauth":
+				redacted[i+1] = commandRedactReplacement
+			}
+		}
+	case name == "ACL" && len(args) > 2 && strings.EqualFold(args[1], "SETUSER"):
+		// >password 添加密码, <password 删除密码, #hash !hash 为密码的哈希
+		for i := 3; i < len(args); i++ {
+			if arg := args[i]; len(arg) > 1 && strings.ContainsRune("><#!", rune(arg[0])) {
+				redacted[i] = arg[:1] + commandRedactReplacement
+			}
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+	"github.com/jumpserver/koko/pkg/srvconn"
+)
+
+func TestMatchRedisFilterRules(t *testing.T) {
+	rules := []model.SystemUserFilterRule{
+		{ID: "flush", Type: model.TypeCmd, Content: "FLUSHALL\nflushdb", Action: model.ActionDeny, Priority: 1},
+		{ID: "config", Type: model.TypeCmd, Content: "CONFIG SET", Action: model.ActionDeny, Priority: 2},
+		{ID: "keys", Type: model.TypeCmd, Content: "KEYS *", Action: model.ActionAudit, Priority: 3},
+		{ID: "regex", Type: model.TypeRegex, Content: `^DEL user:`, Action: model.ActionAudit, Priority: 4},
+		{ID: "confirm", Type: model.TypeCmd, Content: "SHUTDOWN", Action: model.ActionConfirm, Priority: 5},
+	}
+	tests := []struct {
+		args   []string
+		ruleID string
+		cmd    string
+	}{
+		{[]string{"flushall", "ASYNC"}, "flush", "flushall"},
+		{[]string{"FLUSHDB"}, "flush", "FLUSHDB"},
+		{[]string{"config", "set", "maxmemory", "1gb"}, "config", "config set"},
+		{[]string{"CONFIG", "GET", "maxmemory"}, "", ""},
+		{[]string{"KEYS", "*"}, "keys", "KEYS *"},
+		{[]string{"keys", "user:*"}, "", ""},
+		{[]string{"DEL", "user:1", "user:2"}, "regex", "DEL user:"},
+		{[]string{"GET", "FLUSHALL"}, "", ""},
+		{[]string{"SHUTDOWN"}, "", ""},
+	}
+	for _, tt := range tests {
+		line := srvconn.FormatRedisArgs(tt.args)
+		rule, cmd, ok := matchRedisFilterRules(rules, tt.args, line)
+		if ok != (tt.ruleID != "") || rule.ID != tt.ruleID || cmd != tt.cmd {
+			t.Errorf("%s: expect rule %q %q, got %q %q", line, tt.ruleID, tt.cmd, rule.ID, cmd)
+		}
+	}
+}
+
+func TestRedactRedisArgs(t *testing.T) {
+	tests := []struct {
+		args   []string
+		expect []string
+	}{
+		{[]string{"AUTH", "secret"}, []string{"AUTH", "******"}},
+		{[]string{"auth", "user", "secret"}, []string{"auth", "user", "******"}},
+		{[]string{"HELLO", "3", "AUTH", "user", "secret"}, []string{"HELLO", "3", "AUTH", "user", "******"}},
+		{[]string{"MIGRATE", "h", "6379", "k", "0", "10", "AUTH", "secret"},
+			[]string{"MIGRATE", "h", "6379", "k", "0", "10", "AUTH", "******"}},
+		{[]string{"CONFIG", "SET", "maxmemory", "1gb", "requirepass", "secret"},
+			[]string{"CONFIG", "SET", "maxmemory", "1gb", "requirepass", "******"}},
+		{[]string{"ACL", "SETUSER", "bob", "on", ">secret", "~*"},
+			[]string{"ACL", "SETUSER", "bob", "on", ">******", "~*"}},
+		{[]string{"GET", "secret"}, []string{"GET", "secret"}},
+	}
+	for _, tt := range tests {
+		if got := redactRedisArgs(tt.args); !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("%q: expect %q, got %q", tt.args, tt.expect, got)
+		}
+	}
+}
@@ -0,0 +1,645 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/jumpserver/koko/pkg/config"
+)
+
+/*
+	录像信封加密: 每个录像文件使用随机的数据密钥(AES-256-GCM 分块流式加密),
+	数据密钥使用配置的公钥 (age X25519 公钥或 RSA 公钥) 加密后保存在文件头部。
+	注意: 只是复用了 age 的密钥格式, 文件不是 age 格式, age 命令无法解密, 需要使用 replaytool decrypt 解密。
+
+	koko-encrypted-replay/v1\n
+	{"algorithm":"x25519","key_id":"...","ephemeral_key":"...","wrapped_key":"...","chunk_size":65536}\n
+	chunk(0) chunk(1) ... chunk(n)
+
+	头部 json 中的二进制字段为 base64 编码, key_id 为公钥 sha256 的前 4 个字节(RSA 公钥为 PKCS1 编码)。
+	x25519: 临时私钥和 age 公钥协商 shared, wrap_key = HKDF-SHA256(shared, salt=ephemeral_key+公钥,
+	info="koko-replay/v1/X25519"), wrapped_key 为 AES-256-GCM(wrap_key, nonce=0) 加密的数据密钥。
+	rsa-oaep: wrapped_key 为 RSA-OAEP-SHA256(label="koko-replay/v1/RSA-OAEP") 加密的数据密钥。
+	每个分块为 chunk_size 字节的明文加密后的密文和 16 字节的 tag, 最后一个分块可能更短(可以为空),
+	分块的 nonce 为 11 字节的分块序号(大端)和 1 字节的结束标记，防止分块被重排或截断。
+	chunk_size 固定为 65536, 文件开头到头部 json 的换行符(包括换行符)作为每个分块的附加数据, 头部被修改后无法解密。
+
+	加密在上传前进行, 会话过程中本地的录像文件(包括分段)是明文, 需要限制录像目录的访问权限。
+*/
+
+const (
+	ReplayEncryptX25519  = "x25519"
+	ReplayEncryptRSAOAEP = "rsa-oaep"
+
+	replayEncryptMagic     = "koko-encrypted-replay/v1\n"
+	replayEncryptChunkSize = 64 * 1024
+
+	x25519RecipientHRP = "age"
+	x25519IdentityHRP  = "age-secret-key-"
+	x25519WrapInfo     = "koko-replay/v1/X25519"
+	rsaOAEPLabel       = "koko-replay/v1/RSA-OAEP"
+)
+
+var (
+	ErrReplayKeyMismatch  = errors.New("replay encrypt key mismatch")
+	ErrReplayNotEncrypted = errors.New("replay not encrypted")
+	ErrReplayTruncated    = errors.New("encrypted replay truncated")
+)
+
+type ReplayEncryptHeader struct {
+	Algorithm    string `json:"algorithm"`
+	KeyID        string `json:"key_id"`
+	EphemeralKey []byte `json:"ephemeral_key,omitempty"`
+	WrappedKey   []byte `json:"wrapped_key"`
+	ChunkSize    int    `json:"chunk_size"`
+}
+
+// ReplayRecipient 加密数据密钥的公钥
+type ReplayRecipient interface {
+	Wrap(dataKey []byte) (*ReplayEncryptHeader, error)
+}
+
+// ReplayIdentity 解密数据密钥的私钥
+type ReplayIdentity interface {
+	Unwrap(header *ReplayEncryptHeader) ([]byte, error)
+}
+
+// LoadReplayRecipient 未配置加密公钥时返回 nil
+func LoadReplayRecipient() (ReplayRecipient, error) {
+	key := strings.TrimSpace(config.GetConf().ReplayEncryptPublicKey)
+	if key == "" {
+		return nil, nil
+	}
+	return ParseReplayRecipient(key)
+}
+
+// ParseReplayRecipient 支持 age 公钥 (age1...) 或者 RSA 公钥 PEM 文件路径
+func ParseReplayRecipient(key string) (ReplayRecipient, error) {
+	if strings.HasPrefix(key, x25519RecipientHRP+"1") {
+		return parseX25519Recipient(key)
+	}
+	block, err := readPEMFile(key)
+	if err != nil {
+		return nil, err
+	}
+	var pub interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not rsa public key", key)
+	}
+	return &rsaRecipient{publicKey: rsaPub}, nil
+}
+
+// ParseReplayIdentity 支持 age 私钥 (AGE-SECRET-KEY-1...) 或者 RSA 私钥 PEM 文件路径
+func ParseReplayIdentity(key string) (ReplayIdentity, error) {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(strings.ToLower(key), x25519IdentityHRP+"1") {
+		return parseX25519Identity(key)
+	}
+	block, err := readPEMFile(key)
+	if err != nil {
+		return nil, err
+	}
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rsaPrivate, ok := private.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not rsa private key", key)
+	}
+	return &rsaIdentity{privateKey: rsaPrivate}, nil
+}
+
+func readPEMFile(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s no pem data", path)
+	}
+	return block, nil
+}
+
+func keyID(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:4])
+}
+
+type x25519Recipient struct {
+	publicKey []byte
+}
+
+func parseX25519Recipient(key string) (*x25519Recipient, error) {
+	hrp, data, err := bech32Decode(key)
+	if err != nil {
+		return nil, err
+	}
+	if hrp != x25519RecipientHRP || len(data) != curve25519.PointSize {
+		return nil, fmt.Errorf("invalid age public key: %s", key)
+	}
+	return &x25519Recipient{publicKey: data}, nil
+}
+
+func (r *x25519Recipient) Wrap(dataKey []byte) (*ReplayEncryptHeader, error) {
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, err
+	}
+	ephemeralShare, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(ephemeral, r.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := x25519Wrap(shared, ephemeralShare, r.publicKey, dataKey, true)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayEncryptHeader{
+		Algorithm:    ReplayEncryptX25519,
+		KeyID:        keyID(r.publicKey),
+		EphemeralKey: ephemeralShare,
+		WrappedKey:   wrapped,
+	}, nil
+}
+
+type x25519Identity struct {
+	secretKey []byte
+	publicKey []byte
+}
+
+func parseX25519Identity(key string) (*x25519Identity, error) {
+	hrp, data, err := bech32Decode(key)
+	if err != nil {
+		return nil, err
+	}
+	if hrp != x25519IdentityHRP || len(data) != curve25519.ScalarSize {
+		return nil, errors.New("invalid age secret key")
+	}
+	publicKey, err := curve25519.X25519(data, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &x25519Identity{secretKey: data, publicKey: publicKey}, nil
+}
+
+// Recipient 返回私钥对应的 age 公钥
+func (i *x25519Identity) Recipient() string {
+	s, _ := bech32Encode(x25519RecipientHRP, i.publicKey)
+	return s
+}
+
+func (i *x25519Identity) Unwrap(header *ReplayEncryptHeader) ([]byte, error) {
+	if header.Algorithm != ReplayEncryptX25519 || header.KeyID != keyID(i.publicKey) {
+		return nil, ErrReplayKeyMismatch
+	}
+	shared, err := curve25519.X25519(i.secretKey, header.EphemeralKey)
+	if err != nil {
+		return nil, err
+	}
+	return x25519Wrap(shared, header.EphemeralKey, i.publicKey, header.WrappedKey, false)
+}
+
+// x25519Wrap 使用 HKDF 派生的密钥加密或解密数据密钥, 派生密钥只使用一次, 因此 nonce 固定为 0
+func x25519Wrap(shared, ephemeralShare, publicKey, data []byte, seal bool) ([]byte, error) {
+	salt := make([]byte, 0, len(ephemeralShare)+len(publicKey))
+	salt = append(salt, ephemeralShare...)
+	salt = append(salt, publicKey...)
+	wrapKey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(x25519WrapInfo)), wrapKey); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if seal {
+		return aead.Seal(nil, nonce, data, nil), nil
+	}
+	return aead.Open(nil, nonce, data, nil)
+}
+
+type rsaRecipient struct {
+	publicKey *rsa.PublicKey
+}
+
+func (r *rsaRecipient) Wrap(dataKey []byte) (*ReplayEncryptHeader, error) {
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, r.publicKey, dataKey, []byte(rsaOAEPLabel))
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayEncryptHeader{
+		Algorithm:  ReplayEncryptRSAOAEP,
+		KeyID:      keyID(x509.MarshalPKCS1PublicKey(r.publicKey)),
+		WrappedKey: wrapped,
+	}, nil
+}
+
+type rsaIdentity struct {
+	privateKey *rsa.PrivateKey
+}
+
+func (i *rsaIdentity) Unwrap(header *ReplayEncryptHeader) ([]byte, error) {
+	if header.Algorithm != ReplayEncryptRSAOAEP ||
+		header.KeyID != keyID(x509.MarshalPKCS1PublicKey(&i.privateKey.PublicKey)) {
+		return nil, ErrReplayKeyMismatch
+	}
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, i.privateKey, header.WrappedKey, []byte(rsaOAEPLabel))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(nonce []byte, counter uint64, last bool) {
+	for i := range nonce {
+		nonce[i] = 0
+	}
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:len(nonce)-1], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+}
+
+type replayEncryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	ad      []byte
+	buf     []byte
+	out     []byte
+	nonce   []byte
+	counter uint64
+}
+
+// NewReplayEncryptWriter 必须调用 Close 写入最后一个分块
+func NewReplayEncryptWriter(w io.Writer, recipient ReplayRecipient) (io.WriteCloser, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	header, err := recipient.Wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header.ChunkSize = replayEncryptChunkSize
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	ad := append([]byte(replayEncryptMagic), headerBytes...)
+	ad = append(ad, '\n')
+	if _, err = w.Write(ad); err != nil {
+		return nil, err
+	}
+	return &replayEncryptWriter{
+		w:     w,
+		aead:  aead,
+		ad:    ad,
+		buf:   make([]byte, 0, header.ChunkSize),
+		out:   make([]byte, 0, header.ChunkSize+aead.Overhead()),
+		nonce: make([]byte, aead.NonceSize()),
+	}, nil
+}
+
+func (e *replayEncryptWriter) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		// 缓冲区满并且还有数据时才写入, 保证最后一个分块在 Close 时写入
+		if len(e.buf) == cap(e.buf) {
+			if err := e.flush(false); err != nil {
+				return total - len(p), err
+			}
+		}
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+	}
+	return total, nil
+}
+
+func (e *replayEncryptWriter) flush(last bool) error {
+	chunkNonce(e.nonce, e.counter, last)
+	e.out = e.aead.Seal(e.out[:0], e.nonce, e.buf, e.ad)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+func (e *replayEncryptWriter) Close() error {
+	return e.flush(true)
+}
+
+type replayDecryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	ad      []byte
+	chunk   []byte
+	plain   []byte
+	nonce   []byte
+	counter uint64
+	last    bool
+}
+
+// NewReplayDecryptReader 读取并解密 NewReplayEncryptWriter 写入的数据
+func NewReplayDecryptReader(r io.Reader, identity ReplayIdentity) (io.Reader, error) {
+	reader := bufio.NewReader(r)
+	magic := make([]byte, len(replayEncryptMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != replayEncryptMagic {
+		return nil, ErrReplayNotEncrypted
+	}
+	headerLine, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	var header ReplayEncryptHeader
+	if err = json.Unmarshal(headerLine, &header); err != nil {
+		return nil, err
+	}
+	if header.ChunkSize != replayEncryptChunkSize {
+		return nil, fmt.Errorf("invalid chunk size %d", header.ChunkSize)
+	}
+	dataKey, err := identity.Unwrap(&header)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &replayDecryptReader{
+		r:     reader,
+		aead:  aead,
+		ad:    append([]byte(replayEncryptMagic), headerLine...),
+		chunk: make([]byte, header.ChunkSize+aead.Overhead()),
+		nonce: make([]byte, aead.NonceSize()),
+	}, nil
+}
+
+func (d *replayDecryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.last {
+			return 0, io.EOF
+		}
+		if err := d.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *replayDecryptReader) readChunk() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	switch err {
+	case nil:
+		if _, err2 := d.r.Peek(1); err2 == io.EOF {
+			d.last = true
+		}
+	case io.ErrUnexpectedEOF:
+		d.last = true
+	case io.EOF:
+		return ErrReplayTruncated
+	default:
+		return err
+	}
+	chunkNonce(d.nonce, d.counter, d.last)
+	plain, err := d.aead.Open(d.chunk[:0], d.nonce, d.chunk[:n], d.ad)
+	if err != nil {
+		if d.last {
+			return ErrReplayTruncated
+		}
+		return err
+	}
+	d.counter++
+	d.plain = plain
+	return nil
+}
+
+// IsEncryptedReplay 判断文件是否已经加密
+func IsEncryptedReplay(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(replayEncryptMagic))
+	if _, err = io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, []byte(replayEncryptMagic))
+}
+
+// EncryptReplayFile 原地加密录像文件, 已经加密的文件不再重复加密
+func EncryptReplayFile(path string, recipient ReplayRecipient) error {
+	if IsEncryptedReplay(path) {
+		return nil
+	}
+	tmpPath := path + ".encrypting"
+	if err := encryptFile(path, tmpPath, recipient); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func encryptFile(src, dst string, recipient ReplayRecipient) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+	w, err := NewReplayEncryptWriter(dstFile, recipient)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, srcFile); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return dstFile.Sync()
+}
+
+// DecryptReplayFile 解密下载的录像文件, 用于播放
+func DecryptReplayFile(src, dst string, identity ReplayIdentity) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	r, err := NewReplayDecryptReader(srcFile, identity)
+	if err != nil {
+		return err
+	}
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+	_, err = io.Copy(dstFile, r)
+	return err
+}
+
+/*
+	age 密钥使用的 bech32 编码 (不限制长度): https://github.com/bitcoin/bips/blob/master/bip-0173.mediawiki
+*/
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	v := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		v = append(v, hrp[i]>>5)
+	}
+	v = append(v, 0)
+	for i := 0; i < len(hrp); i++ {
+		v = append(v, hrp[i]&31)
+	}
+	return v
+}
+
+func bech32ConvertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	maxV := uint32(1)<<toBits - 1
+	ret := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, b := range data {
+		if uint32(b)>>fromBits != 0 {
+			return nil, errors.New("invalid bech32 data")
+		}
+		acc = acc<<fromBits | uint32(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			ret = append(ret, byte(acc>>bits&maxV))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			ret = append(ret, byte(acc<<(toBits-bits)&maxV))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxV != 0 {
+		return nil, errors.New("invalid bech32 padding")
+	}
+	return ret, nil
+}
+
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := bech32ConvertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	hrp = strings.ToLower(hrp)
+	values = append(values, make([]byte, 6)...)
+	polymod := bech32Polymod(append(bech32HRPExpand(hrp), values...)) ^ 1
+	for i := 0; i < 6; i++ {
+		values[len(values)-6+i] = byte(polymod >> uint(5*(5-i)) & 31)
+	}
+	var s strings.Builder
+	s.WriteString(hrp)
+	s.WriteByte('1')
+	for _, v := range values {
+		s.WriteByte(bech32Charset[v])
+	}
+	return s.String(), nil
+}
+
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case bech32 string")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid bech32 separator")
+	}
+	hrp := s[:pos]
+	values := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid bech32 character %q", s[i])
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid bech32 checksum")
+	}
+	data, err := bech32ConvertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
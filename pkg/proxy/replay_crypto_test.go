@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestX25519Key(t *testing.T) (ReplayRecipient, ReplayIdentity) {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	secretKey, err := bech32Encode(x25519IdentityHRP, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := ParseReplayIdentity(strings.ToUpper(secretKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := ParseReplayRecipient(identity.(*x25519Identity).Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recipient, identity
+}
+
+func newTestRSAKey(t *testing.T, dir string) (ReplayRecipient, ReplayIdentity) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeyBytes, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	publicKeyPath := filepath.Join(dir, "replay.pub")
+	privateKeyPath := filepath.Join(dir, "replay.key")
+	_ = ioutil.WriteFile(publicKeyPath, pem.EncodeToMemory(&pem.Block{
+		Type: "PUBLIC KEY", Bytes: publicKeyBytes}), 0644)
+	_ = ioutil.WriteFile(privateKeyPath, pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}), 0600)
+	recipient, err := ParseReplayRecipient(publicKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := ParseReplayIdentity(privateKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recipient, identity
+}
+
+func TestReplayEncrypt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	x25519Recipient, x25519Identity := newTestX25519Key(t)
+	rsaRecipient, rsaIdentity := newTestRSAKey(t, dir)
+	keys := []struct {
+		recipient ReplayRecipient
+		identity  ReplayIdentity
+	}{
+		{x25519Recipient, x25519Identity},
+		{rsaRecipient, rsaIdentity},
+	}
+	sizes := []int{0, 1, replayEncryptChunkSize, replayEncryptChunkSize + 1, 3*replayEncryptChunkSize - 7}
+	for _, key := range keys {
+		for _, size := range sizes {
+			plain := make([]byte, size)
+			_, _ = rand.Read(plain)
+			path := filepath.Join(dir, "replay.gz")
+			_ = ioutil.WriteFile(path, plain, 0644)
+			if err = EncryptReplayFile(path, key.recipient); err != nil {
+				t.Fatal(err)
+			}
+			// 重复加密不会生效
+			if err = EncryptReplayFile(path, key.recipient); err != nil {
+				t.Fatal(err)
+			}
+			encrypted, _ := ioutil.ReadFile(path)
+			if size > 16 && bytes.Contains(encrypted, plain) {
+				t.Fatal("replay not encrypted")
+			}
+			decryptedPath := filepath.Join(dir, "replay.decrypted")
+			if err = DecryptReplayFile(path, decryptedPath, key.identity); err != nil {
+				t.Fatalf("size %d decrypt err: %s", size, err)
+			}
+			decrypted, _ := ioutil.ReadFile(decryptedPath)
+			if !bytes.Equal(decrypted, plain) {
+				t.Fatalf("size %d decrypted replay not equal", size)
+			}
+		}
+	}
+}
+
+func TestReplayDecryptInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	recipient, identity := newTestX25519Key(t)
+	_, otherIdentity := newTestX25519Key(t)
+	path := filepath.Join(dir, "replay.gz")
+	_ = ioutil.WriteFile(path, bytes.Repeat([]byte("password\r\n"), replayEncryptChunkSize/5), 0644)
+	if err = EncryptReplayFile(path, recipient); err != nil {
+		t.Fatal(err)
+	}
+	decryptedPath := filepath.Join(dir, "replay.decrypted")
+	if err = DecryptReplayFile(path, decryptedPath, otherIdentity); err != ErrReplayKeyMismatch {
+		t.Fatalf("expect key mismatch, got %v", err)
+	}
+
+	encrypted, _ := ioutil.ReadFile(path)
+	truncatedPath := filepath.Join(dir, "replay.truncated")
+	_ = ioutil.WriteFile(truncatedPath, encrypted[:len(encrypted)-100], 0644)
+	if err = DecryptReplayFile(truncatedPath, decryptedPath, identity); err == nil {
+		t.Fatal("truncated replay not detected")
+	}
+
+	tampered := append([]byte(nil), encrypted...)
+	tampered[len(tampered)/2] ^= 0xff
+	tamperedPath := filepath.Join(dir, "replay.tampered")
+	_ = ioutil.WriteFile(tamperedPath, tampered, 0644)
+	if err = DecryptReplayFile(tamperedPath, decryptedPath, identity); err == nil {
+		t.Fatal("tampered replay not detected")
+	}
+
+	// 头部作为附加数据, 不影响解出数据密钥的修改同样无法解密
+	header := bytes.Replace(encrypted, []byte(`{"algorithm"`), []byte(`{ "algorithm"`), 1)
+	_ = ioutil.WriteFile(tamperedPath, header, 0644)
+	if err = DecryptReplayFile(tamperedPath, decryptedPath, identity); err == nil {
+		t.Fatal("tampered header not detected")
+	}
+	header = bytes.Replace(encrypted, []byte(`"chunk_size":65536`), []byte(`"chunk_size":1073741824`), 1)
+	_ = ioutil.WriteFile(tamperedPath, header, 0644)
+	if err = DecryptReplayFile(tamperedPath, decryptedPath, identity); err == nil {
+		t.Fatal("invalid chunk size not detected")
+	}
+}
+
+func TestBech32(t *testing.T) {
+	hrp, data, err := bech32Decode("age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p")
+	if err != nil || hrp != "age" || len(data) != 32 {
+		t.Fatalf("decode age public key failed: %s %d %v", hrp, len(data), err)
+	}
+	s, _ := bech32Encode(hrp, data)
+	if s != "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p" {
+		t.Fatalf("encode age public key failed: %s", s)
+	}
+	if _, _, err = bech32Decode("age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8q"); err == nil {
+		t.Fatal("invalid checksum not detected")
+	}
+}
@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ReplayFormatJSON      = "json"
+	ReplayFormatAsciicast = "asciicast"
+)
+
+// ReplayHeader 录像头部信息, 目前只有 asciicast 格式会使用
+type ReplayHeader struct {
+	Width     int
+	Height    int
+	Term      string
+	Timestamp time.Time
+}
+
+// ReplayEncoder 录像格式编码器, offset 为距离录像开始的时间
+type ReplayEncoder interface {
+	WriteHeader(header ReplayHeader) error
+	WriteOutput(offset time.Duration, p []byte) error
+	WriteResize(offset time.Duration, width, height int) error
+	WriteEnd(offset time.Duration) error
+}
+
+func NewReplayEncoder(format string, w io.Writer) ReplayEncoder {
+	switch format {
+	case ReplayFormatAsciicast:
+		return &asciicastEncoder{w: w}
+	default:
+		return &jsonReplayEncoder{w: w}
+	}
+}
+
+var (
+	_ ReplayEncoder = (*jsonReplayEncoder)(nil)
+	_ ReplayEncoder = (*asciicastEncoder)(nil)
+)
+
+/*
+	旧版录像格式:
+	{"0.123456":"data","1.234567":"data",...,"0.000000":""}
+*/
+
+type jsonReplayEncoder struct {
+	w    io.Writer
+	once sync.Once
+}
+
+func (e *jsonReplayEncoder) begin() {
+	e.once.Do(func() {
+		_, _ = e.w.Write([]byte("{"))
+	})
+}
+
+func (e *jsonReplayEncoder) WriteHeader(ReplayHeader) error {
+	return nil
+}
+
+func (e *jsonReplayEncoder) WriteOutput(offset time.Duration, p []byte) error {
+	e.begin()
+	data, _ := json.Marshal(string(p))
+	_, err := fmt.Fprintf(e.w, `"%f":%s,`, offset.Seconds(), data)
+	return err
+}
+
+// WriteResize 旧版格式不支持记录窗口变化
+func (e *jsonReplayEncoder) WriteResize(time.Duration, int, int) error {
+	return nil
+}
+
+func (e *jsonReplayEncoder) WriteEnd(offset time.Duration) error {
+	e.begin()
+	_, err := fmt.Fprintf(e.w, `"%f":"","%f":""}`, offset.Seconds(), 0.0)
+	return err
+}
+
+/*
+	asciicast v2 格式: https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md
+	{"version": 2, "width": 80, "height": 24, "timestamp": 1504467315, "env": {"TERM": "xterm"}}
+	[0.248848, "o", "data"]
+	[1.001376, "r", "100x40"]
+*/
+
+type asciicastEncoder struct {
+	w io.Writer
+}
+
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+const (
+	asciicastOutputEvent = "o"
+	asciicastResizeEvent = "r"
+)
+
+func (e *asciicastEncoder) WriteHeader(header ReplayHeader) error {
+	h := asciicastHeader{
+		Version:   2,
+		Width:     header.Width,
+		Height:    header.Height,
+		Timestamp: header.Timestamp.Unix(),
+	}
+	if header.Term != "" {
+		h.Env = map[string]string{"TERM": header.Term}
+	}
+	return e.writeLine(h)
+}
+
+func (e *asciicastEncoder) WriteOutput(offset time.Duration, p []byte) error {
+	return e.writeEvent(offset, asciicastOutputEvent, string(p))
+}
+
+func (e *asciicastEncoder) WriteResize(offset time.Duration, width, height int) error {
+	return e.writeEvent(offset, asciicastResizeEvent, fmt.Sprintf("%dx%d", width, height))
+}
+
+// WriteEnd asciicast 每行都是完整的事件，结束时无需额外写入
+func (e *asciicastEncoder) WriteEnd(time.Duration) error {
+	return nil
+}
+
+func (e *asciicastEncoder) writeEvent(offset time.Duration, event, data string) error {
+	return e.writeLine([]interface{}{offset.Seconds(), event, data})
+}
+
+func (e *asciicastEncoder) writeLine(v interface{}) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	p = append(p, '\n')
+	_, err = e.w.Write(p)
+	return err
+}
+
+// ReplayEvent 录像中的事件, Time 为距离录像开始的秒数
+type ReplayEvent struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// ReplayDecoder 按顺序读取录像事件, 读取结束时返回 io.EOF
+type ReplayDecoder interface {
+	Header() ReplayHeader
+	Next() (ReplayEvent, error)
+}
+
+// NewReplayDecoder 根据内容识别录像格式, 支持 gzip 压缩的录像
+func NewReplayDecoder(r io.Reader) (ReplayDecoder, error) {
+	reader := bufio.NewReader(r)
+	if magic, _ := reader.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		reader = bufio.NewReader(gzReader)
+	}
+	if prefix, err := reader.Peek(len(`{"version"`)); err == nil &&
+		bytes.Equal(prefix, []byte(`{"version"`)) {
+		firstLine, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		var header asciicastHeader
+		if err = json.Unmarshal(firstLine, &header); err != nil {
+			return nil, fmt.Errorf("invalid asciicast header: %w", err)
+		}
+		d := asciicastDecoder{r: reader, header: ReplayHeader{
+			Width:     header.Width,
+			Height:    header.Height,
+			Term:      header.Env["TERM"],
+			Timestamp: time.Unix(header.Timestamp, 0),
+		}}
+		return &d, nil
+	}
+	d := jsonReplayDecoder{decoder: json.NewDecoder(reader)}
+	if _, err := d.decoder.Token(); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+var (
+	_ ReplayDecoder = (*jsonReplayDecoder)(nil)
+	_ ReplayDecoder = (*asciicastDecoder)(nil)
+)
+
+type jsonReplayDecoder struct {
+	decoder *json.Decoder
+}
+
+func (d *jsonReplayDecoder) Header() ReplayHeader {
+	return ReplayHeader{}
+}
+
+func (d *jsonReplayDecoder) Next() (ReplayEvent, error) {
+	for d.decoder.More() {
+		keyToken, err := d.decoder.Token()
+		if err != nil {
+			return ReplayEvent{}, err
+		}
+		valueToken, err := d.decoder.Token()
+		if err != nil {
+			return ReplayEvent{}, err
+		}
+		key, _ := keyToken.(string)
+		data, _ := valueToken.(string)
+		// 旧版格式结尾的空数据不是录像帧
+		if data == "" {
+			continue
+		}
+		offset, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			return ReplayEvent{}, err
+		}
+		return ReplayEvent{Time: offset, Type: asciicastOutputEvent, Data: data}, nil
+	}
+	return ReplayEvent{}, io.EOF
+}
+
+type asciicastDecoder struct {
+	r      *bufio.Reader
+	header ReplayHeader
+	count  int
+}
+
+func (d *asciicastDecoder) Header() ReplayHeader {
+	return d.header
+}
+
+func (d *asciicastDecoder) Next() (ReplayEvent, error) {
+	for {
+		line, err := d.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			d.count++
+			var event []interface{}
+			if err2 := json.Unmarshal(line, &event); err2 != nil || len(event) != 3 {
+				return ReplayEvent{}, fmt.Errorf("invalid asciicast event %d: %s", d.count, line)
+			}
+			offset, _ := event[0].(float64)
+			eventType, _ := event[1].(string)
+			data, _ := event[2].(string)
+			return ReplayEvent{Time: offset, Type: eventType, Data: data}, nil
+		}
+		if err != nil {
+			return ReplayEvent{}, err
+		}
+	}
+}
+
+// DetectReplayFormat 根据录像文件内容判断录像格式
+func DetectReplayFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	firstLine, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	var header asciicastHeader
+	if bytes.HasPrefix(firstLine, []byte(`{"version"`)) &&
+		json.Unmarshal(firstLine, &header) == nil && header.Version == 2 {
+		return ReplayFormatAsciicast, nil
+	}
+	return ReplayFormatJSON, nil
+}
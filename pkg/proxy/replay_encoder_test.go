@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsciicastEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	encoder := NewReplayEncoder(ReplayFormatAsciicast, &buf)
+	header := ReplayHeader{Width: 80, Height: 24, Term: "xterm", Timestamp: time.Unix(1504467315, 0)}
+	if err := encoder.WriteHeader(header); err != nil {
+		t.Fatal(err)
+	}
+	_ = encoder.WriteOutput(1500*time.Millisecond, []byte("ls\r\n"))
+	_ = encoder.WriteResize(2*time.Second, 100, 40)
+	_ = encoder.WriteEnd(3 * time.Second)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expect 3 lines but got %d: %q", len(lines), buf.String())
+	}
+	var h asciicastHeader
+	if err := json.Unmarshal([]byte(lines[0]), &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 2 || h.Width != 80 || h.Height != 24 || h.Timestamp != 1504467315 {
+		t.Fatalf("invalid header: %+v", h)
+	}
+	expects := []string{`[1.5,"o","ls\r\n"]`, `[2,"r","100x40"]`}
+	for i, expect := range expects {
+		if lines[i+1] != expect {
+			t.Fatalf("expect %s but got %s", expect, lines[i+1])
+		}
+	}
+}
+
+func TestJSONReplayEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	encoder := NewReplayEncoder(ReplayFormatJSON, &buf)
+	_ = encoder.WriteHeader(ReplayHeader{Width: 80, Height: 24})
+	_ = encoder.WriteOutput(time.Second, []byte("ls"))
+	_ = encoder.WriteResize(2*time.Second, 100, 40)
+	_ = encoder.WriteEnd(3 * time.Second)
+	var result map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("invalid json replay %s: %s", buf.String(), err)
+	}
+	if result["1.000000"] != "ls" {
+		t.Fatalf("invalid json replay %s", buf.String())
+	}
+}
+
+func TestReplayDecoder(t *testing.T) {
+	for _, format := range []string{ReplayFormatAsciicast, ReplayFormatJSON} {
+		var buf bytes.Buffer
+		gzWriter := gzip.NewWriter(&buf)
+		encoder := NewReplayEncoder(format, gzWriter)
+		_ = encoder.WriteHeader(ReplayHeader{Width: 80, Height: 24, Timestamp: time.Unix(1504467315, 0)})
+		_ = encoder.WriteOutput(time.Second, []byte("ls\r\n"))
+		_ = encoder.WriteOutput(2*time.Second, []byte("bin"))
+		_ = encoder.WriteEnd(3 * time.Second)
+		_ = gzWriter.Close()
+
+		decoder, err := NewReplayDecoder(&buf)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		var events []ReplayEvent
+		for {
+			event, err := decoder.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %s", format, err)
+			}
+			events = append(events, event)
+		}
+		if len(events) != 2 || events[0].Time != 1 || events[1].Data != "bin" {
+			t.Fatalf("%s: invalid events %+v", format, events)
+		}
+		if format == ReplayFormatAsciicast && decoder.Header().Width != 80 {
+			t.Fatalf("invalid header %+v", decoder.Header())
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	录像索引文件, 每行一个 JSON, 上传到录像存储 {date}/{sid}.index.gz:
+	{"type":"header","version":1,"format":"asciicast","interval":10,"keyframe_interval":60}
+	{"type":"offset","time":10.012,"offset":12345}
+	{"type":"keyframe","time":60.003,"offset":45678,"screen":{"width":80,"height":24,"cursor_x":2,"cursor_y":3,"lines":["..."]}}
+	{"type":"command","time":65.321,"offset":46000,"input":"cat /etc/passwd"}
+
+	offset 为未压缩录像文件中事件开始的字节位置, 从该位置开始解析事件即可继续播放;
+	keyframe 为播放到该事件之前的屏幕内容, 跳转时先还原屏幕再播放之后的事件;
+	command 在命令执行结束后才写入, 不保证按时间排序。
+*/
+
+const (
+	ReplayIndexTypeHeader   = "header"
+	ReplayIndexTypeOffset   = "offset"
+	ReplayIndexTypeKeyframe = "keyframe"
+	ReplayIndexTypeCommand  = "command"
+
+	replayIndexVersion = 1
+	replayIndexExt     = ".index"
+
+	// 保存最近事件的位置, 用于查找命令开始时的位置
+	replayIndexRecentEvents = 4096
+)
+
+type ReplayIndexEntry struct {
+	Type   string  `json:"type"`
+	Time   float64 `json:"time"`
+	Offset int64   `json:"offset"`
+
+	Version          int    `json:"version,omitempty"`
+	Format           string `json:"format,omitempty"`
+	Interval         int    `json:"interval,omitempty"`
+	KeyframeInterval int    `json:"keyframe_interval,omitempty"`
+
+	Screen *VTSnapshot `json:"screen,omitempty"`
+	Input  string      `json:"input,omitempty"`
+}
+
+type replayIndexPoint struct {
+	time   time.Duration
+	offset int64
+}
+
+type replayIndexWriter struct {
+	mu     sync.Mutex
+	file   *os.File
+	buf    *bufio.Writer
+	screen *VTScreen
+	err    error
+	closed bool
+
+	interval         time.Duration
+	keyframeInterval time.Duration
+	nextOffset       time.Duration
+	nextKeyframe     time.Duration
+
+	recent     []replayIndexPoint
+	recentNext int
+}
+
+// newReplayIndexWriter interval 必须大于 0, keyframeInterval 为 0 时不记录关键帧
+func newReplayIndexWriter(path, format string, header ReplayHeader,
+	interval, keyframeInterval time.Duration) (*replayIndexWriter, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid replay index interval %s", interval)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := replayIndexWriter{
+		file:             f,
+		buf:              bufio.NewWriter(f),
+		screen:           NewVTScreen(header.Width, header.Height),
+		interval:         interval,
+		keyframeInterval: keyframeInterval,
+		nextOffset:       interval,
+		nextKeyframe:     keyframeInterval,
+		recent:           make([]replayIndexPoint, 0, replayIndexRecentEvents),
+	}
+	w.writeEntry(&ReplayIndexEntry{
+		Type:             ReplayIndexTypeHeader,
+		Version:          replayIndexVersion,
+		Format:           format,
+		Interval:         int(interval / time.Second),
+		KeyframeInterval: int(keyframeInterval / time.Second),
+	})
+	return &w, w.err
+}
+
+func (w *replayIndexWriter) writeEntry(entry *ReplayIndexEntry) {
+	if w.err != nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		data = append(data, '\n')
+		_, err = w.buf.Write(data)
+	}
+	if err != nil {
+		w.err = err
+		logger.Errorf("Write replay index %s err: %s", w.file.Name(), err)
+	}
+}
+
+// checkpoint 事件写入录像之前调用, 到达间隔时记录位置和屏幕快照
+func (w *replayIndexWriter) checkpoint(t time.Duration, offset int64) {
+	if w.keyframeInterval > 0 && t >= w.nextKeyframe {
+		w.writeEntry(&ReplayIndexEntry{
+			Type:   ReplayIndexTypeKeyframe,
+			Time:   t.Seconds(),
+			Offset: offset,
+			Screen: w.screen.Snapshot(),
+		})
+		w.nextKeyframe = (t/w.keyframeInterval + 1) * w.keyframeInterval
+		w.nextOffset = (t/w.interval + 1) * w.interval
+	} else if t >= w.nextOffset {
+		w.writeEntry(&ReplayIndexEntry{
+			Type:   ReplayIndexTypeOffset,
+			Time:   t.Seconds(),
+			Offset: offset,
+		})
+		w.nextOffset = (t/w.interval + 1) * w.interval
+	}
+	point := replayIndexPoint{time: t, offset: offset}
+	if len(w.recent) < cap(w.recent) {
+		w.recent = append(w.recent, point)
+		return
+	}
+	w.recent[w.recentNext] = point
+	w.recentNext = (w.recentNext + 1) % len(w.recent)
+}
+
+func (w *replayIndexWriter) Output(t time.Duration, offset int64, p []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.checkpoint(t, offset)
+	_, _ = w.screen.Write(p)
+}
+
+func (w *replayIndexWriter) Resize(t time.Duration, offset int64, width, height int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.checkpoint(t, offset)
+	w.screen.Resize(width, height)
+}
+
+// Command 标记命令, 位置为命令开始后的第一个事件
+func (w *replayIndexWriter) Command(t time.Duration, input string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	entry := ReplayIndexEntry{
+		Type:  ReplayIndexTypeCommand,
+		Time:  t.Seconds(),
+		Input: input,
+	}
+	var found bool
+	for i := 0; i < len(w.recent); i++ {
+		// 按时间顺序遍历环形缓冲
+		point := w.recent[(w.recentNext+i)%len(w.recent)]
+		if point.time >= t {
+			entry.Offset = point.offset
+			found = true
+			break
+		}
+	}
+	if !found && len(w.recent) > 0 {
+		// 命令之后还没有新的事件, 使用最后一个事件的位置
+		entry.Offset = w.recent[(w.recentNext+len(w.recent)-1)%len(w.recent)].offset
+	}
+	w.writeEntry(&entry)
+}
+
+func (w *replayIndexWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if err := w.buf.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// indexEncoder 写入录像的同时记录索引
+type indexEncoder struct {
+	ReplayEncoder
+	index   *replayIndexWriter
+	counter *countingWriter
+}
+
+func (e *indexEncoder) WriteOutput(offset time.Duration, p []byte) error {
+	e.index.Output(offset, e.counter.Count(), p)
+	return e.ReplayEncoder.WriteOutput(offset, p)
+}
+
+func (e *indexEncoder) WriteResize(offset time.Duration, width, height int) error {
+	e.index.Resize(offset, e.counter.Count(), width, height)
+	return e.ReplayEncoder.WriteResize(offset, width, height)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingWriter) Count() int64 {
+	return c.n
+}
+
+// LoadReplayIndex 读取索引文件, 支持 gzip 压缩, 返回的条目按时间排序
+func LoadReplayIndex(r io.Reader) ([]ReplayIndexEntry, error) {
+	reader := bufio.NewReader(r)
+	var src io.Reader = reader
+	if magic, _ := reader.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzReader.Close()
+		src = gzReader
+	}
+	var entries []ReplayIndexEntry
+	decoder := json.NewDecoder(src)
+	for {
+		var entry ReplayIndexEntry
+		if err := decoder.Decode(&entry); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	// 头部的时间为 0, 稳定排序后仍然在第一行
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time < entries[j].Time
+	})
+	return entries, nil
+}
+
+// SeekReplayIndex 返回 t 之前最近的关键帧, 没有关键帧时返回 nil, 从 offset 开始播放
+func SeekReplayIndex(entries []ReplayIndexEntry, t float64) (keyframe *ReplayIndexEntry, offset int64) {
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Type != ReplayIndexTypeKeyframe || entry.Time > t {
+			continue
+		}
+		if keyframe == nil || entry.Time >= keyframe.Time {
+			keyframe = entry
+		}
+	}
+	if keyframe != nil {
+		offset = keyframe.Offset
+	}
+	return keyframe, offset
+}
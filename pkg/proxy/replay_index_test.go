@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayIndexWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	indexPath := filepath.Join(dir, "replay"+replayIndexExt)
+	header := ReplayHeader{Width: 40, Height: 10, Timestamp: time.Now()}
+	index, err := newReplayIndexWriter(indexPath, ReplayFormatAsciicast, header, time.Second, 3*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replay bytes.Buffer
+	counter := &countingWriter{w: &replay}
+	encoder := &indexEncoder{
+		ReplayEncoder: NewReplayEncoder(ReplayFormatAsciicast, counter),
+		index:         index,
+		counter:       counter,
+	}
+	_ = encoder.WriteHeader(header)
+	for i := 0; i < 10; i++ {
+		offset := time.Duration(i)*time.Second + time.Millisecond
+		_ = encoder.WriteOutput(offset, []byte("$ echo "+string(rune('a'+i))+"\r\n"))
+		if i == 5 {
+			index.Command(offset-time.Microsecond, "echo f")
+		}
+	}
+	_ = encoder.WriteEnd(10 * time.Second)
+	if err = index.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries, err := LoadReplayIndex(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].Type != ReplayIndexTypeHeader || entries[0].Interval != 1 {
+		t.Fatalf("invalid index header: %+v", entries[0])
+	}
+	counts := make(map[string]int)
+	data := replay.Bytes()
+	for _, entry := range entries[1:] {
+		counts[entry.Type]++
+		// 每个位置都应该是一个完整事件的开始
+		line := string(data[entry.Offset:])
+		line = line[:strings.IndexByte(line, '\n')]
+		var event []interface{}
+		if err = json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("offset %d is not event start: %s", entry.Offset, line)
+		}
+		if entry.Type == ReplayIndexTypeCommand && event[2] != "$ echo f\r\n" {
+			t.Fatalf("command mark should point to the command output, got %v", event)
+		}
+	}
+	if counts[ReplayIndexTypeKeyframe] != 3 || counts[ReplayIndexTypeOffset] != 6 ||
+		counts[ReplayIndexTypeCommand] != 1 {
+		t.Fatalf("invalid index entry counts: %v", counts)
+	}
+	keyframe, offset := SeekReplayIndex(entries, 7.5)
+	if keyframe == nil || keyframe.Time < 6 || keyframe.Time > 7 {
+		t.Fatalf("invalid keyframe: %+v", keyframe)
+	}
+	// 关键帧为第 6 个事件之前的屏幕
+	lines := keyframe.Screen.Lines
+	if len(lines) != 6 || lines[5] != "$ echo f" {
+		t.Fatalf("invalid keyframe screen: %q", lines)
+	}
+	if !bytes.HasPrefix(data[offset:], []byte(`[6.001`)) {
+		t.Fatalf("invalid seek offset %d", offset)
+	}
+}
@@ -0,0 +1,454 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/common"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	分段录像: 会话过程中将录像按时间或大小切分成多个分段，每个分段结束后立即压缩上传到对象存储,
+	同时上传 manifest 记录所有已上传的分段。分段按顺序拼接(gzip 支持多段直接拼接)即为完整的录像文件。
+	本地仍然保留完整录像，会话结束后按原有流程上传。
+	上传失败的分段保留在本地, 所有分段上传完成前 manifest 的 complete 为 false 且保留在本地,
+	由 UploadRemainReplayParts 重新上传。
+
+	2021-06-01/{sid}.part-0001.replay.gz
+	2021-06-01/{sid}.part-0002.replay.gz
+	2021-06-01/{sid}.manifest.json
+*/
+
+const replayManifestExt = ".manifest.json"
+
+type ReplayManifest struct {
+	SessionID   string               `json:"session_id"`
+	Format      string               `json:"format"`
+	Parts       []ReplayManifestPart `json:"parts"`
+	Total       int                  `json:"total"`
+	Complete    bool                 `json:"complete"`
+	Encrypted   bool                 `json:"encrypted,omitempty"`
+	DateUpdated time.Time            `json:"date_updated"`
+}
+
+type ReplayManifestPart struct {
+	Index  int     `json:"index"`
+	Target string  `json:"target"`
+	Size   int64   `json:"size"`
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+}
+
+type replaySegment struct {
+	index int
+	path  string
+	size  int64
+	start time.Duration
+	end   time.Duration
+}
+
+type replaySegmentWriter struct {
+	sessionID string
+	localDir  string
+	targetDir string
+	format    string
+	maxSize   int64
+	interval  time.Duration
+	storage   ReplayStorage
+	recipient ReplayRecipient
+
+	file      io.Writer
+	timeStart time.Time
+
+	lock      sync.Mutex
+	part      *os.File
+	partIndex int
+	partSize  int64
+	partStart time.Duration
+
+	// 分段队列不限制长度, 对象存储异常时 Write 不会阻塞
+	queueLock sync.Mutex
+	queue     []*replaySegment
+	closed    bool
+	notify    chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+
+	manifest ReplayManifest
+}
+
+func newReplaySegmentWriter(r *ReplyRecorder, maxSize int64, interval time.Duration) *replaySegmentWriter {
+	w := &replaySegmentWriter{
+		sessionID: r.SessionID,
+		localDir:  filepath.Dir(r.absFilePath),
+		targetDir: filepath.Dir(r.Target),
+		format:    r.format,
+		maxSize:   maxSize,
+		interval:  interval,
+		storage:   r.storage,
+		recipient: r.recipient,
+		file:      r.file,
+		timeStart: time.Unix(0, r.timeStartNano),
+		notify:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		manifest: ReplayManifest{
+			SessionID: r.SessionID,
+			Format:    r.format,
+			Encrypted: r.recipient != nil,
+			Parts:     make([]ReplayManifestPart, 0, 10),
+		},
+	}
+	w.wg.Add(1)
+	go w.upload()
+	if interval > 0 {
+		go w.run()
+	}
+	return w
+}
+
+func (w *replaySegmentWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	nr, err := w.file.Write(p)
+	if err != nil {
+		return nr, err
+	}
+	if w.part == nil {
+		if err2 := w.openPart(); err2 != nil {
+			logger.Errorf("Session %s: create replay part file err: %s", w.sessionID, err2)
+			return nr, nil
+		}
+	}
+	partN, err2 := w.part.Write(p)
+	w.partSize += int64(partN)
+	if err2 != nil {
+		logger.Errorf("Session %s: write replay part file err: %s", w.sessionID, err2)
+	}
+	if w.maxSize > 0 && w.partSize >= w.maxSize {
+		w.rollPart()
+	}
+	return nr, nil
+}
+
+func (w *replaySegmentWriter) run() {
+	tick := time.NewTicker(w.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-tick.C:
+		}
+		w.lock.Lock()
+		if w.part != nil && w.offset()-w.partStart >= w.interval {
+			w.rollPart()
+		}
+		w.lock.Unlock()
+	}
+}
+
+func (w *replaySegmentWriter) offset() time.Duration {
+	return time.Since(w.timeStart)
+}
+
+func (w *replaySegmentWriter) openPart() (err error) {
+	w.partIndex++
+	path := filepath.Join(w.localDir, fmt.Sprintf("%s.part-%04d", w.sessionID, w.partIndex))
+	if w.part, err = os.Create(path); err != nil {
+		return err
+	}
+	w.partSize = 0
+	w.partStart = w.offset()
+	return nil
+}
+
+func (w *replaySegmentWriter) rollPart() {
+	if w.part == nil {
+		return
+	}
+	_ = w.part.Close()
+	w.pushSegment(&replaySegment{
+		index: w.partIndex,
+		path:  w.part.Name(),
+		size:  w.partSize,
+		start: w.partStart,
+		end:   w.offset(),
+	})
+	w.part = nil
+}
+
+func (w *replaySegmentWriter) pushSegment(segment *replaySegment) {
+	w.queueLock.Lock()
+	w.queue = append(w.queue, segment)
+	w.queueLock.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// popSegments 取出队列中所有的分段, 同时返回是否已经结束
+func (w *replaySegmentWriter) popSegments() ([]*replaySegment, bool) {
+	w.queueLock.Lock()
+	defer w.queueLock.Unlock()
+	segments := w.queue
+	w.queue = nil
+	return segments, w.closed
+}
+
+// Close 结束最后一个分段, 并等待所有分段上传完成
+func (w *replaySegmentWriter) Close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		w.lock.Lock()
+		w.rollPart()
+		w.lock.Unlock()
+		w.queueLock.Lock()
+		w.closed = true
+		w.queueLock.Unlock()
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	})
+	w.wg.Wait()
+}
+
+func (w *replaySegmentWriter) upload() {
+	defer w.wg.Done()
+	for {
+		segments, closed := w.popSegments()
+		if len(segments) == 0 {
+			if closed {
+				break
+			}
+			<-w.notify
+			continue
+		}
+		for _, segment := range segments {
+			part, err := uploadReplayPart(w.storage, w.recipient, segment, w.targetDir, w.sessionID)
+			if err != nil {
+				logger.Errorf("Session %s: upload replay part %d err: %s", w.sessionID, segment.index, err)
+				continue
+			}
+			w.manifest.addPart(part)
+			_ = uploadReplayManifest(w.storage, &w.manifest, w.localDir, w.targetDir)
+		}
+	}
+	w.lock.Lock()
+	w.manifest.Total = w.partIndex
+	w.lock.Unlock()
+	if err := uploadReplayManifest(w.storage, &w.manifest, w.localDir, w.targetDir); err != nil {
+		logger.Errorf("Session %s: upload replay manifest err: %s", w.sessionID, err)
+	}
+	logger.Infof("Session %s: replay segments upload done, uploaded %d/%d", w.sessionID,
+		len(w.manifest.Parts), w.manifest.Total)
+}
+
+// uploadReplayPart 压缩、加密后上传分段, 上传成功后删除本地的分段
+func uploadReplayPart(storage ReplayStorage, recipient ReplayRecipient, segment *replaySegment,
+	targetDir, sessionID string) (part ReplayManifestPart, err error) {
+	gzPath := segment.path + ".gz"
+	defer os.Remove(gzPath)
+	if err = common.GzipCompressFile(segment.path, gzPath); err != nil {
+		return part, fmt.Errorf("compress err: %w", err)
+	}
+	if recipient != nil {
+		if err = EncryptReplayFile(gzPath, recipient); err != nil {
+			return part, fmt.Errorf("encrypt err: %w", err)
+		}
+	}
+	target := fmt.Sprintf("%s/%s.part-%04d.replay.gz", targetDir, sessionID, segment.index)
+	if err = uploadWithRetry(storage, gzPath, target, 3); err != nil {
+		return part, err
+	}
+	_ = os.Remove(segment.path)
+	return ReplayManifestPart{
+		Index:  segment.index,
+		Target: target,
+		Size:   segment.size,
+		Start:  segment.start.Seconds(),
+		End:    segment.end.Seconds(),
+	}, nil
+}
+
+// addPart 按序号插入分段, 所有分段都已上传时 manifest 完成
+func (m *ReplayManifest) addPart(part ReplayManifestPart) {
+	i := sort.Search(len(m.Parts), func(i int) bool { return m.Parts[i].Index >= part.Index })
+	if i < len(m.Parts) && m.Parts[i].Index == part.Index {
+		m.Parts[i] = part
+	} else {
+		m.Parts = append(m.Parts, ReplayManifestPart{})
+		copy(m.Parts[i+1:], m.Parts[i:])
+		m.Parts[i] = part
+	}
+	m.updateComplete()
+}
+
+func (m *ReplayManifest) updateComplete() {
+	m.Complete = m.Total > 0 && len(m.Parts) == m.Total
+	for i := range m.Parts {
+		if m.Parts[i].Index != i+1 {
+			m.Complete = false
+		}
+	}
+}
+
+// uploadReplayManifest 上传 manifest, 未完成的 manifest 保留在本地用于重新上传分段
+func uploadReplayManifest(storage ReplayStorage, manifest *ReplayManifest, localDir, targetDir string) error {
+	manifest.updateComplete()
+	manifest.DateUpdated = time.Now().UTC()
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(localDir, manifest.SessionID+replayManifestExt)
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	target := fmt.Sprintf("%s/%s%s", targetDir, manifest.SessionID, replayManifestExt)
+	err = uploadWithRetry(storage, path, target, 3)
+	if err == nil && manifest.Complete {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
+// UploadRemainReplayParts 重新上传录像目录中上传失败的分段, 并更新对应的 manifest
+func UploadRemainReplayParts(storage ReplayStorage, recipient ReplayRecipient, replayDir string) {
+	_ = filepath.Walk(replayDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(info.Name(), replayManifestExt) {
+			return nil
+		}
+		if err = uploadRemainManifestParts(storage, recipient, replayDir, path); err != nil {
+			logger.Errorf("Upload remain replay parts %s failed: %s", path, err)
+		}
+		return nil
+	})
+}
+
+func uploadRemainManifestParts(storage ReplayStorage, recipient ReplayRecipient, replayDir, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var manifest ReplayManifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return err
+	}
+	localDir := filepath.Dir(path)
+	targetDir, err := filepath.Rel(replayDir, localDir)
+	if err != nil {
+		return err
+	}
+	targetDir = filepath.ToSlash(targetDir)
+	if manifest.Encrypted && recipient == nil {
+		return fmt.Errorf("replay parts encrypted, but replay encrypt key not configured")
+	}
+	if !manifest.Encrypted {
+		recipient = nil
+	}
+	// 会话异常结束时没有记录分段总数, 使用已上传和本地分段中最大的序号
+	for i := range manifest.Parts {
+		if manifest.Parts[i].Index > manifest.Total {
+			manifest.Total = manifest.Parts[i].Index
+		}
+	}
+	partPaths, _ := filepath.Glob(filepath.Join(localDir, manifest.SessionID+".part-[0-9][0-9][0-9][0-9]"))
+	for _, partPath := range partPaths {
+		var index int
+		if _, err = fmt.Sscanf(filepath.Ext(partPath), ".part-%04d", &index); err != nil {
+			continue
+		}
+		if index > manifest.Total {
+			manifest.Total = index
+		}
+		stat, err := os.Stat(partPath)
+		if err != nil {
+			continue
+		}
+		// 会话异常结束时没有记录分段的时间
+		segment := &replaySegment{index: index, path: partPath, size: stat.Size()}
+		part, err := uploadReplayPart(storage, recipient, segment, targetDir, manifest.SessionID)
+		if err != nil {
+			logger.Errorf("Upload remain replay part %s failed: %s", partPath, err)
+			continue
+		}
+		manifest.addPart(part)
+	}
+	if err = uploadReplayManifest(storage, &manifest, localDir, targetDir); err != nil {
+		return err
+	}
+	logger.Infof("Upload remain replay parts of session %s, uploaded %d/%d", manifest.SessionID,
+		len(manifest.Parts), manifest.Total)
+	return nil
+}
+
+func uploadWithRetry(storage ReplayStorage, path, target string, maxRetry int) (err error) {
+	for i := 0; i <= maxRetry; i++ {
+		if err = storage.Upload(path, target); err == nil {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return err
+}
+
+// MergeReplayParts 将下载到本地目录 partsDir 的分段，按 manifest 顺序合并成完整的录像文件(未压缩),
+// 分段加密时需要提供 identity 解密
+func MergeReplayParts(manifest ReplayManifest, partsDir, dstPath string, identity ReplayIdentity) error {
+	if manifest.Encrypted && identity == nil {
+		return fmt.Errorf("replay parts encrypted, identity required")
+	}
+	if !manifest.Encrypted {
+		identity = nil
+	}
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	for i := range manifest.Parts {
+		part := manifest.Parts[i]
+		if part.Index != i+1 {
+			return fmt.Errorf("replay part %d missing", i+1)
+		}
+		partPath := filepath.Join(partsDir, filepath.Base(part.Target))
+		if err = copyGzipFile(dst, partPath, identity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyGzipFile(dst io.Writer, path string, identity ReplayIdentity) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var src io.Reader = f
+	if identity != nil {
+		if src, err = NewReplayDecryptReader(f, identity); err != nil {
+			return err
+		}
+	}
+	reader, err := gzip.NewReader(src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(dst, reader)
+	return err
+}
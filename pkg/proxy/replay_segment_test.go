@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type localReplayStorage struct {
+	dir string
+}
+
+func (l localReplayStorage) Upload(gZipFile, target string) error {
+	data, err := ioutil.ReadFile(gZipFile)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(l.dir, filepath.Base(target)), data, 0644)
+}
+
+func (l localReplayStorage) TypeName() string {
+	return "s3"
+}
+
+func TestReplaySegmentWriter(t *testing.T) {
+	localDir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(localDir)
+	remoteDir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remoteDir)
+
+	sid := "9f1ba4a2-6ec4-4c4b-9a8c-0d4c1dd2a8b5"
+	r := &ReplyRecorder{
+		SessionID:     sid,
+		absFilePath:   filepath.Join(localDir, sid),
+		Target:        "2021-06-01/" + sid + ".replay.gz",
+		timeStartNano: time.Now().UnixNano(),
+		format:        ReplayFormatAsciicast,
+		storage:       localReplayStorage{dir: remoteDir},
+	}
+	if r.file, err = os.Create(r.absFilePath); err != nil {
+		t.Fatal(err)
+	}
+	w := newReplaySegmentWriter(r, 16, 0)
+	encoder := NewReplayEncoder(r.format, w)
+	_ = encoder.WriteHeader(ReplayHeader{Width: 80, Height: 24, Timestamp: time.Now()})
+	for i := 0; i < 5; i++ {
+		_ = encoder.WriteOutput(time.Duration(i)*time.Second, []byte("hello world\r\n"))
+	}
+	w.Close()
+	_ = r.file.Close()
+
+	data, err := ioutil.ReadFile(filepath.Join(remoteDir, sid+".manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest ReplayManifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.Complete || len(manifest.Parts) != 6 {
+		t.Fatalf("invalid manifest: %s", data)
+	}
+	mergedPath := filepath.Join(localDir, "merged")
+	if err = MergeReplayParts(manifest, remoteDir, mergedPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	merged, _ := ioutil.ReadFile(mergedPath)
+	origin, _ := ioutil.ReadFile(r.absFilePath)
+	if string(merged) != string(origin) {
+		t.Fatalf("merged replay not equal:\n%s\n%s", merged, origin)
+	}
+}
+
+type failReplayStorage struct {
+	localReplayStorage
+	failTarget string
+}
+
+func (f failReplayStorage) Upload(gZipFile, target string) error {
+	if strings.HasSuffix(target, f.failTarget) {
+		return fmt.Errorf("upload %s failed", target)
+	}
+	return f.localReplayStorage.Upload(gZipFile, target)
+}
+
+func TestReplaySegmentWriterRemainParts(t *testing.T) {
+	replayDir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(replayDir)
+	remoteDir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remoteDir)
+
+	sid := "9f1ba4a2-6ec4-4c4b-9a8c-0d4c1dd2a8b5"
+	localDir := filepath.Join(replayDir, "2021-06-01")
+	_ = os.MkdirAll(localDir, 0755)
+	storage := localReplayStorage{dir: remoteDir}
+	r := &ReplyRecorder{
+		SessionID:     sid,
+		absFilePath:   filepath.Join(localDir, sid),
+		Target:        "2021-06-01/" + sid + ".replay.gz",
+		timeStartNano: time.Now().UnixNano(),
+		format:        ReplayFormatAsciicast,
+		storage:       failReplayStorage{localReplayStorage: storage, failTarget: ".part-0002.replay.gz"},
+	}
+	if r.file, err = os.Create(r.absFilePath); err != nil {
+		t.Fatal(err)
+	}
+	w := newReplaySegmentWriter(r, 16, 0)
+	encoder := NewReplayEncoder(r.format, w)
+	_ = encoder.WriteHeader(ReplayHeader{Width: 80, Height: 24, Timestamp: time.Now()})
+	for i := 0; i < 3; i++ {
+		_ = encoder.WriteOutput(time.Duration(i)*time.Second, []byte("hello world\r\n"))
+	}
+	w.Close()
+	_ = r.file.Close()
+
+	readManifest := func() ReplayManifest {
+		data, err := ioutil.ReadFile(filepath.Join(remoteDir, sid+replayManifestExt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var manifest ReplayManifest
+		if err = json.Unmarshal(data, &manifest); err != nil {
+			t.Fatal(err)
+		}
+		return manifest
+	}
+	if manifest := readManifest(); manifest.Complete || manifest.Total != 4 || len(manifest.Parts) != 3 {
+		t.Fatalf("invalid manifest: %+v", manifest)
+	}
+	if _, err = os.Stat(filepath.Join(localDir, sid+".part-0002")); err != nil {
+		t.Fatalf("failed part removed: %s", err)
+	}
+
+	UploadRemainReplayParts(storage, nil, replayDir)
+	manifest := readManifest()
+	if !manifest.Complete || len(manifest.Parts) != 4 || manifest.Parts[1].Target != "2021-06-01/"+sid+".part-0002.replay.gz" {
+		t.Fatalf("invalid manifest: %+v", manifest)
+	}
+	if _, err = os.Stat(filepath.Join(localDir, sid+replayManifestExt)); !os.IsNotExist(err) {
+		t.Fatalf("complete manifest not removed: %v", err)
+	}
+	mergedPath := filepath.Join(replayDir, "merged")
+	if err = MergeReplayParts(manifest, remoteDir, mergedPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	merged, _ := ioutil.ReadFile(mergedPath)
+	origin, _ := ioutil.ReadFile(r.absFilePath)
+	if string(merged) != string(origin) {
+		t.Fatalf("merged replay not equal:\n%s\n%s", merged, origin)
+	}
+}
+
+func TestUploadRemainManifestWithoutLocalParts(t *testing.T) {
+	replayDir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(replayDir)
+	remoteDir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remoteDir)
+
+	// 分段都已上传, 会话异常结束时 manifest 没有记录分段总数
+	sid := "9f1ba4a2-6ec4-4c4b-9a8c-0d4c1dd2a8b5"
+	localDir := filepath.Join(replayDir, "2021-06-01")
+	_ = os.MkdirAll(localDir, 0755)
+	manifest := ReplayManifest{SessionID: sid, Format: ReplayFormatAsciicast, Parts: []ReplayManifestPart{
+		{Index: 1, Target: "2021-06-01/" + sid + ".part-0001.replay.gz"},
+		{Index: 2, Target: "2021-06-01/" + sid + ".part-0002.replay.gz"},
+	}}
+	data, _ := json.Marshal(manifest)
+	manifestPath := filepath.Join(localDir, sid+replayManifestExt)
+	_ = ioutil.WriteFile(manifestPath, data, 0644)
+
+	UploadRemainReplayParts(localReplayStorage{dir: remoteDir}, nil, replayDir)
+	if data, err = ioutil.ReadFile(filepath.Join(remoteDir, sid+replayManifestExt)); err != nil {
+		t.Fatal(err)
+	}
+	manifest = ReplayManifest{}
+	_ = json.Unmarshal(data, &manifest)
+	if !manifest.Complete || manifest.Total != 2 {
+		t.Fatalf("invalid manifest: %+v", manifest)
+	}
+	if _, err = os.Stat(manifestPath); !os.IsNotExist(err) {
+		t.Fatalf("complete manifest not removed: %v", err)
+	}
+}
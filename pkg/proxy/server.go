@@ -13,6 +13,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/jumpserver/koko/pkg/auditlog"
 	"github.com/jumpserver/koko/pkg/auth"
 	gossh "golang.org/x/crypto/ssh"
 
@@ -38,6 +39,9 @@ type ConnectionOptions struct {
 	asset  *model.Asset
 	dbApp  *model.DatabaseApplication
 	k8sApp *model.K8sApplication
+
+	// mysqlWire 使用 MySQL 协议代理
+	mysqlWire bool
 }
 
 func ConnectUser(user *model.User) ConnectionOption {
@@ -85,7 +89,8 @@ func (opts *ConnectionOptions) TerminalTitle() string {
 			opts.ProtocolType,
 			opts.systemUser.Username,
 			opts.asset.IP)
-	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb:
+	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb, srvconn.ProtocolPostgreSQL,
+		srvconn.ProtocolRedis, srvconn.ProtocolMongoDB, srvconn.ProtocolSQLServer, srvconn.ProtocolOracle:
 		title = fmt.Sprintf("%s://%s@%s",
 			opts.ProtocolType,
 			opts.systemUser.Username,
@@ -104,7 +109,8 @@ func (opts *ConnectionOptions) ConnectMsg() string {
 	case srvconn.ProtocolTELNET,
 		srvconn.ProtocolSSH:
 		msg = fmt.Sprintf(i18n.T("Connecting to %s@%s"), opts.systemUser.Name, opts.asset.IP)
-	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb:
+	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb, srvconn.ProtocolPostgreSQL,
+		srvconn.ProtocolRedis, srvconn.ProtocolMongoDB, srvconn.ProtocolSQLServer, srvconn.ProtocolOracle:
 		msg = fmt.Sprintf(i18n.T("Connecting to Database %s"), opts.dbApp)
 	case srvconn.ProtocolK8s:
 		msg = fmt.Sprintf(i18n.T("Connecting to Kubernetes %s"), opts.k8sApp.Attrs.Cluster)
@@ -146,6 +152,9 @@ func NewServer(conn UserConnection, jmsService *service.JMService, opts ...Conne
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", ErrAPIFailed, err)
 	}
+	// 合并本地的命令过滤策略
+	filterTarget := newFilterPolicyTarget(connOpts)
+	filterRules = append(filterRules, GetLocalFilterRules(filterTarget)...)
 	// 过滤规则排序
 	sort.Sort(model.FilterRules(filterRules))
 	var (
@@ -252,8 +261,11 @@ func NewServer(conn UserConnection, jmsService *service.JMService, opts ...Conne
 			AssetID:      connOpts.k8sApp.ID,
 			OrgID:        connOpts.k8sApp.OrgID,
 		}
-	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb:
-		if !IsInstalledMysqlClient() {
+	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb, srvconn.ProtocolPostgreSQL,
+		srvconn.ProtocolRedis, srvconn.ProtocolMongoDB, srvconn.ProtocolSQLServer, srvconn.ProtocolOracle:
+		// Redis 的终端由 koko 提供, 不需要本地客户端
+		if !connOpts.mysqlWire && connOpts.ProtocolType != srvconn.ProtocolRedis &&
+			!isInstalledDatabaseClient(connOpts.ProtocolType) {
 			msg := i18n.T("Database %s protocol client not installed.")
 			msg = fmt.Sprintf(msg, connOpts.dbApp.TypeName)
 			utils.IgnoreErrWriteString(conn, utils.WrapperWarn(msg))
@@ -315,23 +327,28 @@ func NewServer(conn UserConnection, jmsService *service.JMService, opts ...Conne
 		connOpts:           connOpts,
 		systemUserAuthInfo: sysUserAuthInfo,
 
-		filterRules:    filterRules,
-		terminalConf:   &terminalConf,
-		domainGateways: domainGateways,
-		expireInfo:     expireInfo,
-		platform:       platform,
-		permActions:    perms,
+		filterRules:        filterRules,
+		sqlFilterRules:     GetLocalSQLFilterRules(filterTarget),
+		kubectlFilterRules: GetLocalKubectlFilterRules(filterTarget),
+		terminalConf:       &terminalConf,
+		domainGateways:     domainGateways,
+		expireInfo:         expireInfo,
+		platform:           platform,
+		permActions:        perms,
 		CreateSessionCallback: func() error {
 			apiSession.DateStart = modelCommon.NewNowUTCTime()
+			auditlog.Emit(auditlog.SessionEvent(auditlog.SessionStart, apiSession, ""))
 			return jmsService.CreateSession(*apiSession)
 		},
 		ConnectedSuccessCallback: func() error {
 			return jmsService.SessionSuccess(apiSession.ID)
 		},
 		ConnectedFailedCallback: func(err error) error {
+			auditlog.Emit(auditlog.SessionEvent(auditlog.SessionFailed, apiSession, err.Error()))
 			return jmsService.SessionFailed(apiSession.ID, err)
 		},
 		DisConnectedCallback: func() error {
+			auditlog.Emit(auditlog.SessionEvent(auditlog.SessionFinish, apiSession, ""))
 			return jmsService.SessionDisconnect(apiSession.ID)
 		},
 	}, nil
@@ -346,7 +363,9 @@ type Server struct {
 
 	systemUserAuthInfo *model.SystemUserAuthInfo
 
-	filterRules    []model.SystemUserFilterRule
+	filterRules        []model.SystemUserFilterRule
+	sqlFilterRules     []SQLFilterRule
+	kubectlFilterRules []KubectlFilterRule
 	terminalConf   *model.TerminalConfig
 	domainGateways *model.Domain
 	expireInfo     *model.ExpireInfo
@@ -354,6 +373,8 @@ type Server struct {
 	permActions    *model.Permission
 
 	cacheSSHConnection *srvconn.SSHConnection
+	// k8sClusterServer 连接集群使用的地址, 使用网关时为本地隧道地址
+	k8sClusterServer string
 
 	CreateSessionCallback    func() error
 	ConnectedSuccessCallback func() error
@@ -424,35 +445,72 @@ func (s *Server) GetFilterParser() ParseEngine {
 				enableUpload = true
 			}
 		}
+		pty := s.UserConn.Pty()
 		var zParser ZmodemParser
 		zParser.setStatus(ZParserStatusNone)
 		zParser.fileEventCallback = s.ZmodemFileTransferEvent
 		shellParser := Parser{
 			id:             s.ID,
 			protocolType:   s.connOpts.ProtocolType,
-			jmsService:     s.jmsService,
 			cmdFilterRules: s.filterRules,
 			permAction:     s.permActions,
 			enableDownload: enableDownload,
 			enableUpload:   enableUpload,
 			zmodemParser:   &zParser,
+			capture:        GetOutputCapturePolicy(),
+			auditBanner:    config.GetConf().CommandAuditBanner,
+			cmdTracker:     newVTCommandTracker(pty.Window.Width, pty.Window.Height),
+			userID:         s.connOpts.user.ID,
+			burst:          newBurstDetector(GetCommandBurstPolicy()),
 		}
+		confirmSession := commandConfirmSession{
+			ID:         s.ID,
+			User:       s.connOpts.user.String(),
+			SystemUser: s.connOpts.systemUser.String(),
+		}
+		if s.connOpts.ProtocolType == srvconn.ProtocolK8s {
+			shellParser.k8sFilterRules = newK8sFilterRules(s.filterRules, s.kubectlFilterRules)
+			shellParser.k8sNamespace = "default"
+			shellParser.podLabels = newK8sLabelFetcher(s.k8sClusterServer, s.systemUserAuthInfo.Token)
+			confirmSession.Asset = s.connOpts.k8sApp.Name
+		} else {
+			confirmSession.Asset = s.connOpts.asset.String()
+		}
+		shellParser.confirmer = newCommandConfirmer(s.jmsService, confirmSession)
 		shellParser.initial()
 		return &shellParser
-	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb:
+	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb, srvconn.ProtocolPostgreSQL,
+		srvconn.ProtocolMongoDB, srvconn.ProtocolSQLServer, srvconn.ProtocolOracle:
 		dbParser := DBParser{
 			id:             s.ID,
-			cmdFilterRules: s.filterRules,
+			protocol:       s.connOpts.ProtocolType,
+			cmdFilterRules: newDBFilterRules(s.filterRules, s.sqlFilterRules),
+			auditBanner:    config.GetConf().CommandAuditBanner,
 		}
 		dbParser.initial()
 		return &dbParser
+	case srvconn.ProtocolRedis:
+		redisParser := RedisParser{
+			id:             s.ID,
+			cmdFilterRules: s.filterRules,
+			auditBanner:    config.GetConf().CommandAuditBanner,
+		}
+		redisParser.initial()
+		return &redisParser
 	}
 	return nil
 }
 
 func (s *Server) GetReplayRecorder() *ReplyRecorder {
+	pty := s.UserConn.Pty()
 	r := ReplyRecorder{
-		SessionID:  s.ID,
+		SessionID: s.ID,
+		format:    config.GetConf().ReplayFormat,
+		header: ReplayHeader{
+			Width:  pty.Window.Width,
+			Height: pty.Window.Height,
+			Term:   pty.Term,
+		},
 		storage:    NewReplayStorage(s.jmsService, s.terminalConf),
 		jmsService: s.jmsService,
 	}
@@ -466,8 +524,16 @@ func (s *Server) GetCommandRecorder() *CommandRecorder {
 		storage:    NewCommandStorage(s.jmsService, s.terminalConf),
 		queue:      make(chan *model.Command, 10),
 		closed:     make(chan struct{}),
+		spool:      commandSpool,
 		jmsService: s.jmsService,
 	}
+	replayStorage := NewReplayStorage(s.jmsService, s.terminalConf)
+	cmdR.outputs = newCommandOutputStore(s.ID, replayStorage)
+	if cmdR.signer = getAuditSigner(s.ID, replayStorage); cmdR.signer != nil {
+		cmdR.chain = newHashChain(s.ID)
+		cmdR.replayStorage = replayStorage
+		cmdR.date = time.Now().UTC().Format("2006-01-02")
+	}
 	go cmdR.record()
 	return &cmdR
 }
@@ -489,7 +555,8 @@ func (s *Server) GenerateCommandItem(user, input, output string,
 			DateCreated: createdDate.UTC(),
 		}
 
-	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb:
+	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb, srvconn.ProtocolPostgreSQL,
+		srvconn.ProtocolRedis, srvconn.ProtocolMongoDB, srvconn.ProtocolSQLServer, srvconn.ProtocolOracle:
 		return &model.Command{
 			SessionID:   s.ID,
 			OrgID:       s.connOpts.dbApp.OrgID,
@@ -564,7 +631,8 @@ func (s *Server) checkRequiredAuth() error {
 			utils.IgnoreErrWriteString(s.UserConn, msg)
 			return errors.New("no auth token")
 		}
-	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb, srvconn.ProtocolTELNET:
+	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb, srvconn.ProtocolPostgreSQL, srvconn.ProtocolMongoDB,
+		srvconn.ProtocolSQLServer, srvconn.ProtocolOracle, srvconn.ProtocolTELNET:
 		if err := s.getUsernameIfNeed(); err != nil {
 			msg := utils.WrapperWarn(i18n.T("Get auth username failed"))
 			utils.IgnoreErrWriteString(s.UserConn, msg)
@@ -575,6 +643,15 @@ func (s *Server) checkRequiredAuth() error {
 			utils.IgnoreErrWriteString(s.UserConn, msg)
 			return fmt.Errorf("get auth password failed: %s", err)
 		}
+	case srvconn.ProtocolRedis:
+		// Redis 可以没有用户名和密码, 手动登录时输入密码
+		if s.systemUserAuthInfo.LoginMode == model.LoginModeManual {
+			if err := s.getAuthPasswordIfNeed(); err != nil {
+				msg := utils.WrapperWarn(i18n.T("Get auth password failed"))
+				utils.IgnoreErrWriteString(s.UserConn, msg)
+				return fmt.Errorf("get auth password failed: %s", err)
+			}
+		}
 	case srvconn.ProtocolSSH:
 		if err := s.getUsernameIfNeed(); err != nil {
 			msg := utils.WrapperWarn(i18n.T("Get auth username failed"))
@@ -665,7 +742,12 @@ func (s *Server) createAvailableGateWay(domain *model.Domain) (*domainGateway, e
 			dstPort: dstPort,
 		}
 	case srvconn.ProtocolMySQL,
-		srvconn.ProtocolMariadb:
+		srvconn.ProtocolMariadb,
+		srvconn.ProtocolPostgreSQL,
+		srvconn.ProtocolRedis,
+		srvconn.ProtocolMongoDB,
+		srvconn.ProtocolSQLServer,
+		srvconn.ProtocolOracle:
 		dGateway = &domainGateway{
 			domain:  domain,
 			dstIP:   s.connOpts.dbApp.Attrs.Host,
@@ -688,6 +770,7 @@ func (s *Server) getK8sConConn(localTunnelAddr *net.TCPAddr) (srvConn *srvconn.K
 		}
 		clusterServer = ReplaceURLHostAndPort(originUrl, "127.0.0.1", localTunnelAddr.Port)
 	}
+	s.k8sClusterServer = clusterServer
 	srvConn, err = srvconn.NewK8sConnection(
 		srvconn.K8sToken(s.systemUserAuthInfo.Token),
 		srvconn.K8sClusterServer(clusterServer),
@@ -701,14 +784,40 @@ func (s *Server) getK8sConConn(localTunnelAddr *net.TCPAddr) (srvConn *srvconn.K
 	return
 }
 
-func (s *Server) getMysqlConn(localTunnelAddr *net.TCPAddr) (srvConn *srvconn.MySQLConn, err error) {
+// getMysqlConn 终端连接使用 pty 中的 mysql 客户端, 原因见 srvconn/conn_mysql.go
+func (s *Server) getMysqlConn(localTunnelAddr *net.TCPAddr) (*srvconn.MySQLConn, error) {
+	return srvconn.NewMySQLConnection(s.getDatabaseConnOptions(localTunnelAddr)...)
+}
+
+func (s *Server) getPostgreSQLConn(localTunnelAddr *net.TCPAddr) (*srvconn.PostgreSQLConn, error) {
+	return srvconn.NewPostgreSQLConnection(s.getDatabaseConnOptions(localTunnelAddr)...)
+}
+
+func (s *Server) getRedisConn(localTunnelAddr *net.TCPAddr) (*srvconn.RedisConn, error) {
+	return srvconn.NewRedisConnection(s.getDatabaseConnOptions(localTunnelAddr)...)
+}
+
+func (s *Server) getMongoDBConn(localTunnelAddr *net.TCPAddr) (*srvconn.MongoDBConn, error) {
+	return srvconn.NewMongoDBConnection(s.getDatabaseConnOptions(localTunnelAddr)...)
+}
+
+func (s *Server) getSQLServerConn(localTunnelAddr *net.TCPAddr) (*srvconn.SQLServerConn, error) {
+	return srvconn.NewSQLServerConnection(s.getDatabaseConnOptions(localTunnelAddr)...)
+}
+
+func (s *Server) getOracleConn(localTunnelAddr *net.TCPAddr) (*srvconn.OracleConn, error) {
+	return srvconn.NewOracleConnection(s.getDatabaseConnOptions(localTunnelAddr)...)
+}
+
+// getDatabaseConnOptions 数据库连接的参数, 使用网关时连接本地的隧道地址
+func (s *Server) getDatabaseConnOptions(localTunnelAddr *net.TCPAddr) []srvconn.SqlOption {
 	host := s.connOpts.dbApp.Attrs.Host
 	port := s.connOpts.dbApp.Attrs.Port
 	if localTunnelAddr != nil {
 		host = "127.0.0.1"
 		port = localTunnelAddr.Port
 	}
-	srvConn, err = srvconn.NewMySQLConnection(
+	return []srvconn.SqlOption{
 		srvconn.SqlHost(host),
 		srvconn.SqlPort(port),
 		srvconn.SqlUsername(s.systemUserAuthInfo.Username),
@@ -718,8 +827,7 @@ func (s *Server) getMysqlConn(localTunnelAddr *net.TCPAddr) (srvConn *srvconn.My
 			Width:  s.UserConn.Pty().Window.Width,
 			Height: s.UserConn.Pty().Window.Height,
 		}),
-	)
-	return
+	}
 }
 
 func (s *Server) getSSHConn() (srvConn *srvconn.SSHConnection, err error) {
@@ -885,6 +993,16 @@ func (s *Server) getServerConn(proxyAddr *net.TCPAddr) (srvconn.ServerConnection
 		return s.getK8sConConn(proxyAddr)
 	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb:
 		return s.getMysqlConn(proxyAddr)
+	case srvconn.ProtocolPostgreSQL:
+		return s.getPostgreSQLConn(proxyAddr)
+	case srvconn.ProtocolRedis:
+		return s.getRedisConn(proxyAddr)
+	case srvconn.ProtocolMongoDB:
+		return s.getMongoDBConn(proxyAddr)
+	case srvconn.ProtocolSQLServer:
+		return s.getSQLServerConn(proxyAddr)
+	case srvconn.ProtocolOracle:
+		return s.getOracleConn(proxyAddr)
 	default:
 		return nil, ErrUnMatchProtocol
 	}
@@ -921,6 +1039,11 @@ func (s *Server) sendConnectingMsg(done chan struct{}) {
 }
 
 func (s *Server) checkLoginConfirm() bool {
+	srv := s.newLoginConfirmService()
+	return validateLoginConfirm(srv, s.UserConn)
+}
+
+func (s *Server) newLoginConfirmService() *auth.LoginConfirmService {
 	opts := make([]auth.ConfirmOption, 0, 4)
 	opts = append(opts, auth.ConfirmWithUser(s.connOpts.user))
 	opts = append(opts, auth.ConfirmWithSystemUser(s.systemUserAuthInfo))
@@ -929,7 +1052,8 @@ func (s *Server) checkLoginConfirm() bool {
 		targetId   string
 	)
 	switch s.connOpts.ProtocolType {
-	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb:
+	case srvconn.ProtocolMySQL, srvconn.ProtocolMariadb, srvconn.ProtocolPostgreSQL,
+		srvconn.ProtocolRedis, srvconn.ProtocolMongoDB, srvconn.ProtocolSQLServer, srvconn.ProtocolOracle:
 		targetType = model.AppType
 		targetId = s.connOpts.dbApp.ID
 	case srvconn.ProtocolK8s:
@@ -941,7 +1065,7 @@ func (s *Server) checkLoginConfirm() bool {
 	opts = append(opts, auth.ConfirmWithTargetType(targetType))
 	opts = append(opts, auth.ConfirmWithTargetID(targetId))
 	srv := auth.NewLoginConfirm(s.jmsService, opts...)
-	return validateLoginConfirm(&srv, s.UserConn)
+	return &srv
 }
 
 func (s *Server) Proxy() {
@@ -985,7 +1109,8 @@ func (s *Server) Proxy() {
 	var proxyAddr *net.TCPAddr
 	if s.domainGateways != nil && len(s.domainGateways.Gateways) != 0 {
 		switch s.connOpts.ProtocolType {
-		case srvconn.ProtocolMySQL, srvconn.ProtocolK8s, srvconn.ProtocolMariadb:
+		case srvconn.ProtocolMySQL, srvconn.ProtocolK8s, srvconn.ProtocolMariadb, srvconn.ProtocolPostgreSQL,
+			srvconn.ProtocolRedis, srvconn.ProtocolMongoDB, srvconn.ProtocolSQLServer, srvconn.ProtocolOracle:
 			dGateway, err := s.createAvailableGateWay(s.domainGateways)
 			if err != nil {
 				msg := i18n.T("Start domain gateway failed %s")
@@ -0,0 +1,737 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+)
+
+/*
+	按 POSIX shell 语法拆分命令行, 用于命令过滤:
+	1. 按 ; & && || | 换行、子 shell ( ) 拆分成多个简单命令
+	2. $() 和 `` 中的命令单独拆分, 其中 echo/printf 的输出作为外层命令的参数
+	3. 去掉单引号、双引号、$'' 和反斜杠转义, 合并 \ 换行的续行
+	4. 记录同一行中的变量赋值 a=rm; $a, ${IFS} 按空白拆分参数, {rm,-rf,/} 展开
+	5. sh -c、bash -c、eval 的参数按命令再次拆分, sudo、env 等前缀单独生成去掉前缀的命令
+	无法静态确定的内容(未知变量、其他命令的输出)保留原文。
+*/
+
+const shellLexerMaxDepth = 8
+
+// ShellCommand 拆分后的简单命令, Args 为去掉引号之后的参数
+type ShellCommand struct {
+	Args []string
+}
+
+func (c ShellCommand) String() string {
+	return strings.Join(c.Args, " ")
+}
+
+// ParseShellCommands 拆分命令行中的所有简单命令, 按出现的顺序返回
+func ParseShellCommands(line string) []ShellCommand {
+	l := shellLexer{vars: make(map[string]string)}
+	return l.parse(line, 0)
+}
+
+type shellLexer struct {
+	vars map[string]string
+}
+
+func (l *shellLexer) parse(line string, depth int) []ShellCommand {
+	if depth > shellLexerMaxDepth {
+		return nil
+	}
+	s := shellScanner{src: []rune(line), lexer: l, depth: depth}
+	s.parseList(0)
+	return s.commands
+}
+
+// shellScanner 解析一段命令文本, 嵌套的 $() 和子 shell 共用同一个 scanner
+type shellScanner struct {
+	src   []rune
+	pos   int
+	lexer *shellLexer
+	depth int
+
+	commands []ShellCommand
+
+	args        []string
+	word        strings.Builder
+	wordStarted bool
+}
+
+func (s *shellScanner) peek(n int) rune {
+	if s.pos+n < len(s.src) {
+		return s.src[s.pos+n]
+	}
+	return 0
+}
+
+func (s *shellScanner) eof() bool {
+	return s.pos >= len(s.src)
+}
+
+func (s *shellScanner) finishWord() {
+	if s.wordStarted {
+		s.args = append(s.args, s.word.String())
+	}
+	s.word.Reset()
+	s.wordStarted = false
+}
+
+func (s *shellScanner) finishCommand() {
+	s.finishWord()
+	args := s.args
+	s.args = nil
+	if len(args) == 0 {
+		return
+	}
+	s.lexer.recordAssignments(args)
+	args = expandBraces(args)
+	s.addCommand(args, s.depth)
+}
+
+func (s *shellScanner) addCommand(args []string, depth int) {
+	args = stripAssignments(args)
+	if len(args) == 0 {
+		return
+	}
+	s.commands = append(s.commands, ShellCommand{Args: args})
+	unwrapped := unwrapShellCommand(args)
+	if len(unwrapped) == 0 {
+		return
+	}
+	if len(unwrapped) != len(args) {
+		s.commands = append(s.commands, ShellCommand{Args: unwrapped})
+	}
+	// sh -c 'cmd' 和 eval 的参数是另一段命令
+	if script, ok := shellScriptArg(unwrapped); ok {
+		s.commands = append(s.commands, s.lexer.parse(script, depth+1)...)
+	}
+}
+
+// parseList 解析到 stop 字符或者结尾, stop 为 0 时解析到结尾, 返回是否找到 stop
+func (s *shellScanner) parseList(stop rune) bool {
+	for !s.eof() {
+		c := s.src[s.pos]
+		switch {
+		case stop != 0 && c == stop:
+			s.pos++
+			s.finishCommand()
+			return true
+		case c == ' ' || c == '\t':
+			s.pos++
+			s.finishWord()
+		case c == '\n' || c == '\r' || c == ';' || c == '&' || c == '|':
+			if c == '&' && s.peek(1) == '>' {
+				s.parseRedirect()
+				continue
+			}
+			s.pos++
+			s.finishCommand()
+		case c == '(':
+			s.pos++
+			s.finishCommand()
+			s.parseList(')')
+		case c == ')':
+			s.pos++
+			s.finishCommand()
+		case c == '<' || c == '>':
+			s.parseRedirect()
+		case c == '#' && !s.wordStarted:
+			for !s.eof() && s.src[s.pos] != '\n' && s.src[s.pos] != '\r' {
+				s.pos++
+			}
+		default:
+			s.parseWordPart(false)
+		}
+	}
+	s.finishCommand()
+	return false
+}
+
+// parseWordPart 解析单词中的一部分, quoted 表示在双引号中
+func (s *shellScanner) parseWordPart(quoted bool) {
+	c := s.src[s.pos]
+	if quoted && c == '\'' {
+		// 双引号中的单引号没有特殊含义
+		s.word.WriteRune(c)
+		s.pos++
+		return
+	}
+	switch c {
+	case '\\':
+		s.pos++
+		if s.eof() {
+			return
+		}
+		next := s.src[s.pos]
+		s.pos++
+		switch next {
+		case '\r':
+			// \ 换行为续行
+			if !s.eof() && s.src[s.pos] == '\n' {
+				s.pos++
+			}
+		case '\n':
+		default:
+			if quoted && !strings.ContainsRune("$`\"\\", next) {
+				s.word.WriteRune('\\')
+			}
+			s.word.WriteRune(next)
+			s.wordStarted = true
+		}
+	case '\'':
+		s.pos++
+		s.wordStarted = true
+		for !s.eof() && s.src[s.pos] != '\'' {
+			s.word.WriteRune(s.src[s.pos])
+			s.pos++
+		}
+		s.skipClose()
+	case '"':
+		s.pos++
+		s.wordStarted = true
+		for !s.eof() && s.src[s.pos] != '"' {
+			s.parseWordPart(true)
+		}
+		s.skipClose()
+	case '`':
+		s.pos++
+		s.appendExpansion(s.parseSubstitution('`'), quoted)
+	case '$':
+		s.parseDollar(quoted)
+	default:
+		s.word.WriteRune(c)
+		s.wordStarted = true
+		s.pos++
+	}
+}
+
+func (s *shellScanner) parseDollar(quoted bool) {
+	s.pos++
+	next := s.peek(0)
+	switch {
+	case next == '\'' && !quoted:
+		s.pos++
+		s.word.WriteString(s.parseANSIQuoted())
+		s.wordStarted = true
+	case next == '"' && !quoted:
+		// $"" 为本地化字符串, 按双引号处理
+		s.parseWordPart(false)
+	case next == '(' && s.peek(1) == '(':
+		// 算术展开, 保留原文
+		start := s.pos - 1
+		s.pos += 2
+		for depth := 2; !s.eof() && depth > 0; s.pos++ {
+			switch s.src[s.pos] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+		}
+		s.word.WriteString(string(s.src[start:minInt(s.pos, len(s.src))]))
+		s.wordStarted = true
+	case next == '(':
+		s.pos++
+		s.appendExpansion(s.parseSubstitution(')'), quoted)
+	case next == '{':
+		s.pos++
+		start := s.pos
+		for !s.eof() && s.src[s.pos] != '}' {
+			s.pos++
+		}
+		expr := string(s.src[start:s.pos])
+		s.skipClose()
+		s.appendParameter(expr, quoted)
+	case isShellNameStart(next):
+		start := s.pos
+		for !s.eof() && isShellNameChar(s.src[s.pos]) {
+			s.pos++
+		}
+		name := string(s.src[start:s.pos])
+		s.appendVariable(name, "$"+name, quoted)
+	case next != 0 && strings.ContainsRune("0123456789@*#?$!-", next):
+		s.pos++
+		s.word.WriteRune('$')
+		s.word.WriteRune(next)
+		s.wordStarted = true
+	default:
+		s.word.WriteRune('$')
+		s.wordStarted = true
+	}
+}
+
+// parseSubstitution 解析 $() 或 “ 中的命令, 返回替换后的文本
+func (s *shellScanner) parseSubstitution(stop rune) string {
+	start := s.pos
+	inner := shellScanner{src: s.src, pos: s.pos, lexer: s.lexer, depth: s.depth + 1}
+	var found bool
+	if inner.depth <= shellLexerMaxDepth {
+		found = inner.parseList(stop)
+	} else {
+		found = inner.skipTo(stop)
+	}
+	s.pos = inner.pos
+	s.commands = append(s.commands, inner.commands...)
+	end := s.pos
+	if found {
+		end--
+	}
+	text := string(s.src[start:end])
+	if value, ok := staticOutput(inner.commands); ok {
+		return value
+	}
+	if stop == '`' {
+		return "`" + text + "`"
+	}
+	return "$(" + text + ")"
+}
+
+// skipClose 跳过结束的引号或括号, 没有结束符时已经到结尾
+func (s *shellScanner) skipClose() {
+	if !s.eof() {
+		s.pos++
+	}
+}
+
+func (s *shellScanner) skipTo(stop rune) bool {
+	for !s.eof() {
+		c := s.src[s.pos]
+		s.pos++
+		if c == stop {
+			return true
+		}
+	}
+	return false
+}
+
+// parseANSIQuoted 解析 $'...' 中的转义字符
+func (s *shellScanner) parseANSIQuoted() string {
+	value := s.parseEscapes('\'')
+	s.skipClose()
+	return value
+}
+
+// parseEscapes 解析 C 语言风格的转义字符直到 stop 字符, 也用于 echo -e 和 printf
+func (s *shellScanner) parseEscapes(stop rune) string {
+	var b strings.Builder
+	for !s.eof() && s.src[s.pos] != stop {
+		c := s.src[s.pos]
+		s.pos++
+		if c != '\\' || s.eof() {
+			b.WriteRune(c)
+			continue
+		}
+		c = s.src[s.pos]
+		s.pos++
+		switch c {
+		case 'n':
+			b.WriteRune('\n')
+		case 't':
+			b.WriteRune('\t')
+		case 'r':
+			b.WriteRune('\r')
+		case 'a':
+			b.WriteRune('\a')
+		case 'b':
+			b.WriteRune('\b')
+		case 'e', 'E':
+			b.WriteRune(0x1b)
+		case 'f':
+			b.WriteRune('\f')
+		case 'v':
+			b.WriteRune('\v')
+		case 'x':
+			b.WriteRune(s.readNumber(16, 2, 'x'))
+		case 'u':
+			b.WriteRune(s.readNumber(16, 4, 'u'))
+		case 'U':
+			b.WriteRune(s.readNumber(16, 8, 'U'))
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			s.pos--
+			b.WriteRune(s.readNumber(8, 3, '0'))
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func decodeShellEscapes(value string) string {
+	s := shellScanner{src: []rune(value)}
+	return s.parseEscapes(0)
+}
+
+func (s *shellScanner) readNumber(base, maxDigits int, fallback rune) rune {
+	start := s.pos
+	for s.pos-start < maxDigits && !s.eof() {
+		if _, err := strconv.ParseUint(string(s.src[s.pos]), base, 8); err != nil {
+			break
+		}
+		s.pos++
+	}
+	if s.pos == start {
+		return fallback
+	}
+	value, err := strconv.ParseUint(string(s.src[start:s.pos]), base, 32)
+	if err != nil {
+		return fallback
+	}
+	return rune(value)
+}
+
+// parseRedirect 重定向作为单独的参数保留, 例如 > /dev/sda, 2>&1
+func (s *shellScanner) parseRedirect() {
+	var op strings.Builder
+	// 2>file 中的数字属于重定向
+	if s.wordStarted && isAllDigits(s.word.String()) {
+		op.WriteString(s.word.String())
+		s.word.Reset()
+		s.wordStarted = false
+	}
+	s.finishWord()
+	if (s.src[s.pos] == '<' || s.src[s.pos] == '>') && s.peek(1) == '(' {
+		// 进程替换 <(cmd)
+		s.pos += 2
+		s.finishWord()
+		s.appendExpansion(s.parseSubstitution(')'), true)
+		s.finishWord()
+		return
+	}
+	for !s.eof() && strings.ContainsRune("<>&|-", s.src[s.pos]) {
+		op.WriteRune(s.src[s.pos])
+		s.pos++
+		if op.Len() >= 3 {
+			break
+		}
+	}
+	s.args = append(s.args, op.String())
+}
+
+// appendVariable 已知变量替换为赋值的内容, 未知变量保留原文
+func (s *shellScanner) appendVariable(name, raw string, quoted bool) {
+	if name == "IFS" {
+		s.appendExpansion(" ", quoted)
+		return
+	}
+	if value, ok := s.lexer.vars[name]; ok {
+		s.appendExpansion(value, quoted)
+		return
+	}
+	s.word.WriteString(raw)
+	s.wordStarted = true
+}
+
+// appendParameter 处理 ${name} 和 ${name:-default}
+func (s *shellScanner) appendParameter(expr string, quoted bool) {
+	name := expr
+	index := strings.IndexAny(expr, ":-")
+	if index > 0 {
+		name = expr[:index]
+	}
+	if _, ok := s.lexer.vars[name]; ok || index <= 0 || name == "IFS" {
+		s.appendVariable(name, "${"+expr+"}", quoted)
+		return
+	}
+	if defaultValue := strings.TrimPrefix(expr[index:], ":"); strings.HasPrefix(defaultValue, "-") {
+		s.appendExpansion(defaultValue[1:], quoted)
+		return
+	}
+	s.word.WriteString("${" + expr + "}")
+	s.wordStarted = true
+}
+
+// appendExpansion 不在引号中的展开结果按空白拆分成多个参数
+func (s *shellScanner) appendExpansion(value string, quoted bool) {
+	if quoted {
+		s.word.WriteString(value)
+		s.wordStarted = true
+		return
+	}
+	for i, field := range strings.FieldsFunc(value, unicode.IsSpace) {
+		if i > 0 || (value != "" && unicode.IsSpace(rune(value[0]))) {
+			s.finishWord()
+		}
+		s.word.WriteString(field)
+		s.wordStarted = true
+	}
+	if value != "" && unicode.IsSpace(rune(value[len(value)-1])) {
+		s.finishWord()
+	}
+}
+
+func (l *shellLexer) recordAssignments(args []string) {
+	switch args[0] {
+	case "export", "readonly", "local", "declare", "typeset":
+		args = args[1:]
+	default:
+		if len(stripAssignments(args)) != 0 {
+			return
+		}
+	}
+	for _, arg := range args {
+		if name, value, ok := splitAssignment(arg); ok {
+			l.vars[name] = value
+		}
+	}
+}
+
+func splitAssignment(arg string) (name, value string, ok bool) {
+	index := strings.IndexByte(arg, '=')
+	if index <= 0 {
+		return "", "", false
+	}
+	name = arg[:index]
+	for i, c := range name {
+		if (i == 0 && !isShellNameStart(c)) || !isShellNameChar(c) {
+			return "", "", false
+		}
+	}
+	return name, arg[index+1:], true
+}
+
+// stripAssignments 去掉命令前面的环境变量赋值
+func stripAssignments(args []string) []string {
+	for len(args) > 0 {
+		if _, _, ok := splitAssignment(args[0]); !ok {
+			break
+		}
+		args = args[1:]
+	}
+	return args
+}
+
+// expandBraces 展开 {rm,-rf,/} 形式的参数
+func expandBraces(args []string) []string {
+	result := make([]string, 0, len(args))
+	for _, arg := range args {
+		if len(arg) > 2 && arg[0] == '{' && arg[len(arg)-1] == '}' &&
+			strings.Contains(arg, ",") && !strings.ContainsAny(arg[1:len(arg)-1], "{} ") {
+			result = append(result, strings.Split(arg[1:len(arg)-1], ",")...)
+			continue
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
+var (
+	shellReservedWords = map[string]bool{
+		"!": true, "{": true, "}": true, "if": true, "then": true, "else": true,
+		"elif": true, "fi": true, "do": true, "done": true, "while": true,
+		"until": true, "time": true,
+	}
+
+	// 前缀命令及需要跳过参数的选项
+	shellWrapperCommands = map[string]string{
+		"sudo":    "ugCpUrtDRTh",
+		"env":     "uSC",
+		"nohup":   "",
+		"exec":    "a",
+		"command": "",
+		"builtin": "",
+		"nice":    "n",
+		"timeout": "sk",
+		"stdbuf":  "ioe",
+		"chroot":  "",
+		"xargs":   "aEeIiLlnPsd",
+		"doas":    "uC",
+	}
+)
+
+// unwrapShellCommand 去掉 sudo、env 等前缀命令和保留字, 返回真正执行的命令
+func unwrapShellCommand(args []string) []string {
+	for len(args) > 0 {
+		name := args[0]
+		if shellReservedWords[name] {
+			args = args[1:]
+			continue
+		}
+		valueOptions, ok := shellWrapperCommands[shellBaseName(name)]
+		if !ok {
+			return args
+		}
+		args = args[1:]
+		for len(args) > 0 {
+			arg := args[0]
+			if arg == "--" {
+				args = args[1:]
+				break
+			}
+			if _, _, ok := splitAssignment(arg); ok && name == "env" {
+				args = args[1:]
+				continue
+			}
+			if len(arg) < 2 || arg[0] != '-' {
+				break
+			}
+			args = args[1:]
+			// -u root 形式的选项跳过选项的值
+			if len(arg) == 2 && strings.IndexByte(valueOptions, arg[1]) >= 0 && len(args) > 0 {
+				args = args[1:]
+			}
+		}
+		switch shellBaseName(name) {
+		case "timeout":
+			// timeout 的第一个参数为时间
+			if len(args) > 0 {
+				args = args[1:]
+			}
+		case "chroot":
+			if len(args) > 0 {
+				args = args[1:]
+			}
+		}
+	}
+	return args
+}
+
+var shellInterpreters = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true,
+	"ash": true, "fish": true, "su": true,
+}
+
+// shellScriptArg 返回 sh -c 和 eval 执行的命令文本
+func shellScriptArg(args []string) (string, bool) {
+	name := shellBaseName(args[0])
+	if name == "eval" {
+		return strings.Join(args[1:], " "), len(args) > 1
+	}
+	if !shellInterpreters[name] {
+		return "", false
+	}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-c" || arg == "--command" ||
+			(len(arg) > 1 && arg[0] == '-' && arg[1] != '-' && strings.HasSuffix(arg, "c")) {
+			if i+1 < len(args) {
+				return args[i+1], true
+			}
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// staticOutput $(echo rm) 中只有 echo/printf 时, 可以确定命令的输出
+func staticOutput(commands []ShellCommand) (string, bool) {
+	if len(commands) != 1 {
+		return "", false
+	}
+	args := commands[0].Args
+	switch shellBaseName(args[0]) {
+	case "echo":
+		args = args[1:]
+		var escape bool
+		for len(args) > 0 && len(args[0]) > 1 && strings.Trim(args[0], "-neE") == "" {
+			escape = escape || strings.Contains(args[0], "e")
+			args = args[1:]
+		}
+		value := strings.Join(args, " ")
+		if escape {
+			value = decodeShellEscapes(value)
+		}
+		return value, true
+	case "printf":
+		// 只处理没有格式或者格式为 %s 的情况
+		switch {
+		case len(args) == 2 && !strings.Contains(args[1], "%"):
+			return decodeShellEscapes(args[1]), true
+		case len(args) > 2 && args[1] == "%s":
+			return strings.Join(args[2:], ""), true
+		}
+	}
+	return "", false
+}
+
+func shellBaseName(name string) string {
+	if index := strings.LastIndexByte(name, '/'); index >= 0 {
+		return name[index+1:]
+	}
+	return name
+}
+
+func isShellNameStart(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isShellNameChar(c rune) bool {
+	return isShellNameStart(c) || (c >= '0' && c <= '9')
+}
+
+func isAllDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// matchShellCommandRules 原始命令和拆分后的每个简单命令分别按优先级匹配规则, 取最严格的动作
+func matchShellCommandRules(rules []model.SystemUserFilterRule, command string) (model.SystemUserFilterRule, string, bool) {
+	var (
+		matchedRule model.SystemUserFilterRule
+		matchedCmd  string
+		matched     bool
+	)
+	candidates := []string{command}
+	for _, item := range ParseShellCommands(command) {
+		candidates = append(candidates, item.String())
+	}
+	for _, candidate := range candidates {
+		rule, cmd, ok := matchFilterRules(rules, candidate)
+		if !ok {
+			continue
+		}
+		if !matched || rule.Action.StricterThan(matchedRule.Action) {
+			matchedRule, matchedCmd, matched = rule, cmd, true
+		}
+	}
+	return matchedRule, matchedCmd, matched
+}
+
+// matchFilterRules rules 已按优先级排序, 返回第一个匹配的规则
+func matchFilterRules(rules []model.SystemUserFilterRule, command string) (model.SystemUserFilterRule, string, bool) {
+	index, cmd, ok := firstMatchedRule(len(rules), func(i int) (model.RuleAction, string) {
+		return rules[i].Match(command)
+	})
+	if !ok {
+		return model.SystemUserFilterRule{}, "", false
+	}
+	return rules[index], cmd, true
+}
+
+// firstMatchedRule 按顺序返回第一个匹配的规则;
+// 审计规则不终止匹配, 之后的拒绝或复核规则仍然生效, 审计规则之后的允许规则不会覆盖审计
+func firstMatchedRule(n int, match func(i int) (model.RuleAction, string)) (int, string, bool) {
+	var (
+		auditIndex int
+		auditCmd   string
+		audited    bool
+	)
+	for i := 0; i < n; i++ {
+		action, cmd := match(i)
+		switch action {
+		case model.ActionAudit:
+			if !audited {
+				auditIndex, auditCmd, audited = i, cmd, true
+			}
+		case model.ActionAllow:
+			if audited {
+				return auditIndex, auditCmd, true
+			}
+			return i, cmd, true
+		case model.ActionConfirm, model.ActionDeny:
+			return i, cmd, true
+		}
+	}
+	return auditIndex, auditCmd, audited
+}
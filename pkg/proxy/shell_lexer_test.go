@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+)
+
+func shellCommandStrings(line string) []string {
+	var result []string
+	for _, item := range ParseShellCommands(line) {
+		result = append(result, item.String())
+	}
+	return result
+}
+
+func TestParseShellCommands(t *testing.T) {
+	tests := []struct {
+		line   string
+		expect []string
+	}{
+		{`ls -l`, []string{"ls -l"}},
+		{`ls; pwd && id || whoami | grep root`, []string{"ls", "pwd", "id", "whoami", "grep root"}},
+		{`r''m -rf /`, []string{"rm -rf /"}},
+		{`"r"m -rf /`, []string{"rm -rf /"}},
+		{`\r\m -rf /`, []string{"rm -rf /"}},
+		{"rm \\\r\n-rf /", []string{"rm -rf /"}},
+		{"rm -r\\\nf /", []string{"rm -rf /"}},
+		{`$'\x72\x6d' -rf /`, []string{"rm -rf /"}},
+		{`$(echo rm) -rf /`, []string{"echo rm", "rm -rf /"}},
+		{"`echo rm` -rf /", []string{"echo rm", "rm -rf /"}},
+		{`$(printf '\162\155') -rf /`, []string{`printf \162\155`, "rm -rf /"}},
+		{`a=r;b=m;$a$b -rf /`, []string{"rm -rf /"}},
+		{`export c="rm -rf"; $c /`, []string{"export c=rm -rf", "rm -rf /"}},
+		{`rm${IFS}-rf${IFS}/`, []string{"rm -rf /"}},
+		{`{rm,-rf,/}`, []string{"rm -rf /"}},
+		{`${x:-rm} -rf /`, []string{"rm -rf /"}},
+		{`(cd /tmp; rm -rf /)`, []string{"cd /tmp", "rm -rf /"}},
+		{`if true; then rm -rf /; fi`, []string{"if true", "true", "then rm -rf /", "rm -rf /", "fi"}},
+		{`sudo -u root rm -rf /`, []string{"sudo -u root rm -rf /", "rm -rf /"}},
+		{`bash -c 'rm -rf /'`, []string{"bash -c rm -rf /", "rm -rf /"}},
+		{`sudo sh -c "r''m -rf /"`, []string{`sudo sh -c r''m -rf /`, "sh -c r''m -rf /", "rm -rf /"}},
+		{`eval "rm -rf /"`, []string{"eval rm -rf /", "rm -rf /"}},
+		{`FOO=1 rm -rf /`, []string{"rm -rf /"}},
+		{`echo x > /dev/sda 2>&1`, []string{"echo x > /dev/sda 2>& 1"}},
+		{`cat <(rm -rf /)`, []string{"rm -rf /", "cat $(rm -rf /)"}},
+		{`echo "$(whoami)" # rm -rf /`, []string{"whoami", "echo $(whoami)"}},
+		{`echo 'a;b' "c|d"`, []string{"echo a;b c|d"}},
+	}
+	for _, tt := range tests {
+		got := shellCommandStrings(tt.line)
+		if !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("%q: expect %q, got %q", tt.line, tt.expect, got)
+		}
+	}
+}
+
+func TestMatchShellCommandRules(t *testing.T) {
+	rules := []model.SystemUserFilterRule{
+		{ID: "allow-ls", Priority: 10, Type: model.TypeCmd, Content: "ls", Action: model.ActionAllow},
+		{ID: "confirm-reboot", Priority: 50, Type: model.TypeCmd, Content: "reboot", Action: model.ActionConfirm},
+		{ID: "deny-rm", Priority: 50, Type: model.TypeCmd, Content: "rm -rf", Action: model.ActionDeny},
+	}
+	bypasses := []string{
+		`rm -rf /`,
+		`r''m -rf /`,
+		`r"m" -r'f' /`,
+		`\rm -rf /`,
+		"rm \\\n-rf /",
+		`$(echo rm) -rf /`,
+		"`echo rm` -rf /",
+		`$(printf 'rm') -rf /`,
+		`$'\162\155' -rf /`,
+		`a=r;b=m;$a$b -rf /`,
+		`rm${IFS}-rf${IFS}/`,
+		`{rm,-rf,/}`,
+		`ls; rm -rf /`,
+		`ls && rm -rf /`,
+		`ls | rm -rf /`,
+		`ls & rm -rf /`,
+		`ls $(rm -rf /)`,
+		`(ls; rm -rf /)`,
+		`bash -c "rm -rf /"`,
+		`sh -c 'r''m -rf /'`,
+		`sudo -u root env X=1 rm -rf /`,
+		`eval 'r''m -rf /'`,
+		`ls; reboot; rm -rf /`,
+	}
+	for _, line := range bypasses {
+		rule, _, ok := matchShellCommandRules(rules, line)
+		if !ok || rule.ID != "deny-rm" {
+			t.Errorf("%q: expect deny-rm, got %q", line, rule.ID)
+		}
+	}
+
+	tests := []struct {
+		line   string
+		ruleID string
+	}{
+		{`ls -l`, "allow-ls"},
+		{`ls; reboot`, "confirm-reboot"},
+		{`pwd`, ""},
+	}
+	for _, tt := range tests {
+		rule, _, ok := matchShellCommandRules(rules, tt.line)
+		if ok != (tt.ruleID != "") || rule.ID != tt.ruleID {
+			t.Errorf("%q: expect %q, got %q", tt.line, tt.ruleID, rule.ID)
+		}
+	}
+}
+
+func TestMatchShellCommandRules_Audit(t *testing.T) {
+	rules := []model.SystemUserFilterRule{
+		{ID: "audit-rm", Priority: 1, Type: model.TypeCmd, Content: "rm", Action: model.ActionAudit},
+		{ID: "deny-rm-root", Priority: 10, Type: model.TypeRegex, Content: `rm\s+-rf\s+/$`, Action: model.ActionDeny},
+		{ID: "allow-all", Priority: 100, Type: model.TypeRegex, Content: ".*", Action: model.ActionAllow},
+	}
+	tests := []struct {
+		line   string
+		ruleID string
+	}{
+		{`rm a.txt`, "audit-rm"},
+		{`rm -rf /`, "deny-rm-root"},
+		{`ls; rm a.txt`, "audit-rm"},
+		{`ls`, "allow-all"},
+	}
+	for _, tt := range tests {
+		rule, _, ok := matchShellCommandRules(rules, tt.line)
+		if !ok || rule.ID != tt.ruleID {
+			t.Errorf("%q: expect %q, got %q", tt.line, tt.ruleID, rule.ID)
+		}
+	}
+}
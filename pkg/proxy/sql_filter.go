@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+)
+
+/*
+	数据库会话的命令过滤, 命令和正则规则匹配语句的文本(多行合并为一行), sql 规则按语句的结构匹配:
+	  statements: 语句类型, 如 delete、drop table、system
+	  tables: 表名通配符, 匹配 db.table 或 table
+	  missing_where: UPDATE、DELETE 没有有效的 WHERE 条件
+	  max_rows: 估算的影响行数超过该值, 没有 LIMIT 等无法估算时也算超过
+	条件之间为且的关系, 至少需要一个条件。数据库会话暂不支持命令复核, 复核规则不生效。
+*/
+
+const filterRuleTypeSQL = "sql"
+
+type SQLFilterRule struct {
+	model.SystemUserFilterRule
+
+	Statements   []string
+	Tables       []string
+	MissingWhere bool
+	MaxRows      int
+}
+
+// MatchStatement 返回匹配的动作和匹配的内容
+func (r *SQLFilterRule) MatchStatement(stmt *SQLStatement) (model.RuleAction, string) {
+	if r.Type != filterRuleTypeSQL {
+		return r.Match(strings.Join(strings.Fields(stmt.Text), " "))
+	}
+	if len(r.Statements) > 0 && !r.matchStatementType(stmt) {
+		return model.ActionUnknown, ""
+	}
+	if len(r.Tables) > 0 && !r.matchTables(stmt.Tables) {
+		return model.ActionUnknown, ""
+	}
+	if r.MissingWhere && (stmt.HasWhere || stmt.Type != "UPDATE" && stmt.Type != "DELETE") {
+		return model.ActionUnknown, ""
+	}
+	if r.MaxRows > 0 && stmt.Rows >= 0 && stmt.Rows <= r.MaxRows {
+		return model.ActionUnknown, ""
+	}
+	return r.Action, stmt.Text
+}
+
+func (r *SQLFilterRule) matchStatementType(stmt *SQLStatement) bool {
+	for _, item := range r.Statements {
+		item = strings.ToUpper(strings.Join(strings.Fields(item), " "))
+		if item == stmt.Type || stmt.Object != "" && item == stmt.Type+" "+stmt.Object {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *SQLFilterRule) matchTables(tables []string) bool {
+	for _, pattern := range r.Tables {
+		pattern = strings.ToLower(pattern)
+		for _, table := range tables {
+			table = strings.ToLower(table)
+			if ok, _ := path.Match(pattern, table); ok {
+				return true
+			}
+			if i := strings.LastIndexByte(table, '.'); i >= 0 {
+				if ok, _ := path.Match(pattern, table[i+1:]); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// newDBFilterRules 合并命令过滤规则和 sql 规则, 按优先级排序
+func newDBFilterRules(rules []model.SystemUserFilterRule, sqlRules []SQLFilterRule) []SQLFilterRule {
+	dbRules := make([]SQLFilterRule, 0, len(rules)+len(sqlRules))
+	for i := range rules {
+		dbRules = append(dbRules, SQLFilterRule{SystemUserFilterRule: rules[i]})
+	}
+	dbRules = append(dbRules, sqlRules...)
+	sort.SliceStable(dbRules, func(i, j int) bool {
+		if dbRules[i].Priority == dbRules[j].Priority {
+			return dbRules[i].Action.StricterThan(dbRules[j].Action)
+		}
+		return dbRules[i].Priority < dbRules[j].Priority
+	})
+	return dbRules
+}
+
+// matchSQLFilterRules 每个语句分别按优先级匹配规则, 取最严格的动作
+func matchSQLFilterRules(rules []SQLFilterRule, stmts []*SQLStatement) (model.SystemUserFilterRule, string, bool) {
+	var (
+		matchedRule model.SystemUserFilterRule
+		matchedCmd  string
+		matched     bool
+	)
+	for _, stmt := range stmts {
+		index, cmd, ok := firstMatchedRule(len(rules), func(i int) (model.RuleAction, string) {
+			if rules[i].Action == model.ActionConfirm {
+				return model.ActionUnknown, ""
+			}
+			return rules[i].MatchStatement(stmt)
+		})
+		if !ok {
+			continue
+		}
+		if rule := rules[index].SystemUserFilterRule; !matched || rule.Action.StricterThan(matchedRule.Action) {
+			matchedRule, matchedCmd, matched = rule, cmd, true
+		}
+	}
+	return matchedRule, matchedCmd, matched
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
+)
+
+func TestMatchSQLFilterRules(t *testing.T) {
+	rules := newDBFilterRules([]model.SystemUserFilterRule{
+		{ID: "deny-shutdown", Priority: 1, Type: model.TypeCmd, Content: "shutdown", Action: model.ActionDeny},
+		{ID: "confirm-grant", Priority: 1, Type: model.TypeCmd, Content: "grant", Action: model.ActionConfirm},
+		{ID: "deny-system", Priority: 1, Type: model.TypeRegex, Content: `rm\s+-rf`, Action: model.ActionDeny},
+		{ID: "allow-all", Priority: 100, Type: model.TypeRegex, Content: ".*", Action: model.ActionAllow},
+	}, []SQLFilterRule{
+		{SystemUserFilterRule: model.SystemUserFilterRule{ID: "deny-no-where", Priority: 10,
+			Type: filterRuleTypeSQL, Action: model.ActionDeny}, MissingWhere: true},
+		{SystemUserFilterRule: model.SystemUserFilterRule{ID: "deny-drop-prod", Priority: 10,
+			Type: filterRuleTypeSQL, Action: model.ActionDeny},
+			Statements: []string{"drop table", "truncate"}, Tables: []string{"prod_*"}},
+		{SystemUserFilterRule: model.SystemUserFilterRule{ID: "audit-large-update", Priority: 20,
+			Type: filterRuleTypeSQL, Action: model.ActionAudit},
+			Statements: []string{"update", "delete"}, MaxRows: 100},
+	})
+	tests := []struct {
+		stmts  []string
+		ruleID string
+	}{
+		{[]string{"delete from t"}, "deny-no-where"},
+		{[]string{"delete from t where 1"}, "deny-no-where"},
+		{[]string{"delete from t where id > 10 limit 10"}, "allow-all"},
+		{[]string{"update t set a = 1 where id > 10"}, "audit-large-update"},
+		{[]string{"drop table db.prod_users"}, "deny-drop-prod"},
+		{[]string{"drop table test_users"}, "allow-all"},
+		{[]string{"select 1", "shutdown"}, "deny-shutdown"},
+		{[]string{"grant all on *.* to x"}, "allow-all"},
+		{[]string{"\\! rm -rf /"}, "deny-system"},
+	}
+	for _, tt := range tests {
+		var stmts []*SQLStatement
+		for _, text := range tt.stmts {
+			stmts = append(stmts, analyzeSQL(text))
+		}
+		rule, _, ok := matchSQLFilterRules(rules, stmts)
+		if !ok || rule.ID != tt.ruleID {
+			t.Errorf("%q: expect %q, got %q", tt.stmts, tt.ruleID, rule.ID)
+		}
+	}
+}
@@ -0,0 +1,751 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jumpserver/koko/pkg/utils"
+)
+
+/*
+	数据库会话的 MySQL 语句分析:
+	1. sqlStatementBuffer 按行缓存用户的输入, 遇到结束符(默认 ;, 可由 DELIMITER 修改, 以及 \g、\G)时返回完整的语句,
+	   引号和注释中的结束符不生效, \c 清空当前语句; 空缓存时行首的客户端命令(use、source、system 等)和
+	   反斜杠命令(\! \. \u 等)不需要结束符, 作为单独的语句返回
+	2. analyzeSQL 分析语句的类型、对象、涉及的表、是否有有效的 WHERE 条件和估算的影响行数
+	版本注释(/*!50000 ...)中的内容会被 MySQL 执行, 分析时按普通语句处理
+	PostgreSQL(psql) 的语句切分和元命令见 psql_lexer.go, MongoDB(mongosh) 见 mongo_lexer.go,
+	SQL Server(sqlcmd) 见 sqlcmd_lexer.go, Oracle(sqlplus) 见 sqlplus_lexer.go
+*/
+
+const (
+	sqlDialectMySQL      = "mysql"
+	sqlDialectPostgreSQL = "postgresql"
+	sqlDialectMongoDB    = "mongodb"
+	sqlDialectSQLServer  = "sqlserver"
+	sqlDialectOracle     = "oracle"
+)
+
+// mysql 客户端的长命令, 只在空缓存的行首生效
+var sqlClientCommands = map[string]bool{
+	"use": true, "quit": true, "exit": true, "delimiter": true, "source": true, "system": true,
+	"status": true, "connect": true, "charset": true, "warnings": true, "nowarning": true,
+	"help": true, "rehash": true, "tee": true, "notee": true, "pager": true, "nopager": true,
+	"prompt": true, "edit": true, "resetconnection": true, "query_attributes": true,
+}
+
+// mysql 客户端的反斜杠命令, 括号中为对应的长命令
+var sqlBackslashCommands = map[byte]string{
+	'!': "SYSTEM", '.': "SOURCE", 'u': "USE", 'q': "QUIT", 'd': "DELIMITER", 's': "STATUS",
+	'r': "CONNECT", 'C': "CHARSET", 'W': "WARNINGS", 'w': "NOWARNING", 'h': "HELP", '?': "HELP",
+	'#': "REHASH", 'T': "TEE", 't': "NOTEE", 'P': "PAGER", 'n': "NOPAGER", 'R': "PROMPT",
+	'e': "EDIT", 'x': "RESETCONNECTION", 'p': "PRINT",
+}
+
+type sqlStatementBuffer struct {
+	dialect   string
+	delimiter string
+	pending   string
+	// clearCommand 清空客户端中未结束语句的反斜杠命令
+	clearCommand byte
+	// last sqlplus 的 SQL 缓存区中的上一条语句, / 和 RUN 再次执行
+	last string
+}
+
+func newSQLStatementBuffer() *sqlStatementBuffer {
+	return &sqlStatementBuffer{dialect: sqlDialectMySQL, delimiter: ";", clearCommand: 'c'}
+}
+
+// Append 追加一行输入, 返回已经结束的语句
+func (b *sqlStatementBuffer) Append(line string) []string {
+	text := line
+	if b.pending != "" {
+		text = b.pending + "\n" + line
+	}
+	stmts, rest := b.split(text)
+	if strings.TrimSpace(rest) == "" {
+		rest = ""
+	}
+	b.pending = rest
+	return stmts
+}
+
+// Pending 是否有未结束的语句
+func (b *sqlStatementBuffer) Pending() bool {
+	return b.pending != ""
+}
+
+func (b *sqlStatementBuffer) Reset() {
+	b.pending = ""
+}
+
+// Analyze 按客户端分析语句
+func (b *sqlStatementBuffer) Analyze(text string) *SQLStatement {
+	switch b.dialect {
+	case sqlDialectPostgreSQL:
+		return analyzePSQL(text)
+	case sqlDialectMongoDB:
+		return analyzeMongo(text)
+	case sqlDialectSQLServer:
+		return analyzeTSQL(text)
+	case sqlDialectOracle:
+		return analyzeSQLPlus(text)
+	}
+	return analyzeSQL(text)
+}
+
+// ClearInput 清空客户端中已输入的语句: mysql 的 \c, psql 的 \r, sqlcmd 的 :reset;
+// mongosh 没有清空的命令, 多行输入时按 Ctrl+C 放弃, 单行时清空当前行后执行空行;
+// sqlplus 多行输入时用 . 结束输入, 再清空 SQL 缓存区, 之后的 / 不会执行被拒绝的语句
+func (b *sqlStatementBuffer) ClearInput(multiLine bool) []byte {
+	switch b.dialect {
+	case sqlDialectMongoDB:
+		if multiLine {
+			return []byte{utils.CharCleanLine, utils.CharCtrlC}
+		}
+		return []byte{utils.CharCleanLine, '\r'}
+	case sqlDialectSQLServer:
+		return append([]byte{utils.CharCleanLine}, ":reset\r"...)
+	case sqlDialectOracle:
+		b.last = ""
+		cleanup := []byte{utils.CharCleanLine}
+		if multiLine {
+			cleanup = append(cleanup, ".\r"...)
+		}
+		return append(cleanup, "clear buffer\r"...)
+	}
+	return []byte{utils.CharCleanLine, '\\', b.clearCommand, '\r'}
+}
+
+func (b *sqlStatementBuffer) split(text string) ([]string, string) {
+	switch b.dialect {
+	case sqlDialectPostgreSQL:
+		return b.splitPSQL(text)
+	case sqlDialectMongoDB:
+		return b.splitMongo(text)
+	case sqlDialectSQLServer:
+		return b.splitSQLCmd(text)
+	case sqlDialectOracle:
+		return b.splitSQLPlus(text)
+	}
+	var (
+		stmts []string
+		cur   strings.Builder
+	)
+	flush := func() {
+		if stmt := strings.TrimSpace(cur.String()); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		cur.Reset()
+	}
+	for i := 0; i < len(text); {
+		if (i == 0 || text[i-1] == '\n') && strings.TrimSpace(cur.String()) == "" {
+			if end, ok := b.clientCommand(text, i); ok {
+				stmts = append(stmts, strings.TrimSpace(text[i:end]))
+				cur.Reset()
+				i = end
+				continue
+			}
+		}
+		c := text[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end, closed := skipSQLQuoted(text, i)
+			if !closed {
+				return stmts, cur.String() + text[i:]
+			}
+			cur.WriteString(text[i:end])
+			i = end
+		case c == '#' || isSQLDashComment(text, i):
+			end := sqlLineEnd(text, i)
+			cur.WriteString(text[i:end])
+			i = end
+		case strings.HasPrefix(text[i:], "/*"):
+			n := strings.Index(text[i+2:], "*/")
+			if n < 0 {
+				return stmts, cur.String() + text[i:]
+			}
+			cur.WriteString(text[i : i+n+4])
+			i += n + 4
+		case strings.HasPrefix(text[i:], b.delimiter):
+			flush()
+			i += len(b.delimiter)
+		case c == '\\' && i+1 < len(text):
+			switch cmd := text[i+1]; cmd {
+			case 'g', 'G':
+				flush()
+				i += 2
+			case 'c':
+				cur.Reset()
+				i += 2
+			default:
+				if _, ok := sqlBackslashCommands[cmd]; !ok {
+					cur.WriteString(text[i : i+2])
+					i += 2
+					continue
+				}
+				// 反斜杠命令使用到行尾的内容作为参数, 当前语句不受影响
+				end := sqlLineEnd(text, i)
+				if fields := strings.Fields(text[i+2 : end]); cmd == 'd' && len(fields) > 0 {
+					b.delimiter = fields[0]
+				}
+				stmts = append(stmts, strings.TrimSpace(text[i:end]))
+				i = end
+			}
+		default:
+			cur.WriteByte(c)
+			i++
+		}
+	}
+	return stmts, cur.String()
+}
+
+// clientCommand 行首的客户端长命令, 返回命令的结束位置
+func (b *sqlStatementBuffer) clientCommand(text string, i int) (int, bool) {
+	end := sqlLineEnd(text, i)
+	fields := strings.Fields(text[i:end])
+	if len(fields) == 0 {
+		return 0, false
+	}
+	name := strings.ToLower(strings.TrimSuffix(fields[0], b.delimiter))
+	if !sqlClientCommands[name] {
+		return 0, false
+	}
+	if name == "delimiter" && len(fields) > 1 {
+		b.delimiter = fields[1]
+	}
+	return end, true
+}
+
+// skipSQLQuoted 返回引号结束之后的位置, 支持反斜杠转义和连续两个引号
+func skipSQLQuoted(s string, i int) (int, bool) {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			if quote != '`' {
+				j++
+			}
+		case quote:
+			if j+1 < len(s) && s[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1, true
+		}
+	}
+	return len(s), false
+}
+
+// isSQLDashComment -- 之后需要空白字符才是注释
+func isSQLDashComment(s string, i int) bool {
+	if !strings.HasPrefix(s[i:], "--") {
+		return false
+	}
+	return i+2 == len(s) || isSQLSpace(s[i+2])
+}
+
+func sqlLineEnd(s string, i int) int {
+	if n := strings.IndexByte(s[i:], '\n'); n >= 0 {
+		return i + n
+	}
+	return len(s)
+}
+
+func isSQLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == '\v'
+}
+
+func isSQLWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || ('0' <= c && c <= '9') ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+type sqlTokenKind int
+
+const (
+	sqlTokenWord sqlTokenKind = iota
+	sqlTokenIdent
+	sqlTokenString
+	sqlTokenNumber
+	sqlTokenVariable
+	sqlTokenSymbol
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+// is 是否为指定的关键字
+func (t sqlToken) is(keywords ...string) bool {
+	if t.kind != sqlTokenWord {
+		return false
+	}
+	for _, keyword := range keywords {
+		if strings.EqualFold(t.text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+var sqlMultiSymbols = []string{"<=>", "<=", ">=", "<>", "!=", "||", "&&", ":=", "<<", ">>", "->>", "->"}
+
+func tokenizeSQL(s string) []sqlToken {
+	var (
+		tokens []sqlToken
+		// 版本注释的层数
+		execComment int
+	)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case isSQLSpace(c):
+			i++
+		case c == '#' || isSQLDashComment(s, i):
+			i = sqlLineEnd(s, i)
+		case strings.HasPrefix(s[i:], "/*!"):
+			i += 3
+			for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+				i++
+			}
+			execComment++
+		case execComment > 0 && strings.HasPrefix(s[i:], "*/"):
+			execComment--
+			i += 2
+		case strings.HasPrefix(s[i:], "/*"):
+			n := strings.Index(s[i+2:], "*/")
+			if n < 0 {
+				return tokens
+			}
+			i += n + 4
+		case c == '\'' || c == '"' || c == '`':
+			end, _ := skipSQLQuoted(s, i)
+			kind := sqlTokenString
+			if c == '`' {
+				kind = sqlTokenIdent
+			}
+			tokens = append(tokens, sqlToken{kind: kind, text: unquoteSQL(s[i:end])})
+			i = end
+		case '0' <= c && c <= '9' || (c == '.' && i+1 < len(s) && '0' <= s[i+1] && s[i+1] <= '9'):
+			end := i + 1
+			for end < len(s) && (isSQLWordByte(s[end]) || s[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, sqlToken{kind: sqlTokenNumber, text: s[i:end]})
+			i = end
+		case isSQLWordByte(c):
+			end := i + 1
+			for end < len(s) && isSQLWordByte(s[end]) {
+				end++
+			}
+			tokens = append(tokens, sqlToken{kind: sqlTokenWord, text: s[i:end]})
+			i = end
+		case c == '@':
+			end := i + 1
+			if end < len(s) && s[end] == '@' {
+				end++
+			}
+			if end < len(s) && (s[end] == '\'' || s[end] == '"' || s[end] == '`') {
+				end, _ = skipSQLQuoted(s, end)
+			} else {
+				for end < len(s) && (isSQLWordByte(s[end]) || s[end] == '.') {
+					end++
+				}
+			}
+			tokens = append(tokens, sqlToken{kind: sqlTokenVariable, text: s[i:end]})
+			i = end
+		default:
+			symbol := s[i : i+1]
+			for _, item := range sqlMultiSymbols {
+				if strings.HasPrefix(s[i:], item) {
+					symbol = item
+					break
+				}
+			}
+			tokens = append(tokens, sqlToken{kind: sqlTokenSymbol, text: symbol})
+			i += len(symbol)
+		}
+	}
+	return tokens
+}
+
+func unquoteSQL(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	quote := s[0]
+	body := s[1:]
+	if body[len(body)-1] == quote {
+		body = body[:len(body)-1]
+	}
+	double := string([]byte{quote, quote})
+	if quote == '`' || !strings.Contains(body, "\\") {
+		return strings.ReplaceAll(body, double, string(quote))
+	}
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		switch {
+		case body[i] == '\\' && i+1 < len(body):
+			i++
+			switch body[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			default:
+				b.WriteByte(body[i])
+			}
+		case body[i] == quote && i+1 < len(body) && body[i+1] == quote:
+			b.WriteByte(quote)
+			i++
+		default:
+			b.WriteByte(body[i])
+		}
+	}
+	return b.String()
+}
+
+// SQLStatement 语句的分析结果
+type SQLStatement struct {
+	Text string
+	// Type 语句类型, 如 SELECT、UPDATE; 客户端命令为对应的长命令, 如 SYSTEM、SOURCE
+	Type string
+	// Object CREATE、DROP、ALTER、TRUNCATE、RENAME 的对象类型, 如 TABLE、DATABASE
+	Object string
+	Tables []string
+	// HasWhere 是否有引用字段的 WHERE 条件, WHERE 1=1 这样的常量条件不算
+	HasWhere bool
+	// Rows 根据 LIMIT、VALUES 估算的影响行数, -1 表示无法估算
+	Rows int
+}
+
+var sqlObjectTypes = map[string]bool{
+	"TABLE": true, "DATABASE": true, "SCHEMA": true, "INDEX": true, "VIEW": true, "USER": true,
+	"FUNCTION": true, "PROCEDURE": true, "TRIGGER": true, "EVENT": true, "TABLESPACE": true,
+	"SERVER": true, "ROLE": true, "TABLES": true,
+}
+
+// 表名之后不能作为别名的关键字
+var sqlClauseKeywords = map[string]bool{
+	"WHERE": true, "SET": true, "ON": true, "USING": true, "JOIN": true, "INNER": true, "LEFT": true,
+	"RIGHT": true, "CROSS": true, "NATURAL": true, "STRAIGHT_JOIN": true, "OUTER": true, "GROUP": true,
+	"ORDER": true, "LIMIT": true, "HAVING": true, "UNION": true, "VALUES": true, "VALUE": true,
+	"SELECT": true, "PARTITION": true, "USE": true, "FORCE": true, "IGNORE": true, "FOR": true,
+	"LOCK": true, "INTO": true, "WINDOW": true, "EXCEPT": true, "INTERSECT": true, "TO": true,
+	"FROM": true, "WITH": true, "READ": true, "WRITE": true, "AS": true, "DEFAULT": true,
+	"LIKE": true, "RETURNING": true, "TABLE": true, "IF": true, "CASCADE": true, "RESTRICT": true,
+}
+
+// WHERE 条件之后的子句
+var sqlWhereEndKeywords = []string{"ORDER", "LIMIT", "GROUP", "HAVING", "WINDOW", "FOR", "LOCK",
+	"UNION", "INTO", "RETURNING", "EXCEPT", "INTERSECT"}
+
+// 常量条件中允许出现的关键字
+var sqlConstantKeywords = []string{"TRUE", "FALSE", "NULL", "AND", "OR", "NOT", "IS", "XOR",
+	"LIKE", "IN", "BETWEEN", "DIV", "MOD"}
+
+func analyzeSQL(text string) *SQLStatement {
+	stmt := SQLStatement{Text: text, Rows: -1}
+	if len(text) >= 2 && text[0] == '\\' {
+		stmt.Type = sqlBackslashCommands[text[1]]
+		return &stmt
+	}
+	tokens := tokenizeSQL(text)
+	if len(tokens) == 0 {
+		return &stmt
+	}
+	if first := strings.ToLower(tokens[0].text); tokens[0].kind == sqlTokenWord && sqlClientCommands[first] {
+		stmt.Type = strings.ToUpper(first)
+		return &stmt
+	}
+	main, depth := sqlMainKeyword(tokens)
+	stmt.Type = strings.ToUpper(tokens[main].text)
+	switch stmt.Type {
+	case "CREATE", "DROP", "ALTER", "TRUNCATE", "RENAME":
+		stmt.Object = sqlObjectType(tokens[main+1:])
+		if stmt.Type == "TRUNCATE" && stmt.Object == "" {
+			stmt.Object = "TABLE"
+		}
+	}
+	stmt.Tables = sqlTables(tokens, stmt.Object)
+	stmt.HasWhere = sqlHasWhere(tokens, main, depth)
+	stmt.Rows = sqlEstimateRows(tokens, main, depth)
+	return &stmt
+}
+
+// sqlMainKeyword 跳过开头的括号和 WITH 子句, 返回语句类型关键字的位置和括号深度
+func sqlMainKeyword(tokens []sqlToken) (int, int) {
+	start, depth := 0, 0
+	for start < len(tokens)-1 && tokens[start].text == "(" {
+		start++
+		depth++
+	}
+	if !tokens[start].is("WITH") {
+		return start, depth
+	}
+	level := depth
+	for i := start + 1; i < len(tokens); i++ {
+		switch {
+		case tokens[i].text == "(":
+			level++
+		case tokens[i].text == ")":
+			level--
+		case level == depth && tokens[i].is("SELECT", "UPDATE", "DELETE", "INSERT", "REPLACE"):
+			return i, depth
+		}
+	}
+	return start, depth
+}
+
+func sqlObjectType(tokens []sqlToken) string {
+	for i, tok := range tokens {
+		if i > 10 || tok.kind == sqlTokenSymbol && tok.text != "=" {
+			break
+		}
+		if word := strings.ToUpper(tok.text); tok.kind == sqlTokenWord && sqlObjectTypes[word] {
+			if word == "TABLES" {
+				word = "TABLE"
+			}
+			return word
+		}
+	}
+	return ""
+}
+
+// sqlTables 返回 FROM、JOIN、UPDATE、INTO、TABLE 等之后的表名
+func sqlTables(tokens []sqlToken, object string) []string {
+	var (
+		tables []string
+		// 每层括号是否为子查询, FROM 在函数中(如 EXTRACT(YEAR FROM d))时不是表名
+		query = []bool{true}
+	)
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		var (
+			names []string
+			next  int
+		)
+		switch {
+		case tok.text == "(":
+			query = append(query, i+1 < len(tokens) && tokens[i+1].is("SELECT", "WITH"))
+			continue
+		case tok.text == ")":
+			if len(query) > 1 {
+				query = query[:len(query)-1]
+			}
+			continue
+		case i > 0 && tokens[i-1].is("ON", "KEY"):
+			// ON DUPLICATE KEY UPDATE、外键的 ON DELETE
+			continue
+		case tok.is("FROM") && query[len(query)-1], tok.is("UPDATE"):
+			names, next = sqlTableList(tokens, sqlSkipWords(tokens, i+1, "LOW_PRIORITY", "IGNORE"), true)
+		case tok.is("DELETE"):
+			names, next = sqlTableList(tokens, sqlSkipWords(tokens, i+1, "LOW_PRIORITY", "QUICK", "IGNORE"), true)
+		case strings.HasSuffix(strings.ToUpper(tok.text), "JOIN") && tok.kind == sqlTokenWord:
+			names, next = sqlTableList(tokens, i+1, false)
+		case tok.is("INTO"):
+			// SELECT ... INTO @var、INTO OUTFILE 不是表
+			if i+1 < len(tokens) && !tokens[i+1].is("OUTFILE", "DUMPFILE", "TABLE") {
+				names, next = sqlTableList(tokens, i+1, false)
+			}
+		case tok.is("INSERT", "REPLACE"):
+			j := sqlSkipWords(tokens, i+1, "LOW_PRIORITY", "DELAYED", "HIGH_PRIORITY", "IGNORE")
+			if j < len(tokens) && !tokens[j].is("INTO") {
+				names, next = sqlTableList(tokens, j, false)
+			}
+		case tok.is("TABLE", "TABLES") && !(i > 0 && tokens[i-1].is("SHOW", "FULL", "OPEN")):
+			names, next = sqlTableList(tokens, sqlSkipWords(tokens, i+1, "IF", "NOT", "EXISTS"), true)
+		case tok.is("TRUNCATE") && i+1 < len(tokens) && !tokens[i+1].is("TABLE"):
+			names, next = sqlTableList(tokens, i+1, false)
+		case tok.is("ON") && (object == "INDEX" || object == "TRIGGER"):
+			names, next = sqlTableList(tokens, i+1, false)
+		case tok.is("LIKE") && object == "TABLE":
+			names, next = sqlTableList(tokens, i+1, false)
+		default:
+			continue
+		}
+		tables = appendUniqueFold(tables, names...)
+		if next > i+1 {
+			i = next - 1
+		}
+	}
+	return tables
+}
+
+// sqlTableList 读取表名列表, 不读取括号, 子查询由 sqlTables 继续处理
+func sqlTableList(tokens []sqlToken, i int, multi bool) ([]string, int) {
+	var names []string
+	for i < len(tokens) {
+		name, next, ok := sqlTableName(tokens, i)
+		if !ok {
+			break
+		}
+		names = append(names, name)
+		i = next
+		// 别名
+		if i < len(tokens) && tokens[i].is("AS") {
+			i += 2
+		} else if i < len(tokens) && (tokens[i].kind == sqlTokenIdent ||
+			tokens[i].kind == sqlTokenWord && !sqlClauseKeywords[strings.ToUpper(tokens[i].text)]) {
+			i++
+		}
+		// RENAME TABLE a TO b
+		if i < len(tokens) && tokens[i].is("TO") {
+			if name, next, ok = sqlTableName(tokens, i+1); ok {
+				names = append(names, name)
+				i = next
+			}
+		}
+		if !multi || i >= len(tokens) || tokens[i].text != "," {
+			break
+		}
+		i++
+	}
+	return names, i
+}
+
+// sqlTableName 读取 db.table 形式的表名
+func sqlTableName(tokens []sqlToken, i int) (string, int, bool) {
+	isName := func(tok sqlToken) bool {
+		return tok.kind == sqlTokenIdent ||
+			tok.kind == sqlTokenWord && !sqlClauseKeywords[strings.ToUpper(tok.text)]
+	}
+	if i >= len(tokens) || !isName(tokens[i]) {
+		return "", i, false
+	}
+	name := tokens[i].text
+	i++
+	for i+1 < len(tokens) && tokens[i].text == "." && (isName(tokens[i+1]) || tokens[i+1].text == "*") {
+		name += "." + tokens[i+1].text
+		i += 2
+	}
+	return name, i, true
+}
+
+func sqlSkipWords(tokens []sqlToken, i int, words ...string) int {
+	for i < len(tokens) && tokens[i].is(words...) {
+		i++
+	}
+	return i
+}
+
+// sqlHasWhere 语句所在层是否有引用字段的 WHERE 条件
+func sqlHasWhere(tokens []sqlToken, main, depth int) bool {
+	level := depth
+	for i := main; i < len(tokens); i++ {
+		switch tok := tokens[i]; {
+		case tok.text == "(":
+			level++
+		case tok.text == ")":
+			level--
+		case level == depth && tok.is("WHERE"):
+			return !sqlConstantCondition(tokens[i+1:], depth)
+		}
+		if level < depth {
+			break
+		}
+	}
+	return false
+}
+
+// sqlConstantCondition 条件中没有字段和函数, 如 1=1、TRUE
+func sqlConstantCondition(tokens []sqlToken, depth int) bool {
+	level := depth
+	for _, tok := range tokens {
+		switch {
+		case tok.text == "(":
+			level++
+		case tok.text == ")":
+			level--
+		case level == depth && tok.is(sqlWhereEndKeywords...):
+			return true
+		case tok.kind == sqlTokenIdent, tok.kind == sqlTokenVariable:
+			return false
+		case tok.kind == sqlTokenWord && !tok.is(sqlConstantKeywords...):
+			return false
+		}
+		if level < depth {
+			break
+		}
+	}
+	return true
+}
+
+// sqlEstimateRows 根据语句所在层的 LIMIT 或 INSERT 的 VALUES、SET 估算影响的行数
+func sqlEstimateRows(tokens []sqlToken, main, depth int) int {
+	var (
+		level    = depth
+		values   = -1
+		inValues bool
+	)
+	insert := tokens[main].is("INSERT", "REPLACE")
+	for i := main; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok.text == "(":
+			if level == depth && inValues {
+				values++
+			}
+			level++
+		case tok.text == ")":
+			level--
+		case level != depth:
+		case insert && values < 0 && tok.is("VALUES", "VALUE"):
+			values = 0
+			inValues = true
+		case insert && values < 0 && tok.is("SET"):
+			values = 1
+		case insert && tok.is("SELECT"):
+			insert = false
+		case tok.is("ON", "AS") && values >= 0:
+			return values
+		case tok.is("LIMIT"):
+			return sqlLimitRows(tokens[i+1:])
+		}
+		if level < depth {
+			break
+		}
+	}
+	return values
+}
+
+// sqlLimitRows 解析 LIMIT n、LIMIT offset, n 和 LIMIT n OFFSET offset
+func sqlLimitRows(tokens []sqlToken) int {
+	if len(tokens) == 0 || tokens[0].kind != sqlTokenNumber {
+		return -1
+	}
+	count := tokens[0].text
+	if len(tokens) >= 3 && tokens[1].text == "," {
+		if tokens[2].kind != sqlTokenNumber {
+			return -1
+		}
+		count = tokens[2].text
+	}
+	rows, err := strconv.Atoi(count)
+	if err != nil {
+		return -1
+	}
+	return rows
+}
+
+func appendUniqueFold(items []string, values ...string) []string {
+	for _, value := range values {
+		exist := false
+		for _, item := range items {
+			if strings.EqualFold(item, value) {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			items = append(items, value)
+		}
+	}
+	return items
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSQLStatementBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		expect [][]string
+	}{
+		{"single", []string{"select 1;"}, [][]string{{"select 1"}}},
+		{"multi line", []string{"delete from t", "where id = 1", ";"},
+			[][]string{nil, nil, {"delete from t\nwhere id = 1"}}},
+		{"multi statements", []string{"select 1; select 2\\G select"},
+			[][]string{{"select 1", "select 2"}}},
+		{"quoted delimiter", []string{"select 'a;", "b', \"c;\" -- d;", "# e;", "/* f; */;"},
+			[][]string{nil, nil, nil, {"select 'a;\nb', \"c;\" -- d;\n# e;\n/* f; */"}}},
+		{"clear", []string{"delete from t", "\\c", "select 1;"}, [][]string{nil, nil, {"select 1"}}},
+		{"delimiter", []string{"DELIMITER //", "create procedure p() begin delete from t; end//", "delimiter ;"},
+			[][]string{{"DELIMITER //"}, {"create procedure p() begin delete from t; end"}, {"delimiter ;"}}},
+		{"client command", []string{"use test", "system rm -rf /", "select 1 \\! rm -rf /", ";"},
+			[][]string{{"use test"}, {"system rm -rf /"}, {"\\! rm -rf /"}, {"select 1"}}},
+		{"client command in statement", []string{"select", "use test;"}, [][]string{nil, {"select\nuse test"}}},
+	}
+	for _, tt := range tests {
+		buf := newSQLStatementBuffer()
+		for i, line := range tt.lines {
+			var expect []string
+			if i < len(tt.expect) {
+				expect = tt.expect[i]
+			}
+			if got := buf.Append(line); !reflect.DeepEqual(got, expect) {
+				t.Errorf("%s: line %d expect %q, got %q", tt.name, i, expect, got)
+			}
+		}
+	}
+}
+
+func TestAnalyzeSQL(t *testing.T) {
+	tests := []struct {
+		text   string
+		expect SQLStatement
+	}{
+		{"SELECT * FROM a JOIN db.b ON a.id = b.id WHERE a.x IN (SELECT y FROM `c`) LIMIT 10, 20",
+			SQLStatement{Type: "SELECT", Tables: []string{"a", "db.b", "c"}, HasWhere: true, Rows: 20}},
+		{"select extract(year from d) from t as x, u",
+			SQLStatement{Type: "SELECT", Tables: []string{"t", "u"}, Rows: -1}},
+		{"delete from t", SQLStatement{Type: "DELETE", Tables: []string{"t"}, Rows: -1}},
+		{"DELETE FROM t WHERE 1=1 LIMIT 5", SQLStatement{Type: "DELETE", Tables: []string{"t"}, Rows: 5}},
+		{"update low_priority t1, t2 set t1.a = t2.a where t1.id = t2.id",
+			SQLStatement{Type: "UPDATE", Tables: []string{"t1", "t2"}, HasWhere: true, Rows: -1}},
+		{"update t set a = (select max(b) from u where u.id = 1)",
+			SQLStatement{Type: "UPDATE", Tables: []string{"t", "u"}, Rows: -1}},
+		{"with x as (select id from a) delete from b where id in (select id from x)",
+			SQLStatement{Type: "DELETE", Tables: []string{"a", "b", "x"}, HasWhere: true, Rows: -1}},
+		{"insert into t (a, b) values (1, 2), (3, 4) on duplicate key update a = 1",
+			SQLStatement{Type: "INSERT", Tables: []string{"t"}, Rows: 2}},
+		{"insert ignore t set a = (1)", SQLStatement{Type: "INSERT", Tables: []string{"t"}, Rows: 1}},
+		{"DROP TABLE IF EXISTS a, `b`", SQLStatement{Type: "DROP", Object: "TABLE", Tables: []string{"a", "b"}, Rows: -1}},
+		{"drop database prod", SQLStatement{Type: "DROP", Object: "DATABASE", Rows: -1}},
+		{"truncate logs", SQLStatement{Type: "TRUNCATE", Object: "TABLE", Tables: []string{"logs"}, Rows: -1}},
+		{"create unique index i on t (a)", SQLStatement{Type: "CREATE", Object: "INDEX", Tables: []string{"t"}, Rows: -1}},
+		{"/*!50000 DROP TABLE t */", SQLStatement{Type: "DROP", Object: "TABLE", Tables: []string{"t"}, Rows: -1}},
+		{"(select a from t) union (select a from u)", SQLStatement{Type: "SELECT", Tables: []string{"t", "u"}, Rows: -1}},
+		{"\\! rm -rf /", SQLStatement{Type: "SYSTEM", Rows: -1}},
+		{"source /tmp/a.sql", SQLStatement{Type: "SOURCE", Rows: -1}},
+	}
+	for _, tt := range tests {
+		got := analyzeSQL(tt.text)
+		tt.expect.Text = tt.text
+		if !reflect.DeepEqual(*got, tt.expect) {
+			t.Errorf("%q: expect %+v, got %+v", tt.text, tt.expect, *got)
+		}
+	}
+}
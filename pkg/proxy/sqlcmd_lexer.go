@@ -0,0 +1,355 @@
+package proxy
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+	sqlcmd(SQL Server) 的语句切分:
+	1. 输入按批处理缓存, 单独一行的 GO [count] 结束批处理, :reset 或 reset 清空当前批处理
+	2. 行首的 sqlcmd 命令(:r :connect :setvar :out !! ed exit 等)作为单独的语句返回, 当前批处理不受影响
+	3. 批处理中的语句可以没有结束符, 按顶层的 ; 和行首的语句关键字(INSERT、DELETE、DROP、EXEC 等)拆分后分析;
+	   CREATE/ALTER PROCEDURE、FUNCTION、TRIGGER、VIEW 必须是批处理的第一条语句, 整个批处理作为一条语句
+	4. 字符串中的反斜杠不转义, [name] 为标识符, -- 之后不需要空白字符, 块注释可以嵌套
+*/
+
+// sqlcmdGoRegexp 结束批处理的 GO, 可以带执行次数和注释
+var sqlcmdGoRegexp = regexp.MustCompile(`(?i)^go(\s+\d+)?\s*(--.*)?$`)
+
+// sqlcmd 命令对应的语句类型, 与 mysql 客户端的长命令保持一致, 方便使用相同的规则
+var sqlcmdCommands = map[string]string{
+	"!!": "SYSTEM", "r": "SOURCE", "connect": "CONNECT", "out": "TEE", "error": "TEE", "perftrace": "TEE",
+	"ed": "EDIT", "exit": "EXIT", "quit": "QUIT", "setvar": "SETVAR", "listvar": "LISTVAR", "list": "LIST",
+	"help": "HELP", "serverlist": "SERVERLIST", "on": "ON ERROR", "xml": "XML", "reset": "RESET",
+}
+
+// sqlcmd 不需要冒号的命令
+var sqlcmdBareCommands = map[string]bool{
+	"!!": true, "ed": true, "exit": true, "quit": true, "reset": true,
+}
+
+// 批处理中行首出现时开始新语句的关键字; SELECT、SET、WITH 可能属于上一条语句(INSERT ... SELECT、UPDATE ... SET)
+var tsqlStatementKeywords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "DROP": true, "CREATE": true,
+	"ALTER": true, "TRUNCATE": true, "EXEC": true, "EXECUTE": true, "GRANT": true, "REVOKE": true,
+	"DENY": true, "USE": true, "BACKUP": true, "RESTORE": true, "SHUTDOWN": true, "DBCC": true,
+	"KILL": true, "DECLARE": true, "BEGIN": true, "COMMIT": true, "ROLLBACK": true,
+}
+
+// 批处理中只能作为第一条语句的对象
+var tsqlBatchObjects = map[string]bool{
+	"PROCEDURE": true, "PROC": true, "FUNCTION": true, "TRIGGER": true, "VIEW": true,
+}
+
+func newSQLServerStatementBuffer() *sqlStatementBuffer {
+	return &sqlStatementBuffer{dialect: sqlDialectSQLServer, delimiter: "GO"}
+}
+
+func (b *sqlStatementBuffer) splitSQLCmd(text string) ([]string, string) {
+	var (
+		stmts []string
+		batch []string
+		state tsqlScanState
+	)
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if state.normal() {
+			if sqlcmdGoRegexp.MatchString(trimmed) {
+				stmts = append(stmts, splitTSQLBatch(strings.Join(batch, "\n"))...)
+				batch = nil
+				continue
+			}
+			if name, ok := sqlcmdCommandName(trimmed); ok {
+				if name == "reset" {
+					batch = nil
+				} else {
+					stmts = append(stmts, trimmed)
+				}
+				continue
+			}
+		}
+		batch = append(batch, line)
+		state.scan(line)
+	}
+	return stmts, strings.Join(batch, "\n")
+}
+
+// sqlcmdCommandName 行首的 sqlcmd 命令名称, 不是命令时返回 false
+func sqlcmdCommandName(line string) (string, bool) {
+	colon := strings.HasPrefix(line, ":")
+	name := strings.TrimPrefix(line, ":")
+	if strings.HasPrefix(name, "!!") {
+		return "!!", true
+	}
+	end := 0
+	for end < len(name) && ('a' <= name[end]|0x20 && name[end]|0x20 <= 'z') {
+		end++
+	}
+	if end < len(name) && !isSQLSpace(name[end]) && name[end] != '(' {
+		return "", false
+	}
+	name = strings.ToLower(name[:end])
+	if _, ok := sqlcmdCommands[name]; !ok || !colon && !sqlcmdBareCommands[name] {
+		return "", false
+	}
+	return name, true
+}
+
+// tsqlScanState 跨行的字符串、标识符和块注释
+type tsqlScanState struct {
+	quote   byte
+	comment int
+}
+
+func (s *tsqlScanState) normal() bool {
+	return s.quote == 0 && s.comment == 0
+}
+
+func (s *tsqlScanState) scan(line string) {
+	for i := 0; i < len(line); {
+		i = s.step(line, i)
+	}
+}
+
+// step 处理 i 位置的字符, 返回下一个位置, 行注释直接跳到行尾
+func (s *tsqlScanState) step(line string, i int) int {
+	c := line[i]
+	switch {
+	case s.quote != 0:
+		if c == s.quote {
+			if i+1 < len(line) && line[i+1] == s.quote {
+				return i + 2
+			}
+			s.quote = 0
+		}
+	case s.comment > 0:
+		switch {
+		case strings.HasPrefix(line[i:], "/*"):
+			s.comment++
+			return i + 2
+		case strings.HasPrefix(line[i:], "*/"):
+			s.comment--
+			return i + 2
+		}
+	case c == '\'' || c == '"':
+		s.quote = c
+	case c == '[':
+		s.quote = ']'
+	case strings.HasPrefix(line[i:], "--"):
+		return len(line)
+	case strings.HasPrefix(line[i:], "/*"):
+		s.comment++
+		return i + 2
+	}
+	return i + 1
+}
+
+// splitTSQLBatch 拆分批处理中的语句
+func splitTSQLBatch(batch string) []string {
+	var (
+		stmts []string
+		cur   strings.Builder
+		state tsqlScanState
+		first string
+	)
+	flush := func() {
+		if stmt := strings.TrimSpace(cur.String()); stmt != "" && !tsqlOnlyComments(stmt) {
+			stmts = append(stmts, stmt)
+		}
+		cur.Reset()
+		first = ""
+	}
+	if tsqlIsBatchOnly(batch) {
+		flush()
+		cur.WriteString(batch)
+		flush()
+		return stmts
+	}
+	for _, line := range strings.Split(batch, "\n") {
+		if state.normal() {
+			word := strings.ToUpper(tsqlFirstWord(line))
+			// ALTER TABLE t 之后行首的 DROP COLUMN、ALTER COLUMN 属于同一条语句
+			if tsqlStatementKeywords[word] && !(first == "ALTER" && (word == "DROP" || word == "ALTER")) {
+				flush()
+			}
+			if first == "" {
+				first = word
+			}
+		}
+		// 顶层的 ; 结束语句
+		start := 0
+		for i := 0; i < len(line); {
+			if !state.normal() || line[i] != ';' {
+				i = state.step(line, i)
+				continue
+			}
+			cur.WriteString(line[start:i])
+			flush()
+			i++
+			start = i
+		}
+		cur.WriteString(line[start:])
+		cur.WriteByte('\n')
+	}
+	flush()
+	return stmts
+}
+
+// tsqlIsBatchOnly 批处理以 CREATE/ALTER PROCEDURE 等开始
+func tsqlIsBatchOnly(batch string) bool {
+	tokens := tokenizeSQL(batch)
+	if len(tokens) < 2 || !tokens[0].is("CREATE", "ALTER") {
+		return false
+	}
+	i := 1
+	if tokens[i].is("OR") && i+2 < len(tokens) && tokens[i+1].is("ALTER") {
+		i += 2
+	}
+	return tsqlBatchObjects[strings.ToUpper(tokens[i].text)]
+}
+
+func tsqlFirstWord(line string) string {
+	line = strings.TrimSpace(line)
+	end := 0
+	for end < len(line) && isSQLWordByte(line[end]) {
+		end++
+	}
+	return line[:end]
+}
+
+func tsqlOnlyComments(stmt string) bool {
+	return len(tokenizeSQL(normalizeTSQL(stmt))) == 0
+}
+
+// normalizeTSQL 转换为 mysql 的语法后分析: [name] 和 "name" 转换为 `name`, -- 之后补充空白字符
+func normalizeTSQL(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\'':
+			end := i + 1
+			for end < len(text) {
+				if text[end] == c {
+					if end+1 < len(text) && text[end+1] == c {
+						end += 2
+						continue
+					}
+					end++
+					break
+				}
+				end++
+			}
+			// 反斜杠在 T-SQL 中不转义
+			b.WriteString(strings.ReplaceAll(text[i:end], `\`, `\\`))
+			i = end - 1
+		case c == '[' || c == '"':
+			closing := byte(']')
+			if c == '"' {
+				closing = c
+			}
+			end := i + 1
+			var name strings.Builder
+			for end < len(text) {
+				if text[end] == closing {
+					if end+1 < len(text) && text[end+1] == closing {
+						name.WriteByte(closing)
+						end += 2
+						continue
+					}
+					break
+				}
+				name.WriteByte(text[end])
+				end++
+			}
+			b.WriteString("`" + strings.ReplaceAll(name.String(), "`", "``") + "`")
+			i = end
+		case strings.HasPrefix(text[i:], "--"):
+			end := sqlLineEnd(text, i)
+			b.WriteString("-- " + text[i+2:end])
+			i = end - 1
+		case strings.HasPrefix(text[i:], "/*"):
+			depth, end := 1, i+2
+			for end < len(text) && depth > 0 {
+				switch {
+				case strings.HasPrefix(text[end:], "/*"):
+					depth++
+					end += 2
+				case strings.HasPrefix(text[end:], "*/"):
+					depth--
+					end += 2
+				default:
+					end++
+				}
+			}
+			i = end - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// analyzeTSQL sqlcmd 命令按 sqlcmdCommands 分类, 语句补充 PROC 对象和 TOP 的影响行数
+func analyzeTSQL(text string) *SQLStatement {
+	if name, ok := sqlcmdCommandName(text); ok {
+		return &SQLStatement{Text: text, Type: sqlcmdCommands[name], Rows: -1}
+	}
+	normalized := normalizeTSQL(text)
+	stmt := analyzeSQL(normalized)
+	stmt.Text = text
+	tokens := tokenizeSQL(normalized)
+	if len(tokens) == 0 {
+		return stmt
+	}
+	main, _ := sqlMainKeyword(tokens)
+	switch stmt.Type {
+	case "CREATE", "DROP", "ALTER":
+		// CREATE OR ALTER PROC
+		i := sqlSkipWords(tokens, main+1, "OR", "ALTER")
+		if stmt.Object == "" && i < len(tokens) && tokens[i].is("PROC") {
+			stmt.Object = "PROCEDURE"
+		}
+	case "SELECT", "UPDATE", "DELETE", "INSERT":
+		if stmt.Rows < 0 {
+			stmt.Rows = tsqlTopRows(tokens[main+1:])
+		}
+		// DELETE TOP (n) 的 TOP 不是表名
+		if main+1 < len(tokens) && tokens[main+1].is("TOP") {
+			tables := stmt.Tables[:0]
+			for _, table := range stmt.Tables {
+				if !strings.EqualFold(table, "TOP") {
+					tables = append(tables, table)
+				}
+			}
+			stmt.Tables = tables
+		}
+	}
+	return stmt
+}
+
+// tsqlTopRows 解析 TOP n 和 TOP (n), PERCENT 无法估算
+func tsqlTopRows(tokens []sqlToken) int {
+	for i, tok := range tokens {
+		if i > 2 {
+			break
+		}
+		if !tok.is("TOP") {
+			continue
+		}
+		rest := tokens[i+1:]
+		if len(rest) >= 3 && rest[0].text == "(" && rest[2].text == ")" {
+			rest = append([]sqlToken{rest[1]}, rest[3:]...)
+		}
+		if len(rest) == 0 || rest[0].kind != sqlTokenNumber || len(rest) > 1 && rest[1].is("PERCENT") {
+			return -1
+		}
+		if rows, err := strconv.Atoi(rest[0].text); err == nil {
+			return rows
+		}
+		return -1
+	}
+	return -1
+}
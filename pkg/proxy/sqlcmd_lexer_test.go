@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSQLServerStatementBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		expect [][]string
+	}{
+		{"batch", []string{"delete from t", "where id = 1", "go"}, [][]string{nil, nil, {"delete from t\nwhere id = 1"}}},
+		{"statements", []string{"use prod; select 1", "delete from t", "GO 2"},
+			[][]string{nil, nil, {"use prod", "select 1", "delete from t"}}},
+		{"quoted", []string{"select 'a;", "go", "'; select [b;]", "go"},
+			[][]string{nil, nil, nil, {"select 'a;\ngo\n'", "select [b;]"}}},
+		{"comment", []string{"/* go", "*/ drop table t -- go", "go"},
+			[][]string{nil, nil, {"/* go\n*/ drop table t -- go"}}},
+		{"command", []string{"delete from t", "!! rm -rf /", ":r /tmp/a.sql", "go"},
+			[][]string{nil, {"!! rm -rf /"}, {":r /tmp/a.sql"}, {"delete from t"}}},
+		{"reset", []string{"drop table t", ":reset", "select 1", "go"}, [][]string{nil, nil, nil, {"select 1"}}},
+		{"procedure", []string{"create procedure p as", "delete from t", "go"},
+			[][]string{nil, nil, {"create procedure p as\ndelete from t"}}},
+		{"alter table", []string{"alter table t", "drop column c", "go"}, [][]string{nil, nil, {"alter table t\ndrop column c"}}},
+		{"exit", []string{"exit"}, [][]string{{"exit"}}},
+	}
+	for _, tt := range tests {
+		buf := newSQLServerStatementBuffer()
+		for i, line := range tt.lines {
+			var expect []string
+			if i < len(tt.expect) {
+				expect = tt.expect[i]
+			}
+			if got := buf.Append(line); !reflect.DeepEqual(got, expect) {
+				t.Errorf("%s: line %d expect %q, got %q", tt.name, i, expect, got)
+			}
+		}
+	}
+}
+
+func TestAnalyzeTSQL(t *testing.T) {
+	tests := []struct {
+		text   string
+		expect SQLStatement
+	}{
+		{"!! rm -rf /", SQLStatement{Type: "SYSTEM", Rows: -1}},
+		{":connect prod", SQLStatement{Type: "CONNECT", Rows: -1}},
+		{"delete top (10) from [dbo].[users]", SQLStatement{Type: "DELETE", Tables: []string{"dbo.users"}, Rows: 10}},
+		{"update \"t\" set a = 1 where [id]=1", SQLStatement{Type: "UPDATE", Tables: []string{"t"}, HasWhere: true, Rows: -1}},
+		{"drop proc p", SQLStatement{Type: "DROP", Object: "PROCEDURE", Rows: -1}},
+		{"select 'C:\\' from t--x", SQLStatement{Type: "SELECT", Tables: []string{"t"}, Rows: -1}},
+	}
+	for _, tt := range tests {
+		got := newSQLServerStatementBuffer().Analyze(tt.text)
+		tt.expect.Text = tt.text
+		if !reflect.DeepEqual(*got, tt.expect) {
+			t.Errorf("%q: expect %+v, got %+v", tt.text, tt.expect, *got)
+		}
+	}
+}
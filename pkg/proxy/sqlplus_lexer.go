@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"strings"
+)
+
+/*
+	sqlplus(Oracle) 的语句切分:
+	1. SQL 语句在行尾的 ; 或单独一行的 / 结束, ; 不属于语句; 引号和注释中的 ; 不生效
+	2. PL/SQL 块(DECLARE、BEGIN、CREATE PROCEDURE 等)中的 ; 不结束语句, 只在单独一行的 / 结束
+	3. 单独一行的 . 或 SQL 语句中的空行结束输入但不执行, 语句保存在 SQL 缓存区中;
+	   空缓存时的 /、R、RUN 执行 SQL 缓存区中的上一条语句
+	4. 空缓存时行首的 sqlplus 命令(CONNECT、HOST、@ 等, 支持缩写)不需要结束符, 作为单独的语句返回
+	5. 字符串中的反斜杠不转义, 支持 q'[...]' 引用, -- 之后不需要空白字符
+*/
+
+// sqlplus 命令及缩写对应的语句类型, 与 mysql 客户端的长命令保持一致, 方便使用相同的规则
+var sqlplusCommands = map[string]string{
+	"@": "SOURCE", "@@": "SOURCE", "sta": "SOURCE", "start": "SOURCE", "get": "SOURCE",
+	"!": "SYSTEM", "$": "SYSTEM", "ho": "SYSTEM", "host": "SYSTEM",
+	"conn": "CONNECT", "connect": "CONNECT", "disc": "DISCONNECT", "disconnect": "DISCONNECT",
+	"exit": "EXIT", "quit": "QUIT", "spo": "TEE", "spool": "TEE", "sav": "SAVE", "save": "SAVE",
+	"ed": "EDIT", "edit": "EDIT", "desc": "DESCRIBE", "describe": "DESCRIBE",
+	"passw": "PASSWORD", "password": "PASSWORD", "exec": "EXECUTE", "execute": "EXECUTE",
+	"startup": "STARTUP", "shutdown": "SHUTDOWN", "recover": "RECOVER", "archive": "ARCHIVE",
+	"copy": "COPY", "store": "STORE", "set": "SET", "sho": "SHOW", "show": "SHOW",
+	"col": "COLUMN", "column": "COLUMN", "def": "DEFINE", "define": "DEFINE", "undef": "UNDEFINE",
+	"undefine": "UNDEFINE", "var": "VARIABLE", "variable": "VARIABLE", "pri": "PRINT", "print": "PRINT",
+	"pro": "PROMPT", "prompt": "PROMPT", "acc": "ACCEPT", "accept": "ACCEPT", "cl": "CLEAR", "clear": "CLEAR",
+	"l": "LIST", "list": "LIST", "h": "HELP", "help": "HELP", "rem": "REMARK", "remark": "REMARK",
+	"timi": "TIMING", "timing": "TIMING", "whenever": "WHENEVER", "pau": "PAUSE", "pause": "PAUSE",
+}
+
+// SET ROLE、SET TRANSACTION 等是 SQL 语句
+var sqlplusSetStatements = map[string]bool{
+	"ROLE": true, "TRANSACTION": true, "CONSTRAINT": true, "CONSTRAINTS": true,
+}
+
+// PL/SQL 块中 CREATE 的对象
+var plsqlObjects = map[string]bool{
+	"PROCEDURE": true, "FUNCTION": true, "PACKAGE": true, "TRIGGER": true, "TYPE": true,
+	"LIBRARY": true, "JAVA": true,
+}
+
+func newOracleStatementBuffer() *sqlStatementBuffer {
+	return &sqlStatementBuffer{dialect: sqlDialectOracle, delimiter: ";"}
+}
+
+func (b *sqlStatementBuffer) splitSQLPlus(text string) ([]string, string) {
+	var (
+		stmts []string
+		lines []string
+	)
+	store := func() string {
+		stmt := strings.TrimSpace(strings.Join(lines, "\n"))
+		b.last = stmt
+		lines = nil
+		return stmt
+	}
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(lines) == 0 {
+			switch {
+			case trimmed == "", trimmed == ".":
+				continue
+			case trimmed == "/" || strings.EqualFold(trimmed, "r") || strings.EqualFold(trimmed, "run"):
+				if b.last != "" {
+					stmts = append(stmts, b.last)
+				}
+				continue
+			case sqlplusCommandType(trimmed) != "":
+				stmts = append(stmts, trimmed)
+				continue
+			}
+		}
+		block := isPLSQLBlock(strings.Join(lines, "\n") + "\n" + line)
+		switch {
+		case trimmed == "/":
+			stmts = append(stmts, store())
+		case trimmed == ".", trimmed == "" && !block:
+			store()
+		default:
+			lines = append(lines, line)
+			if stmt, ok := sqlplusTerminated(strings.Join(lines, "\n")); ok && !block {
+				lines = []string{stmt}
+				stmts = append(stmts, store())
+			}
+		}
+	}
+	return stmts, strings.Join(lines, "\n")
+}
+
+// sqlplusCommandType sqlplus 命令的语句类型, 不是命令时返回空
+func sqlplusCommandType(line string) string {
+	for _, prefix := range []string{"@@", "@", "!", "$"} {
+		if strings.HasPrefix(line, prefix) {
+			return sqlplusCommands[prefix]
+		}
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	name := strings.ToLower(strings.TrimSuffix(fields[0], ";"))
+	if name == "set" && len(fields) > 1 && sqlplusSetStatements[strings.ToUpper(strings.TrimSuffix(fields[1], ";"))] {
+		return ""
+	}
+	return sqlplusCommands[name]
+}
+
+// isPLSQLBlock 语句是否为 PL/SQL 块
+func isPLSQLBlock(text string) bool {
+	tokens := tokenizeSQL(normalizeSQLPlus(text))
+	if len(tokens) == 0 {
+		return false
+	}
+	if tokens[0].is("DECLARE", "BEGIN") {
+		return true
+	}
+	if !tokens[0].is("CREATE") {
+		return false
+	}
+	i := sqlSkipWords(tokens, 1, "OR", "REPLACE", "EDITIONABLE", "NONEDITIONABLE")
+	return i < len(tokens) && plsqlObjects[strings.ToUpper(tokens[i].text)]
+}
+
+// sqlplusTerminated 语句在行尾的 ; 结束时返回去掉 ; 的语句, 之后有注释时不结束
+func sqlplusTerminated(text string) (string, bool) {
+	// last 最后的非空白字符, code 最后的不在引号和注释中的字符
+	last, code := -1, -1
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\'' || c == '"':
+			end, closed := skipOracleQuoted(text, i)
+			if !closed {
+				return "", false
+			}
+			i = end - 1
+			last = i
+		case strings.HasPrefix(text[i:], "--"):
+			i = sqlLineEnd(text, i) - 1
+			last = i
+		case strings.HasPrefix(text[i:], "/*"):
+			n := strings.Index(text[i+2:], "*/")
+			if n < 0 {
+				return "", false
+			}
+			i += n + 3
+			last = i
+		case !isSQLSpace(c):
+			last, code = i, i
+		}
+	}
+	if code < 0 || code != last || text[code] != ';' {
+		return "", false
+	}
+	return strings.TrimSpace(text[:code]), true
+}
+
+// skipOracleQuoted 返回引号结束之后的位置, 支持连续两个引号和 q'[...]' 引用, 反斜杠不转义
+func skipOracleQuoted(s string, i int) (int, bool) {
+	if begin, closing := oracleQuoteDelimiter(s, i); closing != 0 {
+		n := strings.Index(s[begin:], string([]byte{closing, '\''}))
+		if n < 0 {
+			return len(s), false
+		}
+		return begin + n + 2, true
+	}
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		if s[j] == quote {
+			if j+1 < len(s) && s[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1, true
+		}
+	}
+	return len(s), false
+}
+
+// oracleQuoteDelimiter q'[...]' 引用时返回内容开始的位置和结束的分隔符
+func oracleQuoteDelimiter(s string, i int) (int, byte) {
+	if s[i] != '\'' || i == 0 || s[i-1]|0x20 != 'q' || i+1 >= len(s) {
+		return 0, 0
+	}
+	// q'...' 或 nq'...'
+	if j := i - 2; j >= 0 && isSQLWordByte(s[j]) && !(s[j]|0x20 == 'n' && (j == 0 || !isSQLWordByte(s[j-1]))) {
+		return 0, 0
+	}
+	closing := s[i+1]
+	switch closing {
+	case '[':
+		closing = ']'
+	case '{':
+		closing = '}'
+	case '(':
+		closing = ')'
+	case '<':
+		closing = '>'
+	case ' ', '\t', '\n', '\r':
+		return 0, 0
+	}
+	return i + 2, closing
+}
+
+// normalizeSQLPlus 转换为 mysql 的语法后分析: 反斜杠不转义, q'[...]' 转换为普通字符串, "name" 转换为 `name`,
+// -- 之后补充空白字符
+func normalizeSQLPlus(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\'' || text[i] == '"':
+			end, _ := skipOracleQuoted(text, i)
+			quoted := text[i:end]
+			if begin, closing := oracleQuoteDelimiter(text, i); closing != 0 {
+				body := strings.TrimSuffix(text[begin:end], string([]byte{closing, '\''}))
+				quoted = "'" + strings.ReplaceAll(body, "'", "''") + "'"
+			} else if text[i] == '"' {
+				quoted = "`" + strings.ReplaceAll(strings.Trim(quoted, `"`), "`", "``") + "`"
+			}
+			b.WriteString(strings.ReplaceAll(quoted, `\`, `\\`))
+			i = end - 1
+		case text[i]|0x20 == 'q' && i+1 < len(text) && text[i+1] == '\'':
+			// q'[...]' 的前缀
+			if _, closing := oracleQuoteDelimiter(text, i+1); closing == 0 {
+				b.WriteByte(text[i])
+			}
+		case strings.HasPrefix(text[i:], "--"):
+			end := sqlLineEnd(text, i)
+			b.WriteString("-- " + text[i+2:end])
+			i = end - 1
+		default:
+			b.WriteByte(text[i])
+		}
+	}
+	return b.String()
+}
+
+// analyzeSQLPlus sqlplus 命令按 sqlplusCommands 分类, 其他按 SQL 语句分析
+func analyzeSQLPlus(text string) *SQLStatement {
+	if cmdType := sqlplusCommandType(text); cmdType != "" {
+		return &SQLStatement{Text: text, Type: cmdType, Rows: -1}
+	}
+	stmt := analyzeSQL(normalizeSQLPlus(text))
+	stmt.Text = text
+	return stmt
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOracleStatementBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		expect [][]string
+	}{
+		{"multi line", []string{"delete from t", "where id = 1;"}, [][]string{nil, {"delete from t\nwhere id = 1"}}},
+		{"quoted", []string{"select 'a;", "b', q'[c';]' from dual; -- d", ";"},
+			[][]string{nil, nil, {"select 'a;\nb', q'[c';]' from dual; -- d"}}},
+		{"slash", []string{"drop table t", "/", "/"}, [][]string{nil, {"drop table t"}, {"drop table t"}}},
+		{"plsql", []string{"begin", "delete from t;", "", "end;", "/"},
+			[][]string{nil, nil, nil, nil, {"begin\ndelete from t;\n\nend;"}}},
+		{"store", []string{"truncate table t", ".", "run"}, [][]string{nil, nil, {"truncate table t"}}},
+		{"blank line", []string{"delete from t", "", "select 1 from dual;"},
+			[][]string{nil, nil, {"select 1 from dual"}}},
+		{"command", []string{"host rm -rf /", "@/tmp/a.sql", "set role dba;"},
+			[][]string{{"host rm -rf /"}, {"@/tmp/a.sql"}, {"set role dba"}}},
+	}
+	for _, tt := range tests {
+		buf := newOracleStatementBuffer()
+		for i, line := range tt.lines {
+			var expect []string
+			if i < len(tt.expect) {
+				expect = tt.expect[i]
+			}
+			if got := buf.Append(line); !reflect.DeepEqual(got, expect) {
+				t.Errorf("%s: line %d expect %q, got %q", tt.name, i, expect, got)
+			}
+		}
+	}
+}
+
+func TestAnalyzeSQLPlus(t *testing.T) {
+	tests := []struct {
+		text   string
+		expect SQLStatement
+	}{
+		{"host rm -rf /", SQLStatement{Type: "SYSTEM", Rows: -1}},
+		{"conn sys/x as sysdba", SQLStatement{Type: "CONNECT", Rows: -1}},
+		{"@@a.sql", SQLStatement{Type: "SOURCE", Rows: -1}},
+		{"shutdown immediate", SQLStatement{Type: "SHUTDOWN", Rows: -1}},
+		{"delete \"HR\".\"EMP\"", SQLStatement{Type: "DELETE", Tables: []string{"HR.EMP"}, Rows: -1}},
+		{"update t set a = 'x\\' where id = 1", SQLStatement{Type: "UPDATE", Tables: []string{"t"}, HasWhere: true, Rows: -1}},
+	}
+	for _, tt := range tests {
+		got := newOracleStatementBuffer().Analyze(tt.text)
+		tt.expect.Text = tt.text
+		if !reflect.DeepEqual(*got, tt.expect) {
+			t.Errorf("%q: expect %+v, got %+v", tt.text, tt.expect, *got)
+		}
+	}
+}
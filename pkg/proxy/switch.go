@@ -43,50 +43,66 @@ func (s *SwitchSession) SessionID() string {
 	return s.ID
 }
 
-func (s *SwitchSession) recordCommand(cmdRecordChan chan *ExecutedCommand) {
+func (s *SwitchSession) recordCommand(cmdRecordChan chan *ExecutedCommand, replayRecorder *ReplyRecorder) {
 	// 命令记录
 	cmdRecorder := s.p.GetCommandRecorder()
 	for item := range cmdRecordChan {
 		if item.Command == "" {
 			continue
 		}
-		cmd := s.generateCommandResult(item)
+		// 替换命令参数中的凭证
+		item.Command = RedactCommand(item.Command)
+		replayRecorder.RecordCommand(item.Command, item.CreatedDate)
+		cmd, fullOutput := s.generateCommandResult(item)
+		if fullOutput != "" {
+			cmdRecorder.RecordWithOutput(cmd, fullOutput)
+			continue
+		}
 		cmdRecorder.Record(cmd)
 	}
 	// 关闭命令记录
 	cmdRecorder.End()
 }
 
-// generateCommandResult 生成命令结果
-func (s *SwitchSession) generateCommandResult(item *ExecutedCommand) *model.Command {
+// generateCommandResult 生成命令结果, 需要记录完整输出并且输出被截断时返回完整输出
+func (s *SwitchSession) generateCommandResult(item *ExecutedCommand) (*model.Command, string) {
 	var (
-		input     string
-		output    string
-		riskLevel int64
-		user      string
+		input      string
+		output     string
+		fullOutput string
+		riskLevel  int64
+		user       string
 	)
+	policy := GetOutputCapturePolicy()
 	user = item.User.User
-	if len(item.Command) > 128 {
-		input = item.Command[:128]
-	} else {
-		input = item.Command
+	input = policy.TruncateInput(item.Command)
+	// 最后一行大概率是 ps1
+	fullOutput = item.Output
+	if i := strings.LastIndexByte(item.Output, '\r'); i > 0 {
+		fullOutput = item.Output[:i]
 	}
-	i := strings.LastIndexByte(item.Output, '\r')
-	if i <= 0 {
-		output = item.Output
-	} else if i > 0 && i < 1024 {
-		output = item.Output[:i]
-	} else {
-		output = item.Output[:1024]
+	if masker := GetOutputMasker(); masker != nil {
+		fullOutput = masker.MaskString(fullOutput)
+	}
+	output = policy.TruncateOutput(fullOutput)
+	if !item.FullOutput || len(output) == len(fullOutput) {
+		fullOutput = ""
 	}
 
 	switch item.RiskLevel {
 	case model.HighRiskFlag:
 		riskLevel = model.DangerLevel
+	case model.WarnRiskFlag:
+		riskLevel = model.WarningLevel
 	default:
 		riskLevel = model.NormalLevel
 	}
-	return s.p.GenerateCommandItem(user, input, output, riskLevel, item.CreatedDate)
+	cmd := s.p.GenerateCommandItem(user, input, output, riskLevel, item.CreatedDate)
+	if cmd != nil {
+		cmd.RuleID = item.RuleID
+		cmd.Kubectl = item.Kubectl
+	}
+	return cmd, fullOutput
 }
 
 // Bridge 桥接两个链接
@@ -94,6 +110,12 @@ func (s *SwitchSession) Bridge(userConn UserConnection, srvConn srvconn.ServerCo
 
 	parser := s.p.GetFilterParser()
 	logger.Infof("Conn[%s] create ParseEngine success", userConn.ID())
+	// 在连接内部解析命令的协议, 由 parser 过滤和记录命令
+	if conn, ok := srvConn.(srvconn.InterceptableConnection); ok {
+		if interceptor, ok := parser.(srvconn.CommandInterceptor); ok {
+			conn.SetCommandInterceptor(interceptor)
+		}
+	}
 	//replayRecorder = NewReplyRecord(s.ID)
 	replayRecorder := s.p.GetReplayRecorder()
 	logger.Infof("Conn[%s] create replay success", userConn.ID())
@@ -102,19 +124,23 @@ func (s *SwitchSession) Bridge(userConn UserConnection, srvConn srvconn.ServerCo
 	userInputMessageChan := make(chan *exchange.RoomMessage, 1)
 	// 处理数据流
 	userOutChan, srvOutChan := parser.ParseStream(userInputMessageChan, srvInChan)
+	// 录像和共享会话的输出脱敏
+	outputMasker := newSessionOutputMasker(s.ID)
 
 	defer func() {
 		close(done)
 		_ = userConn.Close()
 		_ = srvConn.Close()
 		parser.Close()
+		replayRecorder.Record(outputMasker.Flush())
+		outputMasker.Close()
 		// 关闭录像
 		replayRecorder.End()
 	}()
 
 	// 记录命令
 	cmdChan := parser.CommandRecordChan()
-	go s.recordCommand(cmdChan)
+	go s.recordCommand(cmdChan, replayRecorder)
 
 	winCh := userConn.WinCh()
 	maxIdleTime := time.Duration(s.MaxIdleTime) * time.Minute
@@ -125,7 +151,7 @@ func (s *SwitchSession) Bridge(userConn UserConnection, srvConn srvconn.ServerCo
 	room := exchange.CreateRoom(s.ID, userInputMessageChan)
 	exchange.Register(room)
 	defer exchange.UnRegister(room)
-	conn := exchange.WrapperUserCon(userConn)
+	conn := exchange.WrapperOwnerCon(userConn)
 	room.Subscribe(conn)
 	defer room.UnSubscribe(conn)
 	exitSignal := make(chan struct{}, 2)
@@ -183,6 +209,13 @@ func (s *SwitchSession) Bridge(userConn UserConnection, srvConn srvconn.ServerCo
 			Body:  []byte(zmodemEndEvent),
 		})
 	})
+	parser.RegisterEventCallback(commandBurstEvent, func() {
+		room.Broadcast(&exchange.RoomMessage{
+			Event: exchange.ActionEvent,
+			Body:  []byte(commandBurstEvent),
+		})
+	})
+	parser.RegisterEventCallback(commandBurstTerminateEvent, s.Terminate)
 	go func() {
 		for {
 			buf := make([]byte, 1024)
@@ -254,6 +287,8 @@ func (s *SwitchSession) Bridge(userConn UserConnection, srvConn srvconn.ServerCo
 			_ = srvConn.SetWinSize(win.Width, win.Height)
 			logger.Infof("Session[%s] Window server change: %d*%d",
 				s.ID, win.Width, win.Height)
+			replayRecorder.RecordResize(win.Width, win.Height)
+			parser.Resize(win.Width, win.Height)
 			p, _ := json.Marshal(win)
 			msg := exchange.RoomMessage{
 				Event: exchange.WindowsEvent,
@@ -265,14 +300,26 @@ func (s *SwitchSession) Bridge(userConn UserConnection, srvConn srvconn.ServerCo
 			if !ok {
 				return
 			}
+			masked := outputMasker.Write(p)
 			if parser.NeedRecord() {
-				replayRecorder.Record(p)
+				replayRecorder.Record(masked)
 			}
 			msg := exchange.RoomMessage{
-				Event: exchange.DataEvent,
-				Body:  p,
+				Event:       exchange.DataEvent,
+				Body:        p,
+				WatcherBody: outputMasker.WatcherBody(masked),
 			}
 			room.Broadcast(&msg)
+			// 脱敏缓存的没有换行的输出
+		case <-outputMasker.FlushC():
+			masked := outputMasker.Flush()
+			if parser.NeedRecord() {
+				replayRecorder.Record(masked)
+			}
+			if body := outputMasker.WatcherBody(masked); len(body) > 0 {
+				room.Broadcast(&exchange.RoomMessage{Event: exchange.DataEvent, WatcherBody: body})
+			}
+			continue
 			// 经过parse处理的user数据，发给server
 		case p, ok := <-userOutChan:
 			if !ok {
@@ -11,6 +11,7 @@ import (
 
 	"github.com/jumpserver/koko/pkg/i18n"
 	"github.com/jumpserver/koko/pkg/logger"
+	"github.com/jumpserver/koko/pkg/srvconn"
 )
 
 const (
@@ -83,6 +84,86 @@ func IsInstalledMysqlClient() bool {
 	return false
 }
 
+func IsInstalledPostgreSQLClient() bool {
+	checkLine := "psql -V"
+	cmd := exec.Command("bash", "-c", checkLine)
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) == 0 {
+		logger.Errorf("Check psql client installed failed: %s", err)
+		return false
+	}
+	// psql (PostgreSQL) 13.3
+	if bytes.HasPrefix(out, []byte("psql")) {
+		return true
+	}
+	logger.Errorf("Check psql client installed failed: %s", out)
+	return false
+}
+
+func IsInstalledMongoDBClient() bool {
+	checkLine := "mongosh --version"
+	cmd := exec.Command("bash", "-c", checkLine)
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) == 0 {
+		logger.Errorf("Check mongosh client installed failed: %s", err)
+		return false
+	}
+	// mongosh --version 只输出版本号, 如 1.6.0
+	if len(out) > 0 && out[0] >= '0' && out[0] <= '9' {
+		return true
+	}
+	logger.Errorf("Check mongosh client installed failed: %s", out)
+	return false
+}
+
+func IsInstalledSQLServerClient() bool {
+	checkLine := "sqlcmd -?"
+	cmd := exec.Command("bash", "-c", checkLine)
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) == 0 {
+		logger.Errorf("Check sqlcmd client installed failed: %s", err)
+		return false
+	}
+	// Microsoft (R) SQL Server Command Line Tool ... usage: sqlcmd [-U login id] ...
+	if bytes.Contains(out, []byte("sqlcmd")) {
+		return true
+	}
+	logger.Errorf("Check sqlcmd client installed failed: %s", out)
+	return false
+}
+
+func IsInstalledOracleClient() bool {
+	checkLine := "sqlplus -V"
+	cmd := exec.Command("bash", "-c", checkLine)
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) == 0 {
+		logger.Errorf("Check sqlplus client installed failed: %s", err)
+		return false
+	}
+	// SQL*Plus: Release 21.0.0.0.0 - Production
+	if bytes.Contains(out, []byte("SQL*Plus")) {
+		return true
+	}
+	logger.Errorf("Check sqlplus client installed failed: %s", out)
+	return false
+}
+
+// isInstalledDatabaseClient 检查数据库协议使用的客户端是否安装
+func isInstalledDatabaseClient(protocol string) bool {
+	switch protocol {
+	case srvconn.ProtocolPostgreSQL:
+		return IsInstalledPostgreSQLClient()
+	case srvconn.ProtocolMongoDB:
+		return IsInstalledMongoDBClient()
+	case srvconn.ProtocolSQLServer:
+		return IsInstalledSQLServerClient()
+	case srvconn.ProtocolOracle:
+		return IsInstalledOracleClient()
+	default:
+		return IsInstalledMysqlClient()
+	}
+}
+
 func ReplaceURLHostAndPort(originUrl *url.URL, ip string, port int) string {
 	newHost := net.JoinHostPort(ip, strconv.Itoa(port))
 	switch originUrl.Scheme {
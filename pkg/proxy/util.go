@@ -3,8 +3,10 @@ package proxy
 import (
 	"strings"
 
+	"github.com/jumpserver/koko/pkg/auditlog"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/service"
+	"github.com/jumpserver/koko/pkg/logger"
 	storage "github.com/jumpserver/koko/pkg/proxy/recorderstorage"
 )
 
@@ -22,6 +24,18 @@ type CommandStorage interface {
 	StorageType
 }
 
+// 对象存储可以按 target 上传任意对象; server 存储按会话上传, 会覆盖同一个会话的录像
+var objectStorageTypes = map[string]bool{
+	"s3":    true,
+	"oss":   true,
+	"azure": true,
+	"obs":   true,
+}
+
+func IsObjectStorage(storage StorageType) bool {
+	return objectStorageTypes[storage.TypeName()]
+}
+
 func NewReplayStorage(jmsService *service.JMService, conf *model.TerminalConfig) ReplayStorage {
 	cf := conf.ReplayStorage
 	tp, ok := cf["TYPE"]
@@ -188,6 +202,61 @@ func NewCommandStorage(jmsService *service.JMService, conf *model.TerminalConfig
 			DocType:            docType,
 			InsecureSkipVerify: skipVerify,
 		}
+	/*
+		{
+		  'HOSTS': ['172.16.10.122:9092'],
+		  'TOPIC': 'jumpserver_command',
+		  'OTHER': {'SASL_MECHANISM': 'SCRAM-SHA-512', 'SASL_USERNAME': 'koko', 'SASL_PASSWORD': 'xxx',
+		            'USE_TLS': True, 'IGNORE_VERIFY_CERTS': False},
+		  'TYPE': 'kafka'
+		}
+	*/
+	case "kafka":
+		var kafkaConf storage.KafkaConfig
+		if hosts, ok := cf["HOSTS"].([]interface{}); ok {
+			for i := range hosts {
+				kafkaConf.Hosts = append(kafkaConf.Hosts, hosts[i].(string))
+			}
+		}
+		kafkaConf.Topic, _ = cf["TOPIC"].(string)
+		if otherMap, ok := cf["OTHER"].(map[string]interface{}); ok {
+			kafkaConf.SASLMechanism, _ = otherMap["SASL_MECHANISM"].(string)
+			kafkaConf.SASLUsername, _ = otherMap["SASL_USERNAME"].(string)
+			kafkaConf.SASLPassword, _ = otherMap["SASL_PASSWORD"].(string)
+			kafkaConf.UseTLS, _ = otherMap["USE_TLS"].(bool)
+			kafkaConf.InsecureSkipVerify, _ = otherMap["IGNORE_VERIFY_CERTS"].(bool)
+		}
+		kafkaStorage, err := storage.NewKafkaCommandStorage(kafkaConf)
+		if err != nil {
+			logger.Errorf("Create kafka command storage err: %s, use server storage", err)
+			return storage.ServerStorage{StorageType: "server", JmsService: jmsService}
+		}
+		return kafkaStorage
+	/*
+		{
+		  'HOST': '172.16.10.122:6514',
+		  'PROTOCOL': 'tls',
+		  'FORMAT': 'cef',
+		  'OTHER': {'FACILITY': 'local0', 'SD_ID': 'jumpserver@32473', 'IGNORE_VERIFY_CERTS': False},
+		  'TYPE': 'syslog'
+		}
+	*/
+	case "syslog":
+		var syslogConf auditlog.Config
+		syslogConf.Addr, _ = cf["HOST"].(string)
+		syslogConf.Protocol, _ = cf["PROTOCOL"].(string)
+		syslogConf.Format, _ = cf["FORMAT"].(string)
+		if otherMap, ok := cf["OTHER"].(map[string]interface{}); ok {
+			syslogConf.Facility, _ = otherMap["FACILITY"].(string)
+			syslogConf.SDID, _ = otherMap["SD_ID"].(string)
+			syslogConf.InsecureSkipVerify, _ = otherMap["IGNORE_VERIFY_CERTS"].(bool)
+		}
+		writer, err := auditlog.GetWriter(syslogConf)
+		if err != nil {
+			logger.Errorf("Create syslog command storage err: %s, use server storage", err)
+			return storage.ServerStorage{StorageType: "server", JmsService: jmsService}
+		}
+		return storage.SyslogCommandStorage{Writer: writer}
 	case "null":
 		return storage.NewNullStorage()
 	default:
@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+/*
+	vtCommandTracker 使用 VTScreen 维护会话的终端屏幕, 从屏幕内容中获取命令和输出:
+	1. 用户开始输入新命令时记录光标位置, 光标之前的内容为提示符
+	2. 按下 Enter 时读取提示符之后到命令结尾的内容作为命令, Tab 补全、历史命令、光标编辑都以屏幕为准
+	3. 命令之后到下一个提示符之前的行为命令输出, 滚出屏幕的行通过 OnScroll 保存
+	行号使用绝对行号(屏幕行号 + 已滚出屏幕的行数), 命令或输出较长导致滚屏时仍然可以定位。
+*/
+
+// readline 的历史搜索会替换整行的内容, 包括提示符
+var readlineSearchPattern = regexp.MustCompile("^\\((?:reverse-i-search|i-search|failed reverse-i-search|failed i-search)\\)`[^']*': ")
+
+type vtCommandTracker struct {
+	lock   sync.Mutex
+	screen *VTScreen
+
+	scrolled int
+
+	// scrollback 为 keepFrom 行开始滚出屏幕的内容
+	scrollback     []string
+	scrollbackSize int
+	keepFrom       int
+
+	// maxSize 命令输出的最大长度
+	maxSize int
+
+	prompt    string
+	promptX   int
+	promptRow int
+	hasPrompt bool
+
+	outputRow int
+	hasOutput bool
+}
+
+func newVTCommandTracker(width, height int) *vtCommandTracker {
+	t := vtCommandTracker{
+		screen:   NewVTScreen(width, height),
+		keepFrom: -1,
+		maxSize:  cmdParserBufMaxSize,
+	}
+	t.screen.OnScroll = t.onScroll
+	return &t
+}
+
+// Write 写入服务器的输出
+func (t *vtCommandTracker) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.screen.Write(p)
+}
+
+func (t *vtCommandTracker) Resize(width, height int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.screen.Resize(width, height)
+}
+
+// AltScreen 是否处于全屏程序(vim、top 等)的备用屏幕
+func (t *vtCommandTracker) AltScreen() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.screen.AltScreen()
+}
+
+// SetMaxSize 需要记录完整输出时调整输出的最大长度
+func (t *vtCommandTracker) SetMaxSize(size int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if size <= 0 {
+		size = cmdParserBufMaxSize
+	}
+	t.maxSize = size
+}
+
+func (t *vtCommandTracker) onScroll(line string) {
+	row := t.scrolled
+	t.scrolled++
+	if t.keepFrom < 0 || row < t.keepFrom || t.scrollbackSize >= t.maxSize {
+		return
+	}
+	t.scrollback = append(t.scrollback, line)
+	t.scrollbackSize += len(line)
+}
+
+func (t *vtCommandTracker) keepScrollback(row int) {
+	t.keepFrom = row
+	t.scrollback = nil
+	t.scrollbackSize = 0
+}
+
+func (t *vtCommandTracker) cursorRow() int {
+	_, y := t.screen.Cursor()
+	return t.scrolled + y
+}
+
+// row 返回绝对行号对应的内容, 已滚出屏幕且没有保存时返回 false
+func (t *vtCommandTracker) row(row int) (string, bool) {
+	if row >= t.scrolled {
+		_, height := t.screen.Size()
+		if row-t.scrolled >= height {
+			return "", false
+		}
+		return t.screen.Line(row - t.scrolled), true
+	}
+	index := row - t.keepFrom
+	if t.keepFrom < 0 || index < 0 || index >= len(t.scrollback) {
+		return "", false
+	}
+	return t.scrollback[index], true
+}
+
+// MarkPrompt 用户开始输入新命令时调用
+func (t *vtCommandTracker) MarkPrompt() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.screen.AltScreen() {
+		return
+	}
+	x, _ := t.screen.Cursor()
+	t.promptX = x
+	t.promptRow = t.cursorRow()
+	line, _ := t.row(t.promptRow)
+	t.prompt = vtCutColumns(vtPadColumns(line, x), 0, x)
+	t.hasPrompt = true
+	t.keepScrollback(t.promptRow)
+}
+
+// Command 用户按下 Enter 时调用, 返回屏幕上提示符之后的命令, 并记录输出开始的行
+func (t *vtCommandTracker) Command() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.hasPrompt || t.screen.AltScreen() {
+		return ""
+	}
+	t.hasPrompt = false
+	width, _ := t.screen.Size()
+	end := maxInt(t.cursorRow(), t.promptRow)
+	if strings.TrimSpace(t.prompt) != "" {
+		// Ctrl+C 等中断输入后出现了新的提示符, 从最后一个提示符开始
+		for row := end; row > t.promptRow; row-- {
+			if line, _ := t.row(row); strings.HasPrefix(line, t.prompt) {
+				t.promptRow = row
+				break
+			}
+		}
+	}
+	// 光标不在命令结尾时, 之后自动换行的行也属于命令
+	for {
+		line, ok := t.row(end)
+		if !ok || vtStringWidth(line) < width {
+			break
+		}
+		next, ok := t.row(end + 1)
+		if !ok || next == "" {
+			break
+		}
+		end++
+	}
+	var b strings.Builder
+	for row := t.promptRow; row <= end; row++ {
+		line, _ := t.row(row)
+		if row < end {
+			line = vtPadColumns(line, width)
+		}
+		b.WriteString(line)
+	}
+	t.outputRow = end + 1
+	t.hasOutput = true
+	t.keepScrollback(t.outputRow)
+
+	text := b.String()
+	switch {
+	case t.prompt != "" && strings.HasPrefix(text, t.prompt):
+		text = text[len(t.prompt):]
+	case readlineSearchPattern.MatchString(text):
+		text = text[len(readlineSearchPattern.FindString(text)):]
+	default:
+		text = vtCutColumns(text, t.promptX, -1)
+	}
+	return strings.TrimSpace(text)
+}
+
+// Output 返回命令的输出, 到光标所在行之前为止, 光标所在行为新的提示符
+func (t *vtCommandTracker) Output() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.hasOutput {
+		return ""
+	}
+	t.hasOutput = false
+	var (
+		lines []string
+		size  int
+	)
+	end := t.cursorRow()
+	if t.screen.AltScreen() {
+		// 全屏程序中只有滚出主屏幕的输出
+		end = t.scrolled
+	}
+	for row := t.outputRow; row < end && size < t.maxSize; row++ {
+		line, ok := t.row(row)
+		if !ok {
+			continue
+		}
+		lines = append(lines, line)
+		size += len(line) + 2
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return truncateString(strings.Join(lines, "\r\n"), t.maxSize)
+}
+
+func vtStringWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += vtRuneWidth(r)
+	}
+	return width
+}
+
+// vtPadColumns 行尾补充空格到 width 列
+func vtPadColumns(s string, width int) string {
+	if n := width - vtStringWidth(s); n > 0 {
+		return s + strings.Repeat(" ", n)
+	}
+	return s
+}
+
+// vtCutColumns 返回 [from, to) 列的内容, to 为 -1 时到结尾
+func vtCutColumns(s string, from, to int) string {
+	var (
+		b   strings.Builder
+		col int
+	)
+	for _, r := range s {
+		if to >= 0 && col >= to {
+			break
+		}
+		if col >= from {
+			b.WriteRune(r)
+		}
+		col += vtRuneWidth(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestVTCommandTracker_Command(t *testing.T) {
+	tests := []struct {
+		name   string
+		echo   []string
+		expect string
+	}{
+		{"plain", []string{"ls -l"}, "ls -l"},
+		{"tab completion", []string{"cat /et", "c/"}, "cat /etc/"},
+		{"history", []string{"\r\x1b[K$ ls -la"}, "ls -la"},
+		{"cursor edit", []string{"lx -la", "\x08\x08\x08\x08\x08", "s -la\x08\x08\x08\x08"}, "ls -la"},
+		{"reverse search", []string{"\r\x1b[K(reverse-i-search)`ls': ls -la"}, "ls -la"},
+		{"wide chars", []string{"echo 你好"}, "echo 你好"},
+	}
+	for _, tt := range tests {
+		tracker := newVTCommandTracker(80, 24)
+		_, _ = tracker.Write([]byte("Last login: today\r\n$ "))
+		tracker.MarkPrompt()
+		for _, item := range tt.echo {
+			_, _ = tracker.Write([]byte(item))
+		}
+		if got := tracker.Command(); got != tt.expect {
+			t.Errorf("%s: expect %q, got %q", tt.name, tt.expect, got)
+		}
+	}
+}
+
+func TestVTCommandTracker_Wrap(t *testing.T) {
+	tracker := newVTCommandTracker(10, 3)
+	_, _ = tracker.Write([]byte("$ "))
+	tracker.MarkPrompt()
+	// 命令自动换行, 并导致屏幕滚动
+	_, _ = tracker.Write([]byte("echo 0123 456789abcdefghij"))
+	if got := tracker.Command(); got != "echo 0123 456789abcdefghij" {
+		t.Fatalf("unexpected command %q", got)
+	}
+	// Ctrl+C 之后重新输入
+	_, _ = tracker.Write([]byte("\r\n$ "))
+	tracker.MarkPrompt()
+	_, _ = tracker.Write([]byte("sleep^C\r\n$ pwd"))
+	if got := tracker.Command(); got != "pwd" {
+		t.Fatalf("expect pwd, got %q", got)
+	}
+}
+
+func TestVTCommandTracker_Output(t *testing.T) {
+	tracker := newVTCommandTracker(80, 5)
+	_, _ = tracker.Write([]byte("$ "))
+	tracker.MarkPrompt()
+	_, _ = tracker.Write([]byte("seq 20"))
+	if got := tracker.Command(); got != "seq 20" {
+		t.Fatalf("unexpected command %q", got)
+	}
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprint(i))
+	}
+	_, _ = tracker.Write([]byte("\r\n" + strings.Join(lines, "\r\n") + "\r\n$ "))
+	if got := tracker.Output(); got != strings.Join(lines, "\r\n") {
+		t.Fatalf("unexpected output %q", got)
+	}
+
+	tracker.MarkPrompt()
+	_, _ = tracker.Write([]byte("seq 20"))
+	tracker.Command()
+	tracker.SetMaxSize(10)
+	_, _ = tracker.Write([]byte("\r\n" + strings.Join(lines, "\r\n") + "\r\n$ "))
+	if got := tracker.Output(); got != "1\r\n2\r\n3\r\n4" {
+		t.Fatalf("unexpected truncated output %q", got)
+	}
+}
+
+func TestVTCommandTracker_AltScreen(t *testing.T) {
+	tracker := newVTCommandTracker(80, 24)
+	_, _ = tracker.Write([]byte("$ "))
+	tracker.MarkPrompt()
+	_, _ = tracker.Write([]byte("vim a.txt"))
+	if got := tracker.Command(); got != "vim a.txt" {
+		t.Fatalf("unexpected command %q", got)
+	}
+	_, _ = tracker.Write([]byte("\r\n\x1b[?1049h\x1b[H\x1b[2J~\r\n~\r\n\"a.txt\" [New]"))
+	if !tracker.AltScreen() {
+		t.Fatal("expect alt screen")
+	}
+	tracker.MarkPrompt()
+	if got := tracker.Command(); got != "" {
+		t.Fatalf("expect no command in alt screen, got %q", got)
+	}
+	_, _ = tracker.Write([]byte("\x1b[?1049l$ "))
+	if tracker.AltScreen() {
+		t.Fatal("expect main screen")
+	}
+	if got := tracker.Output(); got != "" {
+		t.Fatalf("expect empty output, got %q", got)
+	}
+}
@@ -0,0 +1,695 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+	VTScreen 简单的 VT100/xterm 终端模拟器, 只保存屏幕上的字符, 不处理颜色等属性。
+	支持光标移动、擦除、插入删除、滚动区域和备用屏幕(vim、top 等全屏程序使用)。
+*/
+
+const (
+	defaultVTWidth  = 80
+	defaultVTHeight = 24
+
+	// 防止异常的窗口大小占用过多内存
+	maxVTWidth  = 1024
+	maxVTHeight = 512
+
+	vtTabStop = 8
+)
+
+// vtWideCellPlaceholder 宽字符占用的第二个单元格
+const vtWideCellPlaceholder rune = -1
+
+const (
+	vtStateGround = iota
+	vtStateEscape
+	vtStateEscapeSkip
+	vtStateCSI
+	vtStateOSC
+	vtStateString
+	vtStateStringEscape
+)
+
+type vtCursor struct {
+	x, y     int
+	wrapNext bool
+}
+
+type vtBuffer struct {
+	lines [][]rune
+	vtCursor
+	saved vtCursor
+}
+
+func newVTBuffer(width, height int) *vtBuffer {
+	b := vtBuffer{lines: make([][]rune, height)}
+	for i := range b.lines {
+		b.lines[i] = newVTLine(width)
+	}
+	return &b
+}
+
+func newVTLine(width int) []rune {
+	line := make([]rune, width)
+	for i := range line {
+		line[i] = ' '
+	}
+	return line
+}
+
+type VTScreen struct {
+	width  int
+	height int
+
+	main *vtBuffer
+	alt  *vtBuffer
+	buf  *vtBuffer
+
+	scrollTop    int
+	scrollBottom int
+	autoWrap     bool
+
+	// 解析状态
+	state   int
+	params  []byte
+	private byte
+	utf8Buf []byte
+
+	// OnScroll 行滚出屏幕顶部时回调, 只在主屏幕触发
+	OnScroll func(line string)
+}
+
+func NewVTScreen(width, height int) *VTScreen {
+	width, height = normalizeVTSize(width, height)
+	s := VTScreen{
+		width:    width,
+		height:   height,
+		main:     newVTBuffer(width, height),
+		autoWrap: true,
+	}
+	s.buf = s.main
+	s.scrollBottom = height - 1
+	return &s
+}
+
+func normalizeVTSize(width, height int) (int, int) {
+	if width <= 0 {
+		width = defaultVTWidth
+	}
+	if height <= 0 {
+		height = defaultVTHeight
+	}
+	if width > maxVTWidth {
+		width = maxVTWidth
+	}
+	if height > maxVTHeight {
+		height = maxVTHeight
+	}
+	return width, height
+}
+
+func (s *VTScreen) Size() (int, int) {
+	return s.width, s.height
+}
+
+// Cursor 光标位置, 从 0 开始
+func (s *VTScreen) Cursor() (int, int) {
+	return s.buf.x, s.buf.y
+}
+
+// AltScreen 是否处于备用屏幕
+func (s *VTScreen) AltScreen() bool {
+	return s.buf == s.alt
+}
+
+// Line 返回第 y 行的内容, 去掉行尾空白
+func (s *VTScreen) Line(y int) string {
+	if y < 0 || y >= s.height {
+		return ""
+	}
+	return lineString(s.buf.lines[y])
+}
+
+// Lines 返回屏幕所有行, 去掉末尾的空行
+func (s *VTScreen) Lines() []string {
+	lines := make([]string, s.height)
+	last := -1
+	for i := range s.buf.lines {
+		if lines[i] = lineString(s.buf.lines[i]); lines[i] != "" {
+			last = i
+		}
+	}
+	return lines[:last+1]
+}
+
+func lineString(line []rune) string {
+	var b strings.Builder
+	for _, r := range line {
+		if r != vtWideCellPlaceholder {
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// VTSnapshot 屏幕快照
+type VTSnapshot struct {
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+	CursorX   int      `json:"cursor_x"`
+	CursorY   int      `json:"cursor_y"`
+	AltScreen bool     `json:"alt_screen,omitempty"`
+	Lines     []string `json:"lines"`
+}
+
+func (s *VTScreen) Snapshot() *VTSnapshot {
+	return &VTSnapshot{
+		Width:     s.width,
+		Height:    s.height,
+		CursorX:   s.buf.x,
+		CursorY:   s.buf.y,
+		AltScreen: s.AltScreen(),
+		Lines:     s.Lines(),
+	}
+}
+
+// Resize 改变窗口大小, 高度变小时保证光标所在行仍然可见
+func (s *VTScreen) Resize(width, height int) {
+	width, height = normalizeVTSize(width, height)
+	if width == s.width && height == s.height {
+		return
+	}
+	for _, b := range []*vtBuffer{s.main, s.alt} {
+		if b == nil {
+			continue
+		}
+		if shift := b.y - height + 1; shift > 0 {
+			if b == s.main && s.OnScroll != nil {
+				for i := 0; i < shift; i++ {
+					s.OnScroll(lineString(b.lines[i]))
+				}
+			}
+			b.lines = b.lines[shift:]
+			b.y -= shift
+		}
+		for len(b.lines) < height {
+			b.lines = append(b.lines, newVTLine(width))
+		}
+		b.lines = b.lines[:height]
+		for i, line := range b.lines {
+			if len(line) > width {
+				b.lines[i] = line[:width]
+				continue
+			}
+			for len(b.lines[i]) < width {
+				b.lines[i] = append(b.lines[i], ' ')
+			}
+		}
+		b.x = minInt(b.x, width-1)
+		b.y = minInt(b.y, height-1)
+		b.wrapNext = false
+	}
+	s.width, s.height = width, height
+	s.scrollTop, s.scrollBottom = 0, height-1
+}
+
+func (s *VTScreen) Write(p []byte) (int, error) {
+	for _, c := range p {
+		s.feed(c)
+	}
+	return len(p), nil
+}
+
+func (s *VTScreen) feed(c byte) {
+	switch s.state {
+	case vtStateEscape:
+		s.escape(c)
+		return
+	case vtStateEscapeSkip:
+		// 字符集选择等, 忽略后一个字符
+		s.state = vtStateGround
+		return
+	case vtStateCSI:
+		s.csi(c)
+		return
+	case vtStateOSC, vtStateString:
+		switch c {
+		case 0x07:
+			s.state = vtStateGround
+		case 0x1b:
+			s.state = vtStateStringEscape
+		}
+		return
+	case vtStateStringEscape:
+		// ESC \ 结束字符串
+		s.state = vtStateGround
+		if c != '\\' {
+			s.escape(c)
+		}
+		return
+	}
+	if c < 0x20 || c == 0x7f {
+		s.utf8Buf = s.utf8Buf[:0]
+		s.control(c)
+		return
+	}
+	if c < 0x80 && len(s.utf8Buf) == 0 {
+		s.print(rune(c))
+		return
+	}
+	s.utf8Buf = append(s.utf8Buf, c)
+	if !utf8.FullRune(s.utf8Buf) {
+		return
+	}
+	r, _ := utf8.DecodeRune(s.utf8Buf)
+	s.utf8Buf = s.utf8Buf[:0]
+	s.print(r)
+}
+
+func (s *VTScreen) control(c byte) {
+	b := s.buf
+	switch c {
+	case '\r':
+		b.x = 0
+		b.wrapNext = false
+	case '\n', '\v', '\f':
+		s.lineFeed()
+	case '\b':
+		if b.x > 0 {
+			b.x--
+		}
+		b.wrapNext = false
+	case '\t':
+		b.x = minInt((b.x/vtTabStop+1)*vtTabStop, s.width-1)
+	case 0x1b:
+		s.state = vtStateEscape
+	}
+}
+
+func (s *VTScreen) escape(c byte) {
+	s.state = vtStateGround
+	b := s.buf
+	switch c {
+	case '[':
+		s.state = vtStateCSI
+		s.params = s.params[:0]
+		s.private = 0
+	case ']':
+		s.state = vtStateOSC
+	case 'P', 'X', '^', '_':
+		s.state = vtStateString
+	case '(', ')', '*', '+', '#', '%':
+		s.state = vtStateEscapeSkip
+	case '7':
+		b.saved = b.vtCursor
+	case '8':
+		b.vtCursor = b.saved
+		s.clampCursor()
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		b.x = 0
+		s.lineFeed()
+	case 'M':
+		s.reverseIndex()
+	case 'c':
+		s.reset()
+	}
+}
+
+func (s *VTScreen) reset() {
+	s.main = newVTBuffer(s.width, s.height)
+	s.alt = nil
+	s.buf = s.main
+	s.scrollTop, s.scrollBottom = 0, s.height-1
+	s.autoWrap = true
+}
+
+func (s *VTScreen) csi(c byte) {
+	switch {
+	case c >= '0' && c <= '9', c == ';', c == ':':
+		s.params = append(s.params, c)
+		return
+	case c == '?' || c == '>' || c == '<' || c == '=':
+		s.private = c
+		return
+	case c >= 0x20 && c <= 0x2f:
+		// 中间字符, 目前支持的序列都不需要
+		return
+	case c < 0x20:
+		// 控制字符在序列中直接执行, ESC 会中断当前序列
+		s.control(c)
+		return
+	}
+	s.state = vtStateGround
+	params := parseVTParams(s.params)
+	if s.private != 0 {
+		if s.private == '?' && (c == 'h' || c == 'l') {
+			for _, mode := range params {
+				s.setPrivateMode(mode, c == 'h')
+			}
+		}
+		return
+	}
+	b := s.buf
+	n := vtParam(params, 0, 1)
+	switch c {
+	case 'A':
+		b.y = maxInt(b.y-n, s.topLimit())
+	case 'B', 'e':
+		b.y = minInt(b.y+n, s.bottomLimit())
+	case 'C', 'a':
+		b.x = minInt(b.x+n, s.width-1)
+	case 'D':
+		b.x = maxInt(b.x-n, 0)
+	case 'E':
+		b.x = 0
+		b.y = minInt(b.y+n, s.bottomLimit())
+	case 'F':
+		b.x = 0
+		b.y = maxInt(b.y-n, s.topLimit())
+	case 'G', '`':
+		b.x = n - 1
+	case 'd':
+		b.y = n - 1
+	case 'H', 'f':
+		b.y = vtParam(params, 0, 1) - 1
+		b.x = vtParam(params, 1, 1) - 1
+	case 'J':
+		s.eraseDisplay(vtParam(params, 0, 0))
+	case 'K':
+		s.eraseLine(vtParam(params, 0, 0))
+	case '@':
+		s.insertChars(n)
+	case 'P':
+		s.deleteChars(n)
+	case 'X':
+		s.eraseChars(n)
+	case 'L':
+		s.insertLines(n)
+	case 'M':
+		s.deleteLines(n)
+	case 'S':
+		s.scrollUp(s.scrollTop, s.scrollBottom, n)
+	case 'T':
+		s.scrollDown(s.scrollTop, s.scrollBottom, n)
+	case 'r':
+		top := vtParam(params, 0, 1) - 1
+		bottom := vtParam(params, 1, s.height) - 1
+		if bottom >= s.height {
+			bottom = s.height - 1
+		}
+		if top < bottom {
+			s.scrollTop, s.scrollBottom = top, bottom
+			b.x, b.y = 0, 0
+		}
+	case 's':
+		b.saved = b.vtCursor
+	case 'u':
+		b.vtCursor = b.saved
+	}
+	b.wrapNext = false
+	s.clampCursor()
+}
+
+func (s *VTScreen) setPrivateMode(mode int, set bool) {
+	switch mode {
+	case 7:
+		s.autoWrap = set
+	case 47, 1047:
+		s.switchScreen(set)
+	case 1049:
+		if set {
+			s.main.saved = s.main.vtCursor
+			s.switchScreen(true)
+		} else {
+			s.switchScreen(false)
+			s.main.vtCursor = s.main.saved
+			s.clampCursor()
+		}
+	}
+}
+
+// switchScreen 进入备用屏幕时清空备用屏幕
+func (s *VTScreen) switchScreen(alt bool) {
+	if alt {
+		if s.buf == s.alt {
+			return
+		}
+		s.alt = newVTBuffer(s.width, s.height)
+		s.alt.vtCursor = s.main.vtCursor
+		s.buf = s.alt
+		return
+	}
+	s.buf = s.main
+	s.alt = nil
+}
+
+func parseVTParams(raw []byte) []int {
+	if len(raw) == 0 {
+		return nil
+	}
+	fields := strings.FieldsFunc(string(raw), func(r rune) bool {
+		return r == ';' || r == ':'
+	})
+	params := make([]int, 0, len(fields))
+	for _, field := range fields {
+		n, _ := strconv.Atoi(field)
+		params = append(params, n)
+	}
+	return params
+}
+
+// vtParam 参数不存在或者为 0 时返回默认值
+func vtParam(params []int, i, def int) int {
+	if i < len(params) && params[i] > 0 {
+		return params[i]
+	}
+	return def
+}
+
+func (s *VTScreen) topLimit() int {
+	if s.buf.y >= s.scrollTop {
+		return s.scrollTop
+	}
+	return 0
+}
+
+func (s *VTScreen) bottomLimit() int {
+	if s.buf.y <= s.scrollBottom {
+		return s.scrollBottom
+	}
+	return s.height - 1
+}
+
+func (s *VTScreen) clampCursor() {
+	b := s.buf
+	b.x = minInt(maxInt(b.x, 0), s.width-1)
+	b.y = minInt(maxInt(b.y, 0), s.height-1)
+}
+
+func (s *VTScreen) print(r rune) {
+	width := vtRuneWidth(r)
+	if width == 0 {
+		return
+	}
+	b := s.buf
+	if b.wrapNext && s.autoWrap {
+		b.x = 0
+		s.lineFeed()
+	}
+	b.wrapNext = false
+	if width == 2 && b.x == s.width-1 {
+		if !s.autoWrap {
+			return
+		}
+		b.lines[b.y][b.x] = ' '
+		b.x = 0
+		s.lineFeed()
+	}
+	line := b.lines[b.y]
+	// 覆盖宽字符的一半时清除另一半
+	if line[b.x] == vtWideCellPlaceholder && b.x > 0 {
+		line[b.x-1] = ' '
+	}
+	line[b.x] = r
+	if width == 2 {
+		line[b.x+1] = vtWideCellPlaceholder
+	}
+	if end := b.x + width; end < s.width && line[end] == vtWideCellPlaceholder {
+		line[end] = ' '
+	}
+	b.x += width
+	if b.x >= s.width {
+		b.x = s.width - 1
+		b.wrapNext = true
+	}
+}
+
+func (s *VTScreen) lineFeed() {
+	b := s.buf
+	b.wrapNext = false
+	if b.y == s.scrollBottom {
+		s.scrollUp(s.scrollTop, s.scrollBottom, 1)
+		return
+	}
+	if b.y < s.height-1 {
+		b.y++
+	}
+}
+
+func (s *VTScreen) reverseIndex() {
+	b := s.buf
+	b.wrapNext = false
+	if b.y == s.scrollTop {
+		s.scrollDown(s.scrollTop, s.scrollBottom, 1)
+		return
+	}
+	if b.y > 0 {
+		b.y--
+	}
+}
+
+func (s *VTScreen) scrollUp(top, bottom, n int) {
+	b := s.buf
+	n = minInt(n, bottom-top+1)
+	if top == 0 && b == s.main && s.OnScroll != nil {
+		for i := 0; i < n; i++ {
+			s.OnScroll(lineString(b.lines[i]))
+		}
+	}
+	copy(b.lines[top:bottom+1], b.lines[top+n:bottom+1])
+	for i := bottom - n + 1; i <= bottom; i++ {
+		b.lines[i] = newVTLine(s.width)
+	}
+}
+
+func (s *VTScreen) scrollDown(top, bottom, n int) {
+	b := s.buf
+	n = minInt(n, bottom-top+1)
+	copy(b.lines[top+n:bottom+1], b.lines[top:bottom+1-n])
+	for i := top; i < top+n; i++ {
+		b.lines[i] = newVTLine(s.width)
+	}
+}
+
+func (s *VTScreen) insertLines(n int) {
+	b := s.buf
+	if b.y < s.scrollTop || b.y > s.scrollBottom {
+		return
+	}
+	s.scrollDown(b.y, s.scrollBottom, n)
+	b.x = 0
+}
+
+func (s *VTScreen) deleteLines(n int) {
+	b := s.buf
+	if b.y < s.scrollTop || b.y > s.scrollBottom {
+		return
+	}
+	n = minInt(n, s.scrollBottom-b.y+1)
+	copy(b.lines[b.y:s.scrollBottom+1], b.lines[b.y+n:s.scrollBottom+1])
+	for i := s.scrollBottom - n + 1; i <= s.scrollBottom; i++ {
+		b.lines[i] = newVTLine(s.width)
+	}
+	b.x = 0
+}
+
+func (s *VTScreen) eraseDisplay(mode int) {
+	b := s.buf
+	switch mode {
+	case 0:
+		s.eraseLine(0)
+		for i := b.y + 1; i < s.height; i++ {
+			b.lines[i] = newVTLine(s.width)
+		}
+	case 1:
+		s.eraseLine(1)
+		for i := 0; i < b.y; i++ {
+			b.lines[i] = newVTLine(s.width)
+		}
+	case 2, 3:
+		for i := range b.lines {
+			b.lines[i] = newVTLine(s.width)
+		}
+	}
+}
+
+func (s *VTScreen) eraseLine(mode int) {
+	b := s.buf
+	line := b.lines[b.y]
+	start, end := 0, s.width
+	switch mode {
+	case 0:
+		start = b.x
+	case 1:
+		end = b.x + 1
+	}
+	for i := start; i < end; i++ {
+		line[i] = ' '
+	}
+}
+
+func (s *VTScreen) insertChars(n int) {
+	b := s.buf
+	line := b.lines[b.y]
+	n = minInt(n, s.width-b.x)
+	copy(line[b.x+n:], line[b.x:s.width-n])
+	for i := b.x; i < b.x+n; i++ {
+		line[i] = ' '
+	}
+}
+
+func (s *VTScreen) deleteChars(n int) {
+	b := s.buf
+	line := b.lines[b.y]
+	n = minInt(n, s.width-b.x)
+	copy(line[b.x:], line[b.x+n:])
+	for i := s.width - n; i < s.width; i++ {
+		line[i] = ' '
+	}
+}
+
+func (s *VTScreen) eraseChars(n int) {
+	b := s.buf
+	line := b.lines[b.y]
+	for i := b.x; i < minInt(b.x+n, s.width); i++ {
+		line[i] = ' '
+	}
+}
+
+// vtRuneWidth 字符在终端中占用的宽度, 组合字符为 0, 东亚宽字符为 2
+func vtRuneWidth(r rune) int {
+	switch {
+	case r == utf8.RuneError:
+		return 1
+	case r >= 0x0300 && r <= 0x036f, r >= 0x200b && r <= 0x200f, r == 0xfeff,
+		r >= 0xfe00 && r <= 0xfe0f:
+		return 0
+	case r >= 0x1100 && r <= 0x115f, r >= 0x2e80 && r <= 0xa4cf && r != 0x303f,
+		r >= 0xac00 && r <= 0xd7a3, r >= 0xf900 && r <= 0xfaff, r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60, r >= 0xffe0 && r <= 0xffe6, r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x1f900 && r <= 0x1f9ff, r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVTScreen(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		expect []string
+	}{
+		{"plain", "hello\r\nworld", []string{"hello", "world"}},
+		{"backspace and erase", "ifconfig \x08\x1b[K\x08\x1b[K\x08\x1b[K\x08\x1b[K\x08\x1b[K\x08\x1b[Konfig",
+			[]string{"ifconfig"}},
+		{"cursor position", "abc\x1b[2;3Hx\x1b[1;1Hy", []string{"ybc", "  x"}},
+		{"insert and delete chars", "abcdef\x1b[4G\x1b[2P\x1b[1G\x1b[1@", []string{" abcf"}},
+		{"erase display", "line1\r\nline2\x1b[2J\x1b[Hnew", []string{"new"}},
+		{"osc title ignored", "\x1b]0;user@host: ~\x07$ ls", []string{"$ ls"}},
+		{"wide chars", "你好a", []string{"你好a"}},
+		{"auto wrap", "0123456789abc", []string{"0123456789", "abc"}},
+	}
+	for _, tt := range tests {
+		s := NewVTScreen(10, 5)
+		_, _ = s.Write([]byte(tt.input))
+		if got := s.Lines(); !reflect.DeepEqual(got, tt.expect) {
+			t.Fatalf("%s: expect %q, got %q", tt.name, tt.expect, got)
+		}
+	}
+}
+
+func TestVTScreen_Scroll(t *testing.T) {
+	s := NewVTScreen(10, 3)
+	var scrolled []string
+	s.OnScroll = func(line string) {
+		scrolled = append(scrolled, line)
+	}
+	_, _ = s.Write([]byte("1\r\n2\r\n3\r\n4\r\n5"))
+	if got := s.Lines(); !reflect.DeepEqual(got, []string{"3", "4", "5"}) {
+		t.Fatalf("invalid screen: %q", got)
+	}
+	if !reflect.DeepEqual(scrolled, []string{"1", "2"}) {
+		t.Fatalf("invalid scrolled lines: %q", scrolled)
+	}
+}
+
+func TestVTScreen_AltScreen(t *testing.T) {
+	s := NewVTScreen(20, 5)
+	_, _ = s.Write([]byte("$ vim a.txt"))
+	_, _ = s.Write([]byte("\x1b[?1049h\x1b[H\x1b[2J~\r\n~"))
+	if !s.AltScreen() {
+		t.Fatal("should in alt screen")
+	}
+	if got := s.Lines(); !reflect.DeepEqual(got, []string{"~", "~"}) {
+		t.Fatalf("invalid alt screen: %q", got)
+	}
+	_, _ = s.Write([]byte("\x1b[?1049l"))
+	if s.AltScreen() {
+		t.Fatal("should exit alt screen")
+	}
+	if got := s.Lines(); !reflect.DeepEqual(got, []string{"$ vim a.txt"}) {
+		t.Fatalf("main screen should be restored: %q", got)
+	}
+	if x, y := s.Cursor(); x != 11 || y != 0 {
+		t.Fatalf("cursor should be restored, got %d,%d", x, y)
+	}
+}
+
+func TestVTScreen_Resize(t *testing.T) {
+	s := NewVTScreen(10, 4)
+	_, _ = s.Write([]byte("1\r\n2\r\n3\r\n4"))
+	s.Resize(5, 2)
+	if got := s.Lines(); !reflect.DeepEqual(got, []string{"3", "4"}) {
+		t.Fatalf("invalid resized screen: %q", got)
+	}
+	if w, h := s.Size(); w != 5 || h != 2 {
+		t.Fatalf("invalid size %dx%d", w, h)
+	}
+}
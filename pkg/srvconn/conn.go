@@ -11,6 +11,19 @@ type ServerConnection interface {
 	KeepAlive() error
 }
 
+// CommandInterceptor 在连接内部解析命令的协议(如 Redis)通过它过滤和记录命令
+type CommandInterceptor interface {
+	// CheckCommand 执行前检查, 返回错误时不执行; notice 在执行前显示给用户
+	CheckCommand(args []string) (notice string, err error)
+	// CommandDone 命令执行完成, output 为显示给用户的结果
+	CommandDone(args []string, output string)
+}
+
+type InterceptableConnection interface {
+	ServerConnection
+	SetCommandInterceptor(interceptor CommandInterceptor)
+}
+
 type Windows struct {
 	Width  int
 	Height int
@@ -22,7 +35,12 @@ const (
 	ProtocolK8s    = "k8s"
 	ProtocolMySQL  = "mysql"
 
-	ProtocolMariadb = "mariadb"
+	ProtocolMariadb    = "mariadb"
+	ProtocolPostgreSQL = "postgresql"
+	ProtocolRedis      = "redis"
+	ProtocolMongoDB    = "mongodb"
+	ProtocolSQLServer  = "sqlserver"
+	ProtocolOracle     = "oracle"
 )
 
 var (
@@ -30,11 +48,16 @@ var (
 )
 
 var supportedMap = map[string]bool{
-	ProtocolSSH:     true,
-	ProtocolTELNET:  true,
-	ProtocolK8s:     true,
-	ProtocolMySQL:   true,
-	ProtocolMariadb: true,
+	ProtocolSSH:        true,
+	ProtocolTELNET:     true,
+	ProtocolK8s:        true,
+	ProtocolMySQL:      true,
+	ProtocolMariadb:    true,
+	ProtocolPostgreSQL: true,
+	ProtocolRedis:      true,
+	ProtocolMongoDB:    true,
+	ProtocolSQLServer:  true,
+	ProtocolOracle:     true,
 }
 
 func IsSupportedProtocol(p string) bool {
@@ -0,0 +1,167 @@
+package srvconn
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/localcommand"
+	"github.com/jumpserver/koko/pkg/logger"
+)
+
+/*
+	使用本地客户端的数据库连接(PostgreSQL、MongoDB、SQL Server、Oracle)由 cliClient 启动客户端:
+	Linux 下在新的 pid、mount 命名空间中执行生成的 shell 文件, 挂载临时的 HOME 后以 nobody 用户执行客户端,
+	失败时直接以 nobody 用户的权限执行客户端。连接信息通过环境变量传给 shell 文件,
+	密码不出现在命令行参数和环境变量中, 读取到客户端的密码提示后输入。
+	新增客户端只需定义 shell 模板、参数、环境变量和密码提示
+*/
+
+const (
+	// cliLoginTimeout 等待密码提示的时间, 客户端连接失败时可能一直没有提示
+	cliLoginTimeout = 15 * time.Second
+
+	cliMaxPromptSize = 1024
+)
+
+type cliClient struct {
+	// name 客户端命令, 也是生成的 shell 文件名
+	name string
+	// template 在命名空间中执行的 shell 文件
+	template string
+	// args 直接执行客户端时的参数
+	args func(opt *sqlOption) []string
+	// envs 客户端和 shell 文件使用的环境变量
+	envs func(opt *sqlOption) []string
+	// passwordPrompt 客户端的密码提示, 提示之前可能有版本信息
+	passwordPrompt string
+
+	once      sync.Once
+	shellPath string
+}
+
+// Start 启动客户端并输入密码
+func (c *cliClient) Start(opt *sqlOption) (lcmd *localcommand.LocalCommand, err error) {
+	c.initOnceLinuxShellFile()
+	if c.shellPath != "" {
+		if lcmd, err = c.startNameSpaceCommand(opt); err == nil {
+			if lcmd, err = c.tryManualLogin(opt, lcmd); err == nil {
+				return lcmd, nil
+			}
+		}
+	}
+	if lcmd, err = c.startNormalCommand(opt); err != nil {
+		return nil, err
+	}
+	return c.tryManualLogin(opt, lcmd)
+}
+
+func (c *cliClient) startNameSpaceCommand(opt *sqlOption) (*localcommand.LocalCommand, error) {
+	argv := []string{
+		"--fork",
+		"--pid",
+		"--mount-proc",
+		c.shellPath,
+	}
+	return localcommand.New("unshare", argv, localcommand.WithEnv(c.envs(opt)))
+}
+
+func (c *cliClient) startNormalCommand(opt *sqlOption) (*localcommand.LocalCommand, error) {
+	// 使用 nobody 用户的权限
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		logger.Errorf("lookup nobody user err: %s", err)
+		return nil, err
+	}
+	uid, _ := strconv.Atoi(nobody.Uid)
+	gid, _ := strconv.Atoi(nobody.Gid)
+
+	return localcommand.New(c.name, c.args(opt), localcommand.WithEnv(c.envs(opt)),
+		localcommand.WithCmdCredential(&syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}))
+}
+
+func (c *cliClient) tryManualLogin(opt *sqlOption, lcmd *localcommand.LocalCommand) (*localcommand.LocalCommand, error) {
+	if err := c.readPasswordPrompt(lcmd); err != nil {
+		_ = lcmd.Close()
+		logger.Errorf("%s local pty read password prompt err: %s", c.name, err)
+		return lcmd, err
+	}
+
+	// 输入密码, 登录数据库
+	_, err := lcmd.Write([]byte(opt.Password + "\r\n"))
+	if err != nil {
+		_ = lcmd.Close()
+		logger.Errorf("%s local pty write err: %s", c.name, err)
+		return lcmd, fmt.Errorf("%s conn err: %s", c.name, err)
+	}
+	return lcmd, nil
+}
+
+// readPasswordPrompt 读取到密码提示为止, 超时后结束客户端
+func (c *cliClient) readPasswordPrompt(lcmd *localcommand.LocalCommand) error {
+	timer := time.AfterFunc(cliLoginTimeout, func() {
+		_ = lcmd.Close()
+	})
+	defer timer.Stop()
+	var (
+		output []byte
+		buf    [128]byte
+	)
+	for len(output) < cliMaxPromptSize {
+		nr, err := lcmd.Read(buf[:])
+		if err != nil {
+			return fmt.Errorf("%s login prompt not found: %q: %w", c.name, output, err)
+		}
+		output = append(output, buf[:nr]...)
+		if bytes.Contains(output, []byte(c.passwordPrompt)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s login prompt characters did not match: %q", c.name, output)
+}
+
+func (c *cliClient) initOnceLinuxShellFile() {
+	c.once.Do(func() {
+		// Linux系统 初始化客户端的命令文件
+		switch runtime.GOOS {
+		case "linux":
+			if dir, err := os.Getwd(); err == nil {
+				tmpShellPath := filepath.Join(dir, c.name)
+				if _, err := os.Stat(tmpShellPath); err == nil {
+					c.shellPath = tmpShellPath
+					logger.Infof("Already init %s bash file: %s", c.name, tmpShellPath)
+					return
+				}
+				err = ioutil.WriteFile(tmpShellPath, []byte(c.template), os.FileMode(0755))
+				if err != nil {
+					logger.Errorf("Init %s bash file failed: %s", c.name, err)
+					return
+				}
+				c.shellPath = tmpShellPath
+			}
+			logger.Infof("Init %s bash file: %s", c.name, c.shellPath)
+		}
+	})
+}
+
+// cliShellTemplate 生成 shell 文件, command 中可以使用环境变量
+func cliShellTemplate(command string) string {
+	return `#!/bin/bash
+set -e
+mkdir -p /nonexistent
+mount -t tmpfs -o size=10M tmpfs /nonexistent
+cd /nonexistent
+export HOME=/nonexistent
+export TMPDIR=/nonexistent
+export LANG=en_US.UTF-8
+exec su -s /bin/bash --command="` + command + `" nobody
+`
+}
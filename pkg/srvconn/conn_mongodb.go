@@ -0,0 +1,85 @@
+package srvconn
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jumpserver/koko/pkg/localcommand"
+)
+
+const (
+	// 用户默认在 admin 库中认证
+	mongoDBAuthenticationDatabase = "admin"
+)
+
+var _ ServerConnection = (*MongoDBConn)(nil)
+
+// mongosh --password 不带参数时提示 "Enter password: ", 数据库名需要在 --password 之前, 否则会作为密码
+var mongoshClient = &cliClient{
+	name: "mongosh",
+	template: cliShellTemplate("mongosh ${DATABASE} --host=${HOSTNAME} --port=${PORT} --username=${USERNAME} " +
+		"--authenticationDatabase=${AUTH_DATABASE} --password"),
+	args:           (*sqlOption).MongoDBCommandArgs,
+	envs:           (*sqlOption).MongoDBEnvs,
+	passwordPrompt: "Enter password",
+}
+
+func NewMongoDBConnection(ops ...SqlOption) (*MongoDBConn, error) {
+	args := &sqlOption{
+		Username: os.Getenv("USER"),
+		Password: os.Getenv("PASSWORD"),
+		Host:     "127.0.0.1",
+		Port:     27017,
+		DBName:   "",
+		win: Windows{
+			Width:  80,
+			Height: 120,
+		},
+	}
+	for _, setter := range ops {
+		setter(args)
+	}
+	lCmd, err := mongoshClient.Start(args)
+	if err != nil {
+		return nil, err
+	}
+	err = lCmd.SetWinSize(args.win.Width, args.win.Height)
+	if err != nil {
+		_ = lCmd.Close()
+		return nil, err
+	}
+	return &MongoDBConn{options: args, LocalCommand: lCmd}, nil
+}
+
+// MongoDBConn 没有 Go 的驱动检查账号, 认证失败时由 mongosh 提示
+type MongoDBConn struct {
+	options *sqlOption
+	*localcommand.LocalCommand
+}
+
+func (conn *MongoDBConn) KeepAlive() error {
+	return nil
+}
+
+func (conn *MongoDBConn) Close() error {
+	_, _ = conn.Write([]byte("\r\nexit\r\n"))
+	return conn.LocalCommand.Close()
+}
+
+func (opt *sqlOption) MongoDBCommandArgs() []string {
+	var args []string
+	if opt.DBName != "" {
+		args = append(args, opt.DBName)
+	}
+	return append(args,
+		fmt.Sprintf("--host=%s", opt.Host),
+		fmt.Sprintf("--port=%d", opt.Port),
+		fmt.Sprintf("--username=%s", opt.Username),
+		fmt.Sprintf("--authenticationDatabase=%s", mongoDBAuthenticationDatabase),
+		"--password",
+	)
+}
+
+func (opt *sqlOption) MongoDBEnvs() []string {
+	return append(opt.Envs(), fmt.Sprintf("AUTH_DATABASE=%s", mongoDBAuthenticationDatabase))
+}
@@ -15,12 +15,17 @@ import (
 	"syscall"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
-
 	"github.com/jumpserver/koko/pkg/localcommand"
 	"github.com/jumpserver/koko/pkg/logger"
+	"github.com/jumpserver/koko/pkg/mysqld"
 )
 
+/*
+	SSH 菜单和 Web 终端的 MySQL 连接: 账号使用 mysqld 的客户端校验, 然后在 pty 中启动 mysql 客户端并输入密码。
+	终端用户需要 mysql 客户端的行编辑、历史记录和结果表格, mysqld 只转发协议包, 没有这些交互功能,
+	所以终端连接保留 mysql 客户端; DBeaver、JDBC 等客户端使用 mysqld 的协议代理(ENABLE_MYSQL_PROXY)。
+*/
+
 const (
 	mysqlPrompt = "Enter password: "
 
@@ -212,17 +217,6 @@ func (opt *sqlOption) Envs() []string {
 	}
 }
 
-func (opt *sqlOption) DataSourceName() string {
-	// "user:password@tcp(127.0.0.1:3306)/hello"
-	addr := net.JoinHostPort(opt.Host, strconv.Itoa(opt.Port))
-	return fmt.Sprintf("%s:%s@tcp(%s)/%s",
-		opt.Username,
-		opt.Password,
-		addr,
-		opt.DBName,
-	)
-}
-
 type SqlOption func(*sqlOption)
 
 func SqlUsername(username string) SqlOption {
@@ -267,7 +261,13 @@ const (
 )
 
 func checkMySQLAccount(args *sqlOption) error {
-	return checkDatabaseAccountValidate("mysql", args.DataSourceName())
+	addr := net.JoinHostPort(args.Host, strconv.Itoa(args.Port))
+	return mysqld.CheckAccount(addr, mysqld.ClientConfig{
+		Username:     args.Username,
+		Password:     args.Password,
+		Database:     args.DBName,
+		Capabilities: mysqld.ServerCapabilities,
+	}, mySQLMaxIdleTime)
 }
 
 func checkDatabaseAccountValidate(driveName, datasourceName string) error {
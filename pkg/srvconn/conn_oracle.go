@@ -0,0 +1,89 @@
+package srvconn
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/jumpserver/koko/pkg/localcommand"
+)
+
+var _ ServerConnection = (*OracleConn)(nil)
+
+// oracleClientEnvs Instant Client 需要的环境变量, koko 设置时传给 sqlplus
+var oracleClientEnvs = []string{"ORACLE_HOME", "LD_LIBRARY_PATH", "TNS_ADMIN", "NLS_LANG"}
+
+// sqlplus 只有用户名时提示 "Enter password: ", -L 登录失败后不再重试
+var sqlplusClient = &cliClient{
+	name:           "sqlplus",
+	template:       cliShellTemplate("sqlplus -L ${USERNAME}@${CONNECT_STRING}"),
+	args:           (*sqlOption).OracleCommandArgs,
+	envs:           (*sqlOption).OracleEnvs,
+	passwordPrompt: "Enter password:",
+}
+
+func NewOracleConnection(ops ...SqlOption) (*OracleConn, error) {
+	args := &sqlOption{
+		Username: os.Getenv("USER"),
+		Password: os.Getenv("PASSWORD"),
+		Host:     "127.0.0.1",
+		Port:     1521,
+		DBName:   "",
+		win: Windows{
+			Width:  80,
+			Height: 120,
+		},
+	}
+	for _, setter := range ops {
+		setter(args)
+	}
+	lCmd, err := sqlplusClient.Start(args)
+	if err != nil {
+		return nil, err
+	}
+	err = lCmd.SetWinSize(args.win.Width, args.win.Height)
+	if err != nil {
+		_ = lCmd.Close()
+		return nil, err
+	}
+	return &OracleConn{options: args, LocalCommand: lCmd}, nil
+}
+
+// OracleConn 认证失败时由 sqlplus 提示
+type OracleConn struct {
+	options *sqlOption
+	*localcommand.LocalCommand
+}
+
+func (conn *OracleConn) KeepAlive() error {
+	return nil
+}
+
+func (conn *OracleConn) Close() error {
+	_, _ = conn.Write([]byte("\r\nexit\r\n"))
+	return conn.LocalCommand.Close()
+}
+
+// OracleConnectString Easy Connect 格式 //host:port/service_name, 数据库名作为服务名
+func (opt *sqlOption) OracleConnectString() string {
+	connectString := "//" + net.JoinHostPort(opt.Host, strconv.Itoa(opt.Port))
+	if opt.DBName != "" {
+		connectString += "/" + opt.DBName
+	}
+	return connectString
+}
+
+func (opt *sqlOption) OracleCommandArgs() []string {
+	return []string{"-L", fmt.Sprintf("%s@%s", opt.Username, opt.OracleConnectString())}
+}
+
+func (opt *sqlOption) OracleEnvs() []string {
+	envs := append(opt.Envs(), fmt.Sprintf("CONNECT_STRING=%s", opt.OracleConnectString()))
+	for _, key := range oracleClientEnvs {
+		if value, ok := os.LookupEnv(key); ok {
+			envs = append(envs, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	return envs
+}
@@ -0,0 +1,420 @@
+package srvconn
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jumpserver/koko/pkg/logger"
+	"github.com/jumpserver/koko/pkg/utils"
+)
+
+/*
+	Redis 连接没有本地客户端, 由 koko 提供类似 redis-cli 的交互终端:
+	用户输入经过终端行编辑后按 redis-cli 的规则拆分参数, 以 RESP 数组发送给 Redis,
+	回复按 redis-cli 的格式显示。命令在连接内部解析, 通过 CommandInterceptor 过滤和记录。
+	订阅、MONITOR 等持续推送数据的命令不支持; 命令执行中按 Ctrl+C 断开并重新连接以中断命令。
+*/
+
+const (
+	redisDialTimeout = 15 * time.Second
+
+	redisCharCtrlC = 3
+)
+
+var (
+	_ ServerConnection        = (*RedisConn)(nil)
+	_ InterceptableConnection = (*RedisConn)(nil)
+
+	// unsupportedRedisCommands 持续推送数据或者改变连接协议的命令
+	unsupportedRedisCommands = map[string]bool{
+		"SUBSCRIBE":  true,
+		"PSUBSCRIBE": true,
+		"SSUBSCRIBE": true,
+		"MONITOR":    true,
+		"SYNC":       true,
+		"PSYNC":      true,
+	}
+)
+
+func NewRedisConnection(ops ...SqlOption) (*RedisConn, error) {
+	args := &sqlOption{
+		Username: os.Getenv("USER"),
+		Password: os.Getenv("PASSWORD"),
+		Host:     "127.0.0.1",
+		Port:     6379,
+		DBName:   "",
+		win: Windows{
+			Width:  80,
+			Height: 120,
+		},
+	}
+	for _, setter := range ops {
+		setter(args)
+	}
+	db := 0
+	if args.DBName != "" {
+		var err error
+		if db, err = strconv.Atoi(args.DBName); err != nil || db < 0 {
+			return nil, fmt.Errorf("invalid redis db index: %s", args.DBName)
+		}
+	}
+	conn := &RedisConn{
+		options: args,
+		db:      db,
+		input:   newRedisBuffer(),
+		output:  newRedisBuffer(),
+	}
+	if err := conn.connect(); err != nil {
+		return nil, err
+	}
+	conn.term = utils.NewTerminal(&redisTermIO{in: conn.input, out: conn.output}, conn.prompt())
+	_ = conn.term.SetSize(args.win.Width, args.win.Height)
+	go conn.run()
+	return conn, nil
+}
+
+type RedisConn struct {
+	options *sqlOption
+	term    *utils.Terminal
+
+	// db 只在执行命令的 goroutine 中修改
+	db int
+
+	mu          sync.Mutex
+	backend     net.Conn
+	reader      *bufio.Reader
+	running     bool
+	interrupted bool
+	interceptor CommandInterceptor
+
+	input  *redisBuffer
+	output *redisBuffer
+
+	closeOnce sync.Once
+}
+
+func (conn *RedisConn) Read(p []byte) (int, error) {
+	return conn.output.Read(p)
+}
+
+// Write 用户的输入, 命令执行中的 Ctrl+C 中断命令
+func (conn *RedisConn) Write(p []byte) (int, error) {
+	conn.mu.Lock()
+	if conn.running && bytes.IndexByte(p, redisCharCtrlC) >= 0 {
+		conn.interrupted = true
+		_ = conn.backend.Close()
+		conn.mu.Unlock()
+		return len(p), nil
+	}
+	conn.mu.Unlock()
+	return conn.input.Write(p)
+}
+
+func (conn *RedisConn) Close() error {
+	conn.closeOnce.Do(func() {
+		conn.mu.Lock()
+		_ = conn.backend.Close()
+		conn.mu.Unlock()
+		conn.input.Close()
+		conn.output.Close()
+	})
+	return nil
+}
+
+func (conn *RedisConn) SetWinSize(width, height int) error {
+	return conn.term.SetSize(width, height)
+}
+
+// KeepAlive 连接上的命令按顺序执行, 长时间阻塞的命令执行时不能发送 PING, 依赖 TCP 的 keepalive
+func (conn *RedisConn) KeepAlive() error {
+	return nil
+}
+
+func (conn *RedisConn) SetCommandInterceptor(interceptor CommandInterceptor) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.interceptor = interceptor
+}
+
+func (conn *RedisConn) getInterceptor() CommandInterceptor {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.interceptor
+}
+
+// connect 连接 Redis 并认证, 选择数据库
+func (conn *RedisConn) connect() error {
+	addr := net.JoinHostPort(conn.options.Host, strconv.Itoa(conn.options.Port))
+	netConn, err := net.DialTimeout("tcp", addr, redisDialTimeout)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(netConn)
+	_ = netConn.SetDeadline(time.Now().Add(redisDialTimeout))
+	if err = redisHandshake(netConn, reader, conn.options, conn.db); err != nil {
+		_ = netConn.Close()
+		return err
+	}
+	_ = netConn.SetDeadline(time.Time{})
+	conn.mu.Lock()
+	conn.backend = netConn
+	conn.reader = reader
+	conn.mu.Unlock()
+	return nil
+}
+
+func redisHandshake(rw io.ReadWriter, reader *bufio.Reader, opt *sqlOption, db int) error {
+	roundTrip := func(args ...string) (*redisReply, error) {
+		if err := writeRedisCommand(rw, args); err != nil {
+			return nil, err
+		}
+		return readRedisReply(reader)
+	}
+	var (
+		reply *redisReply
+		err   error
+	)
+	switch {
+	case opt.Password == "":
+		reply, err = roundTrip("PING")
+	case opt.Username == "" || opt.Username == "default":
+		reply, err = roundTrip("AUTH", opt.Password)
+	default:
+		// Redis 6 之前的 AUTH 只有密码参数
+		reply, err = roundTrip("AUTH", opt.Username, opt.Password)
+		if err == nil && reply.IsError() && strings.Contains(reply.str, "wrong number of arguments") {
+			reply, err = roundTrip("AUTH", opt.Password)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if reply.IsError() {
+		return fmt.Errorf("redis auth failed: %s", reply.str)
+	}
+	if db == 0 {
+		return nil
+	}
+	if reply, err = roundTrip("SELECT", strconv.Itoa(db)); err != nil {
+		return err
+	}
+	if reply.IsError() {
+		return fmt.Errorf("redis select db %d failed: %s", db, reply.str)
+	}
+	return nil
+}
+
+// prompt 与 redis-cli 一致, 如 127.0.0.1:6379> 或 127.0.0.1:6379[1]>
+func (conn *RedisConn) prompt() string {
+	prompt := net.JoinHostPort(conn.options.Host, strconv.Itoa(conn.options.Port))
+	if conn.db != 0 {
+		prompt += fmt.Sprintf("[%d]", conn.db)
+	}
+	return prompt + "> "
+}
+
+func (conn *RedisConn) run() {
+	defer conn.Close()
+	for {
+		line, err := conn.term.ReadLine()
+		if err != nil && !errors.Is(err, utils.ErrPasteIndicator) {
+			return
+		}
+		args, err := SplitRedisArgs(line)
+		if err != nil {
+			conn.writeLine("Invalid argument(s)")
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		switch strings.ToLower(args[0]) {
+		case "quit", "exit":
+			return
+		case "clear":
+			_, _ = conn.output.Write([]byte("\x1b[H\x1b[2J"))
+			continue
+		}
+		if isUnsupportedRedisCommand(args) {
+			conn.writeLine(fmt.Sprintf("(error) ERR command '%s' is not supported in this session", args[0]))
+			continue
+		}
+		interceptor := conn.getInterceptor()
+		if interceptor != nil {
+			notice, err := interceptor.CheckCommand(args)
+			if err != nil {
+				conn.writeWarn(err.Error())
+				continue
+			}
+			if notice != "" {
+				conn.writeWarn(notice)
+			}
+		}
+		output, err := conn.execute(args)
+		interrupted := conn.isInterrupted()
+		switch {
+		case err != nil && interrupted:
+			output = "(error) Command interrupted"
+		case err != nil:
+			logger.Errorf("Redis conn %s execute command err: %s", conn.options.Host, err)
+			output = fmt.Sprintf("Error: %s", err)
+		}
+		conn.writeLine(output)
+		if interceptor != nil {
+			interceptor.CommandDone(args, output)
+		}
+		if interrupted {
+			// 中断时已经断开连接, 重新连接后继续
+			if err = conn.reconnect(); err != nil {
+				logger.Errorf("Redis conn %s reconnect err: %s", conn.options.Host, err)
+				conn.writeLine(fmt.Sprintf("Could not connect to Redis: %s", err))
+				return
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// execute 发送命令并读取回复, 执行中可以被 Ctrl+C 中断
+func (conn *RedisConn) execute(args []string) (string, error) {
+	conn.mu.Lock()
+	backend, reader := conn.backend, conn.reader
+	conn.running = true
+	conn.mu.Unlock()
+	defer func() {
+		conn.mu.Lock()
+		conn.running = false
+		conn.mu.Unlock()
+	}()
+	if err := writeRedisCommand(backend, args); err != nil {
+		return "", err
+	}
+	reply, err := readRedisReply(reader)
+	if err != nil {
+		return "", err
+	}
+	if !reply.IsError() {
+		conn.afterCommand(args)
+	}
+	return reply.Format(), nil
+}
+
+// afterCommand 切换数据库后更新提示符
+func (conn *RedisConn) afterCommand(args []string) {
+	db := conn.db
+	switch strings.ToUpper(args[0]) {
+	case "SELECT":
+		if len(args) == 2 {
+			if index, err := strconv.Atoi(args[1]); err == nil {
+				db = index
+			}
+		}
+	case "RESET":
+		db = 0
+	default:
+		return
+	}
+	conn.db = db
+	conn.term.SetPrompt(conn.prompt())
+}
+
+func (conn *RedisConn) isInterrupted() bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.interrupted
+}
+
+func (conn *RedisConn) reconnect() error {
+	conn.mu.Lock()
+	_ = conn.backend.Close()
+	conn.interrupted = false
+	conn.mu.Unlock()
+	return conn.connect()
+}
+
+func (conn *RedisConn) writeLine(line string) {
+	_, _ = conn.term.Write([]byte(line + "\n"))
+}
+
+func (conn *RedisConn) writeWarn(msg string) {
+	_, _ = conn.output.Write([]byte(utils.WrapperWarn(msg)))
+}
+
+// isUnsupportedRedisCommand 终端按一问一答执行命令, 不支持持续推送数据和关闭回复的命令
+func isUnsupportedRedisCommand(args []string) bool {
+	name := strings.ToUpper(args[0])
+	if unsupportedRedisCommands[name] {
+		return true
+	}
+	return name == "CLIENT" && len(args) > 1 && strings.EqualFold(args[1], "REPLY")
+}
+
+// redisTermIO 终端从用户输入读取, 输出写入到返回给用户的数据
+type redisTermIO struct {
+	in  *redisBuffer
+	out *redisBuffer
+}
+
+func (t *redisTermIO) Read(p []byte) (int, error) {
+	return t.in.Read(p)
+}
+
+func (t *redisTermIO) Write(p []byte) (int, error) {
+	return t.out.Write(p)
+}
+
+// redisBuffer 写入不阻塞的管道, 读取时等待数据, 关闭后读完剩余数据返回 io.EOF
+type redisBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newRedisBuffer() *redisBuffer {
+	b := &redisBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *redisBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.buf.Len() == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return b.buf.Read(p)
+}
+
+func (b *redisBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	b.buf.Write(p)
+	b.cond.Signal()
+	return len(p), nil
+}
+
+func (b *redisBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
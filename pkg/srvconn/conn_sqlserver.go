@@ -0,0 +1,74 @@
+package srvconn
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jumpserver/koko/pkg/localcommand"
+)
+
+var _ ServerConnection = (*SQLServerConn)(nil)
+
+// sqlcmd 没有 -P 参数时提示 "Password: ", 环境变量只有连接信息, 不会读取 SQLCMDPASSWORD
+var sqlcmdClient = &cliClient{
+	name: "sqlcmd",
+	template: cliShellTemplate("sqlcmd -S tcp:${HOSTNAME},${PORT} -U ${USERNAME} " +
+		"${DATABASE:+-d ${DATABASE}} -C"),
+	args:           (*sqlOption).SQLServerCommandArgs,
+	envs:           (*sqlOption).Envs,
+	passwordPrompt: "Password:",
+}
+
+func NewSQLServerConnection(ops ...SqlOption) (*SQLServerConn, error) {
+	args := &sqlOption{
+		Username: os.Getenv("USER"),
+		Password: os.Getenv("PASSWORD"),
+		Host:     "127.0.0.1",
+		Port:     1433,
+		DBName:   "",
+		win: Windows{
+			Width:  80,
+			Height: 120,
+		},
+	}
+	for _, setter := range ops {
+		setter(args)
+	}
+	lCmd, err := sqlcmdClient.Start(args)
+	if err != nil {
+		return nil, err
+	}
+	err = lCmd.SetWinSize(args.win.Width, args.win.Height)
+	if err != nil {
+		_ = lCmd.Close()
+		return nil, err
+	}
+	return &SQLServerConn{options: args, LocalCommand: lCmd}, nil
+}
+
+// SQLServerConn 认证失败时由 sqlcmd 提示
+type SQLServerConn struct {
+	options *sqlOption
+	*localcommand.LocalCommand
+}
+
+func (conn *SQLServerConn) KeepAlive() error {
+	return nil
+}
+
+func (conn *SQLServerConn) Close() error {
+	_, _ = conn.Write([]byte("\r\nexit\r\n"))
+	return conn.LocalCommand.Close()
+}
+
+// SQLServerCommandArgs -C 信任服务器证书, ODBC Driver 18 默认加密连接
+func (opt *sqlOption) SQLServerCommandArgs() []string {
+	args := []string{
+		"-S", fmt.Sprintf("tcp:%s,%d", opt.Host, opt.Port),
+		"-U", opt.Username,
+	}
+	if opt.DBName != "" {
+		args = append(args, "-d", opt.DBName)
+	}
+	return append(args, "-C")
+}
@@ -0,0 +1,359 @@
+package srvconn
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+	Redis 的 RESP 协议: 命令为 bulk string 组成的数组, 回复的第一个字节为类型:
+	  RESP2: + 状态, - 错误, : 整数, $ bulk string, * 数组
+	  RESP3(HELLO 3 之后): _ 空值, , 浮点数, # 布尔, = verbatim string, ( 大整数, ! bulk 错误,
+	         % map, ~ set, > push, | attribute(附加在回复之前, 不显示)
+	回复的显示格式与 redis-cli 在终端中的显示一致
+*/
+
+var ErrRedisProtocol = errors.New("redis protocol error")
+
+const (
+	redisReplyStatus  = '+'
+	redisReplyError   = '-'
+	redisReplyInteger = ':'
+	redisReplyString  = '$'
+	redisReplyArray   = '*'
+
+	redisReplyNil        = '_'
+	redisReplyDouble     = ','
+	redisReplyBool       = '#'
+	redisReplyVerbatim   = '='
+	redisReplyBigNumber  = '('
+	redisReplyBlobError  = '!'
+	redisReplyMap        = '%'
+	redisReplySet        = '~'
+	redisReplyPush       = '>'
+	redisReplyAttributes = '|'
+)
+
+// redisReply 解析后的回复, 聚合类型的元素在 elements 中, map 的键值依次排列
+type redisReply struct {
+	kind     byte
+	str      string
+	elements []*redisReply
+}
+
+func (r *redisReply) IsError() bool {
+	return r.kind == redisReplyError
+}
+
+// writeRedisCommand 按 bulk string 数组发送命令
+func writeRedisCommand(w io.Writer, args []string) error {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		b.WriteString(arg)
+		b.WriteString("\r\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func readRedisReply(r *bufio.Reader) (*redisReply, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("%w: invalid line %q", ErrRedisProtocol, line)
+	}
+	kind, text := line[0], line[1:len(line)-2]
+	switch kind {
+	case redisReplyStatus, redisReplyError, redisReplyInteger, redisReplyDouble, redisReplyBigNumber:
+		return &redisReply{kind: kind, str: text}, nil
+	case redisReplyNil:
+		return &redisReply{kind: redisReplyNil}, nil
+	case redisReplyBool:
+		if text != "t" && text != "f" {
+			return nil, fmt.Errorf("%w: invalid bool %q", ErrRedisProtocol, text)
+		}
+		return &redisReply{kind: kind, str: text}, nil
+	case redisReplyString, redisReplyVerbatim, redisReplyBlobError:
+		length, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid length %q", ErrRedisProtocol, text)
+		}
+		if length < 0 {
+			return &redisReply{kind: redisReplyNil}, nil
+		}
+		data := make([]byte, length+2)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		str := string(data[:length])
+		switch kind {
+		case redisReplyVerbatim:
+			// 前 4 个字节为格式, 如 txt:
+			if len(str) >= 4 {
+				str = str[4:]
+			}
+		case redisReplyBlobError:
+			kind = redisReplyError
+		}
+		return &redisReply{kind: kind, str: str}, nil
+	case redisReplyArray, redisReplySet, redisReplyPush, redisReplyMap, redisReplyAttributes:
+		count, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid length %q", ErrRedisProtocol, text)
+		}
+		if count < 0 {
+			return &redisReply{kind: redisReplyNil}, nil
+		}
+		if kind == redisReplyMap || kind == redisReplyAttributes {
+			count *= 2
+		}
+		reply := redisReply{kind: kind, elements: make([]*redisReply, 0, count)}
+		for i := 0; i < count; i++ {
+			elem, err := readRedisReply(r)
+			if err != nil {
+				return nil, err
+			}
+			reply.elements = append(reply.elements, elem)
+		}
+		if kind == redisReplyAttributes {
+			return readRedisReply(r)
+		}
+		return &reply, nil
+	}
+	return nil, fmt.Errorf("%w: unknown reply type %q", ErrRedisProtocol, kind)
+}
+
+// Format 按 redis-cli 的格式显示回复, 不包含最后的换行
+func (r *redisReply) Format() string {
+	return strings.TrimSuffix(r.format(""), "\n")
+}
+
+func (r *redisReply) format(prefix string) string {
+	switch r.kind {
+	case redisReplyStatus:
+		return r.str + "\n"
+	case redisReplyError:
+		return "(error) " + r.str + "\n"
+	case redisReplyInteger:
+		return "(integer) " + r.str + "\n"
+	case redisReplyDouble:
+		return "(double) " + r.str + "\n"
+	case redisReplyBigNumber:
+		return "(big number) " + r.str + "\n"
+	case redisReplyString:
+		return quoteRedisString(r.str) + "\n"
+	case redisReplyVerbatim:
+		return r.str + "\n"
+	case redisReplyNil:
+		return "(nil)\n"
+	case redisReplyBool:
+		if r.str == "t" {
+			return "(true)\n"
+		}
+		return "(false)\n"
+	}
+	return r.formatAggregate(prefix)
+}
+
+// formatAggregate 元素按 "1) " 编号, 嵌套的元素缩进到上一级编号之后
+func (r *redisReply) formatAggregate(prefix string) string {
+	if len(r.elements) == 0 {
+		switch r.kind {
+		case redisReplyArray:
+			return "(empty array)\n"
+		case redisReplyMap:
+			return "(empty hash)\n"
+		case redisReplySet:
+			return "(empty set)\n"
+		case redisReplyPush:
+			return "(empty push)\n"
+		}
+		return "(empty aggregate type)\n"
+	}
+	count := len(r.elements)
+	step := 1
+	sep := ')'
+	switch r.kind {
+	case redisReplyMap:
+		step = 2
+		sep = '#'
+	case redisReplySet:
+		sep = '~'
+	}
+	idxLen := len(strconv.Itoa(count / step))
+	childPrefix := prefix + strings.Repeat(" ", idxLen+2)
+	var b strings.Builder
+	for i := 0; i < count; i += step {
+		// 第一个元素的前缀由上一级输出
+		if i > 0 {
+			b.WriteString(prefix)
+		}
+		b.WriteString(fmt.Sprintf("%*d%c ", idxLen, i/step+1, sep))
+		b.WriteString(r.elements[i].format(childPrefix))
+		if step == 2 {
+			value := strings.TrimSuffix(b.String(), "\n")
+			b.Reset()
+			b.WriteString(value)
+			b.WriteString(" => ")
+			b.WriteString(r.elements[i+1].format(childPrefix))
+		}
+	}
+	return b.String()
+}
+
+// quoteRedisString 与 redis 的 sdscatrepr 一致, 不可打印的字符转义
+func quoteRedisString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if c >= 0x20 && c < 0x7f {
+				b.WriteByte(c)
+			} else {
+				b.WriteString(fmt.Sprintf(`\x%02x`, c))
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+var ErrRedisInvalidArgs = errors.New("invalid argument(s)")
+
+// SplitRedisArgs 与 redis-cli 的 sdssplitargs 一致: 参数以空白分隔,
+// 双引号中支持 \n \r \t \b \a \xff 等转义, 单引号中只支持 \'; 结束的引号之后必须是空白
+func SplitRedisArgs(line string) ([]string, error) {
+	var (
+		args []string
+		pos  int
+	)
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f'
+	}
+	for {
+		for pos < len(line) && isSpace(line[pos]) {
+			pos++
+		}
+		if pos >= len(line) {
+			return args, nil
+		}
+		var (
+			current     []byte
+			inQuotes    bool
+			inSQuotes   bool
+			done        bool
+			closedQuote = func() bool {
+				// 结束的引号之后必须是空白或者结尾
+				return pos+1 >= len(line) || isSpace(line[pos+1])
+			}
+		)
+		for !done {
+			switch {
+			case inQuotes:
+				switch {
+				case pos >= len(line):
+					return nil, ErrRedisInvalidArgs
+				case line[pos] == '\\' && pos+3 < len(line) && line[pos+1] == 'x' &&
+					isHexDigit(line[pos+2]) && isHexDigit(line[pos+3]):
+					v, _ := strconv.ParseUint(line[pos+2:pos+4], 16, 8)
+					current = append(current, byte(v))
+					pos += 3
+				case line[pos] == '\\' && pos+1 < len(line):
+					pos++
+					switch c := line[pos]; c {
+					case 'n':
+						current = append(current, '\n')
+					case 'r':
+						current = append(current, '\r')
+					case 't':
+						current = append(current, '\t')
+					case 'b':
+						current = append(current, '\b')
+					case 'a':
+						current = append(current, '\a')
+					default:
+						current = append(current, c)
+					}
+				case line[pos] == '"':
+					if !closedQuote() {
+						return nil, ErrRedisInvalidArgs
+					}
+					done = true
+				default:
+					current = append(current, line[pos])
+				}
+			case inSQuotes:
+				switch {
+				case pos >= len(line):
+					return nil, ErrRedisInvalidArgs
+				case line[pos] == '\\' && pos+1 < len(line) && line[pos+1] == '\'':
+					pos++
+					current = append(current, '\'')
+				case line[pos] == '\'':
+					if !closedQuote() {
+						return nil, ErrRedisInvalidArgs
+					}
+					done = true
+				default:
+					current = append(current, line[pos])
+				}
+			default:
+				switch {
+				case pos >= len(line), line[pos] == ' ', line[pos] == '\n', line[pos] == '\r', line[pos] == '\t':
+					done = true
+				case line[pos] == '"':
+					inQuotes = true
+				case line[pos] == '\'':
+					inSQuotes = true
+				default:
+					current = append(current, line[pos])
+				}
+			}
+			if pos < len(line) {
+				pos++
+			}
+		}
+		args = append(args, string(current))
+	}
+}
+
+// FormatRedisArgs 将命令参数合并为一行, 需要时加引号, 可以用 SplitRedisArgs 还原
+func FormatRedisArgs(args []string) string {
+	items := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "" || strings.IndexFunc(arg, func(r rune) bool {
+			return r <= ' ' || r == '"' || r == '\'' || r == '\\' || r >= 0x7f
+		}) >= 0 {
+			arg = quoteRedisString(arg)
+		}
+		items = append(items, arg)
+	}
+	return strings.Join(items, " ")
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,108 @@
+package srvconn
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitRedisArgs(t *testing.T) {
+	tests := []struct {
+		line   string
+		expect []string
+		err    bool
+	}{
+		{"", nil, false},
+		{"  keys   *  ", []string{"keys", "*"}, false},
+		{`set k "hello world"`, []string{"set", "k", "hello world"}, false},
+		{`set k "a\nb\x41\"c"`, []string{"set", "k", "a\nbA\"c"}, false},
+		{`set k 'it\'s \n'`, []string{"set", "k", `it's \n`}, false},
+		{`set k ""`, []string{"set", "k", ""}, false},
+		{`set k a"b c"`, []string{"set", "k", "ab c"}, false},
+		{`set k "abc`, nil, true},
+		{`set k "abc"d`, nil, true},
+		{`set k 'abc`, nil, true},
+	}
+	for _, tt := range tests {
+		args, err := SplitRedisArgs(tt.line)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: unexpected err %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.expect) {
+			t.Errorf("%q: expect %q, got %q", tt.line, tt.expect, args)
+		}
+	}
+}
+
+func TestFormatRedisArgs(t *testing.T) {
+	args := []string{"SET", "k", "hello world", "", "a\"b\x00"}
+	line := FormatRedisArgs(args)
+	if line != `SET k "hello world" "" "a\"b\x00"` {
+		t.Errorf("unexpected line %s", line)
+	}
+	got, err := SplitRedisArgs(line)
+	if err != nil || !reflect.DeepEqual(got, args) {
+		t.Errorf("split %s: %q, %v", line, got, err)
+	}
+}
+
+func TestWriteRedisCommand(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeRedisCommand(&buf, []string{"GET", "a b"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "*2\r\n$3\r\nGET\r\n$3\r\na b\r\n" {
+		t.Errorf("unexpected command %q", buf.String())
+	}
+}
+
+func TestRedisReply_Format(t *testing.T) {
+	tests := []struct {
+		name   string
+		resp   string
+		expect string
+	}{
+		{"status", "+OK\r\n", "OK"},
+		{"error", "-ERR unknown command 'foo'\r\n", "(error) ERR unknown command 'foo'"},
+		{"integer", ":42\r\n", "(integer) 42"},
+		{"bulk", "$6\r\nhi\r\n\"\xe4\r\n", `"hi\r\n\"\xe4"`},
+		{"nil bulk", "$-1\r\n", "(nil)"},
+		{"nil array", "*-1\r\n", "(nil)"},
+		{"empty array", "*0\r\n", "(empty array)"},
+		{"array", "*2\r\n$1\r\na\r\n:1\r\n", "1) \"a\"\n2) (integer) 1"},
+		{"nested", "*2\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n",
+			"1) 1) \"a\"\n   2) \"b\"\n2) \"c\""},
+		{"index width", "*10\r\n" + strings.Repeat(":1\r\n", 9) + "*2\r\n:1\r\n:2\r\n",
+			" 1) (integer) 1\n 2) (integer) 1\n 3) (integer) 1\n 4) (integer) 1\n 5) (integer) 1\n" +
+				" 6) (integer) 1\n 7) (integer) 1\n 8) (integer) 1\n 9) (integer) 1\n" +
+				"10) 1) (integer) 1\n    2) (integer) 2"},
+		{"resp3 map", "%2\r\n+a\r\n:1\r\n+b\r\n*1\r\n#t\r\n",
+			"1# a => (integer) 1\n2# b => 1) (true)"},
+		{"resp3 set", "~1\r\n,1.5\r\n", "1~ (double) 1.5"},
+		{"resp3 verbatim", "=8\r\ntxt:info\r\n", "info"},
+		{"resp3 attribute", "|1\r\n+ttl\r\n:3\r\n_\r\n", "(nil)"},
+		{"resp3 blob error", "!5\r\nERR x\r\n", "(error) ERR x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := readRedisReply(bufio.NewReader(strings.NewReader(tt.resp)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reply.Format(); got != tt.expect {
+				t.Errorf("expect\n%s\ngot\n%s", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestReadRedisReply_Invalid(t *testing.T) {
+	for _, resp := range []string{"OK\r\n", "+OK\n", "$3\r\nab", "#x\r\n", "*a\r\n"} {
+		if _, err := readRedisReply(bufio.NewReader(strings.NewReader(resp))); err == nil {
+			t.Errorf("%q: expect error", resp)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/pkg/sftp"
 
+	"github.com/jumpserver/koko/pkg/auditlog"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/model"
 	"github.com/jumpserver/koko/pkg/jms-sdk-go/service"
 	"github.com/jumpserver/koko/pkg/logger"
@@ -362,6 +363,7 @@ func (u *UserSftpConn) loopPushFTPLog() {
 			if !ok {
 				return
 			}
+			auditlog.Emit(auditlog.FTPLogEvent(logData))
 			ftpLogList = append(ftpLogList, logData)
 		}
 
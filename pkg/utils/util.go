@@ -23,6 +23,7 @@ const (
 	CharTab       = "\t"
 	CharNewLine   = "\r\n"
 	CharCleanLine = '\x15'
+	CharCtrlC     = '\x03'
 )
 
 func WrapperString(text string, color string, meta ...bool) string {
@@ -23,6 +23,16 @@ export const allRoleRoutes = [
     path: '/monitor/:id/',
     name: 'Monitor',
     component: () => import('../views/Monitor')
+  },
+  {
+    path: '/replay/',
+    name: 'ReplayList',
+    component: () => import('../views/ReplayList')
+  },
+  {
+    path: '/replay/:id/',
+    name: 'Replay',
+    component: () => import('../views/Replay')
   }
 ]
 
@@ -12,6 +12,21 @@ export function decodeToStr(octets) {
     return decodeURIComponent(escape(String.fromCharCode.apply(null, octets)));
 }
 
+// fetchReplayAPI 本地录像 API, 未认证时输入 LOCAL_REPLAY_TOKEN, 通过请求头 X-Replay-Token 发送,
+// 认证通过后服务端写入 cookie, token 不出现在地址中
+export function fetchReplayAPI(vm, url) {
+    return fetch(url, {credentials: 'same-origin'}).then(resp => {
+        if (resp.status !== 401) {
+            return resp
+        }
+        return vm.$prompt(vm.$t('Replay.Token'), {inputType: 'password'})
+            .catch(() => {
+                throw new Error(resp.statusText)
+            })
+            .then(({value}) => fetch(url, {credentials: 'same-origin', headers: {'X-Replay-Token': value}}))
+    })
+}
+
 export function fireEvent(e) {
     window.dispatchEvent(e)
 }
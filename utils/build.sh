@@ -34,6 +34,7 @@ kubectlflags="-X 'github.com/jumpserver/koko/pkg/config.CipherKey=$cipherKey'"
 cd .. && go mod download || exit 3
 CGO_ENABLED=0 GOOS="$OS" go build -ldflags "$goldflags" -o koko ${project_dir}/cmd/koko/ || exit 4
 CGO_ENABLED=0 GOOS="$OS" go build -ldflags "$kubectlflags" -o kubectl ${project_dir}/cmd/kubectl/  || exit 4
+CGO_ENABLED=0 GOOS="$OS" go build -o replaytool ${project_dir}/cmd/replaytool/  || exit 4
 set -x
 
 # 打包
@@ -43,6 +44,6 @@ mkdir -p "${to_dir}"
 
 cp -r "${utils_dir}/init-kubectl.sh" "${to_dir}"
 
-for i in koko kubectl static templates locale config_example.yml;do
+for i in koko kubectl replaytool static templates locale config_example.yml;do
   cp -r $i "${to_dir}"
 done